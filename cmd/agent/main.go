@@ -12,7 +12,7 @@ import (
 	"github.com/sotavant/yandex-metrics/internal/agent/config"
 	"github.com/sotavant/yandex-metrics/internal/agent/storage"
 	"github.com/sotavant/yandex-metrics/internal/utils"
-	pb "github.com/sotavant/yandex-metrics/proto"
+	pbv2 "github.com/sotavant/yandex-metrics/proto/v2"
 	"google.golang.org/grpc"
 )
 
@@ -47,6 +47,7 @@ func main() {
 	if err != nil {
 		internal.Logger.Fatalw("failed to init crypto cipher", "error", err)
 	}
+	ch = ch.WithLegacy(config.AppConfig.CryptoLegacy)
 
 	r, gRPCConn := getReporter(config.AppConfig.UseGRPC, ch)
 	if gRPCConn != nil {
@@ -130,6 +131,6 @@ func getReporter(useGRPC bool, cipher *utils.Cipher) (Reporter, *grpc.ClientConn
 		internal.Logger.Fatalw("failed to create grpc client", "error", err)
 	}
 
-	c := pb.NewMetricsClient(conn)
+	c := pbv2.NewMetricsClient(conn)
 	return client.NewGRPCReporter(c), conn
 }
@@ -2,23 +2,25 @@ package main
 
 import (
 	"context"
-	"errors"
-	"net"
-	"net/http"
 	"net/http/pprof"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/sotavant/yandex-metrics/internal"
 	"github.com/sotavant/yandex-metrics/internal/server"
+	"github.com/sotavant/yandex-metrics/internal/server/auth"
 	grpc2 "github.com/sotavant/yandex-metrics/internal/server/grpc"
 	"github.com/sotavant/yandex-metrics/internal/server/handlers"
 	"github.com/sotavant/yandex-metrics/internal/server/metric"
 	"github.com/sotavant/yandex-metrics/internal/server/middleware"
+	"github.com/sotavant/yandex-metrics/internal/server/repository"
+	"github.com/sotavant/yandex-metrics/internal/server/storage"
 	"github.com/sotavant/yandex-metrics/internal/utils"
 	pb "github.com/sotavant/yandex-metrics/proto"
+	pbv2 "github.com/sotavant/yandex-metrics/proto/v2"
 	"google.golang.org/grpc"
 )
 
@@ -32,10 +34,10 @@ var (
 	buildCommit  string
 )
 
+// keyDirCheckInterval как часто проверяется каталог ключей
+const keyDirCheckInterval = 10 * time.Second
+
 func main() {
-	var listen net.Listener
-	var srv http.Server
-	var s *grpc.Server
 	internal.PrintBuildInfo(buildVersion, buildDate, buildCommit)
 	ctx := context.Background()
 	internal.InitLogger()
@@ -45,17 +47,7 @@ func main() {
 		panic(err)
 	}
 
-	if appInstance.Config.UseGRPC {
-		listen, err = net.Listen("tcp", appInstance.Config.Addr)
-		if err != nil {
-			internal.Logger.Fatalw("failed to listen", "err", err)
-		}
-
-		s = initGRPCServer(appInstance)
-	} else {
-		r := initRouters(appInstance)
-		srv = http.Server{Addr: appInstance.Config.Addr, Handler: r}
-	}
+	srvs := newServers(appInstance)
 
 	jobsDone := make(chan struct{})
 	sigint := make(chan os.Signal, 1)
@@ -63,30 +55,22 @@ func main() {
 
 	go func() {
 		<-sigint
-		if appInstance.Config.UseGRPC {
-			s.GracefulStop()
-		} else {
-			if err = srv.Shutdown(ctx); err != nil {
-				internal.Logger.Infow("shutdown err", "err", err)
-			}
-		}
+		// потоки обновлений держат соединения открытыми, закрываем их до остановки серверов
+		appInstance.Broker.Close()
+		srvs.shutdown(ctx)
 
 		appInstance.SyncFs(ctx)
+		appInstance.CloseStorage()
 		close(jobsDone)
 		internal.Logger.Infow("shutdown complete")
 	}()
 
-	go func() {
-		if appInstance.Config.UseGRPC {
-			if err = s.Serve(listen); err != nil {
-				internal.Logger.Fatalw("failed to grpc serve", "err", err)
-			}
-		} else {
-			if err = srv.ListenAndServe(); err != nil && !errors.Is(http.ErrServerClosed, err) {
-				internal.Logger.Infow("http server err", "err", err)
-			}
-		}
-	}()
+	srvs.serve()
+
+	if appInstance.KeyRing != nil {
+		go appInstance.KeyRing.Watch(ctx, keyDirCheckInterval)
+		go reloadKeysOnHUP(appInstance.KeyRing)
+	}
 
 	go func() {
 		if appInstance.Fs == nil {
@@ -97,68 +81,149 @@ func main() {
 		}
 	}()
 
+	go func() {
+		if appInstance.History == nil {
+			return
+		}
+
+		storage.CompactByInterval(ctx, appInstance.History, repository.CompactInterval(appInstance.HistoryTiers))
+	}()
+
+	go func() {
+		if appInstance.Config.EvictTTL == 0 {
+			return
+		}
+
+		storage.EvictByInterval(ctx, appInstance.Storage, appInstance.Fs, appInstance.Config.EvictTTL, appInstance.Cardinality.Forget)
+	}()
+
 	<-jobsDone
 }
 
-func initRouters(app *server.App) *chi.Mux {
+// reloadKeysOnHUP перечитывает каталог ключей по сигналу SIGHUP
+func reloadKeysOnHUP(ring *utils.KeyRing) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
 
-	r := chi.NewRouter()
+	for range sighup {
+		if err := ring.Reload(); err != nil {
+			internal.Logger.Infow("key ring reload error", "err", err)
+			continue
+		}
 
-	hasher := middleware.NewHasher(app.Config.HashKey)
-	crypto, err := middleware.NewCrypto(app.Config.CryptoKeyPath)
-	ipChecker := middleware.NewIPChecker(app.Config.TrustedSubnet)
-	metricService := metric.NewMetricService(app.Storage)
+		internal.Logger.Infow("key ring reloaded", "keys", ring.KeyIDs())
+	}
+}
 
+// newSecurity конвейер безопасности, общий для HTTP и gRPC: фильтр IP, сертификат клиента, ключ агента,
+// токен доступа, лимиты агента, расшифровка, подпись. Лимиты стоят после токена, чтобы считать запросы
+// по принятому токену, а не по любому из заголовка, и до расшифровки, чтобы запросы сверх лимита
+// не стоили расшифровки и проверки подписи. В HTTP права токена и лимит метрик подключаются в initRouters.
+// Nonce подписанных запросов учитываются одним guard для обоих протоколов.
+func newSecurity(app *server.App, limiter *middleware.RateLimiter) *middleware.Pipeline {
+	crypto, err := middleware.NewCrypto(app.Config.CryptoKeyPath, app.Config.CryptoLegacy)
 	if err != nil {
 		internal.Logger.Fatalw("crypto initialization failed", "error", err)
 	}
 
-	if ipChecker != nil {
-		r.Use(ipChecker.CheckIP)
-	}
+	hasher := middleware.NewHasher(app.Config.HashKey).
+		WithAgentKeys(app.AgentKeys).
+		WithStrict(app.Config.SignStrict).
+		WithReplayGuard(middleware.NewReplayGuard(app.Config.SignSkew, middleware.DefaultNonceCacheSize))
+
+	return middleware.NewPipeline(
+		middleware.NewIPChecker(app.Config.TrustedSubnet).
+			WithTrustedProxies(app.Config.TrustedProxies).
+			WithDenylist(app.Config.DeniedSubnets).
+			Stage(),
+		middleware.NewClientCert().Stage(),
+		middleware.NewAgentKeys().WithTrustedProxies(app.Config.TrustedProxies).Stage(),
+		middleware.NewTokenAuth(app.Tokens).Stage(),
+		limiter.Stage(),
+		crypto.WithKeyRing(app.KeyRing).Stage(),
+		hasher.Stage(),
+	)
+}
 
-	r.Use(crypto.Handler)
-	r.Use(hasher.Handler)
+// newRateLimiter лимиты запросов и метрик агентов, общие для HTTP и gRPC
+func newRateLimiter(app *server.App) *middleware.RateLimiter {
+	return middleware.NewRateLimiter(app.Config.RateRequests, app.Config.RateMetrics).
+		WithTrustedProxies(app.Config.TrustedProxies)
+}
+
+func initRouters(app *server.App, security *middleware.Pipeline, limiter *middleware.RateLimiter) *chi.Mux {
+
+	r := chi.NewRouter()
+	metricService := newMetricService(app)
+
+	r.Use(security.Handler)
 	r.Use(middleware.GzipMiddleware)
 	r.Use(middleware.WithLogging)
+	r.Use(limiter.MetricsHandler)
 
-	r.Post("/update/{type}/{name}/{value}", handlers.UpdateHandler(app))
-	r.Get("/value/{type}/{name}", handlers.GetValueHandler(app))
-	r.Post("/update/", handlers.UpdateJSONHandler(app, metricService))
-	r.Post("/updates/", handlers.UpdateBatchJSONHandler(app))
-	r.Post("/value/", handlers.GetValueJSONHandler(app))
-	r.Get("/", handlers.GetValuesHandler(app))
 	r.Get("/ping", handlers.PingDBHandler(app.DBConn))
 
-	initProfiling(r)
+	tokenAuth := middleware.NewTokenAuth(app.Tokens)
+	r.Group(func(r chi.Router) {
+		r.Use(tokenAuth.Require(auth.ScopeWrite))
+		r.Post("/update/{type}/{name}/{value}", handlers.UpdateHandler(app, metricService))
+		r.Post("/update/", handlers.UpdateJSONHandler(app, metricService))
+		r.Post("/updates/", handlers.UpdateBatchJSONHandler(app, metricService))
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(tokenAuth.Require(auth.ScopeRead))
+		r.Get("/value/{type}/{name}", handlers.GetValueHandler(app))
+		r.Post("/value/", handlers.GetValueJSONHandler(app))
+		r.Get("/", handlers.GetValuesHandler(app))
+		r.Get("/api/v1/query_range", handlers.QueryRangeHandler(metricService))
+		r.Get("/api/v1/metrics", handlers.ListMetricsHandler(metricService))
+		r.Get("/api/v1/stream", handlers.StreamHandler(app.Broker))
+	})
+
+	adminAuth := middleware.NewAdminAuth(app.Config.AdminToken).WithTokens(app.Tokens)
+	r.Group(func(r chi.Router) {
+		r.Use(adminAuth.Handler)
+		r.Delete("/value/{type}/{name}", handlers.DeleteValueHandler(app, metricService))
+		r.Delete("/api/v1/metrics", handlers.DeleteMetricsHandler(app, metricService))
+		r.Post("/reset/counter/{name}", handlers.ResetCounterHandler(app, metricService))
+		r.Get("/api/v1/cardinality", handlers.CardinalityHandler(app.Cardinality))
+		initProfiling(r)
+	})
 
 	return r
 }
 
-func initProfiling(r *chi.Mux) {
+// newMetricService создает сервис метрик, публикующий записи в брокер приложения, с лимитом рядов приложения
+func newMetricService(app *server.App) *metric.MetricService {
+	return metric.NewMetricService(app.Storage).
+		WithStaleTTL(app.Config.StaleTTL).
+		WithBroker(app.Broker).
+		WithCardinality(app.Cardinality)
+}
+
+// initProfiling профили pprof, подключаются в группе администратора
+func initProfiling(r chi.Router) {
 	r.HandleFunc("/pprof/*", pprof.Index)
 	r.Handle("/pprof/heap", pprof.Handler("heap"))
 }
 
-func initGRPCServer(app *server.App) *grpc.Server {
-	var ch *utils.Cipher
-	var err error
-	var interceptors []grpc.UnaryServerInterceptor
-
-	ch, err = utils.NewCipher(app.Config.CryptoKeyPath, "", app.Config.CryptoCertPath)
+func initGRPCServer(app *server.App, security *middleware.Pipeline) *grpc.Server {
+	ch, err := utils.NewCipher(app.Config.CryptoKeyPath, "", app.Config.CryptoCertPath)
 	if err != nil {
 		internal.Logger.Fatalw("error initializing cipher", "err", err)
 	}
 
-	if app.Config.TrustedSubnet != "" {
-		ipChecker := middleware.NewIPChecker(app.Config.TrustedSubnet)
-		interceptors = append(interceptors, ipChecker.CheckIPInterceptor)
-	}
-
-	s := grpc.NewServer(grpc.Creds(ch.GetServerGRPCTransportCreds()), grpc.ChainUnaryInterceptor(interceptors...))
-	ms := metric.NewMetricService(app.Storage)
+	s := grpc.NewServer(
+		grpc.Creds(ch.GetServerGRPCTransportCreds()),
+		grpc.ChainUnaryInterceptor(security.UnaryInterceptors()...),
+		grpc.ChainStreamInterceptor(security.StreamInterceptors()...),
+	)
+	ms := newMetricService(app)
 
+	// первая версия API остается зарегистрированной, пока клиенты переходят на v2
 	pb.RegisterMetricsServer(s, grpc2.NewMetricServer(ms))
+	pbv2.RegisterMetricsServer(s, grpc2.NewMetricServerV2(ms))
 
 	return s
 }
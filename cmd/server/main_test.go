@@ -5,9 +5,12 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/sotavant/yandex-metrics/internal"
 	"github.com/sotavant/yandex-metrics/internal/server"
+	"github.com/sotavant/yandex-metrics/internal/server/broker"
 	"github.com/sotavant/yandex-metrics/internal/server/config"
 	"github.com/sotavant/yandex-metrics/internal/server/handlers"
+	"github.com/sotavant/yandex-metrics/internal/server/metric"
 	"github.com/sotavant/yandex-metrics/internal/server/repository/memory"
 	storage2 "github.com/sotavant/yandex-metrics/internal/server/storage"
 	"github.com/stretchr/testify/assert"
@@ -51,7 +54,7 @@ func Test_badTypeHandler(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			request := httptest.NewRequest(http.MethodPost, tt.request, nil)
 			w := httptest.NewRecorder()
-			h := http.HandlerFunc(handlers.UpdateHandler(appInstanse))
+			h := http.HandlerFunc(handlers.UpdateHandler(appInstanse, metric.NewMetricService(appInstanse.Storage)))
 			h(w, request)
 			result := w.Result()
 			defer func() {
@@ -68,3 +71,45 @@ func Test_badTypeHandler(t *testing.T) {
 		})
 	}
 }
+
+func Test_initRoutersProfiling(t *testing.T) {
+	internal.InitLogger()
+
+	app := &server.App{
+		Config:  &config.Config{AdminToken: "admin"},
+		Storage: memory.NewMetricsRepository(),
+		Broker:  broker.New(),
+	}
+	defer app.Broker.Close()
+
+	limiter := newRateLimiter(app)
+	r := initRouters(app, newSecurity(app, limiter), limiter)
+
+	tests := []struct {
+		name       string
+		token      string
+		wantStatus int
+	}{
+		{
+			name:       "without token",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "admin token",
+			token:      "admin",
+			wantStatus: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/pprof/heap", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assert.Equal(t, tt.wantStatus, w.Code)
+		})
+	}
+}
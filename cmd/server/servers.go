@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/server"
+	"github.com/sotavant/yandex-metrics/internal/server/config"
+	"github.com/sotavant/yandex-metrics/internal/utils"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+)
+
+// servers HTTP- и gRPC-серверы приложения с общим состоянием App
+type servers struct {
+	http         *http.Server
+	httpListener net.Listener
+	grpc         *grpc.Server
+	// grpcListener nil, если gRPC обслуживается HTTP-сервером на общем порту
+	grpcListener net.Listener
+	// tls HTTP-сервер работает по TLS: включен HTTPS или общий порт с сертификатом gRPC
+	tls bool
+	// certPath и keyPath сертификат и ключ для tls
+	certPath, keyPath string
+}
+
+// listenAddrs адреса HTTP и gRPC, пустой адрес - протокол не обслуживается.
+// Флаг UseGRPC без отдельного адреса gRPC сохраняет прежний режим: только gRPC на основном адресе.
+func listenAddrs(conf *config.Config) (httpAddr, grpcAddr string) {
+	if conf.UseGRPC && conf.GRPCAddr == "" {
+		return "", conf.Addr
+	}
+
+	return conf.Addr, conf.GRPCAddr
+}
+
+// newServers создает серверы и открывает их адреса. Если адреса HTTP и gRPC совпадают, оба протокола
+// обслуживаются одним HTTP-сервером: запросы HTTP/2 с Content-Type application/grpc уходят в gRPC.
+func newServers(app *server.App) *servers {
+	s := &servers{}
+	httpAddr, grpcAddr := listenAddrs(app.Config)
+	limiter := newRateLimiter(app)
+	security := newSecurity(app, limiter)
+
+	if grpcAddr != "" {
+		s.grpc = initGRPCServer(app, security)
+	}
+
+	if httpAddr != "" {
+		s.http = &http.Server{Handler: initRouters(app, security, limiter)}
+		s.httpListener = listen(httpAddr)
+	}
+
+	s.certPath, s.keyPath = app.Config.CryptoCertPath, app.Config.CryptoKeyPath
+	hasCert := s.certPath != "" && s.keyPath != ""
+	if app.Config.HTTPS && !hasCert {
+		internal.Logger.Fatalw("https requires certificate and key", "cert", s.certPath, "key", s.keyPath)
+	}
+
+	switch {
+	case grpcAddr == "":
+		s.tls = app.Config.HTTPS
+	case grpcAddr == httpAddr:
+		s.http.Handler = grpcHandler(s.grpc, s.http.Handler)
+		s.tls = hasCert
+
+		// без TLS HTTP/2 принимается в открытом виде (h2c), иначе согласуется через ALPN
+		if !s.tls {
+			s.http.Handler = h2c.NewHandler(s.http.Handler, &http2.Server{})
+		}
+	default:
+		s.grpcListener = listen(grpcAddr)
+		s.tls = app.Config.HTTPS
+	}
+
+	if s.http != nil && s.tls {
+		tlsConfig, err := utils.NewServerTLSConfig(app.Config.ClientCAPath)
+		if err != nil {
+			internal.Logger.Fatalw("failed to load client CA", "err", err)
+		}
+
+		s.http.TLSConfig = tlsConfig
+	}
+
+	return s
+}
+
+func listen(addr string) net.Listener {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		internal.Logger.Fatalw("failed to listen", "addr", addr, "err", err)
+	}
+
+	return lis
+}
+
+// grpcHandler направляет gRPC-запросы в grpcServer, остальные - в next
+func grpcHandler(grpcServer *grpc.Server, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			grpcServer.ServeHTTP(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serve запускает серверы в отдельных горутинах
+func (s *servers) serve() {
+	if s.grpcListener != nil {
+		go func() {
+			if err := s.grpc.Serve(s.grpcListener); err != nil {
+				internal.Logger.Fatalw("failed to grpc serve", "err", err)
+			}
+		}()
+	}
+
+	if s.http != nil {
+		go func() {
+			var err error
+			if s.tls {
+				err = s.http.ServeTLS(s.httpListener, s.certPath, s.keyPath)
+			} else {
+				err = s.http.Serve(s.httpListener)
+			}
+
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				internal.Logger.Infow("http server err", "err", err)
+			}
+		}()
+	}
+}
+
+// shutdown параллельно останавливает серверы и ждет завершения начатых запросов
+func (s *servers) shutdown(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	if s.http != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.http.Shutdown(ctx); err != nil {
+				internal.Logger.Infow("shutdown err", "err", err)
+			}
+		}()
+	}
+
+	if s.grpcListener != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.grpc.GracefulStop()
+		}()
+	}
+
+	wg.Wait()
+
+	// на общем порту gRPC-запросы завершаются вместе с HTTP-сервером, а GracefulStop
+	// не поддерживает соединения из ServeHTTP
+	if s.grpc != nil && s.grpcListener == nil {
+		s.grpc.Stop()
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/server"
+	"github.com/sotavant/yandex-metrics/internal/server/broker"
+	"github.com/sotavant/yandex-metrics/internal/server/config"
+	"github.com/sotavant/yandex-metrics/internal/server/repository/memory"
+	pbv2 "github.com/sotavant/yandex-metrics/proto/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func Test_listenAddrs(t *testing.T) {
+	tests := []struct {
+		name     string
+		conf     config.Config
+		wantHTTP string
+		wantGRPC string
+	}{
+		{
+			name:     "http only",
+			conf:     config.Config{Addr: ":8080"},
+			wantHTTP: ":8080",
+		},
+		{
+			name:     "grpc only",
+			conf:     config.Config{Addr: ":8080", UseGRPC: true},
+			wantGRPC: ":8080",
+		},
+		{
+			name:     "separate addresses",
+			conf:     config.Config{Addr: ":8080", GRPCAddr: ":3200", UseGRPC: true},
+			wantHTTP: ":8080",
+			wantGRPC: ":3200",
+		},
+		{
+			name:     "shared port",
+			conf:     config.Config{Addr: ":8080", GRPCAddr: ":8080"},
+			wantHTTP: ":8080",
+			wantGRPC: ":8080",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			httpAddr, grpcAddr := listenAddrs(&tt.conf)
+			assert.Equal(t, tt.wantHTTP, httpAddr)
+			assert.Equal(t, tt.wantGRPC, grpcAddr)
+		})
+	}
+}
+
+func Test_servers(t *testing.T) {
+	internal.InitLogger()
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		grpcAddr string
+	}{
+		{
+			name:     "shared port",
+			grpcAddr: "127.0.0.1:0",
+		},
+		{
+			name:     "separate ports",
+			grpcAddr: "localhost:0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &server.App{
+				Config:  &config.Config{Addr: "127.0.0.1:0", GRPCAddr: tt.grpcAddr},
+				Storage: memory.NewMetricsRepository(),
+				Broker:  broker.New(),
+			}
+
+			srvs := newServers(app)
+			srvs.serve()
+
+			httpAddr := srvs.httpListener.Addr().String()
+			grpcAddr := httpAddr
+			if srvs.grpcListener != nil {
+				grpcAddr = srvs.grpcListener.Addr().String()
+			}
+
+			resp, err := http.Post("http://"+httpAddr+"/update/counter/requests/2", "text/plain", nil)
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			require.NoError(t, resp.Body.Close())
+
+			conn, err := grpc.NewClient(grpcAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+			require.NoError(t, err)
+
+			client := pbv2.NewMetricsClient(conn)
+			m, err := client.UpdateMetric(ctx, &pbv2.Metric{Id: "requests", Value: &pbv2.Metric_Counter{Counter: 3}})
+			require.NoError(t, err)
+			assert.Equal(t, int64(5), m.GetCounter())
+			require.NoError(t, conn.Close())
+
+			app.Broker.Close()
+			srvs.shutdown(ctx)
+
+			_, err = http.Get("http://" + httpAddr + "/")
+			assert.Error(t, err)
+		})
+	}
+}
@@ -3,6 +3,7 @@ package client
 import (
 	"context"
 	"errors"
+	"io"
 	"os"
 	"syscall"
 	"time"
@@ -10,26 +11,35 @@ import (
 	"github.com/sotavant/yandex-metrics/internal"
 	"github.com/sotavant/yandex-metrics/internal/agent/config"
 	"github.com/sotavant/yandex-metrics/internal/agent/storage"
+	"github.com/sotavant/yandex-metrics/internal/pbconv"
 	"github.com/sotavant/yandex-metrics/internal/utils"
-	pb "github.com/sotavant/yandex-metrics/proto"
+	pbv2 "github.com/sotavant/yandex-metrics/proto/v2"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
+// GRPCReporter отправляет метрики через API yandex_metrics.v2.Metrics
 type GRPCReporter struct {
-	c pb.MetricsClient
+	c pbv2.MetricsClient
 }
 
-func NewGRPCReporter(c pb.MetricsClient) *GRPCReporter {
+func NewGRPCReporter(c pbv2.MetricsClient) *GRPCReporter {
 	return &GRPCReporter{
 		c: c,
 	}
 }
 
-// ReportMetric отправляет метрики по протоколу gRPC.
-// На вход принимает хранилище и количество воркеров (параллельных процессов)
+// maxBatchLen максимальное количество метрик, отправляемых одним UpdateMetricsBatch.
+// Больший набор отправляется потоком UpdateMetrics.
+const maxBatchLen = 1000
+
+// ReportMetric отправляет метрики по протоколу gRPC одним пакетом или потоком.
+// Количество воркеров не используется: все метрики уходят одним вызовом.
 func (r *GRPCReporter) ReportMetric(ms *storage.MetricsStorage, workerCount int, sigs chan os.Signal) bool {
 	for {
-		r.sendMetricsByGRPCWorkers(ms, workerCount)
+		r.sendMetrics(ms)
 		select {
 		case <-sigs:
 			return true
@@ -39,67 +49,37 @@ func (r *GRPCReporter) ReportMetric(ms *storage.MetricsStorage, workerCount int,
 	}
 }
 
-func (r *GRPCReporter) sendMetricsByGRPCWorkers(ms *storage.MetricsStorage, workersCount int) {
-	m := collectMetrics(ms)
-	if len(m) == 0 {
-		return
-	}
-
-	jobs := make(chan internal.Metrics, len(m))
-
-	for w := 0; w < workersCount; w++ {
-		go r.gRPCWorker(jobs)
-	}
-
-	for _, metric := range m {
-		jobs <- metric
-	}
-
-	close(jobs)
-}
-
-func (r *GRPCReporter) gRPCWorker(jobs <-chan internal.Metrics) {
-	for j := range jobs {
-		r.sendGRPCRequest(j)
-	}
-}
-
-func (r *GRPCReporter) sendGRPCRequest(m internal.Metrics) {
+func (r *GRPCReporter) sendMetrics(ms *storage.MetricsStorage) {
 	var err error
-	var val float64
-	var delta int64
 	intervals := utils.GetRetryWaitTimes()
 	retries := len(intervals)
 	retries++
 	counter := 1
 
-	md := r.SetMetadata(m)
-	ctx := metadata.NewOutgoingContext(context.Background(), md)
-
-	if m.Value != nil {
-		val = *m.Value
-	}
-
-	if m.Delta != nil {
-		delta = *m.Delta
+	m := collectMetrics(ms)
+	if len(m) == 0 {
+		return
 	}
 
-	pbMetric := pb.Metric{
-		Value: val,
-		Delta: delta,
-		ID:    m.ID,
-		MType: m.MType,
+	send := r.sendBatch
+	if len(m) > maxBatchLen {
+		send = r.sendStream
 	}
 
 	for counter <= retries {
 		internal.Logger.Infoln("sending request")
-		_, err = r.c.UpdateMetric(ctx, &pb.UpdateMetricRequest{Metric: &pbMetric})
+		err = send(m)
 
 		if err != nil {
 			internal.Logger.Infoln("error in request", err)
-			if errors.Is(err, syscall.ECONNREFUSED) {
+			if errors.Is(err, syscall.ECONNREFUSED) || status.Code(err) == codes.Unavailable {
 				time.Sleep(time.Duration(intervals[counter]) * time.Second)
 				counter++
+			} else if status.Code(err) == codes.ResourceExhausted {
+				wait := retryDelay(err)
+				internal.Logger.Infow("rate limited by server", "retry_after", wait)
+				time.Sleep(wait)
+				counter++
 			} else {
 				break
 			}
@@ -108,30 +88,89 @@ func (r *GRPCReporter) sendGRPCRequest(m internal.Metrics) {
 		}
 	}
 
+	// сервер перегружен запросами агента: метрики уйдут в следующий раз
+	if status.Code(err) == codes.ResourceExhausted {
+		internal.Logger.Infow("metrics are not sent, rate limit exceeded", "err", err)
+		return
+	}
+
 	if err != nil {
 		internal.Logger.Fatalw("failed to update metrics", "err", err)
 	}
 }
 
-func (r *GRPCReporter) SetMetadata(m internal.Metrics) metadata.MD {
+// sendBatch отправляет метрики одним вызовом UpdateMetricsBatch, подпись пакета передается в метаданных
+func (r *GRPCReporter) sendBatch(m []internal.Metrics) error {
+	batch := &pbv2.MetricsBatch{Metrics: make([]*pbv2.Metric, 0, len(m))}
+	for _, metric := range m {
+		batch.Metrics = append(batch.Metrics, pbconv.MetricToV2(metric))
+	}
+
+	md := r.addHashMetadata(m, r.SetMetadata())
+	ctx := metadata.NewOutgoingContext(context.Background(), md)
+
+	_, err := r.c.UpdateMetricsBatch(ctx, batch)
+	return err
+}
+
+// sendStream отправляет метрики потоком UpdateMetrics, каждая метрика подписывается отдельно
+// с общими для потока меткой времени и nonce
+func (r *GRPCReporter) sendStream(m []internal.Metrics) error {
+	md := r.SetMetadata()
+	sig := addSignatureMetadata(md)
+	ctx := metadata.NewOutgoingContext(context.Background(), md)
+
+	stream, err := r.c.UpdateMetrics(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, metric := range m {
+		pbMetric := pbconv.MetricToV2(metric)
+		if pbMetric.Hash, err = getMetricHash(metric, sig); err != nil {
+			return err
+		}
+
+		// io.EOF означает, что сервер завершил поток, причину вернет CloseAndRecv
+		if err = stream.Send(pbMetric); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	_, err = stream.CloseAndRecv()
+	return err
+}
+
+// SetMetadata метаданные, общие для всех вызовов агента
+func (r *GRPCReporter) SetMetadata() metadata.MD {
 	ip, err := utils.GetLocalIP()
 	if err != nil {
 		internal.Logger.Fatalw("get local ip error", "err", err)
 	}
 
 	md := metadata.Pairs("X-Real-IP", ip.String())
-	md = r.addHashMetadata(m, md)
+	if config.AppConfig.AgentID != "" {
+		md.Set(utils.AgentIDHeaderKey, config.AppConfig.AgentID)
+	}
+
+	if config.AppConfig.Token != "" {
+		md.Set("authorization", "Bearer "+config.AppConfig.Token)
+	}
 
 	return md
 }
 
-func (r *GRPCReporter) addHashMetadata(m internal.Metrics, md metadata.MD) metadata.MD {
+// addHashMetadata добавляет подпись пакета метрик с меткой времени и nonce, если задан ключ
+func (r *GRPCReporter) addHashMetadata(m []internal.Metrics, md metadata.MD) metadata.MD {
 	if config.AppConfig.HashKey == "" {
 		return md
 	}
 
-	//hash, err := utils.GetHash(metricsBuf.Bytes(), config.AppConfig.HashKey)
-	hash, err := utils.GetMetricHash(m, config.AppConfig.HashKey)
+	hash, err := utils.GetMetricsHash(m, config.AppConfig.HashKey, addSignatureMetadata(md))
 	if err != nil {
 		internal.Logger.Fatalw("get hash error", "err", err)
 	}
@@ -139,3 +178,39 @@ func (r *GRPCReporter) addHashMetadata(m internal.Metrics, md metadata.MD) metad
 	md.Set(utils.HasherHeaderKey, hash)
 	return md
 }
+
+// addSignatureMetadata добавляет новые метку времени и nonce, если задан ключ
+func addSignatureMetadata(md metadata.MD) utils.Signature {
+	if config.AppConfig.HashKey == "" {
+		return utils.Signature{}
+	}
+
+	sig, err := utils.NewSignature()
+	if err != nil {
+		internal.Logger.Fatalw("create signature error", "err", err)
+	}
+
+	md.Set(utils.TimestampHeaderKey, sig.TimestampString())
+	md.Set(utils.NonceHeaderKey, sig.Nonce)
+	return sig
+}
+
+// retryDelay ожидание из RetryInfo в деталях статуса, без него - как для пустого Retry-After
+func retryDelay(err error) time.Duration {
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok && info.RetryDelay != nil {
+			return utils.ClampRetryAfter(info.RetryDelay.AsDuration())
+		}
+	}
+
+	return utils.RetryAfter("", time.Now())
+}
+
+// getMetricHash подпись одной метрики потока, без ключа подпись пустая
+func getMetricHash(m internal.Metrics, sig utils.Signature) (string, error) {
+	if config.AppConfig.HashKey == "" {
+		return "", nil
+	}
+
+	return utils.GetMetricHash(m, config.AppConfig.HashKey, sig)
+}
@@ -2,14 +2,28 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
+	"net"
 	"testing"
 
 	"github.com/sotavant/yandex-metrics/internal"
 	"github.com/sotavant/yandex-metrics/internal/agent/config"
+	"github.com/sotavant/yandex-metrics/internal/agent/storage"
+	grpc2 "github.com/sotavant/yandex-metrics/internal/server/grpc"
+	"github.com/sotavant/yandex-metrics/internal/server/metric"
+	"github.com/sotavant/yandex-metrics/internal/server/middleware"
+	"github.com/sotavant/yandex-metrics/internal/server/repository/memory"
 	"github.com/sotavant/yandex-metrics/internal/utils"
+	pbv2 "github.com/sotavant/yandex-metrics/proto/v2"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
 )
 
 func TestGRPCReporter_addHashMetadata(t *testing.T) {
@@ -51,7 +65,7 @@ func TestGRPCReporter_addHashMetadata(t *testing.T) {
 			r := NewGRPCReporter(nil)
 
 			md := metadata.Pairs()
-			md = r.addHashMetadata(m, md)
+			md = r.addHashMetadata([]internal.Metrics{m}, md)
 
 			assert.Len(t, md.Get(utils.HasherHeaderKey), tt.wantCount)
 
@@ -61,13 +75,113 @@ func TestGRPCReporter_addHashMetadata(t *testing.T) {
 
 			var encodedMD bytes.Buffer
 			enc := gob.NewEncoder(&encodedMD)
-			err := enc.Encode(m)
+			err := enc.Encode([]internal.Metrics{m})
 			assert.NoError(t, err)
 
-			hash, err := utils.GetHash(encodedMD.Bytes(), tt.key)
+			sig, err := utils.ParseSignature(md.Get(utils.TimestampHeaderKey)[0], md.Get(utils.NonceHeaderKey)[0])
+			require.NoError(t, err)
+
+			hash, err := utils.GetSignedHash(encodedMD.Bytes(), tt.key, sig)
 			assert.NoError(t, err)
 
 			assert.Equal(t, hash, md.Get(utils.HasherHeaderKey)[0])
 		})
 	}
 }
+
+func TestGRPCReporter_send(t *testing.T) {
+	const hashKey = "hashKey"
+	internal.InitLogger()
+	ctx := context.Background()
+
+	ms := storage.NewStorage()
+	ms.Metrics["Alloc"] = 1.5
+	ms.Metrics["HeapInuse"] = 2
+	ms.PollCount = 3
+
+	tests := []struct {
+		name       string
+		clientKey  string
+		stream     bool
+		wantStatus codes.Code
+	}{
+		{
+			name:       "batch",
+			clientKey:  hashKey,
+			wantStatus: codes.OK,
+		},
+		{
+			name:       "stream",
+			clientKey:  hashKey,
+			stream:     true,
+			wantStatus: codes.OK,
+		},
+		{
+			name:       "batch with wrong key",
+			clientKey:  "wrong",
+			wantStatus: codes.InvalidArgument,
+		},
+		{
+			name:       "stream with wrong key",
+			clientKey:  "wrong",
+			stream:     true,
+			wantStatus: codes.InvalidArgument,
+		},
+		{
+			name:       "stream without key",
+			stream:     true,
+			wantStatus: codes.Unauthenticated,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := memory.NewMetricsRepository()
+			hasher := middleware.NewHasher(hashKey)
+
+			lis := bufconn.Listen(1024 * 1024)
+			s := grpc.NewServer(
+				grpc.UnaryInterceptor(hasher.CheckHashInterceptor),
+				grpc.StreamInterceptor(hasher.CheckHashStreamInterceptor),
+			)
+			pbv2.RegisterMetricsServer(s, grpc2.NewMetricServerV2(metric.NewMetricService(st)))
+			go func() {
+				assert.NoError(t, s.Serve(lis))
+			}()
+			defer s.Stop()
+
+			conn, err := grpc.NewClient("passthrough://bufnet",
+				grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+				grpc.WithTransportCredentials(insecure.NewCredentials()))
+			require.NoError(t, err)
+			defer func() {
+				assert.NoError(t, conn.Close())
+			}()
+
+			config.AppConfig = &config.Config{HashKey: tt.clientKey}
+			r := NewGRPCReporter(pbv2.NewMetricsClient(conn))
+
+			send := r.sendBatch
+			if tt.stream {
+				send = r.sendStream
+			}
+
+			err = send(collectMetrics(ms))
+			assert.Equal(t, tt.wantStatus, status.Code(err))
+
+			gauges, err := st.GetGauge(ctx)
+			require.NoError(t, err)
+			counters, err := st.GetCounters(ctx)
+			require.NoError(t, err)
+
+			if tt.wantStatus != codes.OK {
+				assert.Empty(t, gauges)
+				assert.Empty(t, counters)
+				return
+			}
+
+			assert.Equal(t, map[string]float64{"Alloc": 1.5, "HeapInuse": 2}, gauges)
+			assert.Equal(t, map[string]int64{poolCounterName: 3}, counters)
+		})
+	}
+}
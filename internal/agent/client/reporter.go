@@ -5,7 +5,9 @@ import (
 	"bytes"
 	"compress/gzip"
 	"errors"
+	"net/http"
 	"os"
+	"sync"
 	"syscall"
 	"time"
 
@@ -30,6 +32,11 @@ var json = jsoniter.ConfigCompatibleWithStandardLibrary
 
 type Reporter struct {
 	ch *utils.Cipher
+
+	clientOnce sync.Once
+	client     *resty.Client
+	// scheme http:// или https://
+	scheme string
 }
 
 func NewReporter(ch *utils.Cipher) *Reporter {
@@ -144,18 +151,24 @@ func (r *Reporter) sendRequest(jsonData []byte, url string) {
 		internal.Logger.Fatalw("get local ip error", "err", err)
 	}
 
-	client := resty.New()
-	req := client.R().
-		SetHeader("Content-Type", "application/json").
-		SetHeader("Content-Encoding", "gzip").
-		SetHeader("X-Real-IP", ip.String())
-
-	req = addHashData(req, data)
-	req = r.addCipheredData(req, data)
+	client, scheme := r.httpClient()
 
 	for counter <= retries {
+		// подпись собирается заново для каждой попытки: сервер не принимает повторный nonce
+		req := client.R().
+			SetHeader("Content-Type", "application/json").
+			SetHeader("Content-Encoding", "gzip").
+			SetHeader("X-Real-IP", ip.String())
+
+		if config.AppConfig.Token != "" {
+			req.SetAuthToken(config.AppConfig.Token)
+		}
+
+		req = addHashData(req, data)
+		req = r.addCipheredData(req, data)
+
 		internal.Logger.Infoln("sending request", string(jsonData))
-		_, err = req.Post("http://" + config.AppConfig.Addr + url)
+		resp, err := req.Post(scheme + config.AppConfig.Addr + url)
 
 		if err != nil {
 			internal.Logger.Infoln("error in request", err)
@@ -165,12 +178,41 @@ func (r *Reporter) sendRequest(jsonData []byte, url string) {
 			} else {
 				break
 			}
+		} else if resp.StatusCode() == http.StatusTooManyRequests {
+			wait := utils.RetryAfter(resp.Header().Get("Retry-After"), time.Now())
+			internal.Logger.Infow("rate limited by server", "retry_after", wait)
+			time.Sleep(wait)
+			counter++
 		} else {
 			break
 		}
 	}
 }
 
+// httpClient клиент для всех запросов агента. С HTTPS сервер проверяется по CryptoCertPath
+// (без него - по системным сертификатам), клиент предъявляет свой сертификат, если он задан.
+func (r *Reporter) httpClient() (*resty.Client, string) {
+	r.clientOnce.Do(func() {
+		r.client, r.scheme = resty.New(), "http://"
+		if !config.AppConfig.HTTPS {
+			return
+		}
+
+		tlsConfig, err := utils.NewClientTLSConfig(
+			config.AppConfig.CryptoCertPath,
+			config.AppConfig.ClientCertPath,
+			config.AppConfig.ClientKeyPath,
+		)
+		if err != nil {
+			internal.Logger.Fatalw("failed to init tls", "err", err)
+		}
+
+		r.client, r.scheme = r.client.SetTLSClientConfig(tlsConfig), "https://"
+	})
+
+	return r.client, r.scheme
+}
+
 func getCompressedData(data []byte) *bytes.Buffer {
 	buf := bytes.NewBuffer(nil)
 	zb := gzip.NewWriter(buf)
@@ -196,9 +238,10 @@ func (r *Reporter) addCipheredData(req *resty.Request, buf *bytes.Buffer) *resty
 			panic(err)
 		}
 		req.SetBody(cryptedData)
+		req.SetHeader(utils.KeyIDHeader, r.ch.KeyID())
 		return req
 	}
-	req.SetBody(buf)
+	req.SetBody(buf.Bytes())
 	return req
 }
 
@@ -207,13 +250,25 @@ func addHashData(req *resty.Request, buf *bytes.Buffer) *resty.Request {
 		return req
 	}
 
-	hash, err := utils.GetHash(buf.Bytes(), config.AppConfig.HashKey)
+	sig, err := utils.NewSignature()
+	if err != nil {
+		internal.Logger.Infoln("error in create signature", err)
+		panic(err)
+	}
+
+	hash, err := utils.GetSignedHash(buf.Bytes(), config.AppConfig.HashKey, sig)
 	if err != nil {
 		internal.Logger.Infoln("error in get hash", err)
 		panic(err)
 	}
 
-	req.SetHeader(utils.HasherHeaderKey, hash)
+	req.SetHeader(utils.HasherHeaderKey, hash).
+		SetHeader(utils.TimestampHeaderKey, sig.TimestampString()).
+		SetHeader(utils.NonceHeaderKey, sig.Nonce)
+
+	if config.AppConfig.AgentID != "" {
+		req.SetHeader(utils.AgentIDHeaderKey, config.AppConfig.AgentID)
+	}
 	return req
 }
 
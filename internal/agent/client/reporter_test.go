@@ -121,3 +121,32 @@ func TestReporter_sendRequestWithIP(t *testing.T) {
 
 	r.sendRequest([]byte("[]"), "/")
 }
+
+func TestReporter_sendRequestRateLimited(t *testing.T) {
+	internal.InitLogger()
+
+	var nonces []string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		nonces = append(nonces, req.Header.Get(utils.NonceHeaderKey))
+		if len(nonces) == 1 {
+			rw.Header().Set("Retry-After", "0")
+			rw.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	config.AppConfig = &config.Config{
+		Addr:      strings.TrimPrefix(server.URL, "http://"),
+		HashKey:   "key",
+		RateLimit: 1,
+	}
+
+	NewReporter(nil).sendRequest([]byte("[]"), "/")
+
+	// после 429 запрос повторяется с новой подписью, иначе сервер отклонит его как повтор
+	assert.Len(t, nonces, 2)
+	assert.NotEqual(t, nonces[0], nonces[1])
+}
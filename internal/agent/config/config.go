@@ -29,6 +29,12 @@ const (
 	CryptKeyVar      = `CRYPTO_KEY`
 	CryptCertVar     = `CRYPTO_CERT`
 	configPathKeyVar = `CONFIG`
+	CryptLegacyVar   = `CRYPTO_LEGACY`
+	AgentIDVar       = `AGENT_ID`
+	HTTPSVar         = `HTTPS`
+	ClientCertVar    = `CLIENT_CERT`
+	ClientKeyVar     = `CLIENT_KEY`
+	TokenVar         = `TOKEN`
 )
 
 // AppConfig глобальная переменная, в которой хранятся конфигурации.
@@ -41,6 +47,12 @@ type fileConfig struct {
 	ReportIntervalStr string `json:"report_interval"`
 	CryptoKey         string `json:"crypto_key"`
 	CryptoCert        string `json:"crypto_cert"`
+	AgentID           string `json:"agent_id"`
+	ClientCert        string `json:"client_cert"`
+	ClientKey         string `json:"client_key"`
+	Token             string `json:"token"`
+	CryptoLegacy      bool   `json:"crypto_legacy"`
+	HTTPS             bool   `json:"https"`
 }
 
 // Config структура для хранения настроек.
@@ -49,10 +61,16 @@ type Config struct {
 	HashKey        string
 	CryptoKeyPath  string
 	CryptoCertPath string
+	AgentID        string
+	ClientCertPath string
+	ClientKeyPath  string
+	Token          string
 	ReportInterval int
 	PollInterval   int
 	RateLimit      int
 	UseGRPC        bool
+	CryptoLegacy   bool
+	HTTPS          bool
 }
 
 // InitConfig инициализация значения конфигурации.
@@ -63,8 +81,9 @@ func InitConfig() {
 
 // ParseFlags считыванание значений либо из параметров запуска либо из переменных окружения
 func (c *Config) ParseFlags() {
-	var address, cryptoKey, cryptoCert, config, cnfShort string
+	var address, cryptoKey, cryptoCert, config, cnfShort, agentID, clientCert, clientKey, token string
 	var pullInterval, reportIntervalFlag int
+	var cryptoLegacy, https bool
 
 	flag.StringVar(&address, "a", "", "server address")
 	flag.StringVar(&cryptoKey, "crypto-key", "", "path to public key")
@@ -76,6 +95,12 @@ func (c *Config) ParseFlags() {
 	flag.StringVar(&config, "config", "", "path to config file")
 	flag.StringVar(&cnfShort, "c", "", "path to config file")
 	flag.BoolVar(&c.UseGRPC, "g", false, "use gRPC")
+	flag.StringVar(&agentID, "agent-id", "", "agent id, server checks hash with the key of this agent")
+	flag.BoolVar(&https, "https", false, "send metrics over HTTPS, server certificate is checked with -crypto-cert")
+	flag.StringVar(&clientCert, "client-cert", "", "path to client certificate for mutual TLS")
+	flag.StringVar(&clientKey, "client-key", "", "path to client certificate key for mutual TLS")
+	flag.StringVar(&token, "token", "", "access token with metrics:write scope")
+	flag.BoolVar(&cryptoLegacy, "crypto-legacy", false, "encrypt bodies with RSA PKCS#1 v1.5 without envelope")
 
 	flag.Parse()
 
@@ -97,6 +122,30 @@ func (c *Config) ParseFlags() {
 		c.CryptoCertPath = cryptoCert
 	}
 
+	if cryptoLegacy {
+		c.CryptoLegacy = cryptoLegacy
+	}
+
+	if agentID != "" {
+		c.AgentID = agentID
+	}
+
+	if https {
+		c.HTTPS = https
+	}
+
+	if clientCert != "" {
+		c.ClientCertPath = clientCert
+	}
+
+	if clientKey != "" {
+		c.ClientKeyPath = clientKey
+	}
+
+	if token != "" {
+		c.Token = token
+	}
+
 	if pullInterval != 0 {
 		c.PollInterval = pullInterval
 	} else if c.PollInterval == 0 {
@@ -149,6 +198,40 @@ func (c *Config) ParseFlags() {
 	if cryptoCertEnv := os.Getenv(CryptCertVar); cryptoCertEnv != "" {
 		c.CryptoCertPath = cryptoCertEnv
 	}
+
+	if agentIDEnv := os.Getenv(AgentIDVar); agentIDEnv != "" {
+		c.AgentID = agentIDEnv
+	}
+
+	if clientCertEnv := os.Getenv(ClientCertVar); clientCertEnv != "" {
+		c.ClientCertPath = clientCertEnv
+	}
+
+	if clientKeyEnv := os.Getenv(ClientKeyVar); clientKeyEnv != "" {
+		c.ClientKeyPath = clientKeyEnv
+	}
+
+	if tokenEnv := os.Getenv(TokenVar); tokenEnv != "" {
+		c.Token = tokenEnv
+	}
+
+	if httpsEnv := os.Getenv(HTTPSVar); httpsEnv != "" {
+		httpsEnvVal, err := strconv.ParseBool(httpsEnv)
+		if err == nil {
+			c.HTTPS = httpsEnvVal
+		} else {
+			internal.Logger.Infow("https convert error", "err", err)
+		}
+	}
+
+	if cryptoLegacyEnv := os.Getenv(CryptLegacyVar); cryptoLegacyEnv != "" {
+		cryptoLegacyEnvVal, err := strconv.ParseBool(cryptoLegacyEnv)
+		if err == nil {
+			c.CryptoLegacy = cryptoLegacyEnvVal
+		} else {
+			internal.Logger.Infow("crypto legacy convert error", "err", err)
+		}
+	}
 }
 
 // readFile чтение конфигурации из файла
@@ -195,6 +278,30 @@ func (c *Config) readConfig(configPath string) {
 		c.CryptoCertPath = fileCnf.CryptoCert
 	}
 
+	if fileCnf.CryptoLegacy {
+		c.CryptoLegacy = fileCnf.CryptoLegacy
+	}
+
+	if fileCnf.AgentID != "" {
+		c.AgentID = fileCnf.AgentID
+	}
+
+	if fileCnf.HTTPS {
+		c.HTTPS = fileCnf.HTTPS
+	}
+
+	if fileCnf.ClientCert != "" {
+		c.ClientCertPath = fileCnf.ClientCert
+	}
+
+	if fileCnf.ClientKey != "" {
+		c.ClientKeyPath = fileCnf.ClientKey
+	}
+
+	if fileCnf.Token != "" {
+		c.Token = fileCnf.Token
+	}
+
 	if fileCnf.Address != "" {
 		c.Addr = fileCnf.Address
 	}
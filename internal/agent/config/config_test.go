@@ -15,7 +15,13 @@ func TestConfig_ReadConfigFromFile(t *testing.T) {
   "address": "localhost:3456",
   "report_interval": "133s",
   "crypto_key": "somePath",
-  "poll_interval": "122s"
+  "poll_interval": "122s",
+  "crypto_legacy": true,
+  "agent_id": "agent-1",
+  "https": true,
+  "client_cert": "client.pem",
+  "client_key": "client-key.pem",
+  "token": "agent-token"
 }
 `
 	file, err := os.CreateTemp(os.TempDir(), "config")
@@ -40,6 +46,12 @@ func TestConfig_ReadConfigFromFile(t *testing.T) {
 				ReportInterval: 133,
 				PollInterval:   122,
 				CryptoKeyPath:  "somePath",
+				CryptoLegacy:   true,
+				AgentID:        "agent-1",
+				HTTPS:          true,
+				ClientCertPath: "client.pem",
+				ClientKeyPath:  "client-key.pem",
+				Token:          "agent-token",
 			},
 		},
 	}
@@ -51,6 +63,12 @@ func TestConfig_ReadConfigFromFile(t *testing.T) {
 			assert.Equal(t, tt.want.ReportInterval, AppConfig.ReportInterval)
 			assert.Equal(t, tt.want.CryptoKeyPath, AppConfig.CryptoKeyPath)
 			assert.Equal(t, tt.want.PollInterval, AppConfig.PollInterval)
+			assert.Equal(t, tt.want.CryptoLegacy, AppConfig.CryptoLegacy)
+			assert.Equal(t, tt.want.AgentID, AppConfig.AgentID)
+			assert.Equal(t, tt.want.HTTPS, AppConfig.HTTPS)
+			assert.Equal(t, tt.want.ClientCertPath, AppConfig.ClientCertPath)
+			assert.Equal(t, tt.want.ClientKeyPath, AppConfig.ClientKeyPath)
+			assert.Equal(t, tt.want.Token, AppConfig.Token)
 		})
 	}
 }
@@ -12,4 +12,6 @@ type Metrics struct {
 	Delta *int64   `json:"delta,omitempty"`
 	ID    string   `json:"id"`
 	MType string   `json:"type"`
+	// Stale метрика не обновлялась дольше заданного на сервере времени
+	Stale bool `json:"stale,omitempty"`
 }
@@ -0,0 +1,48 @@
+// Package pbconv преобразует метрики между internal.Metrics и сообщениями gRPC API версии v2
+package pbconv
+
+import (
+	"errors"
+
+	"github.com/sotavant/yandex-metrics/internal"
+	pbv2 "github.com/sotavant/yandex-metrics/proto/v2"
+)
+
+// ErrValueAbsent в сообщении не заполнено значение, поэтому неизвестен и тип метрики
+var ErrValueAbsent = errors.New("metric value is absent")
+
+// MetricFromV2 возвращает метрику с типом и значением из заполненного поля oneof.
+// Если значение не заполнено, возвращает метрику только с ID и ErrValueAbsent.
+func MetricFromV2(m *pbv2.Metric) (internal.Metrics, error) {
+	res := internal.Metrics{ID: m.GetId()}
+
+	switch v := m.GetValue().(type) {
+	case *pbv2.Metric_Gauge:
+		value := v.Gauge
+		res.MType = internal.GaugeType
+		res.Value = &value
+	case *pbv2.Metric_Counter:
+		delta := v.Counter
+		res.MType = internal.CounterType
+		res.Delta = &delta
+	default:
+		return res, ErrValueAbsent
+	}
+
+	return res, nil
+}
+
+// MetricToV2 заполняет значение по типу метрики. Метрика неизвестного типа или без значения
+// передается без значения.
+func MetricToV2(m internal.Metrics) *pbv2.Metric {
+	res := &pbv2.Metric{Id: m.ID, Stale: m.Stale}
+
+	switch {
+	case m.MType == internal.GaugeType && m.Value != nil:
+		res.Value = &pbv2.Metric_Gauge{Gauge: *m.Value}
+	case m.MType == internal.CounterType && m.Delta != nil:
+		res.Value = &pbv2.Metric_Counter{Counter: *m.Delta}
+	}
+
+	return res
+}
@@ -0,0 +1,82 @@
+package pbconv
+
+import (
+	"testing"
+
+	"github.com/sotavant/yandex-metrics/internal"
+	pbv2 "github.com/sotavant/yandex-metrics/proto/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricFromV2(t *testing.T) {
+	value, delta := 1.5, int64(3)
+
+	tests := []struct {
+		name    string
+		m       *pbv2.Metric
+		want    internal.Metrics
+		wantErr error
+	}{
+		{
+			name: "gauge",
+			m:    &pbv2.Metric{Id: "temp", Value: &pbv2.Metric_Gauge{Gauge: value}},
+			want: internal.Metrics{ID: "temp", MType: internal.GaugeType, Value: &value},
+		},
+		{
+			name: "counter",
+			m:    &pbv2.Metric{Id: "cnt", Value: &pbv2.Metric_Counter{Counter: delta}},
+			want: internal.Metrics{ID: "cnt", MType: internal.CounterType, Delta: &delta},
+		},
+		{
+			name:    "value absent",
+			m:       &pbv2.Metric{Id: "temp"},
+			want:    internal.Metrics{ID: "temp"},
+			wantErr: ErrValueAbsent,
+		},
+		{
+			name:    "nil",
+			wantErr: ErrValueAbsent,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := MetricFromV2(tt.m)
+			require.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, tt.want, m)
+		})
+	}
+}
+
+func TestMetricToV2(t *testing.T) {
+	value, delta := 1.5, int64(3)
+
+	tests := []struct {
+		name string
+		m    internal.Metrics
+		want *pbv2.Metric
+	}{
+		{
+			name: "gauge",
+			m:    internal.Metrics{ID: "temp", MType: internal.GaugeType, Value: &value, Delta: &delta, Stale: true},
+			want: &pbv2.Metric{Id: "temp", Value: &pbv2.Metric_Gauge{Gauge: value}, Stale: true},
+		},
+		{
+			name: "counter",
+			m:    internal.Metrics{ID: "cnt", MType: internal.CounterType, Value: &value, Delta: &delta},
+			want: &pbv2.Metric{Id: "cnt", Value: &pbv2.Metric_Counter{Counter: delta}},
+		},
+		{
+			name: "value absent",
+			m:    internal.Metrics{ID: "cnt", MType: internal.CounterType},
+			want: &pbv2.Metric{Id: "cnt"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, MetricToV2(tt.m))
+		})
+	}
+}
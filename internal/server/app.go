@@ -5,14 +5,21 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/server/auth"
+	"github.com/sotavant/yandex-metrics/internal/server/broker"
+	"github.com/sotavant/yandex-metrics/internal/server/cardinality"
 	"github.com/sotavant/yandex-metrics/internal/server/config"
 	"github.com/sotavant/yandex-metrics/internal/server/repository"
+	"github.com/sotavant/yandex-metrics/internal/server/repository/bolt"
 	"github.com/sotavant/yandex-metrics/internal/server/repository/memory"
 	"github.com/sotavant/yandex-metrics/internal/server/repository/postgres"
 	"github.com/sotavant/yandex-metrics/internal/server/storage"
+	"github.com/sotavant/yandex-metrics/internal/utils"
 )
 
 // App структура для хранения текущего состояния, конфигурация, соединения с базой данных
@@ -21,6 +28,19 @@ type App struct {
 	Storage repository.Storage
 	Fs      *storage.FileStorage
 	DBConn  *pgxpool.Pool
+	// History хранилище истории, nil если история не включена
+	History      repository.HistoryStorage
+	HistoryTiers []repository.RetentionTier
+	// Broker рассылает записанные метрики подписчикам потока обновлений
+	Broker *broker.Broker
+	// KeyRing закрытые ключи для расшифровки тел запросов, nil если каталог ключей не задан
+	KeyRing *utils.KeyRing
+	// AgentKeys ключи подписи агентов по их идентификатору
+	AgentKeys map[string]string
+	// Tokens токены доступа к API, nil если проверка токенов выключена
+	Tokens auth.Tokens
+	// Cardinality учет и лимиты активных рядов по агентам
+	Cardinality *cardinality.Tracker
 }
 
 // InitApp Инициализация приложения
@@ -34,42 +54,159 @@ func InitApp(ctx context.Context) (*App, error) {
 	}
 	appInstance := new(App)
 
-	if dbConn == nil {
-		appInstance.Storage = memory.NewMetricsRepository()
-		appInstance.Fs, err = storage.NewFileStorage(conf.FileStoragePath, conf.Restore, conf.StoreInterval)
+	if conf.HistoryRetention != "" {
+		appInstance.HistoryTiers, err = repository.ParseRetention(conf.HistoryRetention)
+		if err != nil {
+			panic(err)
+		}
+	}
 
+	switch {
+	case dbConn != nil:
+		pgStorage, err := postgres.NewMemStorage(ctx, dbConn, conf.TableName, conf.DatabaseDSN)
 		if err != nil {
 			panic(err)
 		}
 
-		if err = appInstance.Fs.Restore(ctx, appInstance.Storage); err != nil {
+		if appInstance.HistoryTiers != nil {
+			if err = pgStorage.EnableHistory(ctx, appInstance.HistoryTiers); err != nil {
+				panic(err)
+			}
+
+			appInstance.History = pgStorage
+		}
+
+		appInstance.Storage = pgStorage
+	case conf.BoltDBPath != "":
+		appInstance.Storage, err = bolt.NewMetricsRepository(conf.BoltDBPath)
+
+		if err != nil {
 			panic(err)
 		}
-	} else {
-		appInstance.Storage, err = postgres.NewMemStorage(ctx, dbConn, conf.TableName, conf.DatabaseDSN)
+
+		if appInstance.HistoryTiers != nil {
+			internal.Logger.Infow("history is not supported by bolt storage, ignoring retention settings")
+			appInstance.HistoryTiers = nil
+		}
+	default:
+		appInstance.Storage = memory.NewShardedMetricsRepository(memory.DefaultShardsCount)
+		appInstance.Fs, err = storage.NewFileStorage(conf.FileStoragePath, conf.Restore, conf.StoreInterval)
 
 		if err != nil {
 			panic(err)
 		}
+
+		if err = appInstance.Fs.Restore(ctx, appInstance.Storage); err != nil {
+			panic(err)
+		}
+
+		// оборачиваем после восстановления, чтобы восстановленные значения не попали в историю как новые записи
+		if appInstance.HistoryTiers != nil {
+			historyStorage := memory.NewHistoryRepository(appInstance.Storage, appInstance.HistoryTiers)
+			appInstance.Storage = historyStorage
+			appInstance.History = historyStorage
+		}
 	}
 
 	appInstance.Config = conf
 	appInstance.DBConn = dbConn
+	appInstance.Broker = broker.New()
+
+	if conf.CryptoKeyDir != "" {
+		appInstance.KeyRing, err = utils.NewKeyRing(conf.CryptoKeyDir, conf.CryptoKeyGrace)
+		if err != nil {
+			panic(err)
+		}
+
+		appInstance.KeyRing.WithLegacy(conf.CryptoLegacy)
+	}
+
+	if conf.AgentKeysFile != "" {
+		appInstance.AgentKeys, err = utils.LoadAgentKeys(conf.AgentKeysFile)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	appInstance.Tokens, err = initTokens(ctx, conf, dbConn)
+	if err != nil {
+		panic(err)
+	}
+
+	appInstance.Cardinality, err = initCardinality(ctx, conf, appInstance.Storage)
+	if err != nil {
+		panic(err)
+	}
 
 	return appInstance, nil
 }
 
+// initTokens источники токенов доступа: файл и таблица <TableName>_tokens.
+// Токен администратора из конфигурации получает право admin.
+// Если ни файл, ни таблица не заданы, возвращается nil и токены не проверяются.
+func initTokens(ctx context.Context, conf *config.Config, dbConn *pgxpool.Pool) (auth.Tokens, error) {
+	var chain auth.Chain
+
+	if conf.TokensFile != "" {
+		tokens, err := auth.LoadTokens(conf.TokensFile)
+		if err != nil {
+			return nil, err
+		}
+
+		chain = append(chain, tokens)
+	}
+
+	if conf.TokensDB {
+		if dbConn == nil {
+			return nil, errors.New("tokens table requires database")
+		}
+
+		tokens, err := postgres.NewTokensRepository(ctx, dbConn, conf.TableName+"_tokens")
+		if err != nil {
+			return nil, err
+		}
+
+		chain = append(chain, tokens)
+	}
+
+	if len(chain) == 0 {
+		return nil, nil
+	}
+
+	if conf.AdminToken != "" {
+		admin := auth.StaticTokens{}
+		admin.Add(conf.AdminToken, auth.ScopeAdmin)
+		chain = append(auth.Chain{admin}, chain...)
+	}
+
+	return chain, nil
+}
+
+// initCardinality учет рядов с лимитами из конфигурации. Ряды, уже лежащие в хранилище, учитываются сразу,
+// иначе после перезапуска лимит не ограничивал бы их количество.
+func initCardinality(ctx context.Context, conf *config.Config, st repository.Storage) (*cardinality.Tracker, error) {
+	mode, err := cardinality.ParseMode(conf.CardinalityMode)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics, err := st.GetValues(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tracker := cardinality.NewTracker(conf.MaxSeries, conf.MaxAgentSeries, mode)
+	tracker.Seed(metrics)
+
+	return tracker, nil
+}
+
 // SyncFs Метод для синхронизация значения в памяти и в файле. В том случае, если используется in-memory хранилище
 func (app *App) SyncFs(ctx context.Context) {
 	fmt.Println("syncing fs")
-	needSync := false
-
-	switch app.Storage.(type) {
-	case *memory.MetricsRepository:
-		needSync = true
-	}
 
-	if !needSync {
+	// файловое хранилище создается только вместе с in-memory хранилищем
+	if app.Fs == nil {
 		return
 	}
 
@@ -83,3 +220,15 @@ func (app *App) SyncFs(ctx context.Context) {
 		panic(err)
 	}
 }
+
+// CloseStorage закрывает хранилище, если оно держит открытые ресурсы (например, файл встроенной базы данных)
+func (app *App) CloseStorage() {
+	closer, ok := app.Storage.(io.Closer)
+	if !ok {
+		return
+	}
+
+	if err := closer.Close(); err != nil {
+		internal.Logger.Infow("close storage error", "err", err)
+	}
+}
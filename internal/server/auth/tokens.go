@@ -0,0 +1,128 @@
+// Package auth токены доступа к API и их права (scopes).
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Scope право токена
+type Scope string
+
+const (
+	ScopeRead  Scope = "metrics:read"
+	ScopeWrite Scope = "metrics:write"
+	// ScopeAdmin административные методы, включает чтение и запись
+	ScopeAdmin Scope = "admin"
+)
+
+var (
+	ErrUnknownToken = errors.New("unknown token")
+	ErrUnknownScope = errors.New("unknown scope")
+)
+
+// Tokens источник токенов: возвращает права токена или ErrUnknownToken
+type Tokens interface {
+	Scopes(ctx context.Context, token string) ([]Scope, error)
+}
+
+// Allowed есть ли среди прав scopes право want
+func Allowed(scopes []Scope, want Scope) bool {
+	for _, s := range scopes {
+		if s == want || s == ScopeAdmin {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseScopes проверяет названия прав
+func ParseScopes(names []string) ([]Scope, error) {
+	res := make([]Scope, 0, len(names))
+	for _, name := range names {
+		s := Scope(name)
+		if s != ScopeRead && s != ScopeWrite && s != ScopeAdmin {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownScope, name)
+		}
+
+		res = append(res, s)
+	}
+
+	return res, nil
+}
+
+// HashToken SHA-256 токена в hex. Токены хранятся и ищутся по хешу,
+// поэтому сравнение не зависит от совпадающего префикса.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// StaticTokens токены в памяти по хешу токена
+type StaticTokens map[string][]Scope
+
+// LoadTokens читает токены из JSON-файла вида {"<token>": ["metrics:read", "metrics:write"]}
+func LoadTokens(path string) (StaticTokens, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := make(map[string][]string)
+	if err = json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	tokens := make(StaticTokens, len(raw))
+	for token, names := range raw {
+		if token == "" {
+			return nil, errors.New("empty token")
+		}
+
+		scopes, err := ParseScopes(names)
+		if err != nil {
+			return nil, err
+		}
+
+		tokens.Add(token, scopes...)
+	}
+
+	return tokens, nil
+}
+
+// Add добавляет права токену
+func (t StaticTokens) Add(token string, scopes ...Scope) {
+	key := HashToken(token)
+	t[key] = append(t[key], scopes...)
+}
+
+func (t StaticTokens) Scopes(_ context.Context, token string) ([]Scope, error) {
+	scopes, ok := t[HashToken(token)]
+	if !ok {
+		return nil, ErrUnknownToken
+	}
+
+	return scopes, nil
+}
+
+// Chain ищет токен в источниках по очереди, до первого, которому токен известен
+type Chain []Tokens
+
+func (c Chain) Scopes(ctx context.Context, token string) ([]Scope, error) {
+	for _, tokens := range c {
+		scopes, err := tokens.Scopes(ctx, token)
+		if errors.Is(err, ErrUnknownToken) {
+			continue
+		}
+
+		return scopes, err
+	}
+
+	return nil, ErrUnknownToken
+}
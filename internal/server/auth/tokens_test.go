@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadTokens(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		token   string
+		want    []Scope
+		wantErr error
+	}{
+		{
+			name:    "read and write",
+			content: `{"agent": ["metrics:read", "metrics:write"]}`,
+			token:   "agent",
+			want:    []Scope{ScopeRead, ScopeWrite},
+		},
+		{
+			name:    "unknown token",
+			content: `{"agent": ["metrics:read"]}`,
+			token:   "other",
+			wantErr: ErrUnknownToken,
+		},
+		{
+			name:    "unknown scope",
+			content: `{"agent": ["metrics:delete"]}`,
+			wantErr: ErrUnknownScope,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tokens.json")
+			require.NoError(t, os.WriteFile(path, []byte(tt.content), 0600))
+
+			tokens, err := LoadTokens(path)
+			if err == nil {
+				var scopes []Scope
+				scopes, err = tokens.Scopes(context.Background(), tt.token)
+				assert.Equal(t, tt.want, scopes)
+			}
+
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestChain(t *testing.T) {
+	first := StaticTokens{}
+	first.Add("reader", ScopeRead)
+	second := StaticTokens{}
+	second.Add("admin", ScopeAdmin)
+	chain := Chain{first, second}
+
+	scopes, err := chain.Scopes(context.Background(), "admin")
+	require.NoError(t, err)
+	assert.True(t, Allowed(scopes, ScopeWrite))
+
+	scopes, err = chain.Scopes(context.Background(), "reader")
+	require.NoError(t, err)
+	assert.True(t, Allowed(scopes, ScopeRead))
+	assert.False(t, Allowed(scopes, ScopeWrite))
+
+	_, err = chain.Scopes(context.Background(), "unknown")
+	assert.ErrorIs(t, err, ErrUnknownToken)
+}
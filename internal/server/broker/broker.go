@@ -0,0 +1,214 @@
+// Package broker Рассылка обновлений метрик подписчикам внутри сервера.
+//
+// Каждый подписчик получает обновления через собственный буферизированный канал.
+// Публикация никогда не блокируется: подписчик, который не успевает читать и у которого
+// переполнился буфер, отключается с ошибкой ErrSlowConsumer и может переподключиться.
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/server/query"
+)
+
+// DefaultBufferSize размер буфера подписчика по-умолчанию
+const DefaultBufferSize = 256
+
+var (
+	// ErrSlowConsumer подписчик отключен из-за переполнения буфера
+	ErrSlowConsumer = errors.New("subscriber is too slow, updates dropped")
+	// ErrClosed брокер остановлен
+	ErrClosed = errors.New("broker is closed")
+	// ErrBadFilter неверные параметры фильтра
+	ErrBadFilter = errors.New("bad filter")
+)
+
+// Filter отбор обновлений для подписчика. Пустые поля не ограничивают выборку.
+type Filter struct {
+	MType string
+	Regex *regexp.Regexp
+	// Selector имя и метки из ID вида name{k=v}
+	Selector *query.Selector
+}
+
+// NewFilter разбирает фильтр из строковых параметров (запроса HTTP или сообщения gRPC), пустые параметры пропускаются
+func NewFilter(mType, regex, selector string) (Filter, error) {
+	var err error
+	f := Filter{MType: mType}
+
+	switch mType {
+	case "", internal.GaugeType, internal.CounterType:
+	default:
+		return Filter{}, fmt.Errorf("%w: unknown type %q", ErrBadFilter, mType)
+	}
+
+	if regex != "" {
+		if f.Regex, err = regexp.Compile(regex); err != nil {
+			return Filter{}, fmt.Errorf("%w: %s", ErrBadFilter, err)
+		}
+	}
+
+	if selector != "" {
+		sel, err := query.ParseSelector(selector)
+		if err != nil {
+			return Filter{}, fmt.Errorf("%w: %s", ErrBadFilter, err)
+		}
+
+		f.Selector = &sel
+	}
+
+	return f, nil
+}
+
+// Match проверяет, что метрика подходит под фильтр
+func (f Filter) Match(m internal.Metrics) bool {
+	if f.MType != "" && m.MType != f.MType {
+		return false
+	}
+
+	if f.Regex != nil && !f.Regex.MatchString(m.ID) {
+		return false
+	}
+
+	if f.Selector != nil {
+		if _, ok := f.Selector.Match(m.ID); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Broker рассылает опубликованные метрики подписчикам.
+// Отправка выполняется под блокировкой на чтение, закрытие каналов - под блокировкой на запись,
+// поэтому отправка в закрытый канал невозможна.
+type Broker struct {
+	mutex       sync.RWMutex
+	subscribers map[*Subscription]struct{}
+	closed      bool
+}
+
+// Subscription подписка на обновления
+type Subscription struct {
+	broker *Broker
+	filter Filter
+	ch     chan internal.Metrics
+	// err причина закрытия канала, записывается до закрытия
+	err error
+}
+
+func New() *Broker {
+	return &Broker{subscribers: make(map[*Subscription]struct{})}
+}
+
+// Subscribe создает подписку с буфером bufferSize (DefaultBufferSize, если не положительный)
+func (b *Broker) Subscribe(f Filter, bufferSize int) (*Subscription, error) {
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.closed {
+		return nil, ErrClosed
+	}
+
+	s := &Subscription{
+		broker: b,
+		filter: f,
+		ch:     make(chan internal.Metrics, bufferSize),
+	}
+	b.subscribers[s] = struct{}{}
+
+	return s, nil
+}
+
+// HasSubscribers есть ли активные подписки. Позволяет не готовить данные для публикации, когда их некому читать.
+func (b *Broker) HasSubscribers() bool {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	return len(b.subscribers) > 0
+}
+
+// Publish рассылает метрики подписчикам, не блокируясь на медленных
+func (b *Broker) Publish(metrics []internal.Metrics) {
+	var slow []*Subscription
+
+	b.mutex.RLock()
+subscribers:
+	for s := range b.subscribers {
+		for _, m := range metrics {
+			if !s.filter.Match(m) {
+				continue
+			}
+
+			select {
+			case s.ch <- m:
+			default:
+				slow = append(slow, s)
+				continue subscribers
+			}
+		}
+	}
+	b.mutex.RUnlock()
+
+	if len(slow) == 0 {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for _, s := range slow {
+		b.remove(s, ErrSlowConsumer)
+	}
+}
+
+// Close отключает всех подписчиков с ошибкой ErrClosed, новые подписки после этого не создаются
+func (b *Broker) Close() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.closed = true
+	for s := range b.subscribers {
+		b.remove(s, ErrClosed)
+	}
+}
+
+// remove закрывает канал подписки, вызывается под блокировкой на запись
+func (b *Broker) remove(s *Subscription, reason error) {
+	if _, ok := b.subscribers[s]; !ok {
+		return
+	}
+
+	delete(b.subscribers, s)
+	s.err = reason
+	close(s.ch)
+}
+
+// C канал обновлений. Закрывается при отключении подписчика, причину возвращает Err.
+func (s *Subscription) C() <-chan internal.Metrics {
+	return s.ch
+}
+
+// Err причина отключения. Имеет смысл после закрытия канала C, nil если подписчик отписался сам.
+func (s *Subscription) Err() error {
+	s.broker.mutex.RLock()
+	defer s.broker.mutex.RUnlock()
+
+	return s.err
+}
+
+// Unsubscribe отменяет подписку и закрывает канал. Повторный вызов ничего не делает.
+func (s *Subscription) Unsubscribe() {
+	s.broker.mutex.Lock()
+	defer s.broker.mutex.Unlock()
+
+	s.broker.remove(s, nil)
+}
@@ -0,0 +1,156 @@
+package broker
+
+import (
+	"regexp"
+	"sync"
+	"testing"
+
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gauge(id string, v float64) internal.Metrics {
+	return internal.Metrics{ID: id, MType: internal.GaugeType, Value: &v}
+}
+
+func counter(id string, d int64) internal.Metrics {
+	return internal.Metrics{ID: id, MType: internal.CounterType, Delta: &d}
+}
+
+func TestFilter_Match(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter Filter
+		metric internal.Metrics
+		want   bool
+	}{
+		{name: "empty", filter: Filter{}, metric: gauge("Alloc", 1), want: true},
+		{name: "type", filter: Filter{MType: internal.CounterType}, metric: gauge("Alloc", 1), want: false},
+		{name: "regex", filter: Filter{Regex: regexp.MustCompile("^Heap")}, metric: gauge("HeapAlloc", 1), want: true},
+		{name: "regexMiss", filter: Filter{Regex: regexp.MustCompile("^Heap")}, metric: gauge("Alloc", 1), want: false},
+		{name: "selector", filter: mustFilter(t, "", "", `requests{host="a"}`), metric: counter("requests{host=a,code=200}", 1), want: true},
+		{name: "selectorMiss", filter: mustFilter(t, "", "", `requests{host="a"}`), metric: counter("requests{host=b}", 1), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.filter.Match(tt.metric))
+		})
+	}
+}
+
+func TestNewFilter(t *testing.T) {
+	tests := []struct {
+		name     string
+		mType    string
+		regex    string
+		selector string
+		wantErr  bool
+	}{
+		{name: "empty"},
+		{name: "all", mType: internal.GaugeType, regex: "^Heap", selector: "HeapAlloc{host=a}"},
+		{name: "badType", mType: "histogram", wantErr: true},
+		{name: "badRegex", regex: "(", wantErr: true},
+		{name: "badSelector", selector: "requests{host", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewFilter(tt.mType, tt.regex, tt.selector)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrBadFilter)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func mustFilter(t *testing.T, mType, regex, selector string) Filter {
+	f, err := NewFilter(mType, regex, selector)
+	require.NoError(t, err)
+
+	return f
+}
+
+func TestBroker_Publish(t *testing.T) {
+	b := New()
+	assert.False(t, b.HasSubscribers())
+
+	all, err := b.Subscribe(Filter{}, 10)
+	require.NoError(t, err)
+	counters, err := b.Subscribe(Filter{MType: internal.CounterType}, 10)
+	require.NoError(t, err)
+	assert.True(t, b.HasSubscribers())
+
+	b.Publish([]internal.Metrics{gauge("Alloc", 1), counter("PollCount", 2)})
+
+	assert.Equal(t, gauge("Alloc", 1), <-all.C())
+	assert.Equal(t, counter("PollCount", 2), <-all.C())
+	assert.Equal(t, counter("PollCount", 2), <-counters.C())
+	assert.Len(t, counters.C(), 0)
+
+	all.Unsubscribe()
+	all.Unsubscribe()
+	_, ok := <-all.C()
+	assert.False(t, ok)
+	assert.NoError(t, all.Err())
+
+	b.Close()
+	_, ok = <-counters.C()
+	assert.False(t, ok)
+	assert.ErrorIs(t, counters.Err(), ErrClosed)
+
+	_, err = b.Subscribe(Filter{}, 10)
+	assert.ErrorIs(t, err, ErrClosed)
+}
+
+func TestBroker_SlowConsumer(t *testing.T) {
+	b := New()
+	slow, err := b.Subscribe(Filter{}, 2)
+	require.NoError(t, err)
+	fast, err := b.Subscribe(Filter{}, 10)
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		b.Publish([]internal.Metrics{counter("PollCount", int64(i))})
+	}
+
+	// медленный подписчик получает то, что успело попасть в буфер, затем канал закрывается
+	received := 0
+	for range slow.C() {
+		received++
+	}
+	assert.Equal(t, 2, received)
+	assert.ErrorIs(t, slow.Err(), ErrSlowConsumer)
+
+	// остальные подписчики не затронуты
+	assert.Len(t, fast.C(), 3)
+	assert.True(t, b.HasSubscribers())
+}
+
+func TestBroker_ConcurrentPublish(t *testing.T) {
+	const publishers = 10
+
+	b := New()
+	var wg sync.WaitGroup
+
+	for i := 0; i < publishers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				b.Publish([]internal.Metrics{counter("PollCount", 1)})
+			}
+		}()
+	}
+
+	// подписки и отписки во время публикации не должны приводить к отправке в закрытый канал
+	for i := 0; i < 100; i++ {
+		s, err := b.Subscribe(Filter{}, 1)
+		require.NoError(t, err)
+		s.Unsubscribe()
+	}
+
+	wg.Wait()
+	b.Close()
+}
@@ -0,0 +1,265 @@
+// Package cardinality Учет активных рядов метрик и ограничение их количества.
+//
+// Ряд - пара (тип, ID). Tracker помнит, какие агенты писали в каждый ряд, и не дает
+// создать новый ряд сверх общего лимита или лимита агента. Допущенные метрики после записи
+// передаются в Commit, при ошибке записи - в Release. Удаленные и вытесненные ряды
+// нужно передавать в Forget, иначе они продолжат занимать лимит.
+package cardinality
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/server/repository"
+)
+
+// RestoredAgent владелец рядов, которые уже были в хранилище при запуске
+const RestoredAgent = "restored"
+
+// Mode поведение при превышении лимита
+type Mode string
+
+const (
+	// ModeReject весь запрос отклоняется с ErrLimitExceeded
+	ModeReject Mode = "reject"
+	// ModeDrop новые ряды сверх лимита молча отбрасываются, остальные метрики записываются
+	ModeDrop Mode = "drop"
+	// ModeLog превышение только записывается в лог, метрики принимаются
+	ModeLog Mode = "log"
+)
+
+var (
+	// ErrLimitExceeded запрос создал бы ряды сверх лимита
+	ErrLimitExceeded = errors.New("cardinality limit exceeded")
+	// ErrBadMode неизвестное поведение при превышении лимита
+	ErrBadMode = errors.New("unknown cardinality mode")
+)
+
+// ParseMode поведение из конфигурации, пустая строка - ModeReject
+func ParseMode(s string) (Mode, error) {
+	switch Mode(s) {
+	case "":
+		return ModeReject, nil
+	case ModeReject, ModeDrop, ModeLog:
+		return Mode(s), nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrBadMode, s)
+	}
+}
+
+// Stats текущее количество рядов и лимиты
+type Stats struct {
+	Series      int            `json:"series"`
+	MaxSeries   int            `json:"max_series"`
+	MaxPerAgent int            `json:"max_per_agent"`
+	Mode        Mode           `json:"mode"`
+	Agents      map[string]int `json:"agents"`
+}
+
+// Tracker учет рядов по агентам. Лимит 0 - без ограничения, учет при этом все равно ведется.
+//
+// Лимит агента - количество разных рядов, в которые он пишет, включая ряды, созданные другими агентами:
+// агент, достигший лимита, не может начать писать и в уже существующий чужой ряд.
+// Ряды, в которые агент уже пишет, принимаются всегда.
+type Tracker struct {
+	maxSeries   int
+	maxPerAgent int
+	mode        Mode
+
+	mu sync.Mutex
+	// series агенты, писавшие или пишущие в ряд
+	series map[repository.MetricKey]map[string]*writer
+	// agents количество рядов агента
+	agents map[string]int
+}
+
+// writer участие агента в ряде
+type writer struct {
+	// pending запросы агента, допущенные Admit и еще не переданные в Commit или Release
+	pending int
+	// stored хотя бы одна запись агента в ряд сохранена
+	stored bool
+}
+
+func NewTracker(maxSeries, maxPerAgent int, mode Mode) *Tracker {
+	return &Tracker{
+		maxSeries:   maxSeries,
+		maxPerAgent: maxPerAgent,
+		mode:        mode,
+		series:      make(map[repository.MetricKey]map[string]*writer),
+		agents:      make(map[string]int),
+	}
+}
+
+// Seed учитывает ряды, уже лежащие в хранилище, от имени RestoredAgent.
+// Они входят в общий лимит, но не в лимиты агентов.
+func (t *Tracker) Seed(metrics []internal.Metrics) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, m := range metrics {
+		t.add(RestoredAgent, repository.KeyOf(m)).stored = true
+	}
+}
+
+// Admit проверяет лимиты для метрик агента и возвращает метрики, которые можно записать,
+// и ряды допущенных метрик без повторов. Ряды нужно передать в Commit после записи или в Release,
+// если запись не удалась. В режиме ModeReject при превышении лимита ничего не учитывается
+// и возвращается ErrLimitExceeded.
+func (t *Tracker) Admit(agent string, metrics []internal.Metrics) ([]internal.Metrics, []repository.MetricKey, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	total, perAgent := len(t.series), t.agents[agent]
+	admitted := make([]internal.Metrics, 0, len(metrics))
+	var held []repository.MetricKey
+	isHeld := make(map[repository.MetricKey]bool)
+	rejected := 0
+
+	for _, m := range metrics {
+		key := repository.KeyOf(m)
+		if isHeld[key] {
+			admitted = append(admitted, m)
+			continue
+		}
+
+		agents, known := t.series[key]
+		if _, own := agents[agent]; own {
+			isHeld[key] = true
+			held = append(held, key)
+			admitted = append(admitted, m)
+			continue
+		}
+
+		overGlobal := !known && t.maxSeries > 0 && total >= t.maxSeries
+		overAgent := t.maxPerAgent > 0 && perAgent >= t.maxPerAgent
+		if overGlobal || overAgent {
+			rejected++
+			if t.mode != ModeLog {
+				continue
+			}
+		}
+
+		if !known {
+			total++
+		}
+		perAgent++
+		isHeld[key] = true
+		held = append(held, key)
+		admitted = append(admitted, m)
+	}
+
+	if rejected > 0 {
+		internal.Logger.Infow("cardinality limit exceeded", "agent", agent, "series", rejected, "mode", t.mode)
+		if t.mode == ModeReject {
+			return nil, nil, ErrLimitExceeded
+		}
+	}
+
+	for _, key := range held {
+		t.add(agent, key).pending++
+	}
+
+	return admitted, held, nil
+}
+
+// Commit отмечает ряды, допущенные Admit, как записанные
+func (t *Tracker) Commit(agent string, held []repository.MetricKey) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, key := range held {
+		if w, ok := t.series[key][agent]; ok {
+			w.pending--
+			w.stored = true
+		}
+	}
+}
+
+// Release отменяет учет рядов, допущенных Admit, если метрики не удалось записать.
+// Ряд остается за агентом, если в него уже есть сохраненная запись агента или его ждут
+// другие запросы агента, допущенные одновременно с этим.
+func (t *Tracker) Release(agent string, held []repository.MetricKey) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, key := range held {
+		agents := t.series[key]
+		w, ok := agents[agent]
+		if !ok {
+			continue
+		}
+
+		w.pending--
+		if w.pending > 0 || w.stored {
+			continue
+		}
+
+		delete(agents, agent)
+		t.dec(agent)
+		if len(agents) == 0 {
+			delete(t.series, key)
+		}
+	}
+}
+
+// Forget убирает удаленные или вытесненные ряды из учета всех агентов
+func (t *Tracker) Forget(keys []repository.MetricKey) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, key := range keys {
+		for agent := range t.series[key] {
+			t.dec(agent)
+		}
+
+		delete(t.series, key)
+	}
+}
+
+// Stats текущее количество рядов, всего и по агентам
+func (t *Tracker) Stats() Stats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	res := Stats{
+		Series:      len(t.series),
+		MaxSeries:   t.maxSeries,
+		MaxPerAgent: t.maxPerAgent,
+		Mode:        t.mode,
+		Agents:      make(map[string]int, len(t.agents)),
+	}
+	for agent, n := range t.agents {
+		res.Agents[agent] = n
+	}
+
+	return res
+}
+
+// add учитывает ряд за агентом, если он еще не учтен, и возвращает участие агента в ряде
+func (t *Tracker) add(agent string, key repository.MetricKey) *writer {
+	agents, ok := t.series[key]
+	if !ok {
+		agents = make(map[string]*writer, 1)
+		t.series[key] = agents
+	}
+
+	if w, own := agents[agent]; own {
+		return w
+	}
+
+	w := &writer{}
+	agents[agent] = w
+	t.agents[agent]++
+
+	return w
+}
+
+func (t *Tracker) dec(agent string) {
+	t.agents[agent]--
+	if t.agents[agent] <= 0 {
+		delete(t.agents, agent)
+	}
+}
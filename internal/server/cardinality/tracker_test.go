@@ -0,0 +1,238 @@
+package cardinality
+
+import (
+	"testing"
+
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/server/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gauges(ids ...string) []internal.Metrics {
+	res := make([]internal.Metrics, len(ids))
+	for i, id := range ids {
+		val := 1.0
+		res[i] = internal.Metrics{ID: id, MType: internal.GaugeType, Value: &val}
+	}
+
+	return res
+}
+
+func ids(metrics []internal.Metrics) []string {
+	res := make([]string, len(metrics))
+	for i, m := range metrics {
+		res[i] = m.ID
+	}
+
+	return res
+}
+
+func TestTracker_Admit(t *testing.T) {
+	internal.InitLogger()
+
+	type write struct {
+		agent string
+		ids   []string
+	}
+
+	tests := []struct {
+		name        string
+		maxSeries   int
+		maxPerAgent int
+		mode        Mode
+		seed        []string
+		before      []write
+		write       write
+		wantIDs     []string
+		wantErr     error
+		wantSeries  int
+		wantAgents  map[string]int
+	}{
+		{
+			name:       "unlimited",
+			write:      write{agent: "a", ids: []string{"x", "y", "x"}},
+			wantIDs:    []string{"x", "y", "x"},
+			wantSeries: 2,
+			wantAgents: map[string]int{"a": 2},
+		},
+		{
+			name:       "global limit rejects batch",
+			maxSeries:  2,
+			mode:       ModeReject,
+			seed:       []string{"x"},
+			write:      write{agent: "a", ids: []string{"x", "y", "z"}},
+			wantErr:    ErrLimitExceeded,
+			wantSeries: 1,
+			wantAgents: map[string]int{RestoredAgent: 1},
+		},
+		{
+			name:       "existing series pass global limit",
+			maxSeries:  2,
+			mode:       ModeReject,
+			seed:       []string{"x", "y"},
+			write:      write{agent: "a", ids: []string{"x", "y"}},
+			wantIDs:    []string{"x", "y"},
+			wantSeries: 2,
+			wantAgents: map[string]int{RestoredAgent: 2, "a": 2},
+		},
+		{
+			name:        "agent limit drops new series",
+			maxPerAgent: 2,
+			mode:        ModeDrop,
+			before:      []write{{agent: "a", ids: []string{"x"}}},
+			write:       write{agent: "a", ids: []string{"x", "y", "z"}},
+			wantIDs:     []string{"x", "y"},
+			wantSeries:  2,
+			wantAgents:  map[string]int{"a": 2},
+		},
+		{
+			name:        "agents are limited separately",
+			maxPerAgent: 1,
+			mode:        ModeReject,
+			before:      []write{{agent: "a", ids: []string{"x"}}},
+			write:       write{agent: "b", ids: []string{"y"}},
+			wantIDs:     []string{"y"},
+			wantSeries:  2,
+			wantAgents:  map[string]int{"a": 1, "b": 1},
+		},
+		{
+			name:        "series of another agent count against agent limit",
+			maxPerAgent: 1,
+			mode:        ModeReject,
+			before:      []write{{agent: "a", ids: []string{"x"}}, {agent: "b", ids: []string{"y"}}},
+			write:       write{agent: "b", ids: []string{"x"}},
+			wantErr:     ErrLimitExceeded,
+			wantSeries:  2,
+			wantAgents:  map[string]int{"a": 1, "b": 1},
+		},
+		{
+			name:       "log mode accepts everything",
+			maxSeries:  1,
+			mode:       ModeLog,
+			write:      write{agent: "a", ids: []string{"x", "y"}},
+			wantIDs:    []string{"x", "y"},
+			wantSeries: 2,
+			wantAgents: map[string]int{"a": 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := NewTracker(tt.maxSeries, tt.maxPerAgent, tt.mode)
+			tracker.Seed(gauges(tt.seed...))
+			for _, w := range tt.before {
+				_, _, err := tracker.Admit(w.agent, gauges(w.ids...))
+				require.NoError(t, err)
+			}
+
+			admitted, _, err := tracker.Admit(tt.write.agent, gauges(tt.write.ids...))
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tt.wantIDs, ids(admitted))
+			}
+
+			stats := tracker.Stats()
+			assert.Equal(t, tt.wantSeries, stats.Series)
+			assert.Equal(t, tt.wantAgents, stats.Agents)
+		})
+	}
+}
+
+func TestTracker_ReleaseAndForget(t *testing.T) {
+	tracker := NewTracker(2, 0, ModeReject)
+	tracker.Seed(gauges("x"))
+
+	_, added, err := tracker.Admit("a", gauges("x", "y"))
+	require.NoError(t, err)
+
+	// запись не удалась: ряд y удаляется, ряд x остается за restored
+	tracker.Release("a", added)
+	assert.Equal(t, Stats{Series: 1, MaxSeries: 2, Mode: ModeReject, Agents: map[string]int{RestoredAgent: 1}}, tracker.Stats())
+
+	_, _, err = tracker.Admit("a", gauges("x", "y"))
+	require.NoError(t, err)
+
+	_, _, err = tracker.Admit("b", gauges("z"))
+	assert.ErrorIs(t, err, ErrLimitExceeded)
+
+	// удаленный ряд освобождает место
+	tracker.Forget([]repository.MetricKey{{MType: internal.GaugeType, ID: "x"}})
+	_, _, err = tracker.Admit("b", gauges("z"))
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]int{"a": 1, "b": 1}, tracker.Stats().Agents)
+}
+
+func TestTracker_ConcurrentAdmit(t *testing.T) {
+	tests := []struct {
+		name string
+		// secondOK сохранена ли запись второго запроса
+		secondOK   bool
+		wantAgents map[string]int
+	}{
+		{
+			name:       "second request stored",
+			secondOK:   true,
+			wantAgents: map[string]int{"a": 1},
+		},
+		{
+			name:       "both requests failed",
+			wantAgents: map[string]int{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := NewTracker(0, 1, ModeReject)
+
+			// два запроса агента допущены в новый ряд до записи любого из них, первая запись не удалась
+			_, first, err := tracker.Admit("a", gauges("x"))
+			require.NoError(t, err)
+			_, second, err := tracker.Admit("a", gauges("x"))
+			require.NoError(t, err)
+			tracker.Release("a", first)
+
+			if tt.secondOK {
+				tracker.Commit("a", second)
+			} else {
+				tracker.Release("a", second)
+			}
+
+			assert.Equal(t, tt.wantAgents, tracker.Stats().Agents)
+
+			// записанный ряд продолжает занимать лимит агента
+			_, _, err = tracker.Admit("a", gauges("y"))
+			if tt.secondOK {
+				assert.ErrorIs(t, err, ErrLimitExceeded)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Mode
+		wantErr bool
+	}{
+		{value: "", want: ModeReject},
+		{value: "drop", want: ModeDrop},
+		{value: "log", want: ModeLog},
+		{value: "ignore", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			mode, err := ParseMode(tt.value)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrBadMode)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, mode)
+		})
+	}
+}
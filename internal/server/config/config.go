@@ -9,6 +9,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/sotavant/yandex-metrics/internal"
 )
@@ -19,6 +20,8 @@ const (
 	DefaultTableName     = "metric"
 	DefaultStoreInterval = 300
 	DefaultMetricDB      = "/tmp/metrics-db.json"
+	DefaultKeyGrace      = time.Hour
+	DefaultSignSkew      = 5 * time.Minute
 )
 
 // Названия переменных окружения
@@ -33,32 +36,104 @@ const (
 	CryptKeyVar        = `CRYPTO_KEY`
 	configPathKeyVar   = `CONFIG`
 	trustedSubnetVar   = `TRUSTED_SUBNET`
+	boltDBPathVar      = `BOLT_DB_PATH`
+	historyVar         = `HISTORY_RETENTION`
+	adminTokenVar      = `ADMIN_TOKEN`
+	staleTTLVar        = `STALE_TTL`
+	evictTTLVar        = `EVICT_TTL`
+	grpcAddressVar     = `GRPC_ADDRESS`
+	cryptoLegacyVar    = `CRYPTO_LEGACY`
+	cryptoKeyDirVar    = `CRYPTO_KEY_DIR`
+	cryptoKeyGraceVar  = `CRYPTO_KEY_GRACE`
+	signSkewVar        = `SIGN_SKEW`
+	signStrictVar      = `SIGN_STRICT`
+	agentKeysVar       = `AGENT_KEYS_FILE`
+	httpsVar           = `HTTPS`
+	clientCAVar        = `CLIENT_CA`
+	tokensFileVar      = `TOKENS_FILE`
+	tokensDBVar        = `TOKENS_DB`
+	trustedProxiesVar  = `TRUSTED_PROXIES`
+	deniedSubnetsVar   = `DENIED_SUBNETS`
+	rateRequestsVar    = `RATE_LIMIT_REQUESTS`
+	rateMetricsVar     = `RATE_LIMIT_METRICS`
+	maxSeriesVar       = `MAX_SERIES`
+	maxAgentSeriesVar  = `MAX_SERIES_PER_AGENT`
+	cardinalityModeVar = `CARDINALITY_MODE`
 )
 
 // fileConfig для настроек из файла конфига
 type fileConfig struct {
-	Address          string `json:"address"`
-	StoreIntervalStr string `json:"store_interval"`
-	StoreFile        string `json:"store_file"`
-	DatabaseDSN      string `json:"database_dsn"`
-	CryptoKey        string `json:"crypto_key"`
-	TrustedSubnet    string `json:"trusted_subnet"`
-	Restore          bool   `json:"restore"`
+	Address          string  `json:"address"`
+	StoreIntervalStr string  `json:"store_interval"`
+	StoreFile        string  `json:"store_file"`
+	DatabaseDSN      string  `json:"database_dsn"`
+	CryptoKey        string  `json:"crypto_key"`
+	TrustedSubnet    string  `json:"trusted_subnet"`
+	BoltDB           string  `json:"bolt_db"`
+	HistoryRetention string  `json:"history_retention"`
+	AdminToken       string  `json:"admin_token"`
+	StaleTTL         string  `json:"stale_ttl"`
+	EvictTTL         string  `json:"evict_ttl"`
+	GRPCAddress      string  `json:"grpc_address"`
+	CryptoKeyDir     string  `json:"crypto_key_dir"`
+	CryptoKeyGrace   string  `json:"crypto_key_grace"`
+	SignSkew         string  `json:"sign_skew"`
+	AgentKeysFile    string  `json:"agent_keys_file"`
+	ClientCA         string  `json:"client_ca"`
+	TokensFile       string  `json:"tokens_file"`
+	TrustedProxies   string  `json:"trusted_proxies"`
+	DeniedSubnets    string  `json:"denied_subnets"`
+	RateRequests     float64 `json:"rate_limit_requests"`
+	RateMetrics      float64 `json:"rate_limit_metrics"`
+	MaxSeries        int     `json:"max_series"`
+	MaxAgentSeries   int     `json:"max_series_per_agent"`
+	CardinalityMode  string  `json:"cardinality_mode"`
+	Restore          bool    `json:"restore"`
+	CryptoLegacy     bool    `json:"crypto_legacy"`
+	SignStrict       bool    `json:"sign_strict"`
+	HTTPS            bool    `json:"https"`
+	TokensDB         bool    `json:"tokens_db"`
 }
 
 // Config Структура для хранения параметров
 type Config struct {
-	Addr            string
-	HashKey         string
-	FileStoragePath string
-	DatabaseDSN     string
-	TableName       string
-	CryptoKeyPath   string
-	CryptoCertPath  string
-	TrustedSubnet   string
-	StoreInterval   uint
+	Addr             string
+	GRPCAddr         string
+	HashKey          string
+	FileStoragePath  string
+	DatabaseDSN      string
+	TableName        string
+	CryptoKeyPath    string
+	CryptoCertPath   string
+	CryptoKeyDir     string
+	CryptoKeyGrace   time.Duration
+	TrustedSubnet    string
+	TrustedProxies   string
+	DeniedSubnets    string
+	BoltDBPath       string
+	HistoryRetention string
+	AdminToken       string
+	StaleTTL         time.Duration
+	EvictTTL         time.Duration
+	SignSkew         time.Duration
+	AgentKeysFile    string
+	ClientCAPath     string
+	TokensFile       string
+	StoreInterval    uint
+	// RateRequests и RateMetrics лимиты запросов и метрик в секунду на агента, 0 - без ограничения
+	RateRequests float64
+	RateMetrics  float64
+	// MaxSeries и MaxAgentSeries лимиты активных рядов, всего и на агента, 0 - без ограничения
+	MaxSeries      int
+	MaxAgentSeries int
+	// CardinalityMode поведение при превышении лимита рядов: reject, drop или log
+	CardinalityMode string
 	Restore         bool
 	UseGRPC         bool
+	CryptoLegacy    bool
+	SignStrict      bool
+	HTTPS           bool
+	TokensDB        bool
 }
 
 // InitConfig инициализация конфигурации
@@ -72,9 +147,12 @@ func InitConfig() *Config {
 // Сначала считываются значения из командной строки, если они не заданы, то берутся значения по-умолчанию
 // Если заданы переменные окружения, то они переопределяют значения заданные ранее
 func (c *Config) ReadConfig() {
-	var address, storeFile, databaseDsn, cryptoKey, config, cnfShort, trustedSubnet string
-	var restore bool
+	var address, storeFile, databaseDsn, cryptoKey, config, cnfShort, trustedSubnet, boltDB, history, adminToken, grpcAddress, keyDir, agentKeys, clientCA, tokensFile, trustedProxies, deniedSubnets, cardinalityMode string
+	var restore, cryptoLegacy, signStrict, https, tokensDB bool
 	var storeInterval uint
+	var rateRequests, rateMetrics float64
+	var maxSeries, maxAgentSeries int
+	var staleTTL, evictTTL, keyGrace, signSkew time.Duration
 
 	flag.StringVar(&address, "a", "", "server address")
 	flag.BoolVar(&restore, "r", true, "need restore values")
@@ -87,8 +165,31 @@ func (c *Config) ReadConfig() {
 	flag.StringVar(&c.CryptoCertPath, "crypto-cert", "", "path to public key")
 	flag.StringVar(&config, "config", "", "path to config file")
 	flag.StringVar(&cnfShort, "c", "", "path to config file")
-	flag.StringVar(&trustedSubnet, "ts", "", "path to config file")
-	flag.BoolVar(&c.UseGRPC, "g", false, "use gRPC")
+	flag.StringVar(&trustedSubnet, "ts", "", "trusted subnets, comma separated CIDRs or addresses")
+	flag.StringVar(&trustedProxies, "trusted-proxies", "", "proxies allowed to pass client address in X-Forwarded-For and X-Real-IP, comma separated")
+	flag.Float64Var(&rateRequests, "rate-requests", 0, "requests per second per agent, 0 - unlimited")
+	flag.Float64Var(&rateMetrics, "rate-metrics", 0, "metrics per second per agent, 0 - unlimited")
+	flag.IntVar(&maxSeries, "max-series", 0, "max active series, 0 - unlimited")
+	flag.IntVar(&maxAgentSeries, "max-series-per-agent", 0, "max active series per agent, 0 - unlimited")
+	flag.StringVar(&cardinalityMode, "cardinality-mode", "", "what to do with new series over the limit: reject (default), drop or log")
+	flag.StringVar(&deniedSubnets, "denied-subnets", "", "denied subnets, comma separated CIDRs or addresses")
+	flag.BoolVar(&c.UseGRPC, "g", false, "use gRPC only, on server address")
+	flag.BoolVar(&cryptoLegacy, "crypto-legacy", false, "also accept bodies encrypted with RSA PKCS#1 v1.5 without envelope")
+	flag.StringVar(&keyDir, "crypto-key-dir", "", "directory with private keys (*.pem), replaces -crypto-key")
+	flag.DurationVar(&keyGrace, "crypto-key-grace", 0, "how long a key removed from key dir is still accepted, default 1h")
+	flag.StringVar(&grpcAddress, "grpc-addr", "", "gRPC server address, same as -a to serve both protocols on one port")
+	flag.StringVar(&boltDB, "bolt", "", "path to embedded bolt database file")
+	flag.StringVar(&history, "history", "", "history retention tiers, e.g. raw:24h,1m:30d,1h:365d")
+	flag.StringVar(&adminToken, "admin-token", "", "bearer token for admin endpoints")
+	flag.DurationVar(&staleTTL, "stale-ttl", 0, "mark series not updated within this time as stale, 0 - disabled")
+	flag.DurationVar(&signSkew, "sign-skew", 0, "allowed clock skew of signed requests, default 5m")
+	flag.BoolVar(&signStrict, "sign-strict", false, "reject unsigned requests that modify data")
+	flag.StringVar(&agentKeys, "agent-keys", "", "path to JSON file with per-agent hash keys {\"agent-id\": \"key\"}")
+	flag.BoolVar(&https, "https", false, "serve HTTP API over TLS with -crypto-cert and -crypto-key")
+	flag.StringVar(&clientCA, "client-ca", "", "CA bundle to verify client certificates, enables mutual TLS")
+	flag.StringVar(&tokensFile, "tokens-file", "", "path to JSON file with access tokens {\"token\": [\"metrics:read\", \"metrics:write\"]}")
+	flag.BoolVar(&tokensDB, "tokens-db", false, "read access tokens from the database table")
+	flag.DurationVar(&evictTTL, "evict-ttl", 0, "evict series not updated within this time, 0 - disabled")
 
 	if config == "" {
 		config = cnfShort
@@ -135,6 +236,102 @@ func (c *Config) ReadConfig() {
 		c.TrustedSubnet = trustedSubnet
 	}
 
+	if trustedProxies != "" {
+		c.TrustedProxies = trustedProxies
+	}
+
+	if deniedSubnets != "" {
+		c.DeniedSubnets = deniedSubnets
+	}
+
+	if rateRequests != 0 {
+		c.RateRequests = rateRequests
+	}
+
+	if rateMetrics != 0 {
+		c.RateMetrics = rateMetrics
+	}
+
+	if maxSeries != 0 {
+		c.MaxSeries = maxSeries
+	}
+
+	if maxAgentSeries != 0 {
+		c.MaxAgentSeries = maxAgentSeries
+	}
+
+	if cardinalityMode != "" {
+		c.CardinalityMode = cardinalityMode
+	}
+
+	if boltDB != "" {
+		c.BoltDBPath = boltDB
+	}
+
+	if history != "" {
+		c.HistoryRetention = history
+	}
+
+	if adminToken != "" {
+		c.AdminToken = adminToken
+	}
+
+	if staleTTL != 0 {
+		c.StaleTTL = staleTTL
+	}
+
+	if evictTTL != 0 {
+		c.EvictTTL = evictTTL
+	}
+
+	if grpcAddress != "" {
+		c.GRPCAddr = grpcAddress
+	}
+
+	if cryptoLegacy {
+		c.CryptoLegacy = cryptoLegacy
+	}
+
+	if keyDir != "" {
+		c.CryptoKeyDir = keyDir
+	}
+
+	if keyGrace != 0 {
+		c.CryptoKeyGrace = keyGrace
+	} else if c.CryptoKeyGrace == 0 {
+		c.CryptoKeyGrace = DefaultKeyGrace
+	}
+
+	if signStrict {
+		c.SignStrict = signStrict
+	}
+
+	if agentKeys != "" {
+		c.AgentKeysFile = agentKeys
+	}
+
+	if https {
+		c.HTTPS = https
+	}
+
+	if clientCA != "" {
+		c.ClientCAPath = clientCA
+	}
+
+	if tokensFile != "" {
+		c.TokensFile = tokensFile
+	}
+
+	if tokensDB {
+		c.TokensDB = tokensDB
+	}
+
+	if signSkew != 0 {
+		c.SignSkew = signSkew
+	} else if c.SignSkew == 0 {
+		c.SignSkew = DefaultSignSkew
+	}
+
 	c.readEnvConfig()
 }
 
@@ -190,6 +387,98 @@ func (c *Config) readConfig(configPath string) {
 	if fileCnf.TrustedSubnet != "" {
 		c.TrustedSubnet = fileCnf.TrustedSubnet
 	}
+
+	if fileCnf.TrustedProxies != "" {
+		c.TrustedProxies = fileCnf.TrustedProxies
+	}
+
+	if fileCnf.DeniedSubnets != "" {
+		c.DeniedSubnets = fileCnf.DeniedSubnets
+	}
+
+	if fileCnf.RateRequests != 0 {
+		c.RateRequests = fileCnf.RateRequests
+	}
+
+	if fileCnf.RateMetrics != 0 {
+		c.RateMetrics = fileCnf.RateMetrics
+	}
+
+	if fileCnf.MaxSeries != 0 {
+		c.MaxSeries = fileCnf.MaxSeries
+	}
+
+	if fileCnf.MaxAgentSeries != 0 {
+		c.MaxAgentSeries = fileCnf.MaxAgentSeries
+	}
+
+	if fileCnf.CardinalityMode != "" {
+		c.CardinalityMode = fileCnf.CardinalityMode
+	}
+
+	if fileCnf.BoltDB != "" {
+		c.BoltDBPath = fileCnf.BoltDB
+	}
+
+	if fileCnf.HistoryRetention != "" {
+		c.HistoryRetention = fileCnf.HistoryRetention
+	}
+
+	if fileCnf.AdminToken != "" {
+		c.AdminToken = fileCnf.AdminToken
+	}
+
+	if fileCnf.StaleTTL != "" {
+		c.StaleTTL = parseDuration(fileCnf.StaleTTL)
+	}
+
+	if fileCnf.EvictTTL != "" {
+		c.EvictTTL = parseDuration(fileCnf.EvictTTL)
+	}
+
+	if fileCnf.GRPCAddress != "" {
+		c.GRPCAddr = fileCnf.GRPCAddress
+	}
+
+	if fileCnf.CryptoLegacy {
+		c.CryptoLegacy = fileCnf.CryptoLegacy
+	}
+
+	if fileCnf.CryptoKeyDir != "" {
+		c.CryptoKeyDir = fileCnf.CryptoKeyDir
+	}
+
+	if fileCnf.CryptoKeyGrace != "" {
+		c.CryptoKeyGrace = parseDuration(fileCnf.CryptoKeyGrace)
+	}
+
+	if fileCnf.SignSkew != "" {
+		c.SignSkew = parseDuration(fileCnf.SignSkew)
+	}
+
+	if fileCnf.SignStrict {
+		c.SignStrict = fileCnf.SignStrict
+	}
+
+	if fileCnf.AgentKeysFile != "" {
+		c.AgentKeysFile = fileCnf.AgentKeysFile
+	}
+
+	if fileCnf.HTTPS {
+		c.HTTPS = fileCnf.HTTPS
+	}
+
+	if fileCnf.ClientCA != "" {
+		c.ClientCAPath = fileCnf.ClientCA
+	}
+
+	if fileCnf.TokensFile != "" {
+		c.TokensFile = fileCnf.TokensFile
+	}
+
+	if fileCnf.TokensDB {
+		c.TokensDB = fileCnf.TokensDB
+	}
 }
 
 func (c *Config) readEnvConfig() {
@@ -238,4 +527,143 @@ func (c *Config) readEnvConfig() {
 	if trustedSubnet := os.Getenv(trustedSubnetVar); trustedSubnet != "" {
 		c.TrustedSubnet = trustedSubnet
 	}
+
+	if trustedProxies := os.Getenv(trustedProxiesVar); trustedProxies != "" {
+		c.TrustedProxies = trustedProxies
+	}
+
+	if deniedSubnets := os.Getenv(deniedSubnetsVar); deniedSubnets != "" {
+		c.DeniedSubnets = deniedSubnets
+	}
+
+	if rateRequests := os.Getenv(rateRequestsVar); rateRequests != "" {
+		c.RateRequests = parseFloat(rateRequests)
+	}
+
+	if rateMetrics := os.Getenv(rateMetricsVar); rateMetrics != "" {
+		c.RateMetrics = parseFloat(rateMetrics)
+	}
+
+	if maxSeries := os.Getenv(maxSeriesVar); maxSeries != "" {
+		c.MaxSeries = parseInt(maxSeries)
+	}
+
+	if maxAgentSeries := os.Getenv(maxAgentSeriesVar); maxAgentSeries != "" {
+		c.MaxAgentSeries = parseInt(maxAgentSeries)
+	}
+
+	if cardinalityMode := os.Getenv(cardinalityModeVar); cardinalityMode != "" {
+		c.CardinalityMode = cardinalityMode
+	}
+
+	if boltDBPath := os.Getenv(boltDBPathVar); boltDBPath != "" {
+		c.BoltDBPath = boltDBPath
+	}
+
+	if history := os.Getenv(historyVar); history != "" {
+		c.HistoryRetention = history
+	}
+
+	if adminToken := os.Getenv(adminTokenVar); adminToken != "" {
+		c.AdminToken = adminToken
+	}
+
+	if staleTTL := os.Getenv(staleTTLVar); staleTTL != "" {
+		c.StaleTTL = parseDuration(staleTTL)
+	}
+
+	if evictTTL := os.Getenv(evictTTLVar); evictTTL != "" {
+		c.EvictTTL = parseDuration(evictTTL)
+	}
+
+	if grpcAddress := os.Getenv(grpcAddressVar); grpcAddress != "" {
+		c.GRPCAddr = grpcAddress
+	}
+
+	if cryptoLegacy := os.Getenv(cryptoLegacyVar); cryptoLegacy != "" {
+		boolVal, err := strconv.ParseBool(cryptoLegacy)
+		if err != nil {
+			panic(err)
+		}
+
+		c.CryptoLegacy = boolVal
+	}
+
+	if keyDir := os.Getenv(cryptoKeyDirVar); keyDir != "" {
+		c.CryptoKeyDir = keyDir
+	}
+
+	if keyGrace := os.Getenv(cryptoKeyGraceVar); keyGrace != "" {
+		c.CryptoKeyGrace = parseDuration(keyGrace)
+	}
+
+	if signSkew := os.Getenv(signSkewVar); signSkew != "" {
+		c.SignSkew = parseDuration(signSkew)
+	}
+
+	if signStrict := os.Getenv(signStrictVar); signStrict != "" {
+		boolVal, err := strconv.ParseBool(signStrict)
+		if err != nil {
+			panic(err)
+		}
+
+		c.SignStrict = boolVal
+	}
+
+	if agentKeys := os.Getenv(agentKeysVar); agentKeys != "" {
+		c.AgentKeysFile = agentKeys
+	}
+
+	if https := os.Getenv(httpsVar); https != "" {
+		boolVal, err := strconv.ParseBool(https)
+		if err != nil {
+			panic(err)
+		}
+
+		c.HTTPS = boolVal
+	}
+
+	if clientCA := os.Getenv(clientCAVar); clientCA != "" {
+		c.ClientCAPath = clientCA
+	}
+
+	if tokensFile := os.Getenv(tokensFileVar); tokensFile != "" {
+		c.TokensFile = tokensFile
+	}
+
+	if tokensDB := os.Getenv(tokensDBVar); tokensDB != "" {
+		boolVal, err := strconv.ParseBool(tokensDB)
+		if err != nil {
+			panic(err)
+		}
+
+		c.TokensDB = boolVal
+	}
+}
+
+func parseInt(value string) int {
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		panic(err)
+	}
+
+	return i
+}
+
+func parseFloat(value string) float64 {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		panic(err)
+	}
+
+	return f
+}
+
+func parseDuration(value string) time.Duration {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		panic(err)
+	}
+
+	return d
 }
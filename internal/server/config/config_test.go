@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/sotavant/yandex-metrics/internal"
 	"github.com/stretchr/testify/assert"
@@ -18,7 +19,30 @@ func TestServerConfig_ReadConfigFromFile(t *testing.T) {
     "store_file": "/path/to/file.db",
     "database_dsn": "",
     "crypto_key": "/path/to/key.pem",
-	"trusted_subnet": "125.125.0.0/16"
+	"trusted_subnet": "125.125.0.0/16,fd00::/8",
+	"trusted_proxies": "10.0.0.1",
+	"denied_subnets": "125.125.1.0/24",
+	"rate_limit_requests": 10,
+	"rate_limit_metrics": 2.5,
+	"max_series": 1000,
+	"max_series_per_agent": 100,
+	"cardinality_mode": "drop",
+	"bolt_db": "/path/to/metrics.db",
+	"history_retention": "raw:24h,1m:30d",
+	"admin_token": "secret",
+	"stale_ttl": "5m",
+	"evict_ttl": "24h",
+	"grpc_address": "localhost:3200",
+	"crypto_legacy": true,
+	"crypto_key_dir": "/path/to/keys",
+	"crypto_key_grace": "2h",
+	"sign_skew": "30s",
+	"sign_strict": true,
+	"agent_keys_file": "/path/to/agents.json",
+	"https": true,
+	"client_ca": "/path/to/ca.pem",
+	"tokens_file": "/path/to/tokens.json",
+	"tokens_db": true
 } 
 `
 	file, err := os.CreateTemp(os.TempDir(), "config")
@@ -39,13 +63,36 @@ func TestServerConfig_ReadConfigFromFile(t *testing.T) {
 				assert.NoError(t, err)
 			},
 			want: Config{
-				Addr:            "localhost:8083",
-				Restore:         false,
-				StoreInterval:   1,
-				FileStoragePath: "/path/to/file.db",
-				DatabaseDSN:     "",
-				CryptoKeyPath:   "/path/to/key.pem",
-				TrustedSubnet:   "125.125.0.0/16",
+				Addr:             "localhost:8083",
+				Restore:          false,
+				StoreInterval:    1,
+				FileStoragePath:  "/path/to/file.db",
+				DatabaseDSN:      "",
+				CryptoKeyPath:    "/path/to/key.pem",
+				TrustedSubnet:    "125.125.0.0/16,fd00::/8",
+				TrustedProxies:   "10.0.0.1",
+				DeniedSubnets:    "125.125.1.0/24",
+				RateRequests:     10,
+				RateMetrics:      2.5,
+				MaxSeries:        1000,
+				MaxAgentSeries:   100,
+				CardinalityMode:  "drop",
+				BoltDBPath:       "/path/to/metrics.db",
+				HistoryRetention: "raw:24h,1m:30d",
+				AdminToken:       "secret",
+				StaleTTL:         5 * time.Minute,
+				EvictTTL:         24 * time.Hour,
+				GRPCAddr:         "localhost:3200",
+				CryptoLegacy:     true,
+				CryptoKeyDir:     "/path/to/keys",
+				CryptoKeyGrace:   2 * time.Hour,
+				SignSkew:         30 * time.Second,
+				SignStrict:       true,
+				AgentKeysFile:    "/path/to/agents.json",
+				HTTPS:            true,
+				ClientCAPath:     "/path/to/ca.pem",
+				TokensFile:       "/path/to/tokens.json",
+				TokensDB:         true,
 			},
 		},
 	}
@@ -60,6 +107,29 @@ func TestServerConfig_ReadConfigFromFile(t *testing.T) {
 			assert.Equal(t, tt.want.DatabaseDSN, conf.DatabaseDSN)
 			assert.Equal(t, tt.want.CryptoKeyPath, conf.CryptoKeyPath)
 			assert.Equal(t, tt.want.TrustedSubnet, conf.TrustedSubnet)
+			assert.Equal(t, tt.want.TrustedProxies, conf.TrustedProxies)
+			assert.Equal(t, tt.want.DeniedSubnets, conf.DeniedSubnets)
+			assert.Equal(t, tt.want.RateRequests, conf.RateRequests)
+			assert.Equal(t, tt.want.RateMetrics, conf.RateMetrics)
+			assert.Equal(t, tt.want.MaxSeries, conf.MaxSeries)
+			assert.Equal(t, tt.want.MaxAgentSeries, conf.MaxAgentSeries)
+			assert.Equal(t, tt.want.CardinalityMode, conf.CardinalityMode)
+			assert.Equal(t, tt.want.BoltDBPath, conf.BoltDBPath)
+			assert.Equal(t, tt.want.HistoryRetention, conf.HistoryRetention)
+			assert.Equal(t, tt.want.AdminToken, conf.AdminToken)
+			assert.Equal(t, tt.want.StaleTTL, conf.StaleTTL)
+			assert.Equal(t, tt.want.EvictTTL, conf.EvictTTL)
+			assert.Equal(t, tt.want.GRPCAddr, conf.GRPCAddr)
+			assert.Equal(t, tt.want.CryptoLegacy, conf.CryptoLegacy)
+			assert.Equal(t, tt.want.CryptoKeyDir, conf.CryptoKeyDir)
+			assert.Equal(t, tt.want.CryptoKeyGrace, conf.CryptoKeyGrace)
+			assert.Equal(t, tt.want.SignSkew, conf.SignSkew)
+			assert.Equal(t, tt.want.SignStrict, conf.SignStrict)
+			assert.Equal(t, tt.want.AgentKeysFile, conf.AgentKeysFile)
+			assert.Equal(t, tt.want.HTTPS, conf.HTTPS)
+			assert.Equal(t, tt.want.ClientCAPath, conf.ClientCAPath)
+			assert.Equal(t, tt.want.TokensFile, conf.TokensFile)
+			assert.Equal(t, tt.want.TokensDB, conf.TokensDB)
 		})
 	}
 }
@@ -27,3 +27,8 @@ func (r *LoggingResponseWriter) WriteHeader(statusCode int) {
 	r.ResponseWriter.WriteHeader(statusCode)
 	r.ResponseData.Status = statusCode
 }
+
+// Unwrap возвращает исходный ResponseWriter, чтобы http.ResponseController мог вызвать Flush
+func (r *LoggingResponseWriter) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
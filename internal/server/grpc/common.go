@@ -0,0 +1,240 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/pbconv"
+	"github.com/sotavant/yandex-metrics/internal/server/broker"
+	"github.com/sotavant/yandex-metrics/internal/server/cardinality"
+	"github.com/sotavant/yandex-metrics/internal/server/metric"
+	"github.com/sotavant/yandex-metrics/internal/server/query"
+	"github.com/sotavant/yandex-metrics/internal/server/repository"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Размер страницы ListMetrics, такой же, как в HTTP API
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+// streamBatchSize количество метрик из потока UpdateMetrics, записываемых в хранилище за раз
+const streamBatchSize = 100
+
+// Запросы обеих версий API совпадают по полям, поэтому разбираются общими функциями через геттеры.
+
+// listParams поля запроса ListMetrics, кроме курсора
+type listParams interface {
+	GetType() string
+	GetPrefix() string
+	GetRegex() string
+	GetLabels() map[string]string
+	GetSort() string
+	GetDesc() bool
+	GetLimit() int32
+}
+
+// queryParams поля запроса QueryRange
+type queryParams interface {
+	GetSelector() string
+	GetFunction() string
+	GetQuantile() float64
+	GetStart() int64
+	GetEnd() int64
+	GetStep() int64
+	GetSum() bool
+	GetBy() []string
+}
+
+// watchParams поля запроса Watch
+type watchParams interface {
+	GetType() string
+	GetRegex() string
+	GetSelector() string
+}
+
+func listRequest(req listParams, after *repository.MetricKey) (metric.ListRequest, error) {
+	var err error
+
+	r := metric.ListRequest{
+		Options: repository.ListOptions{
+			MType:  req.GetType(),
+			Prefix: req.GetPrefix(),
+			SortBy: req.GetSort(),
+			Desc:   req.GetDesc(),
+			After:  after,
+			Limit:  defaultListLimit,
+		},
+		Labels: req.GetLabels(),
+	}
+
+	switch req.GetType() {
+	case "", internal.GaugeType, internal.CounterType:
+	default:
+		return r, fmt.Errorf("unknown type %q", req.GetType())
+	}
+
+	switch req.GetSort() {
+	case "", repository.SortByID, repository.SortByType:
+	default:
+		return r, fmt.Errorf("unknown sort %q", req.GetSort())
+	}
+
+	if limit := req.GetLimit(); limit != 0 {
+		if limit < 0 || limit > maxListLimit {
+			return r, fmt.Errorf("limit must be between 1 and %d", maxListLimit)
+		}
+
+		r.Options.Limit = int(limit)
+	}
+
+	if req.GetRegex() != "" {
+		if r.Regex, err = regexp.Compile(req.GetRegex()); err != nil {
+			return r, err
+		}
+	}
+
+	return r, nil
+}
+
+// queryRequest заменяет незаданные границы и шаг значениями по-умолчанию так же, как HTTP-обработчик
+func queryRequest(req queryParams) (query.Request, error) {
+	var err error
+
+	r := query.Request{
+		Function: req.GetFunction(),
+		Quantile: req.GetQuantile(),
+		End:      time.Now(),
+		Step:     query.DefaultStep,
+		Sum:      req.GetSum(),
+		By:       req.GetBy(),
+	}
+
+	if r.Selector, err = query.ParseSelector(req.GetSelector()); err != nil {
+		return r, err
+	}
+
+	if req.GetEnd() != 0 {
+		r.End = time.Unix(req.GetEnd(), 0)
+	}
+
+	r.Start = r.End.Add(-query.DefaultRange)
+	if req.GetStart() != 0 {
+		r.Start = time.Unix(req.GetStart(), 0)
+	}
+
+	if req.GetStep() != 0 {
+		r.Step = time.Duration(req.GetStep()) * time.Second
+	}
+
+	return r, nil
+}
+
+// updateStream записывает метрики, которые возвращает recv, пакетами по streamBatchSize,
+// пока recv не вернет io.EOF. Возвращает количество записанных метрик.
+// При ошибке recv уже полученные метрики записываются, а ошибка возвращается как есть.
+func updateStream(ctx context.Context, ms *metric.MetricService, recv func() (internal.Metrics, error)) (int64, error) {
+	var count int64
+	batch := make([]internal.Metrics, 0, streamBatchSize)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		if err := ms.AddValues(ctx, batch); err != nil {
+			return getError(err)
+		}
+
+		count += int64(len(batch))
+		batch = batch[:0]
+
+		return nil
+	}
+
+	for {
+		m, err := recv()
+		if errors.Is(err, io.EOF) {
+			return count, flush()
+		}
+
+		if err != nil {
+			if flushErr := flush(); flushErr != nil {
+				internal.Logger.Infow("error in flush metrics stream", "err", flushErr)
+			}
+
+			return count, err
+		}
+
+		batch = append(batch, m)
+		if len(batch) == streamBatchSize {
+			if err = flush(); err != nil {
+				return count, err
+			}
+		}
+	}
+}
+
+// watch передает в send каждую записанную метрику, подходящую под фильтр запроса.
+// Завершается без ошибки при остановке сервера (брокер закрывается до GracefulStop)
+// и с ResourceExhausted, если клиент не успевает читать.
+func watch(ctx context.Context, ms *metric.MetricService, req watchParams, send func(internal.Metrics) error) error {
+	filter, err := broker.NewFilter(req.GetType(), req.GetRegex(), req.GetSelector())
+	if err != nil {
+		return getError(err)
+	}
+
+	sub, err := ms.Subscribe(filter)
+	if err != nil {
+		return getError(err)
+	}
+	defer sub.Unsubscribe()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case m, ok := <-sub.C():
+			if !ok {
+				if errors.Is(sub.Err(), broker.ErrClosed) {
+					return nil
+				}
+
+				return getError(sub.Err())
+			}
+
+			if err = send(m); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func getError(err error) error {
+	switch {
+	case errors.Is(err, metric.ErrIDAbsent), errors.Is(err, metric.ErrBadType), errors.Is(err, metric.ErrValueAbsent),
+		errors.Is(err, pbconv.ErrValueAbsent), errors.Is(err, query.ErrBadQuery), errors.Is(err, broker.ErrBadFilter),
+		errors.Is(err, repository.ErrValueAbsent), errors.Is(err, repository.ErrUnknownType):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, repository.ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, repository.ErrHistoryDisabled), errors.Is(err, metric.ErrStreamDisabled),
+		errors.Is(err, cardinality.ErrLimitExceeded):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, broker.ErrClosed):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, broker.ErrSlowConsumer):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, metric.ErrAddGaugeValue), errors.Is(err, metric.ErrAddCounterValue):
+		return status.Error(codes.Internal, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
@@ -2,15 +2,17 @@ package grpc
 
 import (
 	"context"
-	"errors"
 
 	"github.com/sotavant/yandex-metrics/internal"
 	"github.com/sotavant/yandex-metrics/internal/server/metric"
+	"github.com/sotavant/yandex-metrics/internal/server/repository"
 	pb "github.com/sotavant/yandex-metrics/proto"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
+// MetricServer реализация первой версии API (yandex_metrics.Metrics).
+// Оставлена для клиентов, которые еще не перешли на MetricServerV2.
 type MetricServer struct {
 	pb.UnimplementedMetricsServer
 	MService *metric.MetricService
@@ -22,15 +24,11 @@ func NewMetricServer(mService *metric.MetricService) *MetricServer {
 	}
 }
 
+// UpdateMetric записывает метрику. В сообщении заполнены оба значения, используется значение ее типа.
 func (m *MetricServer) UpdateMetric(ctx context.Context, req *pb.UpdateMetricRequest) (*pb.UpdateMetricResponse, error) {
-	value := req.Metric.Value
-	delta := req.Metric.Delta
-
-	reqMetric := internal.Metrics{
-		Value: &value,
-		Delta: &delta,
-		ID:    req.Metric.ID,
-		MType: req.Metric.MType,
+	reqMetric, err := fromProtoMetric(req.Metric)
+	if err != nil {
+		return nil, getError(err)
 	}
 
 	respStruct, err := m.MService.Upsert(ctx, reqMetric)
@@ -39,27 +37,188 @@ func (m *MetricServer) UpdateMetric(ctx context.Context, req *pb.UpdateMetricReq
 	}
 
 	return &pb.UpdateMetricResponse{
-		Metric: &pb.Metric{
-			Value: *respStruct.Value,
-			Delta: *respStruct.Delta,
-			ID:    respStruct.ID,
-			MType: respStruct.MType,
-		},
-		Error: "",
+		Metric: toProtoMetric(respStruct),
+		Error:  "",
 	}, nil
 }
 
+// UpdateMetricsBatch записывает пакет метрик целиком. Если хотя бы одна метрика некорректна,
+// пакет отклоняется с InvalidArgument.
+func (m *MetricServer) UpdateMetricsBatch(ctx context.Context, req *pb.MetricsBatch) (*pb.Summary, error) {
+	if len(req.Metrics) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "data absent")
+	}
+
+	batch := make([]internal.Metrics, 0, len(req.Metrics))
+	for _, pm := range req.Metrics {
+		mt, err := fromProtoMetric(pm)
+		if err != nil {
+			return nil, getError(err)
+		}
+
+		batch = append(batch, mt)
+	}
+
+	if err := m.MService.AddValues(ctx, batch); err != nil {
+		return nil, getError(err)
+	}
+
+	return &pb.Summary{Count: int64(len(batch))}, nil
+}
+
+// UpdateMetrics принимает поток метрик и записывает их пакетами по streamBatchSize.
+// Корректные метрики, полученные до ошибки, остаются записанными.
+func (m *MetricServer) UpdateMetrics(stream pb.Metrics_UpdateMetricsServer) error {
+	count, err := updateStream(stream.Context(), m.MService, func() (internal.Metrics, error) {
+		pm, err := stream.Recv()
+		if err != nil {
+			return internal.Metrics{}, err
+		}
+
+		mt, err := fromProtoMetric(pm)
+		if err != nil {
+			return mt, getError(err)
+		}
+
+		return mt, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return stream.SendAndClose(&pb.Summary{Count: count})
+}
+
+// GetMetric возвращает текущее значение метрики, для отсутствующей - NotFound
+func (m *MetricServer) GetMetric(ctx context.Context, req *pb.MetricKey) (*pb.Metric, error) {
+	res, err := m.MService.Get(ctx, req.MType, req.ID)
+	if err != nil {
+		return nil, getError(err)
+	}
+
+	return toProtoMetric(res), nil
+}
+
+// GetMetrics возвращает значения метрик в порядке ключей запроса.
+// Если хотя бы одной метрики нет, возвращает NotFound с ее типом и именем.
+func (m *MetricServer) GetMetrics(ctx context.Context, req *pb.GetMetricsRequest) (*pb.MetricsBatch, error) {
+	keys := make([]repository.MetricKey, 0, len(req.Keys))
+	for _, k := range req.Keys {
+		keys = append(keys, repository.MetricKey{MType: k.MType, ID: k.ID})
+	}
+
+	metrics, err := m.MService.GetMany(ctx, keys)
+	if err != nil {
+		return nil, getError(err)
+	}
+
+	return &pb.MetricsBatch{Metrics: toProtoMetrics(metrics)}, nil
+}
+
+// ListMetrics возвращает страницу метрик с теми же фильтрами и порядком, что и GET /api/v1/metrics.
+// Следующая страница запрашивается с after = next из ответа.
+func (m *MetricServer) ListMetrics(ctx context.Context, req *pb.ListMetricsRequest) (*pb.ListMetricsResponse, error) {
+	var after *repository.MetricKey
+	if req.After != nil {
+		after = &repository.MetricKey{MType: req.After.MType, ID: req.After.ID}
+	}
+
+	listReq, err := listRequest(req, after)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	metrics, next, err := m.MService.List(ctx, listReq)
+	if err != nil {
+		return nil, getError(err)
+	}
+
+	resp := &pb.ListMetricsResponse{Metrics: toProtoMetrics(metrics)}
+	if next != nil {
+		resp.Next = &pb.MetricKey{ID: next.ID, MType: next.MType}
+	}
+
+	return resp, nil
+}
+
 func (m *MetricServer) UpdateMetricTest(ctx context.Context, req *pb.UpdateMetricRequest) (*pb.UpdateMetricResponse, error) {
 	return nil, nil
 }
 
-func getError(err error) error {
-	switch {
-	case errors.Is(err, metric.ErrIDAbsent), errors.Is(err, metric.ErrBadType), errors.Is(err, metric.ErrValueAbsent):
-		return status.Error(codes.InvalidArgument, err.Error())
-	case errors.Is(err, metric.ErrAddGaugeValue), errors.Is(err, metric.ErrAddCounterValue):
-		return status.Error(codes.Internal, err.Error())
+// QueryRange вычисляет функцию по истории метрик за интервал времени. Незаданные границы и шаг
+// заменяются значениями по-умолчанию так же, как в HTTP-обработчике.
+func (m *MetricServer) QueryRange(ctx context.Context, req *pb.QueryRangeRequest) (*pb.QueryRangeResponse, error) {
+	r, err := queryRequest(req)
+	if err != nil {
+		return nil, getError(err)
+	}
+
+	matrix, err := m.MService.QueryRange(ctx, r)
+	if err != nil {
+		return nil, getError(err)
+	}
+
+	resp := &pb.QueryRangeResponse{Series: make([]*pb.Series, 0, len(matrix))}
+	for _, s := range matrix {
+		series := &pb.Series{Labels: s.Metric, Samples: make([]*pb.Sample, 0, len(s.Values))}
+		for _, v := range s.Values {
+			series.Samples = append(series.Samples, &pb.Sample{Timestamp: v.T.UnixMilli(), Value: v.V})
+		}
+
+		resp.Series = append(resp.Series, series)
+	}
+
+	return resp, nil
+}
+
+// Watch отправляет клиенту каждую записанную метрику, подходящую под фильтр запроса.
+// Поток завершается без ошибки при остановке сервера (брокер закрывается до GracefulStop)
+// и с ResourceExhausted, если клиент не успевает читать.
+func (m *MetricServer) Watch(req *pb.WatchRequest, stream pb.Metrics_WatchServer) error {
+	return watch(stream.Context(), m.MService, req, func(mt internal.Metrics) error {
+		return stream.Send(toProtoMetric(mt))
+	})
+}
+
+// fromProtoMetric проверяет метрику из запроса так же, как Upsert, и берет значение ее типа
+func fromProtoMetric(pm *pb.Metric) (internal.Metrics, error) {
+	if pm == nil || pm.ID == "" {
+		return internal.Metrics{}, metric.ErrIDAbsent
+	}
+
+	res := internal.Metrics{ID: pm.ID, MType: pm.MType}
+	switch pm.MType {
+	case internal.GaugeType:
+		value := pm.Value
+		res.Value = &value
+	case internal.CounterType:
+		delta := pm.Delta
+		res.Delta = &delta
 	default:
-		return status.Error(codes.Internal, err.Error())
+		return internal.Metrics{}, metric.ErrBadType
+	}
+
+	return res, nil
+}
+
+func toProtoMetrics(metrics []internal.Metrics) []*pb.Metric {
+	res := make([]*pb.Metric, 0, len(metrics))
+	for _, mt := range metrics {
+		res = append(res, toProtoMetric(mt))
 	}
+
+	return res
+}
+
+func toProtoMetric(m internal.Metrics) *pb.Metric {
+	res := &pb.Metric{ID: m.ID, MType: m.MType, Stale: m.Stale}
+	if m.Value != nil {
+		res.Value = *m.Value
+	}
+
+	if m.Delta != nil {
+		res.Delta = *m.Delta
+	}
+
+	return res
 }
@@ -2,14 +2,26 @@ package grpc
 
 import (
 	"context"
+	"io"
+	"net"
 	"testing"
+	"time"
 
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/server/broker"
 	"github.com/sotavant/yandex-metrics/internal/server/metric"
+	"github.com/sotavant/yandex-metrics/internal/server/middleware"
+	"github.com/sotavant/yandex-metrics/internal/server/repository"
 	"github.com/sotavant/yandex-metrics/internal/server/repository/memory"
 	pb "github.com/sotavant/yandex-metrics/proto"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
 )
 
 func TestMetricServer_UpdateMetric(t *testing.T) {
@@ -70,3 +82,345 @@ func TestMetricServer_UpdateMetric(t *testing.T) {
 		})
 	}
 }
+
+func TestMetricServer_UpdateMetricsBatch(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name         string
+		req          *pb.MetricsBatch
+		wantStatus   codes.Code
+		wantCount    int64
+		wantCounters map[string]int64
+	}{
+		{
+			name: "success",
+			req: &pb.MetricsBatch{Metrics: []*pb.Metric{
+				{ID: "cnt", MType: internal.CounterType, Delta: 2},
+				{ID: "temp", MType: internal.GaugeType, Value: 1.5},
+				{ID: "cnt", MType: internal.CounterType, Delta: 3},
+			}},
+			wantStatus:   codes.OK,
+			wantCount:    3,
+			wantCounters: map[string]int64{"cnt": 5},
+		},
+		{
+			name:       "empty batch",
+			req:        &pb.MetricsBatch{},
+			wantStatus: codes.InvalidArgument,
+		},
+		{
+			name: "bad type rejects whole batch",
+			req: &pb.MetricsBatch{Metrics: []*pb.Metric{
+				{ID: "cnt", MType: internal.CounterType, Delta: 2},
+				{ID: "h", MType: "histogram"},
+			}},
+			wantStatus: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := memory.NewMetricsRepository()
+			server := NewMetricServer(metric.NewMetricService(st))
+
+			res, err := server.UpdateMetricsBatch(ctx, tt.req)
+			assert.Equal(t, tt.wantStatus, status.Code(err))
+
+			counters, err := st.GetCounters(ctx)
+			require.NoError(t, err)
+
+			if tt.wantStatus != codes.OK {
+				assert.Empty(t, counters)
+				return
+			}
+
+			assert.Equal(t, tt.wantCount, res.Count)
+			assert.Equal(t, tt.wantCounters, counters)
+		})
+	}
+}
+
+func TestMetricServer_UpdateMetrics(t *testing.T) {
+	internal.InitLogger()
+	ctx := context.Background()
+	st := memory.NewMetricsRepository()
+
+	lis := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer()
+	pb.RegisterMetricsServer(s, NewMetricServer(metric.NewMetricService(st)))
+	go func() {
+		assert.NoError(t, s.Serve(lis))
+	}()
+	defer s.Stop()
+
+	conn, err := grpc.NewClient("passthrough://bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, conn.Close())
+	}()
+	client := pb.NewMetricsClient(conn)
+
+	// больше одного пакета записи
+	stream, err := client.UpdateMetrics(ctx)
+	require.NoError(t, err)
+	for i := 0; i < streamBatchSize+1; i++ {
+		require.NoError(t, stream.Send(&pb.Metric{ID: "cnt", MType: internal.CounterType, Delta: 1}))
+	}
+
+	res, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+	assert.Equal(t, int64(streamBatchSize+1), res.Count)
+
+	cnt, err := st.GetCounterValue(ctx, "cnt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(streamBatchSize+1), cnt)
+
+	// метрики до некорректной остаются записанными
+	stream, err = client.UpdateMetrics(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pb.Metric{ID: "temp", MType: internal.GaugeType, Value: 2.5}))
+	require.NoError(t, stream.Send(&pb.Metric{MType: internal.GaugeType, Value: 1}))
+
+	_, err = stream.CloseAndRecv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	temp, err := st.GetGaugeValue(ctx, "temp")
+	require.NoError(t, err)
+	assert.Equal(t, 2.5, temp)
+}
+
+func TestMetricServer_QueryRange(t *testing.T) {
+	ctx := context.Background()
+	tiers := []repository.RetentionTier{{Resolution: 0, Retention: time.Hour}}
+	st := memory.NewHistoryRepository(memory.NewShardedMetricsRepository(memory.DefaultShardsCount), tiers)
+	require.NoError(t, st.AddGaugeValue(ctx, "temp{room=1}", 10))
+	require.NoError(t, st.AddGaugeValue(ctx, "temp{room=1}", 30))
+
+	server := NewMetricServer(metric.NewMetricService(st))
+	end := time.Now().Add(time.Minute).Unix()
+
+	res, err := server.QueryRange(ctx, &pb.QueryRangeRequest{
+		Selector: "temp",
+		Function: "avg_over_time",
+		Start:    end,
+		End:      end,
+		Step:     120,
+	})
+	require.NoError(t, err)
+	require.Len(t, res.Series, 1)
+	assert.Equal(t, map[string]string{"__name__": "temp", "room": "1"}, res.Series[0].Labels)
+	require.Len(t, res.Series[0].Samples, 1)
+	assert.Equal(t, end*1000, res.Series[0].Samples[0].Timestamp)
+	assert.Equal(t, 20.0, res.Series[0].Samples[0].Value)
+
+	_, err = server.QueryRange(ctx, &pb.QueryRangeRequest{Selector: "temp", Function: "median"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	disabled := NewMetricServer(metric.NewMetricService(memory.NewMetricsRepository()))
+	_, err = disabled.QueryRange(ctx, &pb.QueryRangeRequest{Selector: "temp", Function: "avg_over_time"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+func TestMetricServer_Watch(t *testing.T) {
+	internal.InitLogger()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	b := broker.New()
+	ms := metric.NewMetricService(memory.NewShardedMetricsRepository(memory.DefaultShardsCount)).WithBroker(b)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ipChecker := middleware.NewIPChecker("192.168.1.0/24").WithTrustedProxies("127.0.0.1")
+	s := grpc.NewServer(grpc.ChainStreamInterceptor(ipChecker.CheckIPStreamInterceptor))
+	pb.RegisterMetricsServer(s, NewMetricServer(ms))
+	go func() {
+		assert.NoError(t, s.Serve(lis))
+	}()
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, conn.Close())
+	}()
+	client := pb.NewMetricsClient(conn)
+
+	// потоковый метод проходит те же проверки, что и UpdateMetric
+	stream, err := client.Watch(ctx, &pb.WatchRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx = metadata.NewOutgoingContext(ctx, metadata.Pairs("X-Real-IP", "192.168.1.10"))
+
+	stream, err = client.Watch(ctx, &pb.WatchRequest{Type: "histogram"})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	stream, err = client.Watch(ctx, &pb.WatchRequest{Type: internal.CounterType, Selector: "requests{host=a}"})
+	require.NoError(t, err)
+	require.Eventually(t, b.HasSubscribers, time.Second, time.Millisecond)
+
+	gauge, delta := 1.5, int64(2)
+	_, err = ms.Upsert(ctx, internal.Metrics{ID: "requests{host=a}", MType: internal.GaugeType, Value: &gauge})
+	require.NoError(t, err)
+	_, err = ms.Upsert(ctx, internal.Metrics{ID: "requests{host=b}", MType: internal.CounterType, Delta: &delta})
+	require.NoError(t, err)
+	_, err = ms.Upsert(ctx, internal.Metrics{ID: "requests{host=a}", MType: internal.CounterType, Delta: &delta})
+	require.NoError(t, err)
+
+	m, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "requests{host=a}", m.ID)
+	assert.Equal(t, internal.CounterType, m.MType)
+	assert.Equal(t, delta, m.Delta)
+
+	// закрытие брокера перед GracefulStop завершает поток без ошибки
+	b.Close()
+	s.GracefulStop()
+
+	_, err = stream.Recv()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestMetricServer_GetMetric(t *testing.T) {
+	ctx := context.Background()
+	st := memory.NewMetricsRepository()
+	require.NoError(t, st.AddGaugeValue(ctx, "temp", 1.5))
+	require.NoError(t, st.AddCounterValue(ctx, "cnt", 3))
+
+	server := NewMetricServer(metric.NewMetricService(st))
+
+	tests := []struct {
+		name       string
+		req        *pb.MetricKey
+		want       *pb.Metric
+		wantStatus codes.Code
+	}{
+		{
+			name:       "gauge",
+			req:        &pb.MetricKey{ID: "temp", MType: internal.GaugeType},
+			want:       &pb.Metric{ID: "temp", MType: internal.GaugeType, Value: 1.5},
+			wantStatus: codes.OK,
+		},
+		{
+			name:       "counter",
+			req:        &pb.MetricKey{ID: "cnt", MType: internal.CounterType},
+			want:       &pb.Metric{ID: "cnt", MType: internal.CounterType, Delta: 3},
+			wantStatus: codes.OK,
+		},
+		{
+			name:       "not found",
+			req:        &pb.MetricKey{ID: "temp", MType: internal.CounterType},
+			wantStatus: codes.NotFound,
+		},
+		{
+			name:       "bad type",
+			req:        &pb.MetricKey{ID: "temp", MType: "histogram"},
+			wantStatus: codes.InvalidArgument,
+		},
+		{
+			name:       "id absent",
+			req:        &pb.MetricKey{MType: internal.GaugeType},
+			wantStatus: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := server.GetMetric(ctx, tt.req)
+			assert.Equal(t, tt.wantStatus, status.Code(err))
+			if tt.wantStatus != codes.OK {
+				return
+			}
+
+			assert.Equal(t, tt.want.ID, res.ID)
+			assert.Equal(t, tt.want.MType, res.MType)
+			assert.Equal(t, tt.want.Value, res.Value)
+			assert.Equal(t, tt.want.Delta, res.Delta)
+		})
+	}
+}
+
+func TestMetricServer_GetMetrics(t *testing.T) {
+	ctx := context.Background()
+	st := memory.NewMetricsRepository()
+	require.NoError(t, st.AddGaugeValue(ctx, "temp", 1.5))
+	require.NoError(t, st.AddCounterValue(ctx, "cnt", 3))
+
+	server := NewMetricServer(metric.NewMetricService(st))
+
+	res, err := server.GetMetrics(ctx, &pb.GetMetricsRequest{Keys: []*pb.MetricKey{
+		{ID: "cnt", MType: internal.CounterType},
+		{ID: "temp", MType: internal.GaugeType},
+	}})
+	require.NoError(t, err)
+	require.Len(t, res.Metrics, 2)
+	assert.Equal(t, int64(3), res.Metrics[0].Delta)
+	assert.Equal(t, 1.5, res.Metrics[1].Value)
+
+	_, err = server.GetMetrics(ctx, &pb.GetMetricsRequest{Keys: []*pb.MetricKey{
+		{ID: "temp", MType: internal.GaugeType},
+		{ID: "absent", MType: internal.GaugeType},
+	}})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Contains(t, err.Error(), "absent")
+}
+
+func TestMetricServer_ListMetrics(t *testing.T) {
+	ctx := context.Background()
+	st := memory.NewMetricsRepository()
+	require.NoError(t, st.AddGaugeValue(ctx, "cpu{host=a}", 1))
+	require.NoError(t, st.AddGaugeValue(ctx, "cpu{host=b}", 2))
+	require.NoError(t, st.AddGaugeValue(ctx, "mem{host=a}", 3))
+	require.NoError(t, st.AddCounterValue(ctx, "cpu{host=a}", 4))
+
+	server := NewMetricServer(metric.NewMetricService(st))
+
+	ids := func(metrics []*pb.Metric) []string {
+		res := make([]string, 0, len(metrics))
+		for _, m := range metrics {
+			res = append(res, m.MType+"/"+m.ID)
+		}
+
+		return res
+	}
+
+	// постраничное чтение
+	res, err := server.ListMetrics(ctx, &pb.ListMetricsRequest{Limit: 3})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"counter/cpu{host=a}", "gauge/cpu{host=a}", "gauge/cpu{host=b}"}, ids(res.Metrics))
+	require.NotNil(t, res.Next)
+
+	res, err = server.ListMetrics(ctx, &pb.ListMetricsRequest{Limit: 3, After: res.Next})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"gauge/mem{host=a}"}, ids(res.Metrics))
+	assert.Nil(t, res.Next)
+
+	// фильтры
+	res, err = server.ListMetrics(ctx, &pb.ListMetricsRequest{
+		Type:   internal.GaugeType,
+		Labels: map[string]string{"host": "a"},
+		Desc:   true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"gauge/mem{host=a}", "gauge/cpu{host=a}"}, ids(res.Metrics))
+
+	res, err = server.ListMetrics(ctx, &pb.ListMetricsRequest{Regex: "^mem", Prefix: "m"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"gauge/mem{host=a}"}, ids(res.Metrics))
+
+	for _, req := range []*pb.ListMetricsRequest{
+		{Type: "histogram"},
+		{Sort: "value"},
+		{Limit: maxListLimit + 1},
+		{Regex: "("},
+	} {
+		_, err = server.ListMetrics(ctx, req)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+}
@@ -0,0 +1,190 @@
+package grpc
+
+import (
+	"context"
+
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/pbconv"
+	"github.com/sotavant/yandex-metrics/internal/server/metric"
+	"github.com/sotavant/yandex-metrics/internal/server/repository"
+	pbv2 "github.com/sotavant/yandex-metrics/proto/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// MetricServerV2 реализация API yandex_metrics.v2.Metrics, в котором значение метрики
+// передается полем oneof и тип определяется заполненным полем
+type MetricServerV2 struct {
+	pbv2.UnimplementedMetricsServer
+	MService *metric.MetricService
+}
+
+func NewMetricServerV2(mService *metric.MetricService) *MetricServerV2 {
+	return &MetricServerV2{
+		MService: mService,
+	}
+}
+
+// UpdateMetric записывает метрику и возвращает ее текущее значение
+func (m *MetricServerV2) UpdateMetric(ctx context.Context, req *pbv2.Metric) (*pbv2.Metric, error) {
+	reqMetric, err := pbconv.MetricFromV2(req)
+	if err != nil {
+		return nil, getError(err)
+	}
+
+	res, err := m.MService.Upsert(ctx, reqMetric)
+	if err != nil {
+		return nil, getError(err)
+	}
+
+	return pbconv.MetricToV2(res), nil
+}
+
+// UpdateMetricsBatch записывает пакет метрик целиком. Если хотя бы одна метрика некорректна,
+// пакет отклоняется с InvalidArgument.
+func (m *MetricServerV2) UpdateMetricsBatch(ctx context.Context, req *pbv2.MetricsBatch) (*pbv2.Summary, error) {
+	if len(req.Metrics) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "data absent")
+	}
+
+	batch := make([]internal.Metrics, 0, len(req.Metrics))
+	for _, pm := range req.Metrics {
+		mt, err := fromProtoMetricV2(pm)
+		if err != nil {
+			return nil, getError(err)
+		}
+
+		batch = append(batch, mt)
+	}
+
+	if err := m.MService.AddValues(ctx, batch); err != nil {
+		return nil, getError(err)
+	}
+
+	return &pbv2.Summary{Count: int64(len(batch))}, nil
+}
+
+// UpdateMetrics принимает поток метрик и записывает их пакетами по streamBatchSize.
+// Корректные метрики, полученные до ошибки, остаются записанными.
+func (m *MetricServerV2) UpdateMetrics(stream pbv2.Metrics_UpdateMetricsServer) error {
+	count, err := updateStream(stream.Context(), m.MService, func() (internal.Metrics, error) {
+		pm, err := stream.Recv()
+		if err != nil {
+			return internal.Metrics{}, err
+		}
+
+		mt, err := fromProtoMetricV2(pm)
+		if err != nil {
+			return mt, getError(err)
+		}
+
+		return mt, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return stream.SendAndClose(&pbv2.Summary{Count: count})
+}
+
+// GetMetric возвращает текущее значение метрики, для отсутствующей - NotFound
+func (m *MetricServerV2) GetMetric(ctx context.Context, req *pbv2.MetricKey) (*pbv2.Metric, error) {
+	res, err := m.MService.Get(ctx, req.Type, req.Id)
+	if err != nil {
+		return nil, getError(err)
+	}
+
+	return pbconv.MetricToV2(res), nil
+}
+
+// GetMetrics возвращает значения метрик в порядке ключей запроса.
+// Если хотя бы одной метрики нет, возвращает NotFound с ее типом и именем.
+func (m *MetricServerV2) GetMetrics(ctx context.Context, req *pbv2.GetMetricsRequest) (*pbv2.MetricsBatch, error) {
+	keys := make([]repository.MetricKey, 0, len(req.Keys))
+	for _, k := range req.Keys {
+		keys = append(keys, repository.MetricKey{MType: k.Type, ID: k.Id})
+	}
+
+	metrics, err := m.MService.GetMany(ctx, keys)
+	if err != nil {
+		return nil, getError(err)
+	}
+
+	return &pbv2.MetricsBatch{Metrics: toProtoMetricsV2(metrics)}, nil
+}
+
+// ListMetrics возвращает страницу метрик с теми же фильтрами и порядком, что и GET /api/v1/metrics.
+// Следующая страница запрашивается с after = next из ответа.
+func (m *MetricServerV2) ListMetrics(ctx context.Context, req *pbv2.ListMetricsRequest) (*pbv2.ListMetricsResponse, error) {
+	var after *repository.MetricKey
+	if req.After != nil {
+		after = &repository.MetricKey{MType: req.After.Type, ID: req.After.Id}
+	}
+
+	listReq, err := listRequest(req, after)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	metrics, next, err := m.MService.List(ctx, listReq)
+	if err != nil {
+		return nil, getError(err)
+	}
+
+	resp := &pbv2.ListMetricsResponse{Metrics: toProtoMetricsV2(metrics)}
+	if next != nil {
+		resp.Next = &pbv2.MetricKey{Id: next.ID, Type: next.MType}
+	}
+
+	return resp, nil
+}
+
+// QueryRange вычисляет функцию по истории метрик за интервал времени
+func (m *MetricServerV2) QueryRange(ctx context.Context, req *pbv2.QueryRangeRequest) (*pbv2.QueryRangeResponse, error) {
+	r, err := queryRequest(req)
+	if err != nil {
+		return nil, getError(err)
+	}
+
+	matrix, err := m.MService.QueryRange(ctx, r)
+	if err != nil {
+		return nil, getError(err)
+	}
+
+	resp := &pbv2.QueryRangeResponse{Series: make([]*pbv2.Series, 0, len(matrix))}
+	for _, s := range matrix {
+		series := &pbv2.Series{Labels: s.Metric, Samples: make([]*pbv2.Sample, 0, len(s.Values))}
+		for _, v := range s.Values {
+			series.Samples = append(series.Samples, &pbv2.Sample{Timestamp: v.T.UnixMilli(), Value: v.V})
+		}
+
+		resp.Series = append(resp.Series, series)
+	}
+
+	return resp, nil
+}
+
+// Watch отправляет клиенту каждую записанную метрику, подходящую под фильтр запроса
+func (m *MetricServerV2) Watch(req *pbv2.WatchRequest, stream pbv2.Metrics_WatchServer) error {
+	return watch(stream.Context(), m.MService, req, func(mt internal.Metrics) error {
+		return stream.Send(pbconv.MetricToV2(mt))
+	})
+}
+
+// fromProtoMetricV2 метрика из пакета или потока, ID проверяется здесь, так как AddValues его не проверяет
+func fromProtoMetricV2(pm *pbv2.Metric) (internal.Metrics, error) {
+	if pm.GetId() == "" {
+		return internal.Metrics{}, metric.ErrIDAbsent
+	}
+
+	return pbconv.MetricFromV2(pm)
+}
+
+func toProtoMetricsV2(metrics []internal.Metrics) []*pbv2.Metric {
+	res := make([]*pbv2.Metric, 0, len(metrics))
+	for _, mt := range metrics {
+		res = append(res, pbconv.MetricToV2(mt))
+	}
+
+	return res
+}
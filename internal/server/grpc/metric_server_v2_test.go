@@ -0,0 +1,140 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/server/metric"
+	"github.com/sotavant/yandex-metrics/internal/server/repository/memory"
+	pb "github.com/sotavant/yandex-metrics/proto"
+	pbv2 "github.com/sotavant/yandex-metrics/proto/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestMetricServerV2_UpdateMetric(t *testing.T) {
+	ctx := context.Background()
+	st := memory.NewMetricsRepository()
+	server := NewMetricServerV2(metric.NewMetricService(st))
+
+	tests := []struct {
+		name       string
+		req        *pbv2.Metric
+		want       *pbv2.Metric
+		wantStatus codes.Code
+	}{
+		{
+			name:       "gauge",
+			req:        &pbv2.Metric{Id: "temp", Value: &pbv2.Metric_Gauge{Gauge: 1.5}},
+			want:       &pbv2.Metric{Id: "temp", Value: &pbv2.Metric_Gauge{Gauge: 1.5}},
+			wantStatus: codes.OK,
+		},
+		{
+			name:       "counter returns current value",
+			req:        &pbv2.Metric{Id: "cnt", Value: &pbv2.Metric_Counter{Counter: 2}},
+			want:       &pbv2.Metric{Id: "cnt", Value: &pbv2.Metric_Counter{Counter: 2}},
+			wantStatus: codes.OK,
+		},
+		{
+			name:       "counter accumulates",
+			req:        &pbv2.Metric{Id: "cnt", Value: &pbv2.Metric_Counter{Counter: 3}},
+			want:       &pbv2.Metric{Id: "cnt", Value: &pbv2.Metric_Counter{Counter: 5}},
+			wantStatus: codes.OK,
+		},
+		{
+			name:       "value absent",
+			req:        &pbv2.Metric{Id: "temp"},
+			wantStatus: codes.InvalidArgument,
+		},
+		{
+			name:       "id absent",
+			req:        &pbv2.Metric{Value: &pbv2.Metric_Gauge{Gauge: 1}},
+			wantStatus: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := server.UpdateMetric(ctx, tt.req)
+			assert.Equal(t, tt.wantStatus, status.Code(err))
+			if tt.wantStatus != codes.OK {
+				return
+			}
+
+			assert.Equal(t, tt.want.Id, res.Id)
+			assert.Equal(t, tt.want.GetValue(), res.GetValue())
+		})
+	}
+}
+
+func TestMetricServerV2_UpdateMetricsBatch(t *testing.T) {
+	ctx := context.Background()
+	st := memory.NewMetricsRepository()
+	server := NewMetricServerV2(metric.NewMetricService(st))
+
+	res, err := server.UpdateMetricsBatch(ctx, &pbv2.MetricsBatch{Metrics: []*pbv2.Metric{
+		{Id: "cnt", Value: &pbv2.Metric_Counter{Counter: 2}},
+		{Id: "temp", Value: &pbv2.Metric_Gauge{Gauge: 1.5}},
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), res.Count)
+
+	_, err = server.UpdateMetricsBatch(ctx, &pbv2.MetricsBatch{Metrics: []*pbv2.Metric{
+		{Id: "cnt", Value: &pbv2.Metric_Counter{Counter: 2}},
+		{Id: "temp"},
+	}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	cnt, err := st.GetCounterValue(ctx, "cnt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), cnt)
+}
+
+// обе версии API зарегистрированы на одном сервере и работают с одним хранилищем
+func TestMetricServer_Versions(t *testing.T) {
+	internal.InitLogger()
+	ctx := context.Background()
+	ms := metric.NewMetricService(memory.NewMetricsRepository())
+
+	lis := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer()
+	pb.RegisterMetricsServer(s, NewMetricServer(ms))
+	pbv2.RegisterMetricsServer(s, NewMetricServerV2(ms))
+	go func() {
+		assert.NoError(t, s.Serve(lis))
+	}()
+	defer s.Stop()
+
+	conn, err := grpc.NewClient("passthrough://bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, conn.Close())
+	}()
+
+	v1 := pb.NewMetricsClient(conn)
+	v2 := pbv2.NewMetricsClient(conn)
+
+	resp, err := v1.UpdateMetric(ctx, &pb.UpdateMetricRequest{Metric: &pb.Metric{ID: "cnt", MType: internal.CounterType, Delta: 2}})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), resp.Metric.Delta)
+
+	m, err := v2.UpdateMetric(ctx, &pbv2.Metric{Id: "cnt", Value: &pbv2.Metric_Counter{Counter: 3}})
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), m.GetCounter())
+
+	got, err := v1.GetMetric(ctx, &pb.MetricKey{ID: "cnt", MType: internal.CounterType})
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), got.Delta)
+
+	_, err = v2.GetMetric(ctx, &pbv2.MetricKey{Id: "cnt", Type: internal.GaugeType})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
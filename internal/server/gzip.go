@@ -53,6 +53,15 @@ func (c *CompressWriter) WriteHeader(statusCode int) {
 	c.w.WriteHeader(statusCode)
 }
 
+// FlushError сбрасывает накопленные сжатые данные клиенту, используется http.ResponseController
+func (c *CompressWriter) FlushError() error {
+	if err := c.zw.Flush(); err != nil {
+		return err
+	}
+
+	return http.NewResponseController(c.w).Flush()
+}
+
 func (c *CompressWriter) Close() error {
 	return c.zw.Close()
 }
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/server"
+	"github.com/sotavant/yandex-metrics/internal/server/cardinality"
+	"github.com/sotavant/yandex-metrics/internal/server/metric"
+	"github.com/sotavant/yandex-metrics/internal/server/repository"
+)
+
+// deleteMetricsResponse результат массового удаления
+type deleteMetricsResponse struct {
+	Deleted int `json:"deleted"`
+}
+
+// DeleteValueHandler Данный обработчик обрабатывает урлы вида: /value/{type}/{name} (DELETE-запрос).
+// Удаляет метрику, доступен только администратору.
+//
+// Где:
+//
+//	type - тип метрики (gauge/counter)
+//	name - название метрики
+//
+// Коды ответа:
+//
+//	200 - успешный ответ
+//	400 - неверные параметры
+//	404 - метрика не найдена
+//	500 - ошибка сервера
+func DeleteValueHandler(appInstance *server.App, ms *metric.MetricService) func(res http.ResponseWriter, req *http.Request) {
+	return func(res http.ResponseWriter, req *http.Request) {
+		err := ms.Delete(req.Context(), chi.URLParam(req, "type"), chi.URLParam(req, "name"))
+		if !handleAdminError(res, err) {
+			return
+		}
+
+		if !syncDeletion(res, req, appInstance) {
+			return
+		}
+
+		res.WriteHeader(http.StatusOK)
+	}
+}
+
+// DeleteMetricsHandler Данный обработчик обрабатывает урлы вида: /api/v1/metrics (DELETE-запрос).
+// Удаляет все метрики, подходящие под селектор, доступен только администратору.
+//
+// Параметры (должен быть задан хотя бы один):
+//
+//	type - gauge или counter
+//	prefix - префикс ID
+//	regex - регулярное выражение для ID
+//	label - фильтр по метке из ID вида name{k=v}, задается как label=k=v, можно указать несколько раз
+//
+// Коды ответа:
+//
+//	200 - успешный ответ
+//	400 - неверные параметры
+//	500 - ошибка сервера
+//
+// Ответ:
+//
+//	{"deleted": 3}
+func DeleteMetricsHandler(appInstance *server.App, ms *metric.MetricService) func(res http.ResponseWriter, req *http.Request) {
+	return func(res http.ResponseWriter, req *http.Request) {
+		values := req.URL.Query()
+		if values.Get("type") == "" && values.Get("prefix") == "" && values.Get("regex") == "" && len(values["label"]) == 0 {
+			http.Error(res, "selector is empty", http.StatusBadRequest)
+			return
+		}
+
+		listReq, err := parseListRequest(values)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		deleted, err := ms.DeleteMatching(req.Context(), listReq)
+		if err != nil {
+			internal.Logger.Infow("error in delete metrics", "err", err, "deleted", deleted)
+		}
+
+		// часть метрик могла быть удалена до ошибки, сохраняем это состояние
+		if deleted > 0 && !syncDeletion(res, req, appInstance) {
+			return
+		}
+
+		if err != nil {
+			http.Error(res, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(res)
+		if err = enc.Encode(deleteMetricsResponse{Deleted: deleted}); err != nil {
+			internal.Logger.Infow("error in encode")
+			http.Error(res, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// ResetCounterHandler Данный обработчик обрабатывает урлы вида: /reset/counter/{name} (POST-запрос).
+// Обнуляет счетчик, доступен только администратору.
+//
+// Коды ответа:
+//
+//	200 - успешный ответ
+//	404 - счетчик не найден
+//	500 - ошибка сервера
+func ResetCounterHandler(appInstance *server.App, ms *metric.MetricService) func(res http.ResponseWriter, req *http.Request) {
+	return func(res http.ResponseWriter, req *http.Request) {
+		err := ms.ResetCounter(req.Context(), chi.URLParam(req, "name"))
+		if !handleAdminError(res, err) {
+			return
+		}
+
+		if !syncDeletion(res, req, appInstance) {
+			return
+		}
+
+		res.WriteHeader(http.StatusOK)
+	}
+}
+
+// CardinalityHandler Данный обработчик обрабатывает урлы вида: /api/v1/cardinality (GET-запрос).
+// Возвращает количество активных рядов, всего и по агентам, и лимиты. Доступен только администратору.
+// Ряды, загруженные из хранилища при запуске, учтены за агентом restored.
+//
+// Коды ответа:
+//
+//	200 - успешный ответ
+//	500 - ошибка сервера
+//
+// Ответ:
+//
+//	{"series": 3, "max_series": 1000, "max_per_agent": 100, "mode": "reject", "agents": {"agent:host-1": 2, "restored": 1}}
+func CardinalityHandler(tracker *cardinality.Tracker) func(res http.ResponseWriter, req *http.Request) {
+	return func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(res)
+		if err := enc.Encode(tracker.Stats()); err != nil {
+			internal.Logger.Infow("error in encode")
+			http.Error(res, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// handleAdminError пишет ответ с ошибкой, возвращает false, если обработку нужно прервать
+func handleAdminError(res http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, metric.ErrBadType), errors.Is(err, repository.ErrUnknownType):
+		http.Error(res, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+	case errors.Is(err, repository.ErrNotFound):
+		http.Error(res, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	default:
+		internal.Logger.Infow("error in admin request", "err", err)
+		http.Error(res, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+
+	return false
+}
+
+// syncDeletion сразу сбрасывает хранилище в файл независимо от интервала сохранения,
+// иначе после перезапуска удаленные метрики восстановятся из старого снимка
+func syncDeletion(res http.ResponseWriter, req *http.Request, appInstance *server.App) bool {
+	if appInstance.Fs == nil {
+		return true
+	}
+
+	if err := appInstance.Fs.Sync(req.Context(), appInstance.Storage); err != nil {
+		internal.Logger.Infow("error in sync", "err", err)
+		http.Error(res, "internal server error", http.StatusInternalServerError)
+		return false
+	}
+
+	return true
+}
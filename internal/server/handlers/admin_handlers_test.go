@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/server"
+	"github.com/sotavant/yandex-metrics/internal/server/cardinality"
+	"github.com/sotavant/yandex-metrics/internal/server/config"
+	"github.com/sotavant/yandex-metrics/internal/server/metric"
+	"github.com/sotavant/yandex-metrics/internal/server/repository"
+	"github.com/sotavant/yandex-metrics/internal/server/repository/memory"
+	"github.com/sotavant/yandex-metrics/internal/server/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminHandlers(t *testing.T) {
+	internal.InitLogger()
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+
+	fs, err := storage.NewFileStorage(path, true, config.DefaultStoreInterval)
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, fs.File.Close())
+	}()
+
+	st := memory.NewShardedMetricsRepository(memory.DefaultShardsCount)
+	for _, id := range []string{"Alloc", "requests{host=a}", "requests{host=b}"} {
+		require.NoError(t, st.AddGaugeValue(ctx, id, 1))
+	}
+	require.NoError(t, st.AddCounterValue(ctx, "PollCount", 5))
+
+	appInstance := &server.App{Config: &config.Config{}, Storage: st, Fs: fs}
+	values, err := st.GetValues(ctx)
+	require.NoError(t, err)
+	tracker := cardinality.NewTracker(0, 0, cardinality.ModeReject)
+	tracker.Seed(values)
+	ms := metric.NewMetricService(st).WithCardinality(tracker)
+
+	r := chi.NewRouter()
+	r.Delete("/value/{type}/{name}", DeleteValueHandler(appInstance, ms))
+	r.Delete("/api/v1/metrics", DeleteMetricsHandler(appInstance, ms))
+	r.Post("/reset/counter/{name}", ResetCounterHandler(appInstance, ms))
+	r.Get("/api/v1/cardinality", CardinalityHandler(tracker))
+
+	call := func(method, target string) (int, string) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+
+		return w.Code, w.Body.String()
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "deleteGauge",
+			method:     http.MethodDelete,
+			target:     "/value/gauge/Alloc",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "deleteAbsent",
+			method:     http.MethodDelete,
+			target:     "/value/gauge/Alloc",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "deleteBadType",
+			method:     http.MethodDelete,
+			target:     "/value/histogram/Alloc",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "resetCounter",
+			method:     http.MethodPost,
+			target:     "/reset/counter/PollCount",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "resetAbsentCounter",
+			method:     http.MethodPost,
+			target:     "/reset/counter/Absent",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "bulkDeleteWithoutSelector",
+			method:     http.MethodDelete,
+			target:     "/api/v1/metrics",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "bulkDeleteByLabel",
+			method:     http.MethodDelete,
+			target:     "/api/v1/metrics?type=gauge&label=host%3Da",
+			wantStatus: http.StatusOK,
+			wantBody:   `{"deleted":1}` + "\n",
+		},
+		{
+			// удаленные ряды больше не учитываются
+			name:       "cardinality",
+			method:     http.MethodGet,
+			target:     "/api/v1/cardinality",
+			wantStatus: http.StatusOK,
+			wantBody:   `{"series":2,"max_series":0,"max_per_agent":0,"mode":"reject","agents":{"restored":2}}` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, body := call(tt.method, tt.target)
+			assert.Equal(t, tt.wantStatus, code)
+			if tt.wantBody != "" {
+				assert.Equal(t, tt.wantBody, body)
+			}
+		})
+	}
+
+	counter, err := st.GetCounterValue(ctx, "PollCount")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), counter)
+
+	// файл синхронизирован сразу, несмотря на интервал сохранения
+	restoredFs, err := storage.NewFileStorage(path, true, 0)
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, restoredFs.File.Close())
+		assert.NoError(t, os.Remove(path))
+	}()
+
+	restored := memory.NewMetricsRepository()
+	require.NoError(t, restoredFs.Restore(ctx, restored))
+
+	values, err = restored.ListValues(ctx, repository.ListOptions{})
+	assert.NoError(t, err)
+
+	ids := make([]string, 0, len(values))
+	for _, m := range values {
+		ids = append(ids, m.ID)
+	}
+	assert.Equal(t, []string{"PollCount", "requests{host=b}"}, ids)
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/sotavant/yandex-metrics/internal"
 	"github.com/sotavant/yandex-metrics/internal/server"
 	"github.com/sotavant/yandex-metrics/internal/server/config"
+	"github.com/sotavant/yandex-metrics/internal/server/metric"
 	"github.com/sotavant/yandex-metrics/internal/server/repository/memory"
 	"github.com/sotavant/yandex-metrics/internal/server/storage"
 	"github.com/stretchr/testify/assert"
@@ -91,7 +92,7 @@ func Test_handleGauge(t *testing.T) {
 
 			request = request.WithContext(context.WithValue(request.Context(), chi.RouteCtxKey, rctx))
 
-			h := http.HandlerFunc(UpdateHandler(appInstance))
+			h := http.HandlerFunc(UpdateHandler(appInstance, metric.NewMetricService(appInstance.Storage)))
 			h(w, request)
 			result := w.Result()
 			defer func() {
@@ -2,16 +2,21 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/sotavant/yandex-metrics/internal"
 	"github.com/sotavant/yandex-metrics/internal/server"
+	"github.com/sotavant/yandex-metrics/internal/server/metric"
+	"github.com/sotavant/yandex-metrics/internal/server/repository"
 	"github.com/sotavant/yandex-metrics/internal/server/storage"
 )
 
@@ -27,12 +32,14 @@ import (
 //
 //	200 - успешный ответ
 //	400 - неверные параметры
+//	422 - превышен лимит количества рядов
 //	500 - ошибка сервера
-func UpdateHandler(appInstance *server.App) func(res http.ResponseWriter, req *http.Request) {
+func UpdateHandler(appInstance *server.App, ms *metric.MetricService) func(res http.ResponseWriter, req *http.Request) {
 	return func(res http.ResponseWriter, req *http.Request) {
 		mType := chi.URLParam(req, "type")
 		mName := chi.URLParam(req, "name")
 		mVal := chi.URLParam(req, "value")
+		m := internal.Metrics{ID: mName, MType: mType}
 
 		switch mType {
 		case internal.GaugeType:
@@ -42,27 +49,26 @@ func UpdateHandler(appInstance *server.App) func(res http.ResponseWriter, req *h
 				return
 			}
 
-			err = appInstance.Storage.AddGaugeValue(req.Context(), mName, val)
-			if err != nil {
-				http.Error(res, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-				return
-			}
+			m.Value = &val
 		case internal.CounterType:
 			val, err := parseValue[int64](mType, mVal)
 			if err != nil {
 				http.Error(res, "bad request", http.StatusBadRequest)
-			}
-
-			err = appInstance.Storage.AddCounterValue(req.Context(), mName, val)
-			if err != nil {
-				http.Error(res, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 				return
 			}
+
+			m.Delta = &val
 		default:
 			http.Error(res, "bad request", http.StatusBadRequest)
 			return
 		}
 
+		if _, err := ms.Upsert(req.Context(), m); err != nil {
+			internal.Logger.Infow("upsert error", "err", err)
+			http.Error(res, http.StatusText(getStatusCode(err)), getStatusCode(err))
+			return
+		}
+
 		if appInstance.Fs != nil && appInstance.Fs.StoreInterval == 0 {
 			if err := appInstance.Fs.Sync(req.Context(), appInstance.Storage); err != nil {
 				internal.Logger.Infow("error in sync")
@@ -105,13 +111,13 @@ func GetValueHandler(appInstance *server.App) func(w http.ResponseWriter, req *h
 		}
 
 		value, err := appInstance.Storage.GetValue(req.Context(), mType, mName)
-		if err != nil {
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		if errors.Is(err, repository.ErrNotFound) {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 			return
 		}
 
-		if value == nil {
-			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
 
@@ -148,7 +154,7 @@ func GetValueHandler(appInstance *server.App) func(w http.ResponseWriter, req *h
 //
 // Ответ:
 //
-//	строка в виде html разметки
+//	строка в виде html разметки, давно не обновлявшиеся метрики отмечены как stale
 func GetValuesHandler(appInstance *server.App) func(w http.ResponseWriter, req *http.Request) {
 	return func(w http.ResponseWriter, req *http.Request) {
 		gaugeValues, err := appInstance.Storage.GetGauge(req.Context())
@@ -158,7 +164,14 @@ func GetValuesHandler(appInstance *server.App) func(w http.ResponseWriter, req *
 			return
 		}
 
-		resp := getHTMLResponseForGaugeList(gaugeValues)
+		stale, err := staleGauges(req.Context(), appInstance, gaugeValues)
+		if err != nil {
+			internal.Logger.Infow("mark stale error", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		resp := getHTMLResponseForGaugeList(gaugeValues, stale)
 
 		w.Header().Set("Content-Type", "text/html; charset=utf8")
 		_, err = fmt.Fprint(w, resp)
@@ -194,6 +207,41 @@ func PingDBHandler(dbConn *pgxpool.Pool) func(w http.ResponseWriter, req *http.R
 	}
 }
 
+// staleGauges возвращает набор устаревших gauge
+func staleGauges(ctx context.Context, appInstance *server.App, gaugeValues map[string]float64) (map[string]bool, error) {
+	ttl := staleTTL(appInstance)
+	if ttl == 0 {
+		return nil, nil
+	}
+
+	metrics := make([]internal.Metrics, 0, len(gaugeValues))
+	for k := range gaugeValues {
+		metrics = append(metrics, internal.Metrics{ID: k, MType: internal.GaugeType})
+	}
+
+	if err := metric.MarkStale(ctx, appInstance.Storage, metrics, ttl); err != nil {
+		return nil, err
+	}
+
+	stale := make(map[string]bool)
+	for _, m := range metrics {
+		if m.Stale {
+			stale[m.ID] = true
+		}
+	}
+
+	return stale, nil
+}
+
+// staleTTL время устаревания метрик из конфигурации, 0 если не задано
+func staleTTL(appInstance *server.App) time.Duration {
+	if appInstance.Config == nil {
+		return 0
+	}
+
+	return appInstance.Config.StaleTTL
+}
+
 func parseValue[T float64 | int64](mType, mValue string) (T, error) {
 	switch mType {
 	case internal.GaugeType:
@@ -215,7 +263,7 @@ func parseValue[T float64 | int64](mType, mValue string) (T, error) {
 	return T(0), nil
 }
 
-func getHTMLResponseForGaugeList(gaugeValues map[string]float64) (resp string) {
+func getHTMLResponseForGaugeList(gaugeValues map[string]float64, stale map[string]bool) (resp string) {
 	if len(gaugeValues) != 0 {
 		keys := make([]string, 0, len(gaugeValues))
 		for k := range gaugeValues {
@@ -225,7 +273,12 @@ func getHTMLResponseForGaugeList(gaugeValues map[string]float64) (resp string) {
 		sort.Strings(keys)
 
 		for _, k := range keys {
-			resp += fmt.Sprintf("<p>%s: %s</p>", k, strings.TrimRight(strings.TrimRight(fmt.Sprintf(`%f`, gaugeValues[k]), "0"), "."))
+			value := strings.TrimRight(strings.TrimRight(fmt.Sprintf(`%f`, gaugeValues[k]), "0"), ".")
+			if stale[k] {
+				value += " (stale)"
+			}
+
+			resp += fmt.Sprintf("<p>%s: %s</p>", k, value)
 		}
 	} else {
 		resp = "no value"
@@ -8,12 +8,14 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/sotavant/yandex-metrics/internal"
 	"github.com/sotavant/yandex-metrics/internal/server"
 	"github.com/sotavant/yandex-metrics/internal/server/config"
+	"github.com/sotavant/yandex-metrics/internal/server/metric"
 	"github.com/sotavant/yandex-metrics/internal/server/repository"
 	"github.com/sotavant/yandex-metrics/internal/server/repository/memory"
 	"github.com/sotavant/yandex-metrics/internal/server/repository/postgres"
@@ -298,6 +300,70 @@ func Test_getValuesHandler(t *testing.T) {
 	}
 }
 
+func TestStaleMetrics(t *testing.T) {
+	internal.InitLogger()
+	ctx := context.Background()
+	st := memory.NewShardedMetricsRepository(memory.DefaultShardsCount)
+
+	assert.NoError(t, st.AddGaugeValue(ctx, "live", 1))
+	assert.NoError(t, st.AddGaugeValue(ctx, "old", 2))
+	assert.NoError(t, st.SetUpdatedAt(ctx, repository.MetricKey{MType: internal.GaugeType, ID: "old"}, time.Now().Add(-time.Hour)))
+
+	appInstance := &server.App{
+		Config:  &config.Config{StaleTTL: time.Minute},
+		Storage: st,
+	}
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		target  string
+		body    string
+		want    string
+	}{
+		{
+			name:    "html",
+			handler: GetValuesHandler(appInstance),
+			method:  http.MethodGet,
+			target:  "/",
+			want:    `<p>live: 1</p><p>old: 2 (stale)</p>`,
+		},
+		{
+			name:    "jsonStale",
+			handler: GetValueJSONHandler(appInstance),
+			method:  http.MethodPost,
+			target:  "/value/",
+			body:    `{"id":"old","type":"gauge"}`,
+			want:    `{"value":2,"id":"old","type":"gauge","stale":true}` + "\n",
+		},
+		{
+			name:    "jsonLive",
+			handler: GetValueJSONHandler(appInstance),
+			method:  http.MethodPost,
+			target:  "/value/",
+			body:    `{"id":"live","type":"gauge"}`,
+			want:    `{"value":1,"id":"live","type":"gauge"}` + "\n",
+		},
+		{
+			name:    "list",
+			handler: ListMetricsHandler(metric.NewMetricService(st).WithStaleTTL(time.Minute)),
+			method:  http.MethodGet,
+			target:  "/api/v1/metrics",
+			want:    `{"metrics":[{"value":1,"id":"live","type":"gauge"},{"value":2,"id":"old","type":"gauge","stale":true}]}` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.handler(w, httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body)))
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tt.want, w.Body.String())
+		})
+	}
+}
+
 func Test_pingDBHandler(t *testing.T) {
 	ctx := context.Background()
 	internal.InitLogger()
@@ -362,7 +428,7 @@ func ExampleUpdateHandler() {
 	rctx.URLParams.Add(`value`, "134134")
 
 	request = request.WithContext(context.WithValue(request.Context(), chi.RouteCtxKey, rctx))
-	h := http.HandlerFunc(UpdateHandler(appInstance))
+	h := http.HandlerFunc(UpdateHandler(appInstance, metric.NewMetricService(appInstance.Storage)))
 	h(w, request)
 	result := w.Result()
 	defer func() {
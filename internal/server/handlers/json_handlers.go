@@ -8,7 +8,9 @@ import (
 	jsoniter "github.com/json-iterator/go"
 	"github.com/sotavant/yandex-metrics/internal"
 	"github.com/sotavant/yandex-metrics/internal/server"
+	"github.com/sotavant/yandex-metrics/internal/server/cardinality"
 	"github.com/sotavant/yandex-metrics/internal/server/metric"
+	"github.com/sotavant/yandex-metrics/internal/server/repository"
 )
 
 var json = jsoniter.ConfigCompatibleWithStandardLibrary
@@ -29,6 +31,7 @@ var json = jsoniter.ConfigCompatibleWithStandardLibrary
 //
 //	200 - успешный ответ
 //	400 - неверные параметры
+//	422 - превышен лимит количества рядов
 //	500 - ошибка сервера
 //
 // Ответ:
@@ -95,12 +98,13 @@ func UpdateJSONHandler(appInstance *server.App, ms *metric.MetricService) func(r
 //
 //	200 - успешный ответ
 //	400 - неверные параметры
+//	422 - превышен лимит количества рядов
 //	500 - ошибка сервера
 //
 // Ответ:
 //
 //	строка в формате json, со значениями всех метрик
-func UpdateBatchJSONHandler(appInstance *server.App) func(res http.ResponseWriter, req *http.Request) {
+func UpdateBatchJSONHandler(appInstance *server.App, ms *metric.MetricService) func(res http.ResponseWriter, req *http.Request) {
 	return func(res http.ResponseWriter, req *http.Request) {
 		var m []internal.Metrics
 
@@ -114,10 +118,10 @@ func UpdateBatchJSONHandler(appInstance *server.App) func(res http.ResponseWrite
 			return
 		}
 
-		err := appInstance.Storage.AddValues(req.Context(), m)
+		err := ms.AddValues(req.Context(), m)
 		if err != nil {
 			internal.Logger.Infow("error in addValues", "err", err)
-			http.Error(res, "internal server error", http.StatusInternalServerError)
+			http.Error(res, http.StatusText(getStatusCode(err)), getStatusCode(err))
 			return
 		}
 
@@ -167,7 +171,7 @@ func UpdateBatchJSONHandler(appInstance *server.App) func(res http.ResponseWrite
 //
 // Ответ:
 //
-//	строка в формате json, со значением метрики
+//	строка в формате json, со значением метрики; "stale": true, если метрика давно не обновлялась
 func GetValueJSONHandler(appInstance *server.App) func(res http.ResponseWriter, req *http.Request) {
 	return func(res http.ResponseWriter, req *http.Request) {
 		var m internal.Metrics
@@ -204,9 +208,16 @@ func GetValueJSONHandler(appInstance *server.App) func(res http.ResponseWriter,
 			return
 		}
 
+		resp := []internal.Metrics{respStruct}
+		if err = metric.MarkStale(req.Context(), appInstance.Storage, resp, staleTTL(appInstance)); err != nil {
+			internal.Logger.Infow("error in mark stale", "err", err)
+			http.Error(res, "internal server error", http.StatusInternalServerError)
+			return
+		}
+
 		res.Header().Set("Content-Type", "application/json")
 		enc := json.NewEncoder(res)
-		if err := enc.Encode(respStruct); err != nil {
+		if err := enc.Encode(resp[0]); err != nil {
 			internal.Logger.Infow("error in encode")
 			http.Error(res, "internal server error", http.StatusInternalServerError)
 			return
@@ -218,10 +229,14 @@ func GetValueJSONHandler(appInstance *server.App) func(res http.ResponseWriter,
 
 func getStatusCode(err error) int {
 	switch {
-	case errors.Is(err, metric.ErrIDAbsent), errors.Is(err, metric.ErrBadType), errors.Is(err, metric.ErrValueAbsent):
+	case errors.Is(err, metric.ErrIDAbsent), errors.Is(err, metric.ErrBadType), errors.Is(err, metric.ErrValueAbsent),
+		errors.Is(err, repository.ErrValueAbsent), errors.Is(err, repository.ErrUnknownType):
 		return http.StatusBadRequest
 	case errors.Is(err, metric.ErrAddGaugeValue), errors.Is(err, metric.ErrAddCounterValue):
 		return http.StatusInternalServerError
+	// лимит рядов не временный, поэтому не 429: агент не должен повторять запрос
+	case errors.Is(err, cardinality.ErrLimitExceeded):
+		return http.StatusUnprocessableEntity
 	default:
 		return http.StatusInternalServerError
 	}
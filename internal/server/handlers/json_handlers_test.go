@@ -381,6 +381,24 @@ func Test_updateBatchJSONHandler(t *testing.T) {
 			}{status: 200, body: `[{"id":"ss","type":"counter","delta":6}, {"id":"ss","type":"gauge","value":-33.345345}]`},
 			inMemory: true,
 		},
+		{
+			name: `missingValue`,
+			body: `[{"id": "ss","type":"gauge","value":1},{"id": "pp","type":"counter"}]`,
+			want: struct {
+				body   string
+				status int
+			}{status: http.StatusBadRequest},
+			inMemory: true,
+		},
+		{
+			name: `unknownType`,
+			body: `[{"id": "ss","type":"histogram","value":1}]`,
+			want: struct {
+				body   string
+				status int
+			}{status: http.StatusBadRequest},
+			inMemory: true,
+		},
 		{
 			name: `newGaugeValueBD`,
 			body: `[{"id": "ss","type":"gauge","value":-33.345345}]`,
@@ -420,7 +438,7 @@ func Test_updateBatchJSONHandler(t *testing.T) {
 				assert.NoError(t, err)
 			}
 
-			handler := UpdateBatchJSONHandler(appInstance)
+			handler := UpdateBatchJSONHandler(appInstance, metric.NewMetricService(appInstance.Storage))
 
 			request := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(tt.body))
 			w := httptest.NewRecorder()
@@ -492,7 +510,7 @@ func ExampleUpdateBatchJSONHandler() {
 		Storage: st,
 	}
 
-	handler := UpdateBatchJSONHandler(appInstance)
+	handler := UpdateBatchJSONHandler(appInstance, metric.NewMetricService(appInstance.Storage))
 
 	request := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(`[{"id":"a","type":"gauge","value":1},{"id":"b","type":"counter","delta":2}]`))
 	w := httptest.NewRecorder()
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/server/metric"
+	"github.com/sotavant/yandex-metrics/internal/server/repository"
+)
+
+// Размер страницы списка метрик
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+var errBadListParams = errors.New("bad list params")
+
+// listMetricsResponse страница списка метрик
+type listMetricsResponse struct {
+	Metrics    []internal.Metrics `json:"metrics"`
+	NextCursor string             `json:"next_cursor,omitempty"`
+}
+
+// ListMetricsHandler Данный обработчик обрабатывает урлы вида: /api/v1/metrics (GET-запрос)
+//
+// Возвращает страницу метрик обоих типов.
+//
+// Параметры:
+//
+//	type - gauge или counter
+//	prefix - префикс ID
+//	regex - регулярное выражение для ID
+//	label - фильтр по метке из ID вида name{k=v}, задается как label=k=v, можно указать несколько раз
+//	sort - id (по-умолчанию) или type
+//	order - asc (по-умолчанию) или desc
+//	limit - размер страницы, по-умолчанию 100, не больше 1000
+//	cursor - значение next_cursor из предыдущего ответа
+//
+// Коды ответа:
+//
+//	200 - успешный ответ
+//	400 - неверные параметры
+//	500 - ошибка сервера
+//
+// Ответ:
+//
+//	{"metrics": [{"id": "Alloc", "type": "gauge", "value": 1.5}], "next_cursor": "..."}
+func ListMetricsHandler(ms *metric.MetricService) func(res http.ResponseWriter, req *http.Request) {
+	return func(res http.ResponseWriter, req *http.Request) {
+		listReq, err := parseListRequest(req.URL.Query())
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		metrics, next, err := ms.List(req.Context(), listReq)
+		if err != nil {
+			internal.Logger.Infow("error in list metrics", "err", err)
+			http.Error(res, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		resp := listMetricsResponse{Metrics: metrics}
+		if next != nil {
+			if resp.NextCursor, err = encodeCursor(*next); err != nil {
+				internal.Logger.Infow("error in encode cursor", "err", err)
+				http.Error(res, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(res)
+		if err = enc.Encode(resp); err != nil {
+			internal.Logger.Infow("error in encode")
+			http.Error(res, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func parseListRequest(values url.Values) (metric.ListRequest, error) {
+	var err error
+
+	r := metric.ListRequest{
+		Options: repository.ListOptions{
+			MType:  values.Get("type"),
+			Prefix: values.Get("prefix"),
+			Limit:  defaultListLimit,
+		},
+		Labels: make(map[string]string),
+	}
+
+	switch r.Options.MType {
+	case "", internal.GaugeType, internal.CounterType:
+	default:
+		return r, fmt.Errorf("%w: unknown type %q", errBadListParams, r.Options.MType)
+	}
+
+	switch sortBy := values.Get("sort"); sortBy {
+	case "", repository.SortByID, repository.SortByType:
+		r.Options.SortBy = sortBy
+	default:
+		return r, fmt.Errorf("%w: unknown sort %q", errBadListParams, sortBy)
+	}
+
+	switch order := values.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		r.Options.Desc = true
+	default:
+		return r, fmt.Errorf("%w: unknown order %q", errBadListParams, order)
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		r.Options.Limit, err = strconv.Atoi(limit)
+		if err != nil || r.Options.Limit <= 0 || r.Options.Limit > maxListLimit {
+			return r, fmt.Errorf("%w: limit must be between 1 and %d", errBadListParams, maxListLimit)
+		}
+	}
+
+	if cursor := values.Get("cursor"); cursor != "" {
+		key, err := decodeCursor(cursor)
+		if err != nil {
+			return r, err
+		}
+
+		r.Options.After = &key
+	}
+
+	if expr := values.Get("regex"); expr != "" {
+		if r.Regex, err = regexp.Compile(expr); err != nil {
+			return r, fmt.Errorf("%w: %s", errBadListParams, err)
+		}
+	}
+
+	for _, label := range values["label"] {
+		k, v, found := strings.Cut(label, "=")
+		if !found || k == "" {
+			return r, fmt.Errorf("%w: label must be key=value", errBadListParams)
+		}
+
+		r.Labels[k] = v
+	}
+
+	return r, nil
+}
+
+// encodeCursor кодирует ключ последней метрики страницы в непрозрачную строку
+func encodeCursor(key repository.MetricKey) (string, error) {
+	data, err := json.Marshal(key)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeCursor(cursor string) (repository.MetricKey, error) {
+	var key repository.MetricKey
+
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return key, fmt.Errorf("%w: bad cursor", errBadListParams)
+	}
+
+	if err = json.Unmarshal(data, &key); err != nil {
+		return key, fmt.Errorf("%w: bad cursor", errBadListParams)
+	}
+
+	return key, nil
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/server/metric"
+	"github.com/sotavant/yandex-metrics/internal/server/repository/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListMetricsHandler(t *testing.T) {
+	ctx := context.Background()
+	st := memory.NewShardedMetricsRepository(memory.DefaultShardsCount)
+	gauge, delta := 1.5, int64(3)
+
+	for _, id := range []string{"Alloc", "HeapAlloc", "HeapIdle", "requests{host=a}", "requests{host=b}"} {
+		require.NoError(t, st.AddGaugeValue(ctx, id, gauge))
+	}
+	require.NoError(t, st.AddCounterValue(ctx, "PollCount", delta))
+
+	handler := ListMetricsHandler(metric.NewMetricService(st))
+	list := func(params url.Values) (int, listMetricsResponse) {
+		var resp listMetricsResponse
+
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/metrics?"+params.Encode(), nil)
+		w := httptest.NewRecorder()
+		handler(w, req)
+
+		res := w.Result()
+		defer func() {
+			assert.NoError(t, res.Body.Close())
+		}()
+
+		if res.StatusCode == http.StatusOK {
+			assert.NoError(t, json.NewDecoder(res.Body).Decode(&resp))
+		}
+
+		return res.StatusCode, resp
+	}
+	ids := func(metrics []internal.Metrics) []string {
+		res := make([]string, 0, len(metrics))
+		for _, m := range metrics {
+			res = append(res, m.ID)
+		}
+
+		return res
+	}
+
+	t.Run("both types", func(t *testing.T) {
+		code, resp := list(url.Values{})
+		assert.Equal(t, http.StatusOK, code)
+		assert.Len(t, resp.Metrics, 6)
+		assert.Empty(t, resp.NextCursor)
+		assert.Equal(t, internal.Metrics{ID: "PollCount", MType: internal.CounterType, Delta: &delta}, resp.Metrics[3])
+	})
+
+	t.Run("pagination", func(t *testing.T) {
+		params := url.Values{"limit": {"2"}, "order": {"desc"}}
+		got := make([]string, 0)
+
+		for pages := 0; pages < 10; pages++ {
+			code, resp := list(params)
+			require.Equal(t, http.StatusOK, code)
+			got = append(got, ids(resp.Metrics)...)
+
+			if resp.NextCursor == "" {
+				break
+			}
+			params.Set("cursor", resp.NextCursor)
+		}
+
+		assert.Equal(t, []string{"requests{host=b}", "requests{host=a}", "PollCount", "HeapIdle", "HeapAlloc", "Alloc"}, got)
+	})
+
+	tests := []struct {
+		name   string
+		params url.Values
+		want   []string
+	}{
+		{name: "type", params: url.Values{"type": {"counter"}}, want: []string{"PollCount"}},
+		{name: "prefix", params: url.Values{"prefix": {"Heap"}}, want: []string{"HeapAlloc", "HeapIdle"}},
+		{name: "regex", params: url.Values{"regex": {"Alloc$"}}, want: []string{"Alloc", "HeapAlloc"}},
+		{name: "label", params: url.Values{"label": {"host=b"}}, want: []string{"requests{host=b}"}},
+		{
+			name:   "filtered page has cursor",
+			params: url.Values{"regex": {"^Heap|requests"}, "limit": {"3"}},
+			want:   []string{"HeapAlloc", "HeapIdle", "requests{host=a}"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, resp := list(tt.params)
+			assert.Equal(t, http.StatusOK, code)
+			assert.Equal(t, tt.want, ids(resp.Metrics))
+		})
+	}
+
+	t.Run("filtered second page", func(t *testing.T) {
+		_, first := list(url.Values{"regex": {"^Heap|requests"}, "limit": {"3"}})
+		require.NotEmpty(t, first.NextCursor)
+
+		code, resp := list(url.Values{"regex": {"^Heap|requests"}, "limit": {"3"}, "cursor": {first.NextCursor}})
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, []string{"requests{host=b}"}, ids(resp.Metrics))
+		assert.Empty(t, resp.NextCursor)
+	})
+
+	badParams := []url.Values{
+		{"type": {"histogram"}},
+		{"sort": {"value"}},
+		{"order": {"up"}},
+		{"limit": {"0"}},
+		{"limit": {"5000"}},
+		{"cursor": {"%%%"}},
+		{"regex": {"("}},
+		{"label": {"host"}},
+	}
+	for _, params := range badParams {
+		t.Run("bad "+params.Encode(), func(t *testing.T) {
+			code, _ := list(params)
+			assert.Equal(t, http.StatusBadRequest, code)
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/server/metric"
+	"github.com/sotavant/yandex-metrics/internal/server/query"
+	"github.com/sotavant/yandex-metrics/internal/server/repository"
+)
+
+// queryRangeResponse ответ на запрос за интервал времени
+type queryRangeResponse struct {
+	ResultType string       `json:"resultType"`
+	Result     query.Matrix `json:"result"`
+}
+
+// QueryRangeHandler Данный обработчик обрабатывает урлы вида: /api/v1/query_range (GET-запрос)
+//
+// Вычисляет функцию по истории метрик за интервал времени.
+//
+// Параметры:
+//
+//	query - селектор метрики, например requests{env=prod}
+//	func - функция: rate, increase (counter), avg_over_time, max_over_time, quantile_over_time (gauge)
+//	quantile - квантиль для quantile_over_time, от 0 до 1
+//	start, end - границы интервала в unix-секундах или RFC3339 (по-умолчанию последний час)
+//	step - шаг и размер окна, например 60 или 1m (по-умолчанию 1m)
+//	sum_by - суммировать ряды по меткам через запятую, пустое значение суммирует все ряды
+//
+// Пример: /api/v1/query_range?query=requests&func=rate&step=5m&sum_by=host
+//
+// Коды ответа:
+//
+//	200 - успешный ответ
+//	400 - неверные параметры
+//	501 - хранение истории не включено
+//	500 - ошибка сервера
+//
+// Ответ:
+//
+//	{"resultType": "matrix", "result": [{"metric": {"host": "a"}, "values": [[1714564800, 1.5]]}]}
+func QueryRangeHandler(ms *metric.MetricService) func(res http.ResponseWriter, req *http.Request) {
+	return func(res http.ResponseWriter, req *http.Request) {
+		values := req.URL.Query()
+
+		r, err := query.NewRequest(query.Params{
+			Query:    values.Get("query"),
+			Function: values.Get("func"),
+			Quantile: values.Get("quantile"),
+			Start:    values.Get("start"),
+			End:      values.Get("end"),
+			Step:     values.Get("step"),
+			SumBy:    values.Get("sum_by"),
+			HasSumBy: values.Has("sum_by"),
+		}, time.Now())
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		matrix, err := ms.QueryRange(req.Context(), r)
+		if err != nil {
+			internal.Logger.Infow("error in query range", "err", err)
+			http.Error(res, err.Error(), getQueryStatusCode(err))
+			return
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(res)
+		if err = enc.Encode(queryRangeResponse{ResultType: "matrix", Result: matrix}); err != nil {
+			internal.Logger.Infow("error in encode")
+			http.Error(res, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func getQueryStatusCode(err error) int {
+	switch {
+	case errors.Is(err, query.ErrBadQuery):
+		return http.StatusBadRequest
+	case errors.Is(err, repository.ErrHistoryDisabled):
+		return http.StatusNotImplemented
+	default:
+		return http.StatusInternalServerError
+	}
+}
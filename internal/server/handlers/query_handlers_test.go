@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sotavant/yandex-metrics/internal/server/metric"
+	"github.com/sotavant/yandex-metrics/internal/server/repository"
+	"github.com/sotavant/yandex-metrics/internal/server/repository/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryRangeHandler(t *testing.T) {
+	ctx := context.Background()
+	tiers := []repository.RetentionTier{{Resolution: 0, Retention: time.Hour}}
+	st := memory.NewHistoryRepository(memory.NewShardedMetricsRepository(memory.DefaultShardsCount), tiers)
+
+	require.NoError(t, st.AddCounterValue(ctx, "requests{host=a}", 3))
+	require.NoError(t, st.AddCounterValue(ctx, "requests{host=b}", 4))
+
+	// окно [end-2m, end) гарантированно содержит только что записанные точки
+	end := strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10)
+
+	tests := []struct {
+		name     string
+		storage  repository.Storage
+		params   url.Values
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "sum increase",
+			storage:  st,
+			params:   url.Values{"query": {"requests"}, "func": {"increase"}, "start": {end}, "end": {end}, "step": {"120"}, "sum_by": {""}},
+			wantCode: http.StatusOK,
+			wantBody: `{"resultType":"matrix","result":[{"metric":{},"values":[[` + end + `,7]]}]}` + "\n",
+		},
+		{
+			name:     "by host",
+			storage:  st,
+			params:   url.Values{"query": {"requests{host=b}"}, "func": {"increase"}, "start": {end}, "end": {end}, "step": {"2m"}},
+			wantCode: http.StatusOK,
+			wantBody: `{"resultType":"matrix","result":[{"metric":{"__name__":"requests","host":"b"},"values":[[` + end + `,4]]}]}` + "\n",
+		},
+		{
+			name:     "unknown function",
+			storage:  st,
+			params:   url.Values{"query": {"requests"}, "func": {"median"}},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "history disabled",
+			storage:  memory.NewShardedMetricsRepository(memory.DefaultShardsCount),
+			params:   url.Values{"query": {"requests"}, "func": {"rate"}},
+			wantCode: http.StatusNotImplemented,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := QueryRangeHandler(metric.NewMetricService(tt.storage))
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/query_range?"+tt.params.Encode(), nil)
+			w := httptest.NewRecorder()
+			handler(w, req)
+
+			res := w.Result()
+			defer func() {
+				assert.NoError(t, res.Body.Close())
+			}()
+
+			assert.Equal(t, tt.wantCode, res.StatusCode)
+			if tt.wantBody != "" {
+				body, err := io.ReadAll(res.Body)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.wantBody, string(body))
+			}
+		})
+	}
+}
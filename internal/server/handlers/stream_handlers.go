@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/server/broker"
+)
+
+// streamKeepAlive интервал комментариев, не дающих прокси закрыть простаивающее соединение
+const streamKeepAlive = 15 * time.Second
+
+// StreamHandler Данный обработчик обрабатывает урлы вида: /api/v1/stream (GET-запрос)
+//
+// Отправляет обновления метрик по мере записи в формате Server-Sent Events.
+// Если клиент не успевает читать, поток завершается событием error и клиент может переподключиться.
+//
+// Параметры:
+//
+//	type - gauge или counter
+//	regex - регулярное выражение для ID
+//	selector - имя и метки из ID вида name{k=v}
+//
+// Коды ответа:
+//
+//	200 - поток событий
+//	400 - неверные параметры
+//	503 - сервер останавливается
+//
+// Ответ:
+//
+//	event: metric
+//	data: {"id": "Alloc", "type": "gauge", "value": 1.5}
+func StreamHandler(b *broker.Broker) func(res http.ResponseWriter, req *http.Request) {
+	return func(res http.ResponseWriter, req *http.Request) {
+		values := req.URL.Query()
+		filter, err := broker.NewFilter(values.Get("type"), values.Get("regex"), values.Get("selector"))
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		sub, err := b.Subscribe(filter, broker.DefaultBufferSize)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		defer sub.Unsubscribe()
+
+		rc := http.NewResponseController(res)
+		res.Header().Set("Content-Type", "text/event-stream")
+		res.Header().Set("Cache-Control", "no-cache")
+		res.Header().Set("Connection", "keep-alive")
+		res.WriteHeader(http.StatusOK)
+
+		if err = rc.Flush(); err != nil {
+			internal.Logger.Infow("streaming is not supported", "err", err)
+			return
+		}
+
+		ticker := time.NewTicker(streamKeepAlive)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-req.Context().Done():
+				return
+			case <-ticker.C:
+				_, err = fmt.Fprint(res, ": keepalive\n\n")
+			case m, ok := <-sub.C():
+				if !ok {
+					_, _ = fmt.Fprintf(res, "event: error\ndata: %s\n\n", sub.Err())
+					_ = rc.Flush()
+					return
+				}
+
+				err = writeMetricEvent(res, m)
+			}
+
+			if err == nil {
+				err = rc.Flush()
+			}
+
+			if err != nil {
+				internal.Logger.Infow("error in stream write", "err", err)
+				return
+			}
+		}
+	}
+}
+
+func writeMetricEvent(res http.ResponseWriter, m internal.Metrics) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(res, "event: metric\ndata: %s\n\n", data)
+
+	return err
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/server/broker"
+	"github.com/sotavant/yandex-metrics/internal/server/metric"
+	"github.com/sotavant/yandex-metrics/internal/server/repository/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamHandler(t *testing.T) {
+	internal.InitLogger()
+	b := broker.New()
+	ms := metric.NewMetricService(memory.NewShardedMetricsRepository(memory.DefaultShardsCount)).WithBroker(b)
+	srv := httptest.NewServer(http.HandlerFunc(StreamHandler(b)))
+	defer srv.Close()
+
+	t.Run("bad params", func(t *testing.T) {
+		for _, params := range []string{"type=histogram", "regex=("} {
+			res, err := http.Get(srv.URL + "?" + params)
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+			assert.NoError(t, res.Body.Close())
+		}
+	})
+
+	t.Run("filtered updates", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"?type=counter&regex=^Poll", nil)
+		require.NoError(t, err)
+
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer func() {
+			assert.NoError(t, res.Body.Close())
+		}()
+
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+		gauge, delta := 1.5, int64(2)
+		_, err = ms.Upsert(ctx, internal.Metrics{ID: "PollCount", MType: internal.GaugeType, Value: &gauge})
+		require.NoError(t, err)
+		_, err = ms.Upsert(ctx, internal.Metrics{ID: "Other", MType: internal.CounterType, Delta: &delta})
+		require.NoError(t, err)
+		require.NoError(t, ms.AddValues(ctx, []internal.Metrics{
+			{ID: "PollCount", MType: internal.CounterType, Delta: &delta},
+			{ID: "PollCount", MType: internal.CounterType, Delta: &delta},
+		}))
+
+		// пакет публикуется одним текущим значением на метрику
+		reader := bufio.NewReader(res.Body)
+		assert.Equal(t, "event: metric", readLine(t, reader))
+		assert.Equal(t, `data: {"delta":4,"id":"PollCount","type":"counter"}`, readLine(t, reader))
+		assert.Equal(t, "", readLine(t, reader))
+
+		// при остановке брокера поток завершается событием error
+		b.Close()
+		assert.Equal(t, "event: error", readLine(t, reader))
+		assert.Equal(t, "data: "+broker.ErrClosed.Error(), readLine(t, reader))
+	})
+}
+
+func readLine(t *testing.T, r *bufio.Reader) string {
+	line, err := r.ReadString('\n')
+	require.NoError(t, err)
+
+	return strings.TrimSuffix(line, "\n")
+}
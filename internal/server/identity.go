@@ -0,0 +1,30 @@
+package server
+
+import "context"
+
+type agentIDKey struct{}
+
+// WithAgentID контекст запроса с идентификатором агента, подтвержденным сертификатом клиента
+func WithAgentID(ctx context.Context, agentID string) context.Context {
+	return context.WithValue(ctx, agentIDKey{}, agentID)
+}
+
+// AgentID идентификатор агента из контекста запроса, false - агент не установлен
+func AgentID(ctx context.Context) (string, bool) {
+	agentID, ok := ctx.Value(agentIDKey{}).(string)
+	return agentID, ok && agentID != ""
+}
+
+type agentKeyKey struct{}
+
+// WithAgentKey контекст запроса с ключом агента для лимитов: agent:<id>, token:<hash> или ip:<адрес>.
+// Ключ должен строиться только по проверенным данным: сертификату, принятому токену или адресу клиента.
+func WithAgentKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, agentKeyKey{}, key)
+}
+
+// AgentKey ключ агента из контекста запроса, false - ключ не установлен
+func AgentKey(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(agentKeyKey{}).(string)
+	return key, ok && key != ""
+}
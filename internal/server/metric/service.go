@@ -3,8 +3,14 @@ package metric
 import (
 	"context"
 	"errors"
+	"regexp"
+	"time"
 
 	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/server"
+	"github.com/sotavant/yandex-metrics/internal/server/broker"
+	"github.com/sotavant/yandex-metrics/internal/server/cardinality"
+	"github.com/sotavant/yandex-metrics/internal/server/query"
 	"github.com/sotavant/yandex-metrics/internal/server/repository"
 )
 
@@ -14,12 +20,33 @@ var (
 	ErrValueAbsent     = errors.New("value is absent")
 	ErrAddGaugeValue   = errors.New("error in add gauge value")
 	ErrAddCounterValue = errors.New("error in add counter value")
+	ErrStreamDisabled  = errors.New("update stream is disabled")
 )
 
+// listChunkSize размер страницы, которой List читает хранилище при фильтрации по regex и меткам
+const listChunkSize = 500
+
+// ListRequest параметры поиска метрик. Фильтры Options применяются хранилищем,
+// Regex (по ID) и Labels (точное совпадение меток из ID вида name{k=v}) - при чтении.
+type ListRequest struct {
+	Options repository.ListOptions
+	Regex   *regexp.Regexp
+	Labels  map[string]string
+}
+
 type MetricService struct {
 	storage repository.Storage
+	// staleTTL время, после которого не обновлявшаяся метрика отмечается устаревшей, 0 - не отмечать
+	staleTTL time.Duration
+	// broker получает все записанные через сервис метрики, nil - не публиковать
+	broker *broker.Broker
+	// cardinality ограничивает количество рядов, nil - без ограничения
+	cardinality *cardinality.Tracker
 }
 
+// unknownAgent агент запросов, для которых не определен ключ агента
+const unknownAgent = "unknown"
+
 func NewMetricService(st repository.Storage) *MetricService {
 	return &MetricService{
 		storage: st,
@@ -36,25 +63,369 @@ func (ms *MetricService) Upsert(ctx context.Context, m internal.Metrics) (intern
 		if m.Value == nil {
 			return internal.Metrics{}, ErrValueAbsent
 		}
-
-		err := ms.storage.AddGaugeValue(ctx, m.ID, *m.Value)
-		if err != nil {
-			return internal.Metrics{}, ErrAddGaugeValue
-		}
 	case internal.CounterType:
 		if m.Delta == nil {
 			return internal.Metrics{}, ErrValueAbsent
 		}
+	default:
+		return internal.Metrics{}, ErrBadType
+	}
+
+	agent := requestAgent(ctx)
+	admitted, held, err := ms.admit(agent, []internal.Metrics{m})
+	if err != nil {
+		return internal.Metrics{}, err
+	}
+
+	// в режиме drop ряд сверх лимита не записывается, но клиент получает успешный ответ
+	if len(admitted) == 0 {
+		return m, nil
+	}
+
+	if err = ms.write(ctx, m); err != nil {
+		ms.release(agent, held)
+		return internal.Metrics{}, err
+	}
+	ms.commit(agent, held)
+
+	res, err := GetMetricsStruct(ctx, ms.storage, m)
+	if err != nil {
+		return res, err
+	}
+
+	if ms.broker != nil {
+		ms.broker.Publish([]internal.Metrics{published(res)})
+	}
+
+	return res, nil
+}
+
+// AddValues сохраняет пакет метрик и публикует их текущие значения.
+// Метрики новых рядов сверх лимита отклоняют весь пакет или отбрасываются, в зависимости от режима лимита.
+func (ms *MetricService) AddValues(ctx context.Context, metrics []internal.Metrics) error {
+	agent := requestAgent(ctx)
+	metrics, held, err := ms.admit(agent, metrics)
+	if err != nil {
+		return err
+	}
+
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	if err = ms.storage.AddValues(ctx, metrics); err != nil {
+		ms.release(agent, held)
+		return err
+	}
+	ms.commit(agent, held)
+
+	// текущие значения читаются только если их есть кому отправить
+	if ms.broker == nil || !ms.broker.HasSubscribers() {
+		return nil
+	}
+
+	seen := make(map[repository.MetricKey]bool, len(metrics))
+	updated := make([]internal.Metrics, 0, len(metrics))
+	for _, m := range metrics {
+		key := repository.KeyOf(m)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		current, err := GetMetricsStruct(ctx, ms.storage, internal.Metrics{ID: m.ID, MType: m.MType})
+		if err != nil {
+			// пакет уже сохранен, ошибка чтения влияет только на публикацию
+			internal.Logger.Infow("error in get published metric", "err", err)
+			continue
+		}
+
+		updated = append(updated, current)
+	}
+
+	ms.broker.Publish(updated)
+
+	return nil
+}
+
+// write записывает одну проверенную метрику
+func (ms *MetricService) write(ctx context.Context, m internal.Metrics) error {
+	if m.MType == internal.GaugeType {
+		if err := ms.storage.AddGaugeValue(ctx, m.ID, *m.Value); err != nil {
+			return ErrAddGaugeValue
+		}
+
+		return nil
+	}
+
+	if err := ms.storage.AddCounterValue(ctx, m.ID, *m.Delta); err != nil {
+		return ErrAddCounterValue
+	}
+
+	return nil
+}
+
+// admit проверяет лимит рядов, без лимита пропускает все метрики
+func (ms *MetricService) admit(agent string, metrics []internal.Metrics) ([]internal.Metrics, []repository.MetricKey, error) {
+	if ms.cardinality == nil {
+		return metrics, nil, nil
+	}
+
+	return ms.cardinality.Admit(agent, metrics)
+}
+
+func (ms *MetricService) commit(agent string, held []repository.MetricKey) {
+	if ms.cardinality != nil {
+		ms.cardinality.Commit(agent, held)
+	}
+}
+
+func (ms *MetricService) release(agent string, held []repository.MetricKey) {
+	if ms.cardinality != nil {
+		ms.cardinality.Release(agent, held)
+	}
+}
+
+func (ms *MetricService) forget(keys ...repository.MetricKey) {
+	if ms.cardinality != nil {
+		ms.cardinality.Forget(keys)
+	}
+}
+
+// requestAgent ключ агента из контекста запроса. Его ставят шаги безопасности по сертификату,
+// принятому токену или IP, поэтому заголовки запроса не дают агенту новых лимитов.
+func requestAgent(ctx context.Context) string {
+	if agent, ok := server.AgentKey(ctx); ok {
+		return agent
+	}
+
+	return unknownAgent
+}
+
+// WithCardinality включает ограничение количества рядов. Удаленные через сервис ряды убираются из учета,
+// вытесненные нужно передавать в Tracker.Forget отдельно.
+func (ms *MetricService) WithCardinality(t *cardinality.Tracker) *MetricService {
+	ms.cardinality = t
+
+	return ms
+}
+
+// WithBroker включает публикацию записанных метрик в broker
+func (ms *MetricService) WithBroker(b *broker.Broker) *MetricService {
+	ms.broker = b
+
+	return ms
+}
+
+// Subscribe подписывает на записанные через сервис метрики. Без брокера возвращает ErrStreamDisabled.
+func (ms *MetricService) Subscribe(f broker.Filter) (*broker.Subscription, error) {
+	if ms.broker == nil {
+		return nil, ErrStreamDisabled
+	}
+
+	return ms.broker.Subscribe(f, broker.DefaultBufferSize)
+}
+
+// WithStaleTTL включает отметку устаревших метрик в результатах List
+func (ms *MetricService) WithStaleTTL(ttl time.Duration) *MetricService {
+	ms.staleTTL = ttl
+
+	return ms
+}
+
+// QueryRange выполняет запрос к истории метрик. Если хранилище не хранит историю, возвращает repository.ErrHistoryDisabled.
+func (ms *MetricService) QueryRange(ctx context.Context, r query.Request) (query.Matrix, error) {
+	hs, ok := ms.storage.(repository.HistoryStorage)
+	if !ok {
+		return nil, repository.ErrHistoryDisabled
+	}
+
+	return query.Eval(ctx, ms.storage, hs, r)
+}
+
+// Get возвращает текущее значение метрики. Для отсутствующей метрики возвращает repository.NotFoundError.
+func (ms *MetricService) Get(ctx context.Context, mType, id string) (internal.Metrics, error) {
+	res, err := ms.GetMany(ctx, []repository.MetricKey{{MType: mType, ID: id}})
+	if err != nil {
+		return internal.Metrics{}, err
+	}
+
+	return res[0], nil
+}
+
+// GetMany возвращает значения метрик в порядке ключей. Если хотя бы одной метрики нет,
+// возвращает repository.NotFoundError для первой отсутствующей.
+func (ms *MetricService) GetMany(ctx context.Context, keys []repository.MetricKey) ([]internal.Metrics, error) {
+	res := make([]internal.Metrics, 0, len(keys))
+	for _, key := range keys {
+		if key.ID == "" {
+			return nil, ErrIDAbsent
+		}
+
+		switch key.MType {
+		case internal.GaugeType, internal.CounterType:
+		default:
+			return nil, ErrBadType
+		}
+
+		m, err := GetMetricsStruct(ctx, ms.storage, internal.Metrics{ID: key.ID, MType: key.MType})
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, m)
+	}
+
+	return res, MarkStale(ctx, ms.storage, res, ms.staleTTL)
+}
+
+// List возвращает страницу метрик и ключ, с которого начинается следующая страница (nil для последней).
+// Хранилище читается порциями, поэтому фильтры по regex и меткам не загружают в память все метрики.
+func (ms *MetricService) List(ctx context.Context, req ListRequest) ([]internal.Metrics, *repository.MetricKey, error) {
+	limit := req.Options.Limit
+	opts := req.Options
+	opts.Limit = listChunkSize
+	if limit >= listChunkSize {
+		opts.Limit = limit + 1
+	}
 
-		err := ms.storage.AddCounterValue(ctx, m.ID, *m.Delta)
+	res := make([]internal.Metrics, 0)
+	for {
+		chunk, err := ms.storage.ListValues(ctx, opts)
 		if err != nil {
-			return internal.Metrics{}, ErrAddCounterValue
+			return nil, nil, err
 		}
+
+		for _, m := range chunk {
+			if !req.match(m) {
+				continue
+			}
+
+			if limit > 0 && len(res) == limit {
+				next := repository.KeyOf(res[len(res)-1])
+				return res, &next, MarkStale(ctx, ms.storage, res, ms.staleTTL)
+			}
+
+			res = append(res, m)
+		}
+
+		if len(chunk) < opts.Limit {
+			return res, nil, MarkStale(ctx, ms.storage, res, ms.staleTTL)
+		}
+
+		last := repository.KeyOf(chunk[len(chunk)-1])
+		opts.After = &last
+	}
+}
+
+// Delete удаляет метрику
+func (ms *MetricService) Delete(ctx context.Context, mType, id string) error {
+	switch mType {
+	case internal.GaugeType, internal.CounterType:
 	default:
-		return internal.Metrics{}, ErrBadType
+		return ErrBadType
+	}
+
+	if err := ms.storage.Delete(ctx, mType, id); err != nil {
+		return err
+	}
+
+	ms.forget(repository.MetricKey{MType: mType, ID: id})
+
+	return nil
+}
+
+// ResetCounter обнуляет счетчик
+func (ms *MetricService) ResetCounter(ctx context.Context, id string) error {
+	return ms.storage.ResetCounter(ctx, id)
+}
+
+// DeleteMatching удаляет все метрики, подходящие под фильтры запроса, и возвращает количество удаленных.
+// Ключи собираются до удаления, чтобы удаление не сдвигало чтение по страницам.
+func (ms *MetricService) DeleteMatching(ctx context.Context, req ListRequest) (int, error) {
+	req.Options.Limit = 0
+	req.Options.After = nil
+
+	metrics, _, err := ms.List(ctx, req)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, m := range metrics {
+		err = ms.storage.Delete(ctx, m.MType, m.ID)
+		// метрику могли удалить параллельно
+		if errors.Is(err, repository.ErrNotFound) {
+			continue
+		}
+
+		if err != nil {
+			return deleted, err
+		}
+
+		ms.forget(repository.KeyOf(m))
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+// published копия метрики только со значением ее типа: подписчики не должны делить указатели с ответом
+func published(m internal.Metrics) internal.Metrics {
+	res := internal.Metrics{ID: m.ID, MType: m.MType}
+	if m.MType == internal.GaugeType && m.Value != nil {
+		value := *m.Value
+		res.Value = &value
+	} else if m.MType == internal.CounterType && m.Delta != nil {
+		delta := *m.Delta
+		res.Delta = &delta
+	}
+
+	return res
+}
+
+// MarkStale отмечает метрики, не обновлявшиеся дольше ttl. При ttl = 0 ничего не делает.
+func MarkStale(ctx context.Context, st repository.Storage, metrics []internal.Metrics, ttl time.Duration) error {
+	if ttl <= 0 || len(metrics) == 0 {
+		return nil
+	}
+
+	keys := make([]repository.MetricKey, len(metrics))
+	for i, m := range metrics {
+		keys[i] = repository.KeyOf(m)
+	}
+
+	updated, err := st.LastUpdated(ctx, keys)
+	if err != nil {
+		return err
+	}
+
+	staleSince := time.Now().Add(-ttl)
+	for i := range metrics {
+		at, ok := updated[keys[i]]
+		metrics[i].Stale = ok && at.Before(staleSince)
+	}
+
+	return nil
+}
+
+func (r ListRequest) match(m internal.Metrics) bool {
+	if r.Regex != nil && !r.Regex.MatchString(m.ID) {
+		return false
+	}
+
+	if len(r.Labels) == 0 {
+		return true
+	}
+
+	_, labels := query.ParseSeriesID(m.ID)
+	for k, v := range r.Labels {
+		if labels[k] != v {
+			return false
+		}
 	}
 
-	return GetMetricsStruct(ctx, ms.storage, m)
+	return true
 }
 
 func GetMetricsStruct(ctx context.Context, storage repository.Storage, before internal.Metrics) (internal.Metrics, error) {
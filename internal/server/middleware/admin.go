@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/sotavant/yandex-metrics/internal/server/auth"
+)
+
+// AdminAuth проверяет токен администратора в заголовке Authorization: Bearer <token>.
+// Токены с правом admin из источника токенов также допускаются.
+type AdminAuth struct {
+	token  []byte
+	tokens auth.Tokens
+}
+
+func NewAdminAuth(token string) *AdminAuth {
+	return &AdminAuth{token: []byte(token)}
+}
+
+// WithTokens источник токенов, токены с правом admin получают доступ к административным методам
+func (a *AdminAuth) WithTokens(tokens auth.Tokens) *AdminAuth {
+	a.tokens = tokens
+	return a
+}
+
+// Handler пропускает запрос только с токеном администратора.
+// Если ни токен, ни источник токенов не заданы в конфигурации, административные методы недоступны.
+func (a *AdminAuth) Handler(next http.Handler) http.Handler {
+	f := func(w http.ResponseWriter, r *http.Request) {
+		if len(a.token) == 0 && a.tokens == nil {
+			http.Error(w, "admin token is not configured", http.StatusForbidden)
+			return
+		}
+
+		token := requestToken(r.Header.Get("Authorization"), r.Header.Get(APIKeyHeaderKey))
+		if token == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		if !a.allowed(r, token) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+
+	return http.HandlerFunc(f)
+}
+
+func (a *AdminAuth) allowed(r *http.Request, token string) bool {
+	if len(a.token) != 0 && subtle.ConstantTimeCompare([]byte(token), a.token) == 1 {
+		return true
+	}
+
+	if a.tokens == nil {
+		return false
+	}
+
+	scopes, err := a.tokens.Scopes(r.Context(), token)
+	return err == nil && auth.Allowed(scopes, auth.ScopeAdmin)
+}
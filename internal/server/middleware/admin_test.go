@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sotavant/yandex-metrics/internal/server/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminAuth_Handler(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tokens := auth.StaticTokens{}
+	tokens.Add("admin", auth.ScopeAdmin)
+	tokens.Add("writer", auth.ScopeWrite)
+
+	tests := []struct {
+		name       string
+		token      string
+		tokens     auth.Tokens
+		header     string
+		wantStatus int
+	}{
+		{
+			name:       "validToken",
+			token:      "secret",
+			header:     "Bearer secret",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "wrongToken",
+			token:      "secret",
+			header:     "Bearer other",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "noHeader",
+			token:      "secret",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "notBearer",
+			token:      "secret",
+			header:     "Basic c2VjcmV0",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "tokenNotConfigured",
+			header:     "Bearer ",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "adminScope",
+			tokens:     tokens,
+			header:     "Bearer admin",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "writeScope",
+			token:      "secret",
+			tokens:     tokens,
+			header:     "Bearer writer",
+			wantStatus: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodDelete, "/value/gauge/test", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+
+			w := httptest.NewRecorder()
+			NewAdminAuth(tt.token).WithTokens(tt.tokens).Handler(next).ServeHTTP(w, req)
+
+			res := w.Result()
+			defer func() {
+				assert.NoError(t, res.Body.Close())
+			}()
+
+			assert.Equal(t, tt.wantStatus, res.StatusCode)
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/sotavant/yandex-metrics/internal/server"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// AgentKeys определяет агента, от которого пришел запрос, и кладет его ключ в контекст (server.WithAgentKey).
+// Агент определяется по сертификату клиента, иначе по IP-адресу, поэтому шаг должен стоять после ClientCert.
+// Токен из заголовков здесь не используется: он еще не проверен, и случайные токены давали бы
+// новые ключи без ограничения. Ключ по принятому токену ставит TokenAuth. Ключ используют лимиты запросов и рядов.
+type AgentKeys struct {
+	// ips определяет адрес клиента с учетом доверенных прокси
+	ips *IPChecker
+}
+
+func NewAgentKeys() *AgentKeys {
+	return &AgentKeys{ips: NewIPChecker("")}
+}
+
+// WithTrustedProxies прокси, от которых принимается адрес клиента, как в IPChecker
+func (k *AgentKeys) WithTrustedProxies(proxies string) *AgentKeys {
+	k.ips = NewIPChecker("").WithTrustedProxies(proxies)
+	return k
+}
+
+// Handler middleware для HTTP
+func (k *AgentKeys) Handler(next http.Handler) http.Handler {
+	f := func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(server.WithAgentKey(r.Context(), k.httpKey(r))))
+	}
+
+	return http.HandlerFunc(f)
+}
+
+// Interceptor перехватчик для унарных gRPC-методов
+func (k *AgentKeys) Interceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	return handler(server.WithAgentKey(ctx, k.grpcKey(ctx)), req)
+}
+
+// StreamInterceptor перехватчик для потоковых gRPC-методов
+func (k *AgentKeys) StreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx := ss.Context()
+	return handler(srv, &contextStream{ServerStream: ss, ctx: server.WithAgentKey(ctx, k.grpcKey(ctx))})
+}
+
+// Stage определение агента для HTTP и gRPC
+func (k *AgentKeys) Stage() Stage {
+	return Stage{HTTP: k.Handler, Unary: k.Interceptor, Stream: k.StreamInterceptor}
+}
+
+// httpKey ключ агента, уже определенный раньше в цепочке, или вычисленный по запросу
+func (k *AgentKeys) httpKey(r *http.Request) string {
+	if key, ok := server.AgentKey(r.Context()); ok {
+		return key
+	}
+
+	if agentID, ok := server.AgentID(r.Context()); ok {
+		return "agent:" + agentID
+	}
+
+	client, err := k.ips.clientIP(addrIP(r.RemoteAddr), r.Header.Get("X-Forwarded-For"), r.Header.Get("X-Real-IP"))
+	if err != nil || client == nil {
+		return "addr:" + r.RemoteAddr
+	}
+
+	return "ip:" + client.String()
+}
+
+func (k *AgentKeys) grpcKey(ctx context.Context) string {
+	if key, ok := server.AgentKey(ctx); ok {
+		return key
+	}
+
+	if agentID, ok := server.AgentID(ctx); ok {
+		return "agent:" + agentID
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	var remote string
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		remote = p.Addr.String()
+	}
+
+	client, err := k.ips.clientIP(addrIP(remote), strings.Join(md.Get("x-forwarded-for"), ","), firstMD(md, "x-real-ip"))
+	if err != nil || client == nil {
+		return "addr:" + remote
+	}
+
+	return "ip:" + client.String()
+}
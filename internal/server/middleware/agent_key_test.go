@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/server"
+	"github.com/sotavant/yandex-metrics/internal/server/auth"
+	"github.com/sotavant/yandex-metrics/internal/server/cardinality"
+	"github.com/sotavant/yandex-metrics/internal/server/metric"
+	"github.com/sotavant/yandex-metrics/internal/server/repository/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func TestAgentKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		agentID string
+		token   string
+		// forwardedFor адрес клиента от прокси 10.0.0.1
+		forwardedFor string
+		want         string
+	}{
+		{
+			name:         "certificate",
+			agentID:      "host-1",
+			token:        "secret",
+			forwardedFor: "192.168.1.5",
+			want:         "agent:host-1",
+		},
+		{
+			name:         "unchecked token",
+			token:        "secret",
+			forwardedFor: "192.168.1.5",
+			want:         "ip:192.168.1.5",
+		},
+		{
+			name:         "client ip behind proxy",
+			forwardedFor: "192.168.1.5",
+			want:         "ip:192.168.1.5",
+		},
+		{
+			name: "proxy ip",
+			want: "ip:10.0.0.1",
+		},
+	}
+
+	keys := NewAgentKeys().WithTrustedProxies("10.0.0.1")
+	for _, tt := range tests {
+		t.Run("http "+tt.name, func(t *testing.T) {
+			var got string
+			handler := keys.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, _ = server.AgentKey(r.Context())
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/update/", nil)
+			req.RemoteAddr = "10.0.0.1:4000"
+			if tt.agentID != "" {
+				req = req.WithContext(server.WithAgentID(req.Context(), tt.agentID))
+			}
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			if tt.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
+
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			assert.Equal(t, tt.want, got)
+		})
+
+		t.Run("grpc "+tt.name, func(t *testing.T) {
+			ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 4000}})
+			if tt.agentID != "" {
+				ctx = server.WithAgentID(ctx, tt.agentID)
+			}
+
+			md := metadata.MD{}
+			if tt.token != "" {
+				md.Set("authorization", "Bearer "+tt.token)
+			}
+			if tt.forwardedFor != "" {
+				md.Set("x-forwarded-for", tt.forwardedFor)
+			}
+
+			var got string
+			_, err := keys.Interceptor(metadata.NewIncomingContext(ctx, md), nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req interface{}) (interface{}, error) {
+				got, _ = server.AgentKey(ctx)
+				return nil, nil
+			})
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// TestAgentKeys_Cardinality непроверенные токены не дают агенту новых лимитов рядов
+func TestAgentKeys_Cardinality(t *testing.T) {
+	internal.InitLogger()
+
+	tokens := auth.StaticTokens{}
+	tokens.Add("writer", auth.ScopeWrite)
+
+	tracker := cardinality.NewTracker(0, 1, cardinality.ModeReject)
+	service := metric.NewMetricService(memory.NewMetricsRepository()).WithCardinality(tracker)
+	handler := NewAgentKeys().Handler(NewTokenAuth(tokens).Identify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		val := 1.0
+		_, err := service.Upsert(r.Context(), internal.Metrics{ID: r.URL.Query().Get("id"), MType: internal.GaugeType, Value: &val})
+		if err != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	send := func(id, token string) int {
+		req := httptest.NewRequest(http.MethodPost, "/update/?id="+id, nil)
+		req.RemoteAddr = "192.0.2.1:4000"
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, send("a", "bogus-1"))
+	assert.Equal(t, http.StatusUnprocessableEntity, send("b", "bogus-2"))
+	assert.Equal(t, http.StatusOK, send("b", "writer"))
+
+	assert.Equal(t, map[string]int{
+		"ip:192.0.2.1":                      1,
+		"token:" + auth.HashToken("writer"): 1,
+	}, tracker.Stats().Agents)
+}
@@ -2,74 +2,219 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"net"
 	"net/http"
+	"strings"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 )
 
+var (
+	// errNoClientIP адрес клиента не удалось определить
+	errNoClientIP = errors.New("client ip not found")
+	// errBadClientIP некорректный адрес в заголовке прокси
+	errBadClientIP = errors.New("invalid client ip")
+	// errForbiddenIP адрес не входит в доверенные подсети или запрещен
+	errForbiddenIP = errors.New("forbidden ip")
+)
+
+// IPChecker фильтр по адресу клиента. Адрес берется из соединения (RemoteAddr, peer.Peer),
+// заголовкам X-Forwarded-For и X-Real-IP верит, только если соединение пришло от доверенного прокси.
+// Списки задаются через запятую: подсети CIDR или отдельные адреса, IPv4 и IPv6.
 type IPChecker struct {
-	trustedSubnet *net.IPNet
+	trusted []*net.IPNet
+	proxies []*net.IPNet
+	denied  []*net.IPNet
 }
 
-func NewIPChecker(trustedSubnet string) *IPChecker {
-	if trustedSubnet == "" {
-		return nil
-	}
+// NewIPChecker фильтр с доверенными подсетями, без них проверяется только список запрещенных
+func NewIPChecker(trustedSubnets string) *IPChecker {
+	return &IPChecker{trusted: parseNets(trustedSubnets)}
+}
 
-	_, ipNet, err := net.ParseCIDR(trustedSubnet)
-	if err != nil {
-		panic(err)
-	}
-	return &IPChecker{trustedSubnet: ipNet}
+// WithTrustedProxies прокси, от которых принимаются X-Forwarded-For и X-Real-IP
+func (ip *IPChecker) WithTrustedProxies(proxies string) *IPChecker {
+	ip.proxies = parseNets(proxies)
+	return ip
+}
+
+// WithDenylist запрещенные подсети, проверяются раньше доверенных
+func (ip *IPChecker) WithDenylist(denied string) *IPChecker {
+	ip.denied = parseNets(denied)
+	return ip
 }
 
 func (ip *IPChecker) CheckIP(next http.Handler) http.Handler {
 	f := func(w http.ResponseWriter, r *http.Request) {
-		reqIP := r.Header.Get("X-Real-IP")
-		if reqIP == "" {
+		err := ip.check(ip.clientIP(addrIP(r.RemoteAddr), r.Header.Get("X-Forwarded-For"), r.Header.Get("X-Real-IP")))
+		switch {
+		case errors.Is(err, errBadClientIP):
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		case err != nil:
 			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
-			return
+		default:
+			next.ServeHTTP(w, r)
 		}
+	}
 
-		IP := net.ParseIP(reqIP)
-		if IP == nil {
-			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+	return http.HandlerFunc(f)
+}
+
+func (ip *IPChecker) CheckIPInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	if err = ip.checkGRPCIP(ctx); err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+// CheckIPStreamInterceptor проверка IP для потоковых методов, аналогична CheckIPInterceptor
+func (ip *IPChecker) CheckIPStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := ip.checkGRPCIP(ss.Context()); err != nil {
+		return err
+	}
+
+	return handler(srv, ss)
+}
+
+func (ip *IPChecker) checkGRPCIP(ctx context.Context) error {
+	var remote net.IP
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		remote = addrIP(p.Addr.String())
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	err := ip.check(ip.clientIP(remote, strings.Join(md.Get("x-forwarded-for"), ","), firstMD(md, "x-real-ip")))
+
+	if errors.Is(err, errBadClientIP) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err != nil {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	return nil
+}
+
+// enabled задан хотя бы один список подсетей для проверки
+func (ip *IPChecker) enabled() bool {
+	return len(ip.trusted) > 0 || len(ip.denied) > 0
+}
+
+func (ip *IPChecker) check(client net.IP, err error) error {
+	if err != nil {
+		return err
+	}
+
+	if client == nil {
+		return errNoClientIP
+	}
+
+	if containsIP(ip.denied, client) {
+		return errForbiddenIP
+	}
+
+	if len(ip.trusted) > 0 && !containsIP(ip.trusted, client) {
+		return errForbiddenIP
+	}
+
+	return nil
+}
+
+// clientIP адрес клиента. Если соединение пришло от доверенного прокси, адрес берется
+// из X-Forwarded-For (справа налево, пропуская доверенные прокси), затем из X-Real-IP.
+func (ip *IPChecker) clientIP(remote net.IP, forwardedFor, realIP string) (net.IP, error) {
+	if remote == nil || !containsIP(ip.proxies, remote) {
+		return remote, nil
+	}
+
+	if forwardedFor != "" {
+		hops := strings.Split(forwardedFor, ",")
+		client := remote
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := net.ParseIP(strings.TrimSpace(hops[i]))
+			if hop == nil {
+				return nil, errBadClientIP
+			}
+
+			client = hop
+			if !containsIP(ip.proxies, hop) {
+				break
+			}
 		}
 
-		if !ip.trustedSubnet.Contains(IP) {
-			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
-			return
+		return client, nil
+	}
+
+	if realIP != "" {
+		client := net.ParseIP(strings.TrimSpace(realIP))
+		if client == nil {
+			return nil, errBadClientIP
 		}
 
-		next.ServeHTTP(w, r)
+		return client, nil
 	}
 
-	return http.HandlerFunc(f)
+	return remote, nil
 }
 
-func (ip *IPChecker) CheckIPInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
-	md, ok := metadata.FromIncomingContext(ctx)
-	if ok {
-		ips := md["x-real-ip"]
-		if len(ips) > 0 {
-			IP := net.ParseIP(ips[0])
-			if IP == nil {
-				return nil, status.Errorf(codes.InvalidArgument, "invalid IP")
+// addrIP адрес из строки host:port или просто host, nil если это не IP (например, unix-сокет)
+func addrIP(addr string) net.IP {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+
+	return net.ParseIP(addr)
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseNets разбирает список подсетей через запятую, отдельный адрес считается подсетью из одного адреса.
+// Ошибка в списке - ошибка конфигурации, поэтому паника.
+func parseNets(list string) []*net.IPNet {
+	var res []*net.IPNet
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		if !strings.Contains(item, "/") {
+			addr := net.ParseIP(item)
+			if addr == nil {
+				panic("invalid ip: " + item)
 			}
 
-			if !ip.trustedSubnet.Contains(IP) {
-				return nil, status.Errorf(codes.Unauthenticated, "forbidden IP")
+			bits := 8 * net.IPv6len
+			if v4 := addr.To4(); v4 != nil {
+				addr, bits = v4, 8*net.IPv4len
 			}
 
-			return handler(ctx, req)
+			res = append(res, &net.IPNet{IP: addr, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			panic(err)
 		}
 
-		return nil, status.Errorf(codes.Unauthenticated, "not found X-Real-IP")
-	} else {
-		return nil, status.Errorf(codes.Unauthenticated, "not found X-Real-IP")
+		res = append(res, ipNet)
 	}
+
+	return res
 }
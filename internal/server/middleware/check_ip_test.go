@@ -16,12 +16,11 @@ import (
 	"github.com/sotavant/yandex-metrics/internal/server/metric"
 	"github.com/sotavant/yandex-metrics/internal/server/repository/memory"
 	"github.com/sotavant/yandex-metrics/internal/server/storage"
-	pb "github.com/sotavant/yandex-metrics/proto_test"
 	"github.com/stretchr/testify/assert"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 	"google.golang.org/grpc/test/bufconn"
 )
@@ -56,38 +55,90 @@ func TestIPChecker_CheckIP_Handler(t *testing.T) {
 
 	tests := []struct {
 		name       string
-		ip         string
-		wantStatus int
+		checker    *IPChecker
+		remoteAddr string
+		// forwardedFor и realIP заголовки прокси
+		forwardedFor, realIP string
+		wantStatus           int
 	}{
 		{
-			"good IP",
-			"192.168.1.130",
-			http.StatusOK,
+			name:       "trusted peer",
+			checker:    NewIPChecker(trustedSubnet),
+			remoteAddr: "192.168.1.130:4567",
+			wantStatus: http.StatusOK,
 		},
 		{
-			"bad IP",
-			"132.132.132.132",
-			http.StatusForbidden,
+			name:       "untrusted peer",
+			checker:    NewIPChecker(trustedSubnet),
+			remoteAddr: "132.132.132.132:4567",
+			wantStatus: http.StatusForbidden,
 		},
 		{
-			"empty IP",
-			"",
-			http.StatusForbidden,
+			name:       "spoofed X-Real-IP from untrusted peer",
+			checker:    NewIPChecker(trustedSubnet),
+			remoteAddr: "132.132.132.132:4567",
+			realIP:     "192.168.1.130",
+			wantStatus: http.StatusForbidden,
 		},
 		{
-			"not correct IP",
-			"sdfsdf.sdfsd.sdfsdf",
-			http.StatusBadRequest,
+			name:       "several subnets with ipv6",
+			checker:    NewIPChecker("10.0.0.0/8, fd00::/8"),
+			remoteAddr: "[fd00::10]:4567",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "X-Real-IP from trusted proxy",
+			checker:    NewIPChecker(trustedSubnet).WithTrustedProxies("10.0.0.1"),
+			remoteAddr: "10.0.0.1:4567",
+			realIP:     "192.168.1.130",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "X-Forwarded-For through proxy chain",
+			checker:    NewIPChecker(trustedSubnet).WithTrustedProxies("10.0.0.0/24"),
+			remoteAddr: "10.0.0.1:4567",
+			// первый адрес подставлен клиентом, справа налево первый не прокси - 192.168.1.130
+			forwardedFor: "132.132.132.132, 192.168.1.130, 10.0.0.2",
+			wantStatus:   http.StatusOK,
+		},
+		{
+			name:         "X-Forwarded-For with untrusted client",
+			checker:      NewIPChecker(trustedSubnet).WithTrustedProxies("10.0.0.1"),
+			remoteAddr:   "10.0.0.1:4567",
+			forwardedFor: "192.168.1.130, 132.132.132.132",
+			wantStatus:   http.StatusForbidden,
+		},
+		{
+			name:       "proxy without headers",
+			checker:    NewIPChecker(trustedSubnet).WithTrustedProxies("10.0.0.1"),
+			remoteAddr: "10.0.0.1:4567",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "not correct IP from proxy",
+			checker:    NewIPChecker(trustedSubnet).WithTrustedProxies("10.0.0.1"),
+			remoteAddr: "10.0.0.1:4567",
+			realIP:     "sdfsdf.sdfsd.sdfsdf",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "denied inside trusted subnet",
+			checker:    NewIPChecker(trustedSubnet).WithDenylist("192.168.1.128/25"),
+			remoteAddr: "192.168.1.130:4567",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "denylist only",
+			checker:    NewIPChecker("").WithDenylist("132.132.0.0/16"),
+			remoteAddr: "133.133.133.133:4567",
+			wantStatus: http.StatusOK,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			ipMiddleware := NewIPChecker(conf.TrustedSubnet)
-			assert.NotNil(t, ipMiddleware)
-
 			r := chi.NewRouter()
-			r.Use(ipMiddleware.CheckIP)
+			r.Use(tt.checker.CheckIP)
 			r.Post("/update/", handlers.UpdateJSONHandler(appInstance, metric.NewMetricService(st)))
 
 			w := httptest.NewRecorder()
@@ -96,7 +147,13 @@ func TestIPChecker_CheckIP_Handler(t *testing.T) {
 				req := httptest.NewRequest(http.MethodPost, "/update/", strings.NewReader(requestBody))
 				req.Header.Set("Accept", "application/json")
 				req.Header.Set("Content-Type", "application/json")
-				req.Header.Set("X-Real-IP", tt.ip)
+				req.RemoteAddr = tt.remoteAddr
+				if tt.forwardedFor != "" {
+					req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+				}
+				if tt.realIP != "" {
+					req.Header.Set("X-Real-IP", tt.realIP)
+				}
 
 				return req
 			}
@@ -113,79 +170,83 @@ func TestIPChecker_CheckIP_Handler(t *testing.T) {
 	}
 }
 
-type TestGRPCServer struct {
-	pb.UnimplementedTestServer
-}
-
-func (t *TestGRPCServer) SetXRealIP(ctx context.Context, req *pb.SetXRealIPRequest) (*pb.SetXRealIPResponse, error) {
-	return nil, nil
-}
-
 func TestIPChecker_CheckIPInterceptor(t *testing.T) {
 	internal.InitLogger()
-	var ctx context.Context
 
 	tests := []struct {
-		name       string
-		ip         string
+		name string
+		// peerAddr адрес соединения, пустой - адрес без IP, как у bufconn
+		peerAddr   string
+		md         metadata.MD
 		wantStatus codes.Code
 	}{
 		{
-			"good IP",
-			"192.168.1.130",
-			codes.OK,
+			name:       "trusted peer",
+			peerAddr:   "192.168.1.130:4567",
+			wantStatus: codes.OK,
+		},
+		{
+			name:       "untrusted peer",
+			peerAddr:   "132.132.132.132:4567",
+			wantStatus: codes.Unauthenticated,
+		},
+		{
+			name:       "spoofed X-Real-IP from untrusted peer",
+			peerAddr:   "132.132.132.132:4567",
+			md:         metadata.Pairs("X-Real-IP", "192.168.1.130"),
+			wantStatus: codes.Unauthenticated,
+		},
+		{
+			name:       "X-Real-IP from trusted proxy",
+			peerAddr:   "10.0.0.1:4567",
+			md:         metadata.Pairs("X-Real-IP", "192.168.1.130"),
+			wantStatus: codes.OK,
+		},
+		{
+			name:       "X-Forwarded-For from trusted proxy",
+			peerAddr:   "10.0.0.1:4567",
+			md:         metadata.Pairs("X-Forwarded-For", "192.168.1.130"),
+			wantStatus: codes.OK,
 		},
 		{
-			"bad IP",
-			"132.132.132.132",
-			codes.Unauthenticated,
+			name:       "not correct IP from proxy",
+			peerAddr:   "10.0.0.1:4567",
+			md:         metadata.Pairs("X-Real-IP", "sdfsdf.sdfsd.sdfsdf"),
+			wantStatus: codes.InvalidArgument,
 		},
 		{
-			"empty IP",
-			"",
-			codes.Unauthenticated,
+			name:       "denied",
+			peerAddr:   "192.168.1.5:4567",
+			wantStatus: codes.Unauthenticated,
 		},
 		{
-			"not correct IP",
-			"sdfsdf.sdfsd.sdfsdf",
-			codes.InvalidArgument,
+			name:       "peer without ip",
+			md:         metadata.Pairs("X-Real-IP", "192.168.1.130"),
+			wantStatus: codes.Unauthenticated,
 		},
 	}
 
+	ipMiddleware := NewIPChecker(trustedSubnet).WithTrustedProxies("10.0.0.1").WithDenylist("192.168.1.5")
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	}
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			ipMiddleware := NewIPChecker(trustedSubnet)
-			assert.NotNil(t, ipMiddleware)
-
-			lis = bufconn.Listen(bufSize)
-			s := grpc.NewServer(grpc.UnaryInterceptor(ipMiddleware.CheckIPInterceptor))
-			pb.RegisterTestServer(s, &TestGRPCServer{})
-			go func() {
-				err := s.Serve(lis)
+			var addr net.Addr = &net.UnixAddr{Name: "bufconn", Net: "bufconn"}
+			if tt.peerAddr != "" {
+				tcpAddr, err := net.ResolveTCPAddr("tcp", tt.peerAddr)
 				assert.NoError(t, err)
-			}()
-
-			conn, err := grpc.NewClient("passthrough://bufnet", grpc.WithContextDialer(bufDialer), grpc.WithTransportCredentials(insecure.NewCredentials()))
-			assert.NoError(t, err)
-			defer func(conn *grpc.ClientConn) {
-				err = conn.Close()
-				assert.NoError(t, err)
-			}(conn)
-
-			if tt.ip != "" {
-				md := metadata.Pairs("X-Real-IP", tt.ip)
-				ctx = metadata.NewOutgoingContext(context.Background(), md)
-			} else {
-				ctx = context.Background()
+				addr = tcpAddr
 			}
 
-			client := pb.NewTestClient(conn)
-			_, err = client.SetXRealIP(ctx, &pb.SetXRealIPRequest{IP: tt.ip})
-			if tt.wantStatus == codes.OK {
-				assert.NoError(t, err)
-			} else {
-				assert.Equal(t, status.Code(err), tt.wantStatus)
+			ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: addr})
+			if tt.md != nil {
+				ctx = metadata.NewIncomingContext(ctx, tt.md)
 			}
+
+			_, err := ipMiddleware.CheckIPInterceptor(ctx, nil, &grpc.UnaryServerInfo{}, handler)
+			assert.Equal(t, tt.wantStatus, status.Code(err))
 		})
 	}
 }
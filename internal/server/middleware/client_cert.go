@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/server"
+	"github.com/sotavant/yandex-metrics/internal/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// ClientCert передает обработчикам идентификатор агента из сертификата клиента (CN или SAN).
+// Если агент также передал X-Agent-ID, он должен совпадать с сертификатом.
+// Запросы без проверенного сертификата пропускаются как есть.
+type ClientCert struct{}
+
+func NewClientCert() *ClientCert {
+	return &ClientCert{}
+}
+
+// Handler middleware для HTTP
+func (c *ClientCert) Handler(next http.Handler) http.Handler {
+	f := func(w http.ResponseWriter, r *http.Request) {
+		agentID := utils.PeerIdentity(r.TLS)
+		if agentID == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if claimed := r.Header.Get(utils.AgentIDHeaderKey); claimed != "" && claimed != agentID {
+			internal.Logger.Infow("agent id does not match certificate", "agent", claimed, "cert", agentID)
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(server.WithAgentID(r.Context(), agentID)))
+	}
+
+	return http.HandlerFunc(f)
+}
+
+// Interceptor перехватчик для унарных gRPC-методов
+func (c *ClientCert) Interceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := c.grpcContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+// StreamInterceptor перехватчик для потоковых gRPC-методов
+func (c *ClientCert) StreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := c.grpcContext(ss.Context())
+	if err != nil {
+		return err
+	}
+
+	return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+}
+
+// Stage сертификат клиента для HTTP и gRPC
+func (c *ClientCert) Stage() Stage {
+	return Stage{HTTP: c.Handler, Unary: c.Interceptor, Stream: c.StreamInterceptor}
+}
+
+func (c *ClientCert) grpcContext(ctx context.Context) (context.Context, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ctx, nil
+	}
+
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return ctx, nil
+	}
+
+	agentID := utils.PeerIdentity(&tlsInfo.State)
+	if agentID == "" {
+		return ctx, nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	if claimed := firstMD(md, utils.AgentIDHeaderKey); claimed != "" && claimed != agentID {
+		return nil, status.Error(codes.PermissionDenied, "agent id does not match certificate")
+	}
+
+	return server.WithAgentID(ctx, agentID), nil
+}
+
+// contextStream поток с подмененным контекстом
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
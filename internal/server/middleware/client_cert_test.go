@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/server"
+	"github.com/sotavant/yandex-metrics/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientCert_Handler(t *testing.T) {
+	internal.InitLogger()
+
+	verified := &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "agent-1"}}}},
+	}
+
+	tests := []struct {
+		name       string
+		state      *tls.ConnectionState
+		agentID    string
+		wantStatus int
+		wantAgent  string
+	}{
+		{
+			name:       "certificate",
+			state:      verified,
+			wantStatus: http.StatusOK,
+			wantAgent:  "agent-1",
+		},
+		{
+			name:       "matching agent id",
+			state:      verified,
+			agentID:    "agent-1",
+			wantStatus: http.StatusOK,
+			wantAgent:  "agent-1",
+		},
+		{
+			name:       "other agent id",
+			state:      verified,
+			agentID:    "agent-2",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "plain http",
+			agentID:    "agent-2",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "tls without client certificate",
+			state:      &tls.ConnectionState{},
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotAgent string
+			handler := NewClientCert().Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotAgent, _ = server.AgentID(r.Context())
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/update/", nil)
+			req.TLS = tt.state
+			if tt.agentID != "" {
+				req.Header.Set(utils.AgentIDHeaderKey, tt.agentID)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			assert.Equal(t, tt.wantAgent, gotAgent)
+		})
+	}
+}
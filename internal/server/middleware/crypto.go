@@ -12,26 +12,36 @@ import (
 // Crypto structure with initialized Cipher
 type Crypto struct {
 	Cipher *utils.Cipher
+	// Ring если задан, используется вместо Cipher: ключ выбирается по заголовку X-Key-ID
+	Ring *utils.KeyRing
 }
 
-// NewCrypto initialize struct
-func NewCrypto(pathToPrivateKey string) (*Crypto, error) {
+// NewCrypto initialize struct. В режиме legacy кроме конвертов принимаются тела, зашифрованные RSA PKCS#1 v1.5.
+func NewCrypto(pathToPrivateKey string, legacy bool) (*Crypto, error) {
 	cipher, err := utils.NewCipher(pathToPrivateKey, "", "")
 	if err != nil {
 		return nil, err
 	}
 
 	return &Crypto{
-		Cipher: cipher,
+		Cipher: cipher.WithLegacy(legacy),
 	}, nil
 }
 
+// WithKeyRing расшифровывать тела ключами из ring
+func (h *Crypto) WithKeyRing(ring *utils.KeyRing) *Crypto {
+	h.Ring = ring
+
+	return h
+}
+
 // Handler Данный middleware служит для расшифровки тела запроса.
 // Если приватный ключ неустановлен, то тело запроса считается не зашифрованным.
+// Запросы без тела (GET, DELETE, поток обновлений) пропускаются как есть.
 func (h *Crypto) Handler(next http.Handler) http.Handler {
 	f := func(w http.ResponseWriter, r *http.Request) {
 		ow := w
-		if h.Cipher.IsPrivateKeyExist() {
+		if (h.Ring != nil || h.Cipher.IsPrivateKeyExist()) && r.Body != http.NoBody && r.ContentLength != 0 {
 			body, err := io.ReadAll(r.Body)
 			if err != nil {
 				internal.Logger.Infow("read body error", "error", err)
@@ -39,9 +49,16 @@ func (h *Crypto) Handler(next http.Handler) http.Handler {
 				return
 			}
 
-			encryptedText, err := h.Cipher.Decrypt(body)
+			// длина тела может быть неизвестна заранее (chunked), пустое тело не зашифровано
+			if len(body) == 0 {
+				r.Body = http.NoBody
+				next.ServeHTTP(ow, r)
+				return
+			}
+
+			encryptedText, err := h.decrypt(r, body)
 			if err != nil {
-				internal.Logger.Infow("bad attempt to decrypt")
+				internal.Logger.Infow("bad attempt to decrypt", "err", err)
 				w.WriteHeader(http.StatusBadRequest)
 				return
 			}
@@ -54,3 +71,11 @@ func (h *Crypto) Handler(next http.Handler) http.Handler {
 
 	return http.HandlerFunc(f)
 }
+
+func (h *Crypto) decrypt(r *http.Request, body []byte) ([]byte, error) {
+	if h.Ring != nil {
+		return h.Ring.Decrypt(r.Header.Get(utils.KeyIDHeader), body)
+	}
+
+	return h.Cipher.Decrypt(body)
+}
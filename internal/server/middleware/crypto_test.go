@@ -1,9 +1,17 @@
 package middleware
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -17,6 +25,7 @@ import (
 	"github.com/sotavant/yandex-metrics/internal/server/storage"
 	"github.com/sotavant/yandex-metrics/internal/utils"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestCrypto_Handler(t *testing.T) {
@@ -75,7 +84,7 @@ func TestCrypto_Handler(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			cryptoMiddlware, err = NewCrypto(privateKeyPath)
+			cryptoMiddlware, err = NewCrypto(privateKeyPath, false)
 			assert.NoError(t, err)
 
 			r := chi.NewRouter()
@@ -103,3 +112,231 @@ func TestCrypto_Handler(t *testing.T) {
 		})
 	}
 }
+
+func TestCrypto_HandlerEnvelope(t *testing.T) {
+	internal.InitLogger()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	privateKeyPath, publicKeyPath := filepath.Join(dir, "private.pem"), filepath.Join(dir, "public.pem")
+	priv := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	require.NoError(t, os.WriteFile(privateKeyPath, priv, 0600))
+	pub := pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&key.PublicKey)})
+	require.NoError(t, os.WriteFile(publicKeyPath, pub, 0644))
+
+	// пакет больше размера ключа RSA
+	var batch bytes.Buffer
+	batch.WriteString("[")
+	for i := 0; i < 50; i++ {
+		if i > 0 {
+			batch.WriteString(",")
+		}
+		fmt.Fprintf(&batch, `{"id":"gauge%d","type":"gauge","value":%d}`, i, i)
+	}
+	batch.WriteString("]")
+
+	single := []byte(`{"id":"ss","type":"gauge","value":3}`)
+
+	tests := []struct {
+		name         string
+		body         []byte
+		agentLegacy  bool
+		serverLegacy bool
+		wantStatus   int
+	}{
+		{
+			name:       "large batch in envelope",
+			body:       batch.Bytes(),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:         "envelope on legacy server",
+			body:         single,
+			serverLegacy: true,
+			wantStatus:   http.StatusOK,
+		},
+		{
+			name:         "legacy on legacy server",
+			body:         single,
+			agentLegacy:  true,
+			serverLegacy: true,
+			wantStatus:   http.StatusOK,
+		},
+		{
+			name:        "legacy on strict server",
+			body:        single,
+			agentLegacy: true,
+			wantStatus:  http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := memory.NewMetricsRepository()
+			appInstance := &server.App{Config: &config.Config{}, Storage: st}
+
+			ch, err := utils.NewCipher("", publicKeyPath, "")
+			require.NoError(t, err)
+			encrypted, err := ch.WithLegacy(tt.agentLegacy).Encrypt(tt.body)
+			require.NoError(t, err)
+
+			cryptoMiddleware, err := NewCrypto(privateKeyPath, tt.serverLegacy)
+			require.NoError(t, err)
+
+			r := chi.NewRouter()
+			r.Use(cryptoMiddleware.Handler)
+			r.Post("/update/", handlers.UpdateJSONHandler(appInstance, metric.NewMetricService(st)))
+			r.Post("/updates/", handlers.UpdateBatchJSONHandler(appInstance, metric.NewMetricService(st)))
+
+			url := "/update/"
+			if bytes.HasPrefix(tt.body, []byte("[")) {
+				url = "/updates/"
+			}
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, url, bytes.NewReader(encrypted))
+			req.Header.Set("Content-Type", "application/json")
+			r.ServeHTTP(w, req)
+
+			result := w.Result()
+			require.NoError(t, result.Body.Close())
+			assert.Equal(t, tt.wantStatus, result.StatusCode)
+		})
+	}
+}
+
+func TestCrypto_HandlerKeyRing(t *testing.T) {
+	internal.InitLogger()
+
+	dir := t.TempDir()
+	publicKeys := make(map[string]string)
+	for _, name := range []string{"old", "new"} {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+
+		priv := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name+".pem"), priv, 0600))
+
+		publicKeys[name] = filepath.Join(t.TempDir(), "public.pem")
+		pub := pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&key.PublicKey)})
+		require.NoError(t, os.WriteFile(publicKeys[name], pub, 0644))
+	}
+
+	ring, err := utils.NewKeyRing(dir, 0)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		publicKey  string
+		keyID      func(ch *utils.Cipher) string
+		wantStatus int
+	}{
+		{
+			name:       "old key",
+			publicKey:  publicKeys["old"],
+			keyID:      (*utils.Cipher).KeyID,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "new key",
+			publicKey:  publicKeys["new"],
+			keyID:      (*utils.Cipher).KeyID,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "without key id",
+			publicKey:  publicKeys["new"],
+			keyID:      func(*utils.Cipher) string { return "" },
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "unknown key id",
+			publicKey:  publicKeys["new"],
+			keyID:      func(*utils.Cipher) string { return "0000000000000000" },
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := memory.NewMetricsRepository()
+			appInstance := &server.App{Config: &config.Config{}, Storage: st}
+
+			ch, err := utils.NewCipher("", tt.publicKey, "")
+			require.NoError(t, err)
+			encrypted, err := ch.Encrypt([]byte(`{"id":"ss","type":"gauge","value":3}`))
+			require.NoError(t, err)
+
+			cryptoMiddleware, err := NewCrypto("", false)
+			require.NoError(t, err)
+
+			r := chi.NewRouter()
+			r.Use(cryptoMiddleware.WithKeyRing(ring).Handler)
+			r.Post("/update/", handlers.UpdateJSONHandler(appInstance, metric.NewMetricService(st)))
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/update/", bytes.NewReader(encrypted))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set(utils.KeyIDHeader, tt.keyID(ch))
+			r.ServeHTTP(w, req)
+
+			result := w.Result()
+			defer result.Body.Close()
+			assert.Equal(t, tt.wantStatus, result.StatusCode)
+		})
+	}
+}
+
+func TestCrypto_HandlerWithoutBody(t *testing.T) {
+	internal.InitLogger()
+
+	dir := t.TempDir()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	priv := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "key.pem"), priv, 0600))
+
+	cryptoMiddleware, err := NewCrypto(filepath.Join(dir, "key.pem"), false)
+	require.NoError(t, err)
+
+	st := memory.NewMetricsRepository()
+	val := 1.5
+	require.NoError(t, st.AddValue(context.Background(), internal.Metrics{ID: "gauge", MType: internal.GaugeType, Value: &val}))
+	ms := metric.NewMetricService(st)
+
+	r := chi.NewRouter()
+	r.Use(cryptoMiddleware.Handler)
+	r.Get("/api/v1/metrics", handlers.ListMetricsHandler(ms))
+	r.Delete("/value/{type}/{name}", handlers.DeleteValueHandler(&server.App{Config: &config.Config{}, Storage: st}, ms))
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		wantStatus int
+	}{
+		{
+			name:       "get",
+			method:     http.MethodGet,
+			target:     "/api/v1/metrics",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "delete",
+			method:     http.MethodDelete,
+			target:     "/value/gauge/gauge",
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, nil))
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+		})
+	}
+}
@@ -4,38 +4,120 @@ package middleware
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"strings"
 
 	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/pbconv"
 	"github.com/sotavant/yandex-metrics/internal/utils"
 	pb "github.com/sotavant/yandex-metrics/proto"
+	pbv2 "github.com/sotavant/yandex-metrics/proto/v2"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
+var ErrUnknownAgent = errors.New("unknown agent")
+
 // Hasher содержит ключ шифрования
 type Hasher struct {
 	key string
+	// agentKeys ключи агентов по идентификатору из заголовка X-Agent-ID
+	agentKeys map[string]string
+	// strict отклонять неподписанные HTTP-запросы, изменяющие данные
+	strict bool
+	guard  *ReplayGuard
 }
 
 func NewHasher(key string) *Hasher {
-	return &Hasher{key: key}
+	return &Hasher{key: key, guard: NewReplayGuard(DefaultSignatureSkew, DefaultNonceCacheSize)}
+}
+
+// WithReplayGuard проверять метки времени и nonce подписанных запросов с помощью guard.
+// Один guard можно использовать для HTTP и gRPC, тогда nonce не повторяются между протоколами.
+func (h *Hasher) WithReplayGuard(guard *ReplayGuard) *Hasher {
+	h.guard = guard
+
+	return h
+}
+
+// WithAgentKeys проверять подписи агентов их собственными ключами. Агент с идентификатором
+// не из таблицы и подписанный запрос без идентификатора отклоняются, общий ключ при этом не используется.
+func (h *Hasher) WithAgentKeys(keys map[string]string) *Hasher {
+	h.agentKeys = keys
+
+	return h
+}
+
+// WithStrict отклонять HTTP-запросы без подписи, кроме GET, HEAD и OPTIONS.
+// gRPC-запросы на запись требуют подписи всегда.
+func (h *Hasher) WithStrict(strict bool) *Hasher {
+	h.strict = strict
+
+	return h
+}
+
+// enabled подписи проверяются, если задан общий ключ или ключи агентов
+func (h *Hasher) enabled() bool {
+	return h.key != "" || len(h.agentKeys) > 0
+}
+
+// keyFor ключ подписи агента agentID. Общий ключ подходит только запросам без идентификатора
+// и только пока ключи агентов не заданы, иначе агент из таблицы мог бы подписаться общим ключом,
+// просто не передав X-Agent-ID.
+func (h *Hasher) keyFor(agentID string) (string, error) {
+	if agentID == "" {
+		if h.key == "" || len(h.agentKeys) > 0 {
+			return "", fmt.Errorf("%w: empty agent id", ErrUnknownAgent)
+		}
+
+		return h.key, nil
+	}
+
+	key, ok := h.agentKeys[agentID]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownAgent, agentID)
+	}
+
+	return key, nil
 }
 
 // Handler Данный middleware служит проверки зашифрованного текста запроса.
-// Если ключа нет в Header (HashSHA256) запроса, то тело запроса считается не зашифрованным.
-// Иначе проверяется на корректность.
+// Если ключа нет в Header (HashSHA256) запроса, то тело запроса считается не зашифрованным,
+// в строгом режиме такой запрос отклоняется.
+// Иначе проверяется на корректность вместе с меткой времени (X-Timestamp) и nonce (X-Nonce),
+// запросы вне окна времени и с повторным nonce отклоняются.
 // В ответ на запрос также возвращается зашифрованное сообщение, если был передан ключ.
 func (h *Hasher) Handler(next http.Handler) http.Handler {
 	f := func(w http.ResponseWriter, r *http.Request) {
 		ow := w
 		hash := r.Header.Get(utils.HasherHeaderKey)
-		if h.key != "" && hash != "" {
-			check, err := h.checkHash(hash, r)
+		if h.enabled() && hash == "" && h.strict && !isReadOnly(r) {
+			internal.Logger.Infow("unsigned request", "uri", r.RequestURI)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if h.enabled() && hash != "" {
+			key, err := h.keyFor(r.Header.Get(utils.AgentIDHeaderKey))
+			if err != nil {
+				internal.Logger.Infow("bad signature", "err", err)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			sig, err := utils.ParseSignature(r.Header.Get(utils.TimestampHeaderKey), r.Header.Get(utils.NonceHeaderKey))
+			if err != nil {
+				internal.Logger.Infow("bad signature", "err", err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			check, err := h.checkHash(hash, key, sig, r)
 			if err != nil {
 				internal.Logger.Infow("error in check hash", "err", err)
 				w.WriteHeader(http.StatusInternalServerError)
@@ -48,9 +130,15 @@ func (h *Hasher) Handler(next http.Handler) http.Handler {
 				return
 			}
 
+			if err = h.guard.Check(sig); err != nil {
+				internal.Logger.Infow("rejected signed request", "err", err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
 			hw := &hasherResponseWriter{
 				ResponseWriter: w,
-				hashKey:        h.key,
+				hashKey:        key,
 			}
 
 			ow = hw
@@ -62,52 +150,206 @@ func (h *Hasher) Handler(next http.Handler) http.Handler {
 	return http.HandlerFunc(f)
 }
 
+// CheckHashInterceptor проверяет подпись из метаданных запроса (HashSHA256) вместе с меткой времени
+// и nonce (x-timestamp, x-nonce). Для UpdateMetric подписывается метрика, для UpdateMetricsBatch -
+// весь пакет в порядке следования метрик.
+// Запросы, не изменяющие данные, не проверяются. Запрос без подписи отклоняется с Unauthenticated,
+// как в HTTP с 401, неверная подпись - с InvalidArgument.
 func (h *Hasher) CheckHashInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
-	if h.key == "" {
+	if !h.enabled() {
 		return handler(ctx, req)
 	}
 
-	var res bool
-	md, ok := metadata.FromIncomingContext(ctx)
-	if ok {
-		hashes := md[strings.ToLower(utils.HasherHeaderKey)]
-		if len(hashes) > 0 {
-			res, err = h.checkHashForGRPC(hashes[0], req)
-			if err != nil {
-				return nil, status.Errorf(codes.Internal, "error in check hash: %v", err)
-			}
+	switch req.(type) {
+	case *pb.UpdateMetricRequest, *pb.MetricsBatch, *pbv2.Metric, *pbv2.MetricsBatch:
+	default:
+		return handler(ctx, req)
+	}
 
-			if !res {
-				return nil, status.Error(codes.InvalidArgument, "bad hash")
-			}
+	md, _ := metadata.FromIncomingContext(ctx)
+	hashes := md[strings.ToLower(utils.HasherHeaderKey)]
+	if len(hashes) == 0 {
+		return nil, status.Errorf(codes.Unauthenticated, "empty hash")
+	}
+
+	key, err := h.keyFor(firstMD(md, utils.AgentIDHeaderKey))
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	sig, err := signatureFromMD(md)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
 
-			return handler(ctx, req)
+	res, err := h.checkHashForGRPC(hashes[0], key, sig, req)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error in check hash: %v", err)
+	}
+
+	if !res {
+		return nil, status.Error(codes.InvalidArgument, "bad hash")
+	}
+
+	if err = h.guard.Check(sig); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return handler(ctx, req)
+}
+
+// CheckHashStreamInterceptor проверяет подпись каждой метрики, полученной из потока (поле Hash).
+// Метка времени и nonce передаются в метаданных один раз на поток и входят в подпись каждой метрики,
+// nonce запоминается после первой метрики с верной подписью.
+// Метрика с неверной подписью завершает поток с InvalidArgument, поток без подписи или метрика
+// без подписи - с Unauthenticated.
+func (h *Hasher) CheckHashStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if !h.enabled() || !info.IsClientStream {
+		return handler(srv, ss)
+	}
+
+	md, _ := metadata.FromIncomingContext(ss.Context())
+	key, err := h.keyFor(firstMD(md, utils.AgentIDHeaderKey))
+	if err != nil {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	if firstMD(md, utils.TimestampHeaderKey) == "" && firstMD(md, utils.NonceHeaderKey) == "" {
+		return status.Error(codes.Unauthenticated, "unsigned stream")
+	}
+
+	sig, err := signatureFromMD(md)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return handler(srv, &hashCheckingStream{ServerStream: ss, h: h, key: key, sig: sig})
+}
+
+// hashCheckingStream проверяет подпись входящих сообщений потока
+type hashCheckingStream struct {
+	grpc.ServerStream
+	h   *Hasher
+	key string
+	sig utils.Signature
+	// checked nonce потока уже проверен и запомнен
+	checked bool
+}
+
+func (s *hashCheckingStream) RecvMsg(msg interface{}) error {
+	if err := s.ServerStream.RecvMsg(msg); err != nil {
+		return err
+	}
+
+	var m internal.Metrics
+	var hash string
+
+	switch r := msg.(type) {
+	case *pb.Metric:
+		m, hash = fromProtoMetric(r), r.Hash
+	case *pbv2.Metric:
+		m, hash = fromProtoMetricV2(r), r.Hash
+	default:
+		return nil
+	}
+
+	if hash == "" {
+		return status.Errorf(codes.Unauthenticated, "empty hash")
+	}
+
+	reqHash, err := utils.GetMetricHash(m, s.key, s.sig)
+	if err != nil {
+		return status.Errorf(codes.Internal, "error in check hash: %v", err)
+	}
+
+	if !utils.CheckHash(hash, reqHash) {
+		return status.Error(codes.InvalidArgument, "bad hash")
+	}
+
+	if !s.checked {
+		if err = s.h.guard.Check(s.sig); err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
 		}
 
-		return nil, status.Errorf(codes.InvalidArgument, "empty hash")
-	} else {
-		return nil, status.Errorf(codes.Internal, "error getting metadata")
+		s.checked = true
 	}
+
+	return nil
 }
 
-func (h *Hasher) checkHashForGRPC(hash string, req interface{}) (bool, error) {
-	reqMetric := req.(*pb.UpdateMetricRequest)
-	m := internal.Metrics{
-		Value: &reqMetric.Metric.Value,
-		Delta: &reqMetric.Metric.Delta,
-		ID:    reqMetric.Metric.ID,
-		MType: reqMetric.Metric.MType,
+// signatureFromMD метка времени и nonce из метаданных вызова
+func signatureFromMD(md metadata.MD) (utils.Signature, error) {
+	return utils.ParseSignature(firstMD(md, utils.TimestampHeaderKey), firstMD(md, utils.NonceHeaderKey))
+}
+
+// firstMD первое значение ключа метаданных или пустая строка
+func firstMD(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+
+	return ""
+}
+
+// isReadOnly запрос не изменяет данные и в строгом режиме может быть без подписи
+func isReadOnly(r *http.Request) bool {
+	return r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions
+}
+
+func (h *Hasher) checkHashForGRPC(hash, key string, sig utils.Signature, req interface{}) (bool, error) {
+	var reqHash string
+	var err error
+
+	switch r := req.(type) {
+	case *pb.UpdateMetricRequest:
+		reqHash, err = utils.GetMetricHash(fromProtoMetric(r.Metric), key, sig)
+	case *pb.MetricsBatch:
+		batch := make([]internal.Metrics, 0, len(r.Metrics))
+		for _, m := range r.Metrics {
+			batch = append(batch, fromProtoMetric(m))
+		}
+
+		reqHash, err = utils.GetMetricsHash(batch, key, sig)
+	case *pbv2.Metric:
+		reqHash, err = utils.GetMetricHash(fromProtoMetricV2(r), key, sig)
+	case *pbv2.MetricsBatch:
+		batch := make([]internal.Metrics, 0, len(r.Metrics))
+		for _, m := range r.Metrics {
+			batch = append(batch, fromProtoMetricV2(m))
+		}
+
+		reqHash, err = utils.GetMetricsHash(batch, key, sig)
 	}
 
-	reqHash, err := utils.GetMetricHash(m, h.key)
 	if err != nil {
 		return false, err
 	}
 
-	return reqHash == hash, nil
+	return utils.CheckHash(hash, reqHash), nil
 }
 
-func (h *Hasher) checkHash(reqHash string, r *http.Request) (bool, error) {
+// fromProtoMetricV2 метрика в том виде, в котором ее подписывает агент.
+// Метрика без значения подписывается только по ID, обработчик отклонит ее сам.
+func fromProtoMetricV2(m *pbv2.Metric) internal.Metrics {
+	res, _ := pbconv.MetricFromV2(m)
+	return res
+}
+
+// fromProtoMetric метрика в том виде, в котором ее подписывает агент
+func fromProtoMetric(m *pb.Metric) internal.Metrics {
+	if m == nil {
+		return internal.Metrics{}
+	}
+
+	return internal.Metrics{
+		Value: &m.Value,
+		Delta: &m.Delta,
+		ID:    m.ID,
+		MType: m.MType,
+	}
+}
+
+func (h *Hasher) checkHash(reqHash, key string, sig utils.Signature, r *http.Request) (bool, error) {
 	var body []byte
 
 	body, err := io.ReadAll(r.Body)
@@ -122,12 +364,12 @@ func (h *Hasher) checkHash(reqHash string, r *http.Request) (bool, error) {
 
 	r.Body = io.NopCloser(bytes.NewBuffer(body))
 
-	bodyHash, err := utils.GetHash(body, h.key)
+	bodyHash, err := utils.GetSignedHash(body, key, sig)
 	if err != nil {
 		return false, err
 	}
 
-	return strings.TrimSpace(bodyHash) == strings.TrimSpace(reqHash), nil
+	return utils.CheckHash(reqHash, bodyHash), nil
 }
 
 type hasherResponseWriter struct {
@@ -169,3 +411,7 @@ func (hr *hasherResponseWriter) WriteHeader(code int) {
 func (hr *hasherResponseWriter) Header() http.Header {
 	return hr.ResponseWriter.Header()
 }
+
+func (hr *hasherResponseWriter) Unwrap() http.ResponseWriter {
+	return hr.ResponseWriter
+}
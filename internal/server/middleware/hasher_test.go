@@ -6,6 +6,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/sotavant/yandex-metrics/internal"
@@ -31,8 +32,6 @@ func TestRequestHasherMiddleware(t *testing.T) {
 	key := "hashKey"
 	wrongKey := "wrongHashKey"
 	requestBody := `{"id":"ss","type":"gauge","value":3}`
-	hash, err := utils.GetHash([]byte(requestBody), key)
-	assert.NoError(t, err)
 	internal.InitLogger()
 
 	conf := config.Config{
@@ -54,17 +53,42 @@ func TestRequestHasherMiddleware(t *testing.T) {
 	tests := []struct {
 		name,
 		key string
+		timestamp  time.Time
+		noNonce    bool
+		replay     bool
 		wantStatus int
 	}{
 		{
-			"correctHash",
-			key,
-			http.StatusOK,
+			name:       "correctHash",
+			key:        key,
+			timestamp:  time.Now(),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "wrongHash",
+			key:        wrongKey,
+			timestamp:  time.Now(),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "without nonce",
+			key:        key,
+			timestamp:  time.Now(),
+			noNonce:    true,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "stale timestamp",
+			key:        key,
+			timestamp:  time.Now().Add(-DefaultSignatureSkew - time.Minute),
+			wantStatus: http.StatusBadRequest,
 		},
 		{
-			"wrongHash",
-			wrongKey,
-			http.StatusBadRequest,
+			name:       "replayed request",
+			key:        key,
+			timestamp:  time.Now(),
+			replay:     true,
+			wantStatus: http.StatusBadRequest,
 		},
 	}
 
@@ -76,17 +100,30 @@ func TestRequestHasherMiddleware(t *testing.T) {
 			r.Use(hasherMiddlware.Handler)
 			r.Post("/update/", handlers.UpdateJSONHandler(appInstance, metric.NewMetricService(st)))
 
-			w := httptest.NewRecorder()
+			sig := utils.Signature{Timestamp: tt.timestamp.Unix(), Nonce: tt.name}
+			hash, err := utils.GetSignedHash([]byte(requestBody), key, sig)
+			assert.NoError(t, err)
 
 			reqFunc := func() *http.Request {
 				req := httptest.NewRequest(http.MethodPost, "/update/", strings.NewReader(requestBody))
 				req.Header.Set(utils.HasherHeaderKey, hash)
+				req.Header.Set(utils.TimestampHeaderKey, sig.TimestampString())
+				if !tt.noNonce {
+					req.Header.Set(utils.NonceHeaderKey, sig.Nonce)
+				}
 				req.Header.Set("Accept", "application/json")
 				req.Header.Set("Content-Type", "application/json")
 
 				return req
 			}
 
+			if tt.replay {
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, reqFunc())
+				assert.Equal(t, http.StatusOK, w.Code)
+			}
+
+			w := httptest.NewRecorder()
 			r.ServeHTTP(w, reqFunc())
 			result := w.Result()
 			defer func() {
@@ -103,7 +140,12 @@ func TestResponseHasherMiddleware(t *testing.T) {
 	key := "hashKey"
 	requestBody := `{"value":3,"id":"ss","type":"gauge"}`
 	ctx := context.Background()
-	hash, err := utils.GetHash([]byte(requestBody), key)
+	sig, err := utils.NewSignature()
+	assert.NoError(t, err)
+	reqHash, err := utils.GetSignedHash([]byte(requestBody), key, sig)
+	assert.NoError(t, err)
+	// ответ совпадает с телом запроса, подписывается без метки времени вместе с переводом строки
+	hash, err := utils.GetHash([]byte(requestBody+"\n"), key)
 	assert.NoError(t, err)
 	internal.InitLogger()
 
@@ -150,7 +192,9 @@ func TestResponseHasherMiddleware(t *testing.T) {
 
 			reqFunc := func() *http.Request {
 				req := httptest.NewRequest(http.MethodPost, "/value/", strings.NewReader(requestBody))
-				req.Header.Set(utils.HasherHeaderKey, hash)
+				req.Header.Set(utils.HasherHeaderKey, reqHash)
+				req.Header.Set(utils.TimestampHeaderKey, sig.TimestampString())
+				req.Header.Set(utils.NonceHeaderKey, sig.Nonce)
 				req.Header.Set("Accept", "application/json")
 				req.Header.Set("Content-Type", "application/json")
 
@@ -193,7 +237,10 @@ func TestHasher_CheckHasInterceptor(t *testing.T) {
 		MType: m.MType,
 	}
 
-	mHash, err := utils.GetMetricHash(m, hashKey)
+	sig, err := utils.NewSignature()
+	assert.NoError(t, err)
+
+	mHash, err := utils.GetMetricHash(m, hashKey, sig)
 	assert.NoError(t, err)
 
 	tests := []struct {
@@ -212,7 +259,7 @@ func TestHasher_CheckHasInterceptor(t *testing.T) {
 			"without hash",
 			hashKey,
 			"",
-			codes.InvalidArgument,
+			codes.Unauthenticated,
 		},
 		{
 			"wrong hash",
@@ -226,11 +273,20 @@ func TestHasher_CheckHasInterceptor(t *testing.T) {
 			mHash,
 			codes.OK,
 		},
+		{
+			"replayed hash",
+			hashKey,
+			mHash,
+			codes.InvalidArgument,
+		},
 	}
 
+	// общий guard: последний запрос повторяет nonce предыдущего
+	guard := NewReplayGuard(DefaultSignatureSkew, DefaultNonceCacheSize)
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			hashMiddleware := NewHasher(tt.key)
+			hashMiddleware := NewHasher(tt.key).WithReplayGuard(guard)
 
 			lis = bufconn.Listen(bufSize)
 			s := grpc.NewServer(grpc.UnaryInterceptor(hashMiddleware.CheckHashInterceptor))
@@ -248,7 +304,11 @@ func TestHasher_CheckHasInterceptor(t *testing.T) {
 			}(conn)
 
 			if tt.key != "" && tt.reqHash != "" {
-				md := metadata.Pairs(utils.HasherHeaderKey, tt.reqHash)
+				md := metadata.Pairs(
+					utils.HasherHeaderKey, tt.reqHash,
+					utils.TimestampHeaderKey, sig.TimestampString(),
+					utils.NonceHeaderKey, sig.Nonce,
+				)
 				ctx = metadata.NewOutgoingContext(context.Background(), md)
 			} else {
 				ctx = context.Background()
@@ -264,3 +324,106 @@ func TestHasher_CheckHasInterceptor(t *testing.T) {
 		})
 	}
 }
+
+func TestHasher_AgentKeysAndStrict(t *testing.T) {
+	internal.InitLogger()
+	const sharedKey = "shared"
+	agentKeys := map[string]string{"agent-1": "secret-1", "agent-2": "secret-2"}
+	requestBody := `{"id":"ss","type":"gauge","value":3}`
+
+	tests := []struct {
+		name       string
+		method     string
+		agentID    string
+		key        string
+		strict     bool
+		wantStatus int
+	}{
+		{
+			name:       "agent key",
+			method:     http.MethodPost,
+			agentID:    "agent-1",
+			key:        "secret-1",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "key of another agent",
+			method:     http.MethodPost,
+			agentID:    "agent-1",
+			key:        "secret-2",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "shared key for agent from table",
+			method:     http.MethodPost,
+			agentID:    "agent-2",
+			key:        sharedKey,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unknown agent",
+			method:     http.MethodPost,
+			agentID:    "agent-3",
+			key:        sharedKey,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "shared key without agent id",
+			method:     http.MethodPost,
+			key:        sharedKey,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "agent key without agent id",
+			method:     http.MethodPost,
+			key:        "secret-1",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "unsigned",
+			method:     http.MethodPost,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "unsigned in strict mode",
+			method:     http.MethodPost,
+			strict:     true,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "unsigned read in strict mode",
+			method:     http.MethodGet,
+			strict:     true,
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hasher := NewHasher(sharedKey).WithAgentKeys(agentKeys).WithStrict(tt.strict)
+			handler := hasher.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest(tt.method, "/update/", strings.NewReader(requestBody))
+			if tt.agentID != "" {
+				req.Header.Set(utils.AgentIDHeaderKey, tt.agentID)
+			}
+
+			if tt.key != "" {
+				sig, err := utils.NewSignature()
+				assert.NoError(t, err)
+				hash, err := utils.GetSignedHash([]byte(requestBody), tt.key, sig)
+				assert.NoError(t, err)
+
+				req.Header.Set(utils.HasherHeaderKey, hash)
+				req.Header.Set(utils.TimestampHeaderKey, sig.TimestampString())
+				req.Header.Set(utils.NonceHeaderKey, sig.Nonce)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			assert.Equal(t, tt.wantStatus, w.Code)
+		})
+	}
+}
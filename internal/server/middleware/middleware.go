@@ -25,6 +25,7 @@ func getTypesForEncoding() [3]string {
 //	Данные:
 //	uri
 //	method
+//	agent (из сертификата клиента)
 //	duration
 //	status
 //	size
@@ -45,11 +46,13 @@ func WithLogging(h http.Handler) http.Handler {
 		h.ServeHTTP(&lw, r)
 
 		duration := time.Since(start)
+		agentID, _ := server.AgentID(r.Context())
 
 		internal.Logger.Infow(
 			"Request info",
 			"uri", uri,
 			"method", method,
+			"agent", agentID,
 			"duration", duration,
 		)
 
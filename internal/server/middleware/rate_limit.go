@@ -0,0 +1,316 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/server/auth"
+	pb "github.com/sotavant/yandex-metrics/proto"
+	pbv2 "github.com/sotavant/yandex-metrics/proto/v2"
+	"golang.org/x/time/rate"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+const (
+	// DefaultRateLimitIdle через сколько без запросов ведра агента удаляются
+	DefaultRateLimitIdle = 10 * time.Minute
+	// MaxBatchBodySize наибольший размер распакованного пакета /updates/, как ограничение сообщения в gRPC
+	MaxBatchBodySize = 4 << 20
+)
+
+// RateLimiter ограничивает запросы и метрики в секунду для каждого агента (token bucket).
+// Агент определяется так же, как в AgentKeys, ключ по принятому токену берется из контекста,
+// поэтому лимиты стоят после TokenAuth (в HTTP - после TokenAuth.Identify).
+// Ёмкость ведра - лимит за одну секунду, но не меньше 1.
+type RateLimiter struct {
+	requestsPerSec float64
+	metricsPerSec  float64
+	// keys определяет агента, если ключ не установлен раньше в цепочке
+	keys *AgentKeys
+	idle time.Duration
+
+	mu        sync.Mutex
+	agents    map[string]*agentLimits
+	lastSweep time.Time
+}
+
+type agentLimits struct {
+	requests *rate.Limiter
+	metrics  *rate.Limiter
+	lastSeen time.Time
+}
+
+// NewRateLimiter лимиты запросов и метрик в секунду, 0 - без ограничения
+func NewRateLimiter(requestsPerSec, metricsPerSec float64) *RateLimiter {
+	return &RateLimiter{
+		requestsPerSec: requestsPerSec,
+		metricsPerSec:  metricsPerSec,
+		keys:           NewAgentKeys(),
+		idle:           DefaultRateLimitIdle,
+		agents:         make(map[string]*agentLimits),
+	}
+}
+
+// WithTrustedProxies прокси, от которых принимается адрес клиента, как в IPChecker
+func (l *RateLimiter) WithTrustedProxies(proxies string) *RateLimiter {
+	l.keys.WithTrustedProxies(proxies)
+	return l
+}
+
+// RequestHandler middleware для HTTP, считает только запросы. Стоит в конвейере безопасности
+// до расшифровки и проверки подписи, чтобы запросы сверх лимита отклонялись без этой работы.
+func (l *RateLimiter) RequestHandler(next http.Handler) http.Handler {
+	if l.requestsPerSec <= 0 {
+		return next
+	}
+
+	f := func(w http.ResponseWriter, r *http.Request) {
+		if wait := l.reserve(l.keys.httpKey(r), 0, time.Now()); wait > 0 {
+			tooManyRequests(w, wait)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+
+	return http.HandlerFunc(f)
+}
+
+// MetricsHandler middleware для HTTP, считает метрики запросов на запись. Должен стоять после распаковки тела:
+// метрики пакета /updates/ считаются по JSON, тело больше MaxBatchBodySize отклоняется.
+func (l *RateLimiter) MetricsHandler(next http.Handler) http.Handler {
+	if l.metricsPerSec <= 0 {
+		return next
+	}
+
+	f := func(w http.ResponseWriter, r *http.Request) {
+		metrics, err := countHTTPMetrics(w, r)
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+
+		if wait := l.reserveMetrics(l.keys.httpKey(r), metrics, time.Now()); wait > 0 {
+			tooManyRequests(w, wait)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+
+	return http.HandlerFunc(f)
+}
+
+// Interceptor перехватчик для унарных gRPC-методов
+func (l *RateLimiter) Interceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if wait := l.reserve(l.keys.grpcKey(ctx), countGRPCMetrics(info.FullMethod, req), time.Now()); wait > 0 {
+		return nil, resourceExhausted(wait)
+	}
+
+	return handler(ctx, req)
+}
+
+// StreamInterceptor перехватчик для потоковых gRPC-методов: открытие потока считается запросом,
+// каждое полученное сообщение с метрикой - метрикой
+func (l *RateLimiter) StreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	key := l.keys.grpcKey(ss.Context())
+	if wait := l.reserve(key, 0, time.Now()); wait > 0 {
+		return resourceExhausted(wait)
+	}
+
+	return handler(srv, &rateLimitedStream{ServerStream: ss, l: l, key: key})
+}
+
+// Stage ограничение для gRPC и ограничение запросов для HTTP. В HTTP метрики считаются по распакованному телу,
+// поэтому MetricsHandler подключается к маршрутизатору после GzipMiddleware.
+func (l *RateLimiter) Stage() Stage {
+	if !l.enabled() {
+		return Stage{}
+	}
+
+	return Stage{HTTP: l.RequestHandler, Unary: l.Interceptor, Stream: l.StreamInterceptor}
+}
+
+type rateLimitedStream struct {
+	grpc.ServerStream
+	l   *RateLimiter
+	key string
+}
+
+func (s *rateLimitedStream) RecvMsg(msg interface{}) error {
+	if err := s.ServerStream.RecvMsg(msg); err != nil {
+		return err
+	}
+
+	switch msg.(type) {
+	case *pb.Metric, *pbv2.Metric:
+		if wait := s.l.reserveMetrics(s.key, 1, time.Now()); wait > 0 {
+			return resourceExhausted(wait)
+		}
+	}
+
+	return nil
+}
+
+func (l *RateLimiter) enabled() bool {
+	return l.requestsPerSec > 0 || l.metricsPerSec > 0
+}
+
+// reserve расходует один запрос и metrics метрик. Если лимит превышен, ничего не расходуется
+// и возвращается время, через которое запрос пройдет.
+func (l *RateLimiter) reserve(key string, metrics int, now time.Time) time.Duration {
+	limits := l.limits(key, now)
+
+	requests := limits.requests.ReserveN(now, 1)
+	if wait := requests.DelayFrom(now); wait > 0 {
+		requests.CancelAt(now)
+		return wait
+	}
+
+	if metrics == 0 {
+		return 0
+	}
+
+	if wait := reserveN(limits.metrics, metrics, now); wait > 0 {
+		requests.CancelAt(now)
+		return wait
+	}
+
+	return 0
+}
+
+func (l *RateLimiter) reserveMetrics(key string, metrics int, now time.Time) time.Duration {
+	return reserveN(l.limits(key, now).metrics, metrics, now)
+}
+
+// reserveN пакет больше ёмкости ведра расходует его целиком, иначе такой пакет не прошел бы никогда
+func reserveN(limiter *rate.Limiter, n int, now time.Time) time.Duration {
+	if burst := limiter.Burst(); n > burst {
+		n = burst
+	}
+
+	r := limiter.ReserveN(now, n)
+	if wait := r.DelayFrom(now); wait > 0 {
+		r.CancelAt(now)
+		return wait
+	}
+
+	return 0
+}
+
+// limits ведра агента, заодно удаляет ведра агентов, давно не присылавших запросы
+func (l *RateLimiter) limits(key string, now time.Time) *agentLimits {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > l.idle {
+		for k, a := range l.agents {
+			if now.Sub(a.lastSeen) > l.idle {
+				delete(l.agents, k)
+			}
+		}
+
+		l.lastSweep = now
+	}
+
+	a, ok := l.agents[key]
+	if !ok {
+		a = &agentLimits{requests: newBucket(l.requestsPerSec), metrics: newBucket(l.metricsPerSec)}
+		l.agents[key] = a
+	}
+
+	a.lastSeen = now
+	return a
+}
+
+func newBucket(perSec float64) *rate.Limiter {
+	if perSec <= 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+
+	return rate.NewLimiter(rate.Limit(perSec), int(math.Max(1, math.Ceil(perSec))))
+}
+
+// countHTTPMetrics число метрик в запросе на запись: в пакете /updates/ - длина массива, в остальных - одна
+func countHTTPMetrics(w http.ResponseWriter, r *http.Request) (int, error) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.URL.Path, "/update") {
+		return 0, nil
+	}
+
+	if r.URL.Path != "/updates/" {
+		return 1, nil
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxBatchBodySize))
+	if err != nil {
+		return 0, err
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var batch []json.RawMessage
+	if err = json.Unmarshal(body, &batch); err != nil {
+		// некорректное тело отклонит обработчик, для лимита это одна метрика
+		return 1, nil
+	}
+
+	return len(batch), nil
+}
+
+// countGRPCMetrics число метрик в вызове gRPC-метода на запись
+func countGRPCMetrics(method string, req interface{}) int {
+	if methodScopes[method] != auth.ScopeWrite {
+		return 0
+	}
+
+	switch r := req.(type) {
+	case *pb.MetricsBatch:
+		return len(r.Metrics)
+	case *pbv2.MetricsBatch:
+		return len(r.Metrics)
+	default:
+		return 1
+	}
+}
+
+func resourceExhausted(wait time.Duration) error {
+	st := status.New(codes.ResourceExhausted, "rate limit exceeded")
+	withInfo, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(wait)})
+	if err != nil {
+		internal.Logger.Infow("retry info error", "err", err)
+		return st.Err()
+	}
+
+	return withInfo.Err()
+}
+
+// tooManyRequests ответ 429 с Retry-After
+func tooManyRequests(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", retryAfterSeconds(wait))
+	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+}
+
+// retryAfterSeconds значение Retry-After в целых секундах, не меньше 1
+func retryAfterSeconds(wait time.Duration) string {
+	return strconv.Itoa(int(math.Max(1, math.Ceil(wait.Seconds()))))
+}
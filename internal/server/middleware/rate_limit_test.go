@@ -0,0 +1,240 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/pbconv"
+	"github.com/sotavant/yandex-metrics/internal/server/auth"
+	grpc2 "github.com/sotavant/yandex-metrics/internal/server/grpc"
+	"github.com/sotavant/yandex-metrics/internal/server/metric"
+	"github.com/sotavant/yandex-metrics/internal/server/repository/memory"
+	pbv2 "github.com/sotavant/yandex-metrics/proto/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestRateLimiter_Handler(t *testing.T) {
+	internal.InitLogger()
+
+	tokens := auth.StaticTokens{}
+	tokens.Add("agent-1", auth.ScopeWrite)
+	tokens.Add("agent-2", auth.ScopeWrite)
+
+	type request struct {
+		path  string
+		body  string
+		token string
+	}
+
+	tests := []struct {
+		name         string
+		requests     float64
+		metrics      float64
+		sent         []request
+		wantStatuses []int
+	}{
+		{
+			name:         "requests per second",
+			requests:     1,
+			sent:         []request{{path: "/value/"}, {path: "/value/"}},
+			wantStatuses: []int{http.StatusOK, http.StatusTooManyRequests},
+		},
+		{
+			name:     "agents are limited separately",
+			requests: 1,
+			sent: []request{
+				{path: "/value/", token: "agent-1"},
+				{path: "/value/", token: "agent-2"},
+				{path: "/value/", token: "agent-1"},
+			},
+			wantStatuses: []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests},
+		},
+		{
+			name:     "unknown tokens share ip bucket",
+			requests: 1,
+			sent: []request{
+				{path: "/value/", token: "bogus-1"},
+				{path: "/value/", token: "bogus-2"},
+			},
+			wantStatuses: []int{http.StatusOK, http.StatusTooManyRequests},
+		},
+		{
+			name:    "metrics per second",
+			metrics: 3,
+			sent: []request{
+				{path: "/updates/", body: `[{"id":"a"},{"id":"b"}]`},
+				{path: "/updates/", body: `[{"id":"a"},{"id":"b"}]`},
+				{path: "/update/", body: `{"id":"a"}`},
+				{path: "/value/"},
+			},
+			wantStatuses: []int{http.StatusOK, http.StatusTooManyRequests, http.StatusOK, http.StatusOK},
+		},
+		{
+			name:    "batch bigger than bucket",
+			metrics: 2,
+			sent: []request{
+				{path: "/updates/", body: `[{"id":"a"},{"id":"b"},{"id":"c"}]`},
+				{path: "/update/", body: `{"id":"a"}`},
+			},
+			wantStatuses: []int{http.StatusOK, http.StatusTooManyRequests},
+		},
+		{
+			name:         "disabled",
+			sent:         []request{{path: "/value/"}, {path: "/value/"}},
+			wantStatuses: []int{http.StatusOK, http.StatusOK},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := NewRateLimiter(tt.requests, tt.metrics)
+			p := NewPipeline(NewTokenAuth(tokens).Stage(), limiter.Stage())
+			handler := p.Handler(limiter.MetricsHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// тело пакета после подсчета метрик доступно обработчику
+				body, err := io.ReadAll(r.Body)
+				assert.NoError(t, err)
+				assert.NotNil(t, body)
+				w.WriteHeader(http.StatusOK)
+			})))
+
+			for i, sent := range tt.sent {
+				req := httptest.NewRequest(http.MethodPost, sent.path, strings.NewReader(sent.body))
+				if sent.token != "" {
+					req.Header.Set("Authorization", "Bearer "+sent.token)
+				}
+
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, req)
+
+				assert.Equal(t, tt.wantStatuses[i], w.Code, "request %d", i)
+				if w.Code == http.StatusTooManyRequests {
+					assert.Equal(t, "1", w.Header().Get("Retry-After"))
+				}
+			}
+		})
+	}
+}
+
+// TestRateLimiter_BeforeDecryption запрос сверх лимита не доходит до следующих шагов конвейера
+func TestRateLimiter_BeforeDecryption(t *testing.T) {
+	internal.InitLogger()
+
+	decrypted := 0
+	decrypt := Stage{HTTP: func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			decrypted++
+			next.ServeHTTP(w, r)
+		})
+	}}
+
+	handler := NewPipeline(NewRateLimiter(1, 0).Stage(), decrypt).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	statuses := make([]int, 0, 2)
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/update/", nil))
+		statuses = append(statuses, w.Code)
+	}
+
+	assert.Equal(t, []int{http.StatusOK, http.StatusTooManyRequests}, statuses)
+	assert.Equal(t, 1, decrypted)
+}
+
+func TestRateLimiter_BatchTooLarge(t *testing.T) {
+	internal.InitLogger()
+
+	handler := NewRateLimiter(0, 1000).MetricsHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	body := "[" + strings.Repeat(`{"id":"a"},`, MaxBatchBodySize/10) + `{"id":"a"}]`
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(body)))
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
+
+func TestRateLimiter_GRPC(t *testing.T) {
+	internal.InitLogger()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	tokens := auth.StaticTokens{}
+	tokens.Add("unary", auth.ScopeRead, auth.ScopeWrite)
+	tokens.Add("stream", auth.ScopeRead, auth.ScopeWrite)
+
+	p := NewPipeline(NewTokenAuth(tokens).Stage(), NewRateLimiter(0, 3).Stage())
+	s := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(p.UnaryInterceptors()...),
+		grpc.ChainStreamInterceptor(p.StreamInterceptors()...),
+	)
+	pbv2.RegisterMetricsServer(s, grpc2.NewMetricServerV2(metric.NewMetricService(memory.NewMetricsRepository())))
+	go func() {
+		assert.NoError(t, s.Serve(lis))
+	}()
+	defer s.Stop()
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, conn.Close())
+	}()
+	client := pbv2.NewMetricsClient(conn)
+
+	val := 1.5
+	m := pbconv.MetricToV2(internal.Metrics{ID: "gauge", MType: internal.GaugeType, Value: &val})
+	batch := &pbv2.MetricsBatch{Metrics: []*pbv2.Metric{m, m}}
+
+	agentCtx := func(token string) context.Context {
+		return metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+	}
+
+	t.Run("unary batch", func(t *testing.T) {
+		ctx := agentCtx("unary")
+		_, err = client.UpdateMetricsBatch(ctx, batch)
+		require.NoError(t, err)
+
+		_, err = client.UpdateMetricsBatch(ctx, batch)
+		require.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+		var info *errdetails.RetryInfo
+		for _, detail := range status.Convert(err).Details() {
+			info, _ = detail.(*errdetails.RetryInfo)
+		}
+		require.NotNil(t, info)
+		assert.Positive(t, info.RetryDelay.AsDuration())
+
+		// чтение метрики не расходует лимит метрик
+		_, err = client.GetMetric(ctx, &pbv2.MetricKey{Id: "gauge", Type: internal.GaugeType})
+		assert.NoError(t, err)
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		stream, err := client.UpdateMetrics(agentCtx("stream"))
+		require.NoError(t, err)
+
+		for i := 0; i < 5; i++ {
+			if err = stream.Send(m); err != nil {
+				break
+			}
+		}
+
+		_, err = stream.CloseAndRecv()
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	})
+}
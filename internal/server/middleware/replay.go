@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sotavant/yandex-metrics/internal/utils"
+)
+
+// Параметры защиты от повтора по-умолчанию
+const (
+	DefaultSignatureSkew  = 5 * time.Minute
+	DefaultNonceCacheSize = 100000
+)
+
+var (
+	ErrStaleRequest    = errors.New("request timestamp is outside of allowed window")
+	ErrReplayedRequest = errors.New("request nonce has already been used")
+)
+
+// ReplayGuard отклоняет подписанные запросы с меткой времени вне окна skew и повторные nonce.
+// Nonce хранятся, пока их метка времени не выйдет из окна, но не более size штук:
+// при переполнении вытесняются самые старые.
+type ReplayGuard struct {
+	skew time.Duration
+	size int
+
+	mu    sync.Mutex
+	seen  map[string]time.Time
+	order *list.List
+	now   func() time.Time
+}
+
+func NewReplayGuard(skew time.Duration, size int) *ReplayGuard {
+	return &ReplayGuard{
+		skew:  skew,
+		size:  size,
+		seen:  make(map[string]time.Time),
+		order: list.New(),
+		now:   time.Now,
+	}
+}
+
+// Check проверяет метку времени и запоминает nonce.
+// Вызывается после проверки подписи, чтобы неподписанные запросы не заполняли кеш.
+func (g *ReplayGuard) Check(s utils.Signature) error {
+	now := g.now()
+	ts := time.Unix(s.Timestamp, 0)
+	if ts.Before(now.Add(-g.skew)) || ts.After(now.Add(g.skew)) {
+		return ErrStaleRequest
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.evictExpired(now)
+
+	if _, ok := g.seen[s.Nonce]; ok {
+		return ErrReplayedRequest
+	}
+
+	if g.order.Len() >= g.size {
+		g.remove(g.order.Front())
+	}
+
+	g.seen[s.Nonce] = ts
+	g.order.PushBack(s.Nonce)
+
+	return nil
+}
+
+// evictExpired удаляет nonce, метка времени которых вышла из окна. Nonce хранятся в порядке
+// поступления, поэтому просроченный nonce за непросроченным дождется следующего вызова.
+func (g *ReplayGuard) evictExpired(now time.Time) {
+	for e := g.order.Front(); e != nil; e = g.order.Front() {
+		if !g.seen[e.Value.(string)].Before(now.Add(-g.skew)) {
+			return
+		}
+
+		g.remove(e)
+	}
+}
+
+func (g *ReplayGuard) remove(e *list.Element) {
+	delete(g.seen, e.Value.(string))
+	g.order.Remove(e)
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sotavant/yandex-metrics/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplayGuard_Check(t *testing.T) {
+	now := time.Now()
+	sig := func(ts time.Time, nonce string) utils.Signature {
+		return utils.Signature{Timestamp: ts.Unix(), Nonce: nonce}
+	}
+
+	tests := []struct {
+		name string
+		sigs []utils.Signature
+		// shift сдвиг часов сервера перед последней проверкой
+		shift   time.Duration
+		wantErr error
+	}{
+		{
+			name: "fresh nonce",
+			sigs: []utils.Signature{sig(now, "a")},
+		},
+		{
+			name:    "replayed nonce",
+			sigs:    []utils.Signature{sig(now, "a"), sig(now, "a")},
+			wantErr: ErrReplayedRequest,
+		},
+		{
+			name:    "timestamp too old",
+			sigs:    []utils.Signature{sig(now.Add(-2*time.Minute), "a")},
+			wantErr: ErrStaleRequest,
+		},
+		{
+			name:    "timestamp in future",
+			sigs:    []utils.Signature{sig(now.Add(2*time.Minute), "a")},
+			wantErr: ErrStaleRequest,
+		},
+		{
+			name:    "nonce of expired request",
+			sigs:    []utils.Signature{sig(now, "a"), sig(now, "a")},
+			shift:   2 * time.Minute,
+			wantErr: ErrStaleRequest,
+		},
+		{
+			// при переполнении вытесняется самый старый nonce
+			name: "evicted nonce",
+			sigs: []utils.Signature{sig(now, "a"), sig(now, "b"), sig(now, "c"), sig(now, "a")},
+		},
+		{
+			name:    "kept nonce",
+			sigs:    []utils.Signature{sig(now, "a"), sig(now, "b"), sig(now, "c"), sig(now, "c")},
+			wantErr: ErrReplayedRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewReplayGuard(time.Minute, 2)
+			clock := now
+			g.now = func() time.Time { return clock }
+
+			last := len(tt.sigs) - 1
+			for _, s := range tt.sigs[:last] {
+				assert.NoError(t, g.Check(s))
+			}
+
+			clock = clock.Add(tt.shift)
+			assert.ErrorIs(t, g.Check(tt.sigs[last]), tt.wantErr)
+			assert.LessOrEqual(t, len(g.seen), 2)
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"net/http"
+
+	"google.golang.org/grpc"
+)
+
+// Stage шаг проверки безопасности в виде HTTP middleware и gRPC-перехватчиков.
+// Пустое поле означает, что шаг для этого транспорта не нужен.
+type Stage struct {
+	HTTP   func(next http.Handler) http.Handler
+	Unary  grpc.UnaryServerInterceptor
+	Stream grpc.StreamServerInterceptor
+}
+
+// Pipeline конвейер безопасности: шаги описываются один раз и применяются к HTTP и gRPC
+// в порядке добавления.
+type Pipeline struct {
+	stages []Stage
+}
+
+func NewPipeline(stages ...Stage) *Pipeline {
+	return &Pipeline{stages: stages}
+}
+
+// Handler применяет HTTP-части шагов, первый шаг выполняется первым
+func (p *Pipeline) Handler(next http.Handler) http.Handler {
+	for i := len(p.stages) - 1; i >= 0; i-- {
+		if p.stages[i].HTTP != nil {
+			next = p.stages[i].HTTP(next)
+		}
+	}
+
+	return next
+}
+
+// UnaryInterceptors перехватчики шагов для grpc.ChainUnaryInterceptor
+func (p *Pipeline) UnaryInterceptors() []grpc.UnaryServerInterceptor {
+	var res []grpc.UnaryServerInterceptor
+	for _, s := range p.stages {
+		if s.Unary != nil {
+			res = append(res, s.Unary)
+		}
+	}
+
+	return res
+}
+
+// StreamInterceptors перехватчики шагов для grpc.ChainStreamInterceptor
+func (p *Pipeline) StreamInterceptors() []grpc.StreamServerInterceptor {
+	var res []grpc.StreamServerInterceptor
+	for _, s := range p.stages {
+		if s.Stream != nil {
+			res = append(res, s.Stream)
+		}
+	}
+
+	return res
+}
+
+// Stage проверка IP-адреса клиента, без доверенных и запрещенных подсетей шаг пустой
+func (ip *IPChecker) Stage() Stage {
+	if ip == nil || !ip.enabled() {
+		return Stage{}
+	}
+
+	return Stage{HTTP: ip.CheckIP, Unary: ip.CheckIPInterceptor, Stream: ip.CheckIPStreamInterceptor}
+}
+
+// Stage расшифровка тела HTTP-запроса. gRPC защищается TLS, поэтому перехватчиков нет.
+func (h *Crypto) Stage() Stage {
+	return Stage{HTTP: h.Handler}
+}
+
+// Stage проверка подписи, метки времени и nonce
+func (h *Hasher) Stage() Stage {
+	return Stage{HTTP: h.Handler, Unary: h.CheckHashInterceptor, Stream: h.CheckHashStreamInterceptor}
+}
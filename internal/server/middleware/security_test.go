@@ -0,0 +1,202 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/pbconv"
+	grpc2 "github.com/sotavant/yandex-metrics/internal/server/grpc"
+	"github.com/sotavant/yandex-metrics/internal/server/metric"
+	"github.com/sotavant/yandex-metrics/internal/server/repository/memory"
+	"github.com/sotavant/yandex-metrics/internal/utils"
+	pbv2 "github.com/sotavant/yandex-metrics/proto/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// TestPipeline один набор случаев проверяется через HTTP и через gRPC
+func TestPipeline(t *testing.T) {
+	internal.InitLogger()
+	const hashKey = "hashKey"
+
+	val := 1.5
+	m := internal.Metrics{ID: "gauge", MType: internal.GaugeType, Value: &val}
+	body := `{"id":"gauge","type":"gauge","value":1.5}`
+
+	tests := []struct {
+		name    string
+		ip      string
+		agentID string
+		// key ключ подписи, пустой - запрос без подписи
+		key string
+		// replay запрос отправляется дважды, проверяется второй
+		replay   bool
+		wantHTTP int
+		wantGRPC codes.Code
+	}{
+		{
+			name:     "trusted ip and signature",
+			ip:       "192.168.1.10",
+			agentID:  "agent-1",
+			key:      "secret-1",
+			wantHTTP: http.StatusOK,
+			wantGRPC: codes.OK,
+		},
+		{
+			name:     "shared key without agent id",
+			ip:       "192.168.1.10",
+			key:      hashKey,
+			wantHTTP: http.StatusUnauthorized,
+			wantGRPC: codes.Unauthenticated,
+		},
+		{
+			name:     "untrusted ip",
+			ip:       "10.0.0.1",
+			key:      hashKey,
+			wantHTTP: http.StatusForbidden,
+			wantGRPC: codes.Unauthenticated,
+		},
+		{
+			name:     "without ip",
+			key:      hashKey,
+			wantHTTP: http.StatusForbidden,
+			wantGRPC: codes.Unauthenticated,
+		},
+		{
+			name:     "wrong key",
+			ip:       "192.168.1.10",
+			agentID:  "agent-1",
+			key:      "wrong",
+			wantHTTP: http.StatusBadRequest,
+			wantGRPC: codes.InvalidArgument,
+		},
+		{
+			name:     "unknown agent",
+			ip:       "192.168.1.10",
+			agentID:  "agent-2",
+			key:      hashKey,
+			wantHTTP: http.StatusUnauthorized,
+			wantGRPC: codes.Unauthenticated,
+		},
+		{
+			name:     "replayed request",
+			ip:       "192.168.1.10",
+			agentID:  "agent-1",
+			key:      "secret-1",
+			replay:   true,
+			wantHTTP: http.StatusBadRequest,
+			wantGRPC: codes.InvalidArgument,
+		},
+		{
+			name:     "unsigned",
+			ip:       "192.168.1.10",
+			wantHTTP: http.StatusUnauthorized,
+			wantGRPC: codes.Unauthenticated,
+		},
+	}
+
+	newPipeline := func() *Pipeline {
+		hasher := NewHasher(hashKey).WithAgentKeys(map[string]string{"agent-1": "secret-1"}).WithStrict(true)
+		// запросы приходят с 127.0.0.1, адрес клиента передается в X-Real-IP как через прокси
+		ipChecker := NewIPChecker("192.168.1.0/24").WithTrustedProxies("127.0.0.1")
+		return NewPipeline(ipChecker.Stage(), hasher.Stage())
+	}
+
+	for _, tt := range tests {
+		sig, err := utils.NewSignature()
+		require.NoError(t, err)
+
+		headers := map[string]string{}
+		if tt.ip != "" {
+			headers["X-Real-IP"] = tt.ip
+		}
+
+		if tt.agentID != "" {
+			headers[utils.AgentIDHeaderKey] = tt.agentID
+		}
+
+		if tt.key != "" {
+			headers[utils.TimestampHeaderKey] = sig.TimestampString()
+			headers[utils.NonceHeaderKey] = sig.Nonce
+		}
+
+		t.Run("http "+tt.name, func(t *testing.T) {
+			handler := newPipeline().Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			send := func() int {
+				req := httptest.NewRequest(http.MethodPost, "/update/", strings.NewReader(body))
+				req.RemoteAddr = "127.0.0.1:4567"
+				for k, v := range headers {
+					req.Header.Set(k, v)
+				}
+
+				if tt.key != "" {
+					hash, err := utils.GetSignedHash([]byte(body), tt.key, sig)
+					require.NoError(t, err)
+					req.Header.Set(utils.HasherHeaderKey, hash)
+				}
+
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, req)
+				return w.Code
+			}
+
+			if tt.replay {
+				require.Equal(t, http.StatusOK, send())
+			}
+
+			assert.Equal(t, tt.wantHTTP, send())
+		})
+
+		t.Run("grpc "+tt.name, func(t *testing.T) {
+			p := newPipeline()
+			lis, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			s := grpc.NewServer(
+				grpc.ChainUnaryInterceptor(p.UnaryInterceptors()...),
+				grpc.ChainStreamInterceptor(p.StreamInterceptors()...),
+			)
+			pbv2.RegisterMetricsServer(s, grpc2.NewMetricServerV2(metric.NewMetricService(memory.NewMetricsRepository())))
+			go func() {
+				assert.NoError(t, s.Serve(lis))
+			}()
+			defer s.Stop()
+
+			conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+			require.NoError(t, err)
+			defer func() {
+				assert.NoError(t, conn.Close())
+			}()
+
+			md := metadata.New(headers)
+			if tt.key != "" {
+				hash, err := utils.GetMetricHash(m, tt.key, sig)
+				require.NoError(t, err)
+				md.Set(utils.HasherHeaderKey, hash)
+			}
+
+			ctx := metadata.NewOutgoingContext(context.Background(), md)
+			client := pbv2.NewMetricsClient(conn)
+
+			if tt.replay {
+				_, err = client.UpdateMetric(ctx, pbconv.MetricToV2(m))
+				require.NoError(t, err)
+			}
+
+			_, err = client.UpdateMetric(ctx, pbconv.MetricToV2(m))
+			assert.Equal(t, tt.wantGRPC, status.Code(err))
+		})
+	}
+}
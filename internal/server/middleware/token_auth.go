@@ -0,0 +1,219 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/server"
+	"github.com/sotavant/yandex-metrics/internal/server/auth"
+	pb "github.com/sotavant/yandex-metrics/proto"
+	pbv2 "github.com/sotavant/yandex-metrics/proto/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// APIKeyHeaderKey заголовок с токеном, альтернатива Authorization: Bearer
+const APIKeyHeaderKey = "X-API-Key"
+
+// methodScopes права, необходимые для gRPC-методов. Методы, которых нет в списке, недоступны по токену.
+var methodScopes = map[string]auth.Scope{
+	pb.Metrics_UpdateMetric_FullMethodName:         auth.ScopeWrite,
+	pb.Metrics_UpdateMetricTest_FullMethodName:     auth.ScopeWrite,
+	pb.Metrics_UpdateMetrics_FullMethodName:        auth.ScopeWrite,
+	pb.Metrics_UpdateMetricsBatch_FullMethodName:   auth.ScopeWrite,
+	pb.Metrics_GetMetric_FullMethodName:            auth.ScopeRead,
+	pb.Metrics_GetMetrics_FullMethodName:           auth.ScopeRead,
+	pb.Metrics_ListMetrics_FullMethodName:          auth.ScopeRead,
+	pb.Metrics_QueryRange_FullMethodName:           auth.ScopeRead,
+	pb.Metrics_Watch_FullMethodName:                auth.ScopeRead,
+	pbv2.Metrics_UpdateMetric_FullMethodName:       auth.ScopeWrite,
+	pbv2.Metrics_UpdateMetrics_FullMethodName:      auth.ScopeWrite,
+	pbv2.Metrics_UpdateMetricsBatch_FullMethodName: auth.ScopeWrite,
+	pbv2.Metrics_GetMetric_FullMethodName:          auth.ScopeRead,
+	pbv2.Metrics_GetMetrics_FullMethodName:         auth.ScopeRead,
+	pbv2.Metrics_ListMetrics_FullMethodName:        auth.ScopeRead,
+	pbv2.Metrics_QueryRange_FullMethodName:         auth.ScopeRead,
+	pbv2.Metrics_Watch_FullMethodName:              auth.ScopeRead,
+}
+
+var (
+	// errNoToken запрос без токена
+	errNoToken = errors.New("no token")
+	// errForbidden у токена нет нужного права
+	errForbidden = errors.New("insufficient scope")
+)
+
+// TokenAuth проверяет токен из Authorization: Bearer <token> или X-API-Key и его права.
+// Принятый токен становится ключом агента (token:<hash>), если агент не подтвержден сертификатом,
+// поэтому лимиты должны стоять после проверки токена. Без источника токенов проверка выключена.
+type TokenAuth struct {
+	tokens auth.Tokens
+}
+
+// checkedTokenKey ключ контекста с токеном, принятым в Identify
+type checkedTokenKey struct{}
+
+type checkedToken struct {
+	token  string
+	scopes []auth.Scope
+}
+
+func NewTokenAuth(tokens auth.Tokens) *TokenAuth {
+	return &TokenAuth{tokens: tokens}
+}
+
+// Require middleware для HTTP, пропускает запросы с токеном, у которого есть право scope.
+// Без токена - 401, без права - 403.
+func (a *TokenAuth) Require(scope auth.Scope) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if a.tokens == nil {
+			return next
+		}
+
+		f := func(w http.ResponseWriter, r *http.Request) {
+			token := requestToken(r.Header.Get("Authorization"), r.Header.Get(APIKeyHeaderKey))
+			err := a.check(r.Context(), token, scope)
+			switch {
+			case err == nil:
+				next.ServeHTTP(w, r.WithContext(withTokenKey(r.Context(), token)))
+			case errors.Is(err, errNoToken):
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			case errors.Is(err, auth.ErrUnknownToken), errors.Is(err, errForbidden):
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			default:
+				internal.Logger.Infow("token check error", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}
+		}
+
+		return http.HandlerFunc(f)
+	}
+}
+
+// Identify middleware для HTTP, ставится до лимитов запросов. Права в HTTP зависят от маршрута,
+// поэтому здесь токен только ищется: принятый токен становится ключом агента, а его права
+// запоминаются для Require. Запрос без токена или с неизвестным токеном проходит дальше с прежним ключом
+// (по IP) и отклоняется в Require.
+func (a *TokenAuth) Identify(next http.Handler) http.Handler {
+	if a.tokens == nil {
+		return next
+	}
+
+	f := func(w http.ResponseWriter, r *http.Request) {
+		token := requestToken(r.Header.Get("Authorization"), r.Header.Get(APIKeyHeaderKey))
+		if token == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		scopes, err := a.tokens.Scopes(r.Context(), token)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), checkedTokenKey{}, checkedToken{token: token, scopes: scopes})
+		next.ServeHTTP(w, r.WithContext(withTokenKey(ctx, token)))
+	}
+
+	return http.HandlerFunc(f)
+}
+
+// Interceptor перехватчик для унарных gRPC-методов
+func (a *TokenAuth) Interceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := a.checkGRPC(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+// StreamInterceptor перехватчик для потоковых gRPC-методов, токен проверяется при открытии потока
+func (a *TokenAuth) StreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := a.checkGRPC(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+
+	return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+}
+
+// Stage проверка токена для gRPC. В HTTP права зависят от маршрута, поэтому конвейер только определяет
+// агента по токену (Identify), а права проверяет Require в маршрутизаторе.
+func (a *TokenAuth) Stage() Stage {
+	if a.tokens == nil {
+		return Stage{}
+	}
+
+	return Stage{HTTP: a.Identify, Unary: a.Interceptor, Stream: a.StreamInterceptor}
+}
+
+// checkGRPC проверяет токен вызова и возвращает контекст с ключом агента по токену
+func (a *TokenAuth) checkGRPC(ctx context.Context, method string) (context.Context, error) {
+	scope, ok := methodScopes[method]
+	if !ok {
+		return ctx, status.Error(codes.PermissionDenied, "method is not allowed")
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	token := requestToken(firstMD(md, "authorization"), firstMD(md, strings.ToLower(APIKeyHeaderKey)))
+	err := a.check(ctx, token, scope)
+
+	switch {
+	case err == nil:
+		return withTokenKey(ctx, token), nil
+	case errors.Is(err, errNoToken), errors.Is(err, auth.ErrUnknownToken):
+		return ctx, status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, errForbidden):
+		return ctx, status.Error(codes.PermissionDenied, err.Error())
+	default:
+		internal.Logger.Infow("token check error", "err", err)
+		return ctx, status.Error(codes.Internal, "token check failed")
+	}
+}
+
+func (a *TokenAuth) check(ctx context.Context, token string, scope auth.Scope) error {
+	if token == "" {
+		return errNoToken
+	}
+
+	checked, ok := ctx.Value(checkedTokenKey{}).(checkedToken)
+	scopes := checked.scopes
+	if !ok || checked.token != token {
+		var err error
+		if scopes, err = a.tokens.Scopes(ctx, token); err != nil {
+			return err
+		}
+	}
+
+	if !auth.Allowed(scopes, scope) {
+		return errForbidden
+	}
+
+	return nil
+}
+
+// withTokenKey ключ агента по принятому токену. Агент, подтвержденный сертификатом, сохраняет свой ключ.
+func withTokenKey(ctx context.Context, token string) context.Context {
+	if _, ok := server.AgentID(ctx); ok {
+		return ctx
+	}
+
+	return server.WithAgentKey(ctx, "token:"+auth.HashToken(token))
+}
+
+// requestToken токен из заголовка Authorization: Bearer <token>, иначе из X-API-Key
+func requestToken(authorization, apiKey string) string {
+	if token, found := strings.CutPrefix(authorization, "Bearer "); found {
+		return token
+	}
+
+	return apiKey
+}
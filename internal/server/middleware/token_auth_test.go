@@ -0,0 +1,254 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/pbconv"
+	"github.com/sotavant/yandex-metrics/internal/server"
+	"github.com/sotavant/yandex-metrics/internal/server/auth"
+	grpc2 "github.com/sotavant/yandex-metrics/internal/server/grpc"
+	"github.com/sotavant/yandex-metrics/internal/server/metric"
+	"github.com/sotavant/yandex-metrics/internal/server/repository/memory"
+	pbv2 "github.com/sotavant/yandex-metrics/proto/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestTokenAuth(t *testing.T) {
+	internal.InitLogger()
+
+	tokens := auth.StaticTokens{}
+	tokens.Add("reader", auth.ScopeRead)
+	tokens.Add("writer", auth.ScopeWrite)
+	tokens.Add("admin", auth.ScopeAdmin)
+
+	tests := []struct {
+		name string
+		// header заголовок с токеном и его значение
+		header, value string
+		scope         auth.Scope
+		wantHTTP      int
+		wantGRPC      codes.Code
+	}{
+		{
+			name:     "write with write token",
+			header:   "Authorization",
+			value:    "Bearer writer",
+			scope:    auth.ScopeWrite,
+			wantHTTP: http.StatusOK,
+			wantGRPC: codes.OK,
+		},
+		{
+			name:     "write with api key",
+			header:   APIKeyHeaderKey,
+			value:    "writer",
+			scope:    auth.ScopeWrite,
+			wantHTTP: http.StatusOK,
+			wantGRPC: codes.OK,
+		},
+		{
+			name:     "write with admin token",
+			header:   "Authorization",
+			value:    "Bearer admin",
+			scope:    auth.ScopeWrite,
+			wantHTTP: http.StatusOK,
+			wantGRPC: codes.OK,
+		},
+		{
+			name:     "write with read token",
+			header:   "Authorization",
+			value:    "Bearer reader",
+			scope:    auth.ScopeWrite,
+			wantHTTP: http.StatusForbidden,
+			wantGRPC: codes.PermissionDenied,
+		},
+		{
+			name:     "read with write token",
+			header:   "Authorization",
+			value:    "Bearer writer",
+			scope:    auth.ScopeRead,
+			wantHTTP: http.StatusForbidden,
+			wantGRPC: codes.PermissionDenied,
+		},
+		{
+			name:     "read with read token",
+			header:   "Authorization",
+			value:    "Bearer reader",
+			scope:    auth.ScopeRead,
+			wantHTTP: http.StatusOK,
+			wantGRPC: codes.NotFound,
+		},
+		{
+			name:     "unknown token",
+			header:   "Authorization",
+			value:    "Bearer other",
+			scope:    auth.ScopeWrite,
+			wantHTTP: http.StatusForbidden,
+			wantGRPC: codes.Unauthenticated,
+		},
+		{
+			name:     "without token",
+			scope:    auth.ScopeWrite,
+			wantHTTP: http.StatusUnauthorized,
+			wantGRPC: codes.Unauthenticated,
+		},
+	}
+
+	a := NewTokenAuth(tokens)
+
+	lis := bufconn.Listen(bufSize)
+	s := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(a.Stage().Unary),
+		grpc.ChainStreamInterceptor(a.Stage().Stream),
+	)
+	pbv2.RegisterMetricsServer(s, grpc2.NewMetricServerV2(metric.NewMetricService(memory.NewMetricsRepository())))
+	go func() {
+		assert.NoError(t, s.Serve(lis))
+	}()
+	defer s.Stop()
+
+	conn, err := grpc.NewClient("passthrough://bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, conn.Close())
+	}()
+
+	client := pbv2.NewMetricsClient(conn)
+	val := 1.5
+	m := internal.Metrics{ID: "gauge", MType: internal.GaugeType, Value: &val}
+
+	for _, tt := range tests {
+		t.Run("http "+tt.name, func(t *testing.T) {
+			handler := a.Require(tt.scope)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/update/", nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			assert.Equal(t, tt.wantHTTP, w.Code)
+		})
+
+		t.Run("grpc "+tt.name, func(t *testing.T) {
+			md := metadata.MD{}
+			if tt.header != "" {
+				md.Set(tt.header, tt.value)
+			}
+			ctx := metadata.NewOutgoingContext(context.Background(), md)
+
+			if tt.scope == auth.ScopeWrite {
+				_, err = client.UpdateMetric(ctx, pbconv.MetricToV2(m))
+			} else {
+				_, err = client.GetMetric(ctx, &pbv2.MetricKey{Id: "unknown", Type: internal.GaugeType})
+			}
+
+			assert.Equal(t, tt.wantGRPC, status.Code(err))
+		})
+	}
+}
+
+func TestTokenAuth_Disabled(t *testing.T) {
+	a := NewTokenAuth(nil)
+	handler := a.Require(auth.ScopeWrite)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/update/", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, Stage{}, a.Stage())
+}
+
+func TestTokenAuth_AgentKey(t *testing.T) {
+	tokens := auth.StaticTokens{}
+	tokens.Add("writer", auth.ScopeWrite)
+
+	tests := []struct {
+		name    string
+		agentID string
+		token   string
+		want    string
+	}{
+		{
+			name:  "accepted token",
+			token: "writer",
+			want:  "token:" + auth.HashToken("writer"),
+		},
+		{
+			name:    "certificate wins",
+			agentID: "host-1",
+			token:   "writer",
+			want:    "agent:host-1",
+		},
+		{
+			name:  "unknown token",
+			token: "bogus",
+			want:  "ip:192.0.2.1",
+		},
+	}
+
+	a := NewTokenAuth(tokens)
+	keys := NewAgentKeys()
+	for _, tt := range tests {
+		t.Run("http "+tt.name, func(t *testing.T) {
+			var got string
+			handler := keys.Handler(a.Identify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, _ = server.AgentKey(r.Context())
+			})))
+
+			req := httptest.NewRequest(http.MethodPost, "/update/", nil)
+			if tt.agentID != "" {
+				req = req.WithContext(server.WithAgentID(req.Context(), tt.agentID))
+			}
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			assert.Equal(t, tt.want, got)
+		})
+
+		t.Run("grpc "+tt.name, func(t *testing.T) {
+			ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 4000}})
+			if tt.agentID != "" {
+				ctx = server.WithAgentID(ctx, tt.agentID)
+			}
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "Bearer "+tt.token))
+
+			var got string
+			info := &grpc.UnaryServerInfo{FullMethod: pbv2.Metrics_UpdateMetric_FullMethodName}
+			_, err := keys.Interceptor(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return a.Interceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+					got, _ = server.AgentKey(ctx)
+					return nil, nil
+				})
+			})
+
+			if tt.token == "bogus" {
+				// неизвестный токен в gRPC отклоняется до лимитов
+				assert.Equal(t, codes.Unauthenticated, status.Code(err))
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NameLabel служебная метка с именем метрики в результатах запроса
+const NameLabel = "__name__"
+
+// Selector выбор рядов по имени метрики и точному совпадению меток
+type Selector struct {
+	Name     string
+	Matchers map[string]string
+}
+
+// ParseSeriesID разбирает ID метрики вида name{k1=v1,k2="v2"} на имя и метки.
+// ID без фигурных скобок или с неверным списком меток считается именем без меток.
+func ParseSeriesID(id string) (string, map[string]string) {
+	name, labels, err := parseLabels(id)
+	if err != nil {
+		return id, map[string]string{}
+	}
+
+	return name, labels
+}
+
+// ParseSelector разбирает селектор в том же формате, что и ID метрики
+func ParseSelector(s string) (Selector, error) {
+	name, labels, err := parseLabels(strings.TrimSpace(s))
+	if err != nil {
+		return Selector{}, fmt.Errorf("%w: %s", ErrBadQuery, err)
+	}
+
+	if name == "" {
+		return Selector{}, fmt.Errorf("%w: metric name is empty", ErrBadQuery)
+	}
+
+	return Selector{Name: name, Matchers: labels}, nil
+}
+
+// Match проверяет, что ряд с данным ID подходит под селектор
+func (s Selector) Match(id string) (map[string]string, bool) {
+	name, labels := ParseSeriesID(id)
+	if name != s.Name {
+		return nil, false
+	}
+
+	for k, v := range s.Matchers {
+		if labels[k] != v {
+			return nil, false
+		}
+	}
+
+	return labels, true
+}
+
+func parseLabels(s string) (string, map[string]string, error) {
+	labels := make(map[string]string)
+
+	open := strings.IndexByte(s, '{')
+	if open < 0 {
+		return s, labels, nil
+	}
+
+	if !strings.HasSuffix(s, "}") {
+		return "", nil, fmt.Errorf("unclosed label list in %q", s)
+	}
+
+	body := strings.TrimSpace(s[open+1 : len(s)-1])
+	if body == "" {
+		return s[:open], labels, nil
+	}
+
+	for _, pair := range strings.Split(body, ",") {
+		k, v, found := strings.Cut(pair, "=")
+		k = strings.TrimSpace(k)
+		if !found || k == "" {
+			return "", nil, fmt.Errorf("bad label %q", pair)
+		}
+
+		labels[k] = strings.Trim(strings.TrimSpace(v), `"`)
+	}
+
+	return s[:open], labels, nil
+}
@@ -0,0 +1,56 @@
+package query
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSeriesID(t *testing.T) {
+	tests := []struct {
+		id         string
+		wantName   string
+		wantLabels map[string]string
+	}{
+		{id: "Alloc", wantName: "Alloc", wantLabels: map[string]string{}},
+		{id: "requests{}", wantName: "requests", wantLabels: map[string]string{}},
+		{id: "requests{host=a,env=prod}", wantName: "requests", wantLabels: map[string]string{"host": "a", "env": "prod"}},
+		{id: `requests{host="a", env="prod"}`, wantName: "requests", wantLabels: map[string]string{"host": "a", "env": "prod"}},
+		{id: "broken{host", wantName: "broken{host", wantLabels: map[string]string{}},
+		{id: "broken{=a}", wantName: "broken{=a}", wantLabels: map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			name, labels := ParseSeriesID(tt.id)
+			assert.Equal(t, tt.wantName, name)
+			assert.Equal(t, tt.wantLabels, labels)
+		})
+	}
+}
+
+func TestSelector_Match(t *testing.T) {
+	sel, err := ParseSelector(`requests{env="prod"}`)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{id: "requests{env=prod,host=a}", want: true},
+		{id: "requests{env=dev,host=a}", want: false},
+		{id: "requests", want: false},
+		{id: "errors{env=prod}", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			_, ok := sel.Match(tt.id)
+			assert.Equal(t, tt.want, ok)
+		})
+	}
+
+	_, err = ParseSelector("{env=prod}")
+	assert.ErrorIs(t, err, ErrBadQuery)
+
+	_, err = ParseSelector("requests{env")
+	assert.ErrorIs(t, err, ErrBadQuery)
+}
@@ -0,0 +1,101 @@
+package query
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Значения по-умолчанию для незаданных параметров запроса
+const (
+	DefaultRange = time.Hour
+	DefaultStep  = time.Minute
+)
+
+// Params параметры запроса в строковом виде (из url или сообщения gRPC)
+type Params struct {
+	Query    string
+	Function string
+	Quantile string
+	Start    string
+	End      string
+	Step     string
+	// SumBy список меток через запятую, HasSumBy - задан ли параметр (пустой список суммирует все ряды)
+	SumBy    string
+	HasSumBy bool
+}
+
+// NewRequest разбирает параметры запроса. Время задается в unix-секундах или RFC3339,
+// шаг - в секундах или в формате time.ParseDuration.
+func NewRequest(p Params, now time.Time) (Request, error) {
+	var err error
+
+	r := Request{Function: p.Function, Sum: p.HasSumBy}
+
+	if r.Selector, err = ParseSelector(p.Query); err != nil {
+		return Request{}, err
+	}
+
+	if p.Quantile != "" {
+		if r.Quantile, err = strconv.ParseFloat(p.Quantile, 64); err != nil {
+			return Request{}, fmt.Errorf("%w: bad quantile %q", ErrBadQuery, p.Quantile)
+		}
+	}
+
+	if r.End, err = parseTime(p.End, now); err != nil {
+		return Request{}, err
+	}
+
+	if r.Start, err = parseTime(p.Start, r.End.Add(-DefaultRange)); err != nil {
+		return Request{}, err
+	}
+
+	if r.Step, err = parseStep(p.Step); err != nil {
+		return Request{}, err
+	}
+
+	if p.SumBy != "" {
+		for _, l := range strings.Split(p.SumBy, ",") {
+			r.By = append(r.By, strings.TrimSpace(l))
+		}
+	}
+
+	return r, r.Validate()
+}
+
+func parseTime(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+
+	if ts, err := strconv.ParseFloat(s, 64); err == nil {
+		sec, frac := math.Modf(ts)
+		return time.Unix(int64(sec), int64(frac*float64(time.Second))), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: bad time %q", ErrBadQuery, s)
+	}
+
+	return t, nil
+}
+
+func parseStep(s string) (time.Duration, error) {
+	if s == "" {
+		return DefaultStep, nil
+	}
+
+	if sec, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(sec * float64(time.Second)), nil
+	}
+
+	step, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("%w: bad step %q", ErrBadQuery, s)
+	}
+
+	return step, nil
+}
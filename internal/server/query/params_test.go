@@ -0,0 +1,88 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRequest(t *testing.T) {
+	now := time.Unix(1714564800, 0)
+
+	tests := []struct {
+		name    string
+		params  Params
+		want    Request
+		wantErr bool
+	}{
+		{
+			name:   "defaults",
+			params: Params{Query: "requests", Function: FuncRate},
+			want: Request{
+				Selector: Selector{Name: "requests", Matchers: map[string]string{}},
+				Function: FuncRate,
+				Start:    now.Add(-DefaultRange),
+				End:      now,
+				Step:     DefaultStep,
+			},
+		},
+		{
+			name: "all params",
+			params: Params{
+				Query:    "temp{room=1}",
+				Function: FuncQuantileOverTime,
+				Quantile: "0.9",
+				Start:    "2024-05-01T10:00:00Z",
+				End:      "1714564800",
+				Step:     "5m",
+				SumBy:    "room, floor",
+				HasSumBy: true,
+			},
+			want: Request{
+				Selector: Selector{Name: "temp", Matchers: map[string]string{"room": "1"}},
+				Function: FuncQuantileOverTime,
+				Quantile: 0.9,
+				Start:    time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+				End:      now,
+				Step:     5 * time.Minute,
+				Sum:      true,
+				By:       []string{"room", "floor"},
+			},
+		},
+		{
+			name:    "bad step",
+			params:  Params{Query: "requests", Function: FuncRate, Step: "abc"},
+			wantErr: true,
+		},
+		{
+			name:    "bad time",
+			params:  Params{Query: "requests", Function: FuncRate, Start: "yesterday"},
+			wantErr: true,
+		},
+		{
+			name:    "bad quantile",
+			params:  Params{Query: "temp", Function: FuncQuantileOverTime, Quantile: "high"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewRequest(tt.params, now)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrBadQuery)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want.Selector, got.Selector)
+			assert.Equal(t, tt.want.Function, got.Function)
+			assert.Equal(t, tt.want.Quantile, got.Quantile)
+			assert.True(t, tt.want.Start.Equal(got.Start))
+			assert.True(t, tt.want.End.Equal(got.End))
+			assert.Equal(t, tt.want.Step, got.Step)
+			assert.Equal(t, tt.want.Sum, got.Sum)
+			assert.Equal(t, tt.want.By, got.By)
+		})
+	}
+}
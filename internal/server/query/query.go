@@ -0,0 +1,316 @@
+// Package query Запросы к истории метрик за интервал времени с функциями агрегации.
+//
+// Запрос выбирает ряды по имени и меткам (метки задаются в ID метрики: name{k=v}),
+// вычисляет функцию в окнах длины Step для каждой отметки времени от Start до End
+// и при необходимости суммирует ряды по заданным меткам.
+package query
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/server/repository"
+)
+
+// Поддерживаемые функции
+const (
+	FuncRate             = "rate"
+	FuncIncrease         = "increase"
+	FuncAvgOverTime      = "avg_over_time"
+	FuncMaxOverTime      = "max_over_time"
+	FuncQuantileOverTime = "quantile_over_time"
+)
+
+// maxPoints ограничение количества отметок времени в одном запросе
+const maxPoints = 11000
+
+// ErrBadQuery ошибка в параметрах запроса
+var ErrBadQuery = errors.New("bad query")
+
+// Request запрос за интервал времени.
+// Значение в отметке t вычисляется по точкам окна [t-Step, t).
+type Request struct {
+	Selector Selector
+	Function string
+	// Quantile параметр для quantile_over_time, от 0 до 1
+	Quantile float64
+	Start    time.Time
+	End      time.Time
+	Step     time.Duration
+	// Sum суммировать ряды с одинаковыми значениями меток By
+	Sum bool
+	By  []string
+}
+
+// Matrix результат запроса: набор рядов со значениями в отметках времени
+type Matrix []Series
+
+// Series ряд результата
+type Series struct {
+	Metric map[string]string `json:"metric"`
+	Values []Sample          `json:"values"`
+}
+
+// Sample значение ряда в отметке времени, в json кодируется как [unix-время в секундах, значение]
+type Sample struct {
+	T time.Time
+	V float64
+}
+
+func (s Sample) MarshalJSON() ([]byte, error) {
+	ts := strconv.FormatFloat(float64(s.T.UnixMilli())/1000, 'f', -1, 64)
+	v := strconv.FormatFloat(s.V, 'g', -1, 64)
+
+	return []byte("[" + ts + "," + v + "]"), nil
+}
+
+// MetricType тип метрик, к которым применяется функция запроса
+func (r Request) MetricType() string {
+	if r.Function == FuncRate || r.Function == FuncIncrease {
+		return internal.CounterType
+	}
+
+	return internal.GaugeType
+}
+
+// Validate проверяет параметры запроса
+func (r Request) Validate() error {
+	switch r.Function {
+	case FuncRate, FuncIncrease, FuncAvgOverTime, FuncMaxOverTime:
+	case FuncQuantileOverTime:
+		if r.Quantile < 0 || r.Quantile > 1 || math.IsNaN(r.Quantile) {
+			return fmt.Errorf("%w: quantile must be between 0 and 1", ErrBadQuery)
+		}
+	default:
+		return fmt.Errorf("%w: unknown function %q", ErrBadQuery, r.Function)
+	}
+
+	switch {
+	case r.Selector.Name == "":
+		return fmt.Errorf("%w: metric name is empty", ErrBadQuery)
+	case r.Step <= 0:
+		return fmt.Errorf("%w: step must be positive", ErrBadQuery)
+	case r.End.Before(r.Start):
+		return fmt.Errorf("%w: end is before start", ErrBadQuery)
+	case r.End.Sub(r.Start)/r.Step >= maxPoints:
+		return fmt.Errorf("%w: too many points, increase step", ErrBadQuery)
+	}
+
+	return nil
+}
+
+// Eval выполняет запрос над рядами хранилища st, история читается из hs
+func Eval(ctx context.Context, st repository.Storage, hs repository.HistoryStorage, r Request) (Matrix, error) {
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+
+	ids, err := seriesIDs(ctx, st, r.MetricType())
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(Matrix, 0)
+	for _, id := range ids {
+		labels, ok := r.Selector.Match(id)
+		if !ok {
+			continue
+		}
+
+		history, err := hs.GetHistory(ctx, r.MetricType(), id, r.Start.Add(-r.Step), r.End)
+		if errors.Is(err, repository.ErrNotFound) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		labels[NameLabel] = r.Selector.Name
+		res = append(res, Series{Metric: labels, Values: evalSeries(r, history.Points)})
+	}
+
+	if r.Sum {
+		res = sumBy(res, r.By)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return seriesKey(res[i].Metric) < seriesKey(res[j].Metric)
+	})
+
+	return res, nil
+}
+
+// evalSeries вычисляет функцию запроса в каждой отметке времени. Отметки без точек в окне пропускаются.
+func evalSeries(r Request, points []repository.Point) []Sample {
+	samples := make([]Sample, 0)
+	first := 0
+
+	for t := r.Start; !t.After(r.End); t = t.Add(r.Step) {
+		from := t.Add(-r.Step)
+		for first < len(points) && points[first].Time.Before(from) {
+			first++
+		}
+
+		last := first
+		for last < len(points) && points[last].Time.Before(t) {
+			last++
+		}
+
+		if last == first {
+			continue
+		}
+
+		samples = append(samples, Sample{T: t, V: apply(r, points[first:last])})
+	}
+
+	return samples
+}
+
+func apply(r Request, window []repository.Point) float64 {
+	switch r.Function {
+	case FuncIncrease, FuncRate:
+		var increase int64
+		for _, p := range window {
+			increase += p.Increase
+		}
+
+		if r.Function == FuncRate {
+			return float64(increase) / r.Step.Seconds()
+		}
+
+		return float64(increase)
+	case FuncAvgOverTime:
+		var sum float64
+		var count int64
+		for _, p := range window {
+			sum += p.Sum
+			count += p.Count
+		}
+
+		return sum / float64(count)
+	case FuncMaxOverTime:
+		res := window[0].Max
+		for _, p := range window[1:] {
+			res = math.Max(res, p.Max)
+		}
+
+		return res
+	default:
+		// на уровнях агрегатов квантиль считается по средним значениям интервалов
+		values := make([]float64, len(window))
+		for i, p := range window {
+			values[i] = p.Avg()
+		}
+
+		return quantile(r.Quantile, values)
+	}
+}
+
+// quantile вычисляет квантиль с линейной интерполяцией между соседними значениями
+func quantile(q float64, values []float64) float64 {
+	sort.Float64s(values)
+
+	rank := q * float64(len(values)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+
+	return values[lower] + (values[upper]-values[lower])*(rank-float64(lower))
+}
+
+// sumBy складывает значения рядов с одинаковыми метками by в совпадающих отметках времени
+func sumBy(matrix Matrix, by []string) Matrix {
+	groups := make(map[string]*Series)
+	sums := make(map[string]map[int64]float64)
+	keys := make([]string, 0)
+
+	for _, s := range matrix {
+		labels := make(map[string]string, len(by))
+		for _, l := range by {
+			if v, ok := s.Metric[l]; ok {
+				labels[l] = v
+			}
+		}
+
+		key := seriesKey(labels)
+		if _, ok := groups[key]; !ok {
+			groups[key] = &Series{Metric: labels}
+			sums[key] = make(map[int64]float64)
+			keys = append(keys, key)
+		}
+
+		for _, v := range s.Values {
+			sums[key][v.T.UnixNano()] += v.V
+		}
+	}
+
+	res := make(Matrix, 0, len(keys))
+	for _, key := range keys {
+		series := groups[key]
+		series.Values = make([]Sample, 0, len(sums[key]))
+		for ts, v := range sums[key] {
+			series.Values = append(series.Values, Sample{T: time.Unix(0, ts), V: v})
+		}
+
+		sort.Slice(series.Values, func(i, j int) bool {
+			return series.Values[i].T.Before(series.Values[j].T)
+		})
+
+		res = append(res, *series)
+	}
+
+	return res
+}
+
+func seriesIDs(ctx context.Context, st repository.Storage, mType string) ([]string, error) {
+	ids := make([]string, 0)
+
+	if mType == internal.CounterType {
+		counters, err := st.GetCounters(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for id := range counters {
+			ids = append(ids, id)
+		}
+	} else {
+		gauges, err := st.GetGauge(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for id := range gauges {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids, nil
+}
+
+// seriesKey строковое представление набора меток, не зависящее от порядка
+func seriesKey(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(labels[k])
+		b.WriteByte(',')
+	}
+
+	return b.String()
+}
@@ -0,0 +1,203 @@
+package query
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/server/repository"
+	"github.com/sotavant/yandex-metrics/internal/server/repository/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var start = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// fakeHistory история с заранее заданными точками
+type fakeHistory struct {
+	points map[string][]repository.Point
+}
+
+func (f *fakeHistory) GetHistory(ctx context.Context, mType, key string, from, to time.Time) (repository.History, error) {
+	points, ok := f.points[mType+"/"+key]
+	if !ok {
+		return repository.History{}, repository.NewNotFoundError(mType, key)
+	}
+
+	return repository.History{Points: points}, nil
+}
+
+func (f *fakeHistory) Compact(ctx context.Context, now time.Time) error {
+	return nil
+}
+
+func counterPoint(at time.Duration, delta int64) repository.Point {
+	return repository.NewPoint(internal.Metrics{MType: internal.CounterType, Delta: &delta}, start.Add(at))
+}
+
+func gaugePoint(at time.Duration, value float64) repository.Point {
+	return repository.NewPoint(internal.Metrics{MType: internal.GaugeType, Value: &value}, start.Add(at))
+}
+
+func newTestData(t *testing.T) (repository.Storage, *fakeHistory) {
+	ctx := context.Background()
+	st := memory.NewShardedMetricsRepository(memory.DefaultShardsCount)
+	hs := &fakeHistory{points: make(map[string][]repository.Point)}
+
+	counters := map[string][]repository.Point{
+		"requests{host=a,env=prod}": {counterPoint(10*time.Second, 6), counterPoint(70*time.Second, 12)},
+		"requests{host=b,env=prod}": {counterPoint(20*time.Second, 3), counterPoint(80*time.Second, 3)},
+		"requests{host=c,env=dev}":  {counterPoint(30*time.Second, 60)},
+	}
+	for id, points := range counters {
+		require.NoError(t, st.AddCounterValue(ctx, id, 1))
+		hs.points[internal.CounterType+"/"+id] = points
+	}
+
+	gauges := map[string][]repository.Point{
+		"temp{room=1}": {gaugePoint(0, 10), gaugePoint(15*time.Second, 20), gaugePoint(30*time.Second, 30), gaugePoint(45*time.Second, 40)},
+	}
+	for id, points := range gauges {
+		require.NoError(t, st.AddGaugeValue(ctx, id, 1))
+		hs.points[internal.GaugeType+"/"+id] = points
+	}
+
+	return st, hs
+}
+
+func TestEval(t *testing.T) {
+	st, hs := newTestData(t)
+	prod := Selector{Name: "requests", Matchers: map[string]string{"env": "prod"}}
+	temp := Selector{Name: "temp", Matchers: map[string]string{}}
+
+	tests := []struct {
+		name string
+		req  Request
+		want Matrix
+	}{
+		{
+			name: "increase",
+			req:  Request{Selector: prod, Function: FuncIncrease},
+			want: Matrix{
+				{
+					Metric: map[string]string{NameLabel: "requests", "host": "a", "env": "prod"},
+					Values: []Sample{{T: start.Add(time.Minute), V: 6}, {T: start.Add(2 * time.Minute), V: 12}},
+				},
+				{
+					Metric: map[string]string{NameLabel: "requests", "host": "b", "env": "prod"},
+					Values: []Sample{{T: start.Add(time.Minute), V: 3}, {T: start.Add(2 * time.Minute), V: 3}},
+				},
+			},
+		},
+		{
+			name: "rate summed by env",
+			req:  Request{Selector: Selector{Name: "requests"}, Function: FuncRate, Sum: true, By: []string{"env"}},
+			want: Matrix{
+				{
+					Metric: map[string]string{"env": "dev"},
+					Values: []Sample{{T: start.Add(time.Minute), V: 1}},
+				},
+				{
+					Metric: map[string]string{"env": "prod"},
+					Values: []Sample{{T: start.Add(time.Minute), V: 0.15}, {T: start.Add(2 * time.Minute), V: 0.25}},
+				},
+			},
+		},
+		{
+			name: "sum without labels",
+			req:  Request{Selector: Selector{Name: "requests"}, Function: FuncIncrease, Sum: true},
+			want: Matrix{
+				{
+					Metric: map[string]string{},
+					Values: []Sample{{T: start.Add(time.Minute), V: 69}, {T: start.Add(2 * time.Minute), V: 15}},
+				},
+			},
+		},
+		{
+			name: "avg over time",
+			req:  Request{Selector: temp, Function: FuncAvgOverTime},
+			want: Matrix{{
+				Metric: map[string]string{NameLabel: "temp", "room": "1"},
+				Values: []Sample{{T: start.Add(time.Minute), V: 25}},
+			}},
+		},
+		{
+			name: "max over time",
+			req:  Request{Selector: temp, Function: FuncMaxOverTime},
+			want: Matrix{{
+				Metric: map[string]string{NameLabel: "temp", "room": "1"},
+				Values: []Sample{{T: start.Add(time.Minute), V: 40}},
+			}},
+		},
+		{
+			name: "quantile over time",
+			req:  Request{Selector: temp, Function: FuncQuantileOverTime, Quantile: 0.5},
+			want: Matrix{{
+				Metric: map[string]string{NameLabel: "temp", "room": "1"},
+				Values: []Sample{{T: start.Add(time.Minute), V: 25}},
+			}},
+		},
+		{
+			name: "no matching series",
+			req:  Request{Selector: Selector{Name: "absent"}, Function: FuncRate},
+			want: Matrix{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.req.Start = start.Add(time.Minute)
+			tt.req.End = start.Add(3 * time.Minute)
+			tt.req.Step = time.Minute
+
+			got, err := Eval(context.Background(), st, hs, tt.req)
+			assert.NoError(t, err)
+			assert.Equal(t, len(tt.want), len(got))
+			for i := range tt.want {
+				assert.Equal(t, tt.want[i].Metric, got[i].Metric)
+				assert.Equal(t, len(tt.want[i].Values), len(got[i].Values))
+				for j := range tt.want[i].Values {
+					assert.True(t, tt.want[i].Values[j].T.Equal(got[i].Values[j].T))
+					assert.InDelta(t, tt.want[i].Values[j].V, got[i].Values[j].V, 1e-9)
+				}
+			}
+		})
+	}
+}
+
+func TestRequest_Validate(t *testing.T) {
+	valid := Request{
+		Selector: Selector{Name: "m"},
+		Function: FuncRate,
+		Start:    start,
+		End:      start.Add(time.Hour),
+		Step:     time.Minute,
+	}
+
+	tests := []struct {
+		name   string
+		modify func(r *Request)
+	}{
+		{name: "unknown function", modify: func(r *Request) { r.Function = "sum" }},
+		{name: "bad quantile", modify: func(r *Request) { r.Function, r.Quantile = FuncQuantileOverTime, 1.5 }},
+		{name: "empty name", modify: func(r *Request) { r.Selector.Name = "" }},
+		{name: "zero step", modify: func(r *Request) { r.Step = 0 }},
+		{name: "end before start", modify: func(r *Request) { r.End = r.Start.Add(-time.Second) }},
+		{name: "too many points", modify: func(r *Request) { r.Step = time.Millisecond }},
+	}
+
+	assert.NoError(t, valid.Validate())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := valid
+			tt.modify(&r)
+			assert.ErrorIs(t, r.Validate(), ErrBadQuery)
+		})
+	}
+}
+
+func TestSample_MarshalJSON(t *testing.T) {
+	got, err := Sample{T: time.UnixMilli(1714564800500), V: 1.5}.MarshalJSON()
+	assert.NoError(t, err)
+	assert.Equal(t, "[1714564800.5,1.5]", string(got))
+}
@@ -0,0 +1,530 @@
+// Package bolt Хранилище метрик во встроенной базе данных bbolt (B-дерево на диске).
+// Позволяет сохранять метрики между перезапусками без внешней СУБД.
+package bolt
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/server/repository"
+	bbolt "go.etcd.io/bbolt"
+)
+
+const openTimeout = time.Second
+
+var (
+	gaugeBucket   = []byte(internal.GaugeType)
+	counterBucket = []byte(internal.CounterType)
+	// updatedBucket время последней записи рядов, ключ - тип и ID через "/"
+	updatedBucket = []byte("updated")
+)
+
+// MetricsRepository хранилище метрик в файле bbolt.
+// Значения каждого типа лежат в отдельном бакете, ключ - ID метрики.
+// Время записи хранится в отдельном бакете, поэтому формат значений в старых файлах не меняется.
+type MetricsRepository struct {
+	db *bbolt.DB
+}
+
+// NewMetricsRepository открывает (или создает) файл базы данных и подготавливает бакеты
+func NewMetricsRepository(path string) (*MetricsRepository, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("error in open bolt db: %w", err)
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{gaugeBucket, counterBucket, updatedBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("error in creating buckets: %w", err)
+	}
+
+	return &MetricsRepository{db: db}, nil
+}
+
+// Close закрывает файл базы данных
+func (m *MetricsRepository) Close() error {
+	return m.db.Close()
+}
+
+func (m *MetricsRepository) AddGaugeValue(ctx context.Context, key string, value float64) error {
+	return m.db.Update(func(tx *bbolt.Tx) error {
+		return putGauge(tx, key, value)
+	})
+}
+
+func (m *MetricsRepository) AddCounterValue(ctx context.Context, key string, value int64) error {
+	return m.db.Update(func(tx *bbolt.Tx) error {
+		return addCounter(tx, key, value)
+	})
+}
+
+func (m *MetricsRepository) AddValue(ctx context.Context, metric internal.Metrics) error {
+	return m.AddValues(ctx, []internal.Metrics{metric})
+}
+
+// AddValues сохраняет пакет метрик в одной транзакции
+func (m *MetricsRepository) AddValues(ctx context.Context, metrics []internal.Metrics) error {
+	return m.db.Update(func(tx *bbolt.Tx) error {
+		for _, metric := range metrics {
+			if err := addMetric(tx, metric); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (m *MetricsRepository) GetValue(ctx context.Context, mType, key string) (interface{}, error) {
+	var res interface{}
+
+	if mType != internal.GaugeType && mType != internal.CounterType {
+		return nil, repository.ErrUnknownType
+	}
+
+	err := m.db.View(func(tx *bbolt.Tx) error {
+		v := tx.Bucket([]byte(mType)).Get([]byte(key))
+		if v == nil {
+			return repository.NewNotFoundError(mType, key)
+		}
+
+		if mType == internal.GaugeType {
+			res = decodeGauge(v)
+		} else {
+			res = decodeCounter(v)
+		}
+
+		return nil
+	})
+
+	return res, err
+}
+
+func (m *MetricsRepository) GetValues(ctx context.Context) ([]internal.Metrics, error) {
+	metrics := make([]internal.Metrics, 0)
+
+	err := m.db.View(func(tx *bbolt.Tx) error {
+		err := tx.Bucket(gaugeBucket).ForEach(func(k, v []byte) error {
+			value := decodeGauge(v)
+			metrics = append(metrics, internal.Metrics{
+				ID:    string(k),
+				MType: internal.GaugeType,
+				Value: &value,
+			})
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		return tx.Bucket(counterBucket).ForEach(func(k, v []byte) error {
+			delta := decodeCounter(v)
+			metrics = append(metrics, internal.Metrics{
+				ID:    string(k),
+				MType: internal.CounterType,
+				Delta: &delta,
+			})
+
+			return nil
+		})
+	})
+
+	return metrics, err
+}
+
+// ListValues возвращает страницу метрик. Ключи бакетов уже отсортированы по ID, поэтому страница
+// читается курсорами с позиции курсора страницы: бакеты типов сливаются в порядке сортировки,
+// чтение останавливается на limit метриках или на конце префикса.
+func (m *MetricsRepository) ListValues(ctx context.Context, opts repository.ListOptions) ([]internal.Metrics, error) {
+	res := make([]internal.Metrics, 0)
+
+	err := m.db.View(func(tx *bbolt.Tx) error {
+		cursors := make([]*listCursor, 0, 2)
+		for _, mType := range []string{internal.CounterType, internal.GaugeType} {
+			if opts.MType == "" || opts.MType == mType {
+				cursors = append(cursors, newListCursor(tx.Bucket([]byte(mType)).Cursor(), mType, opts))
+			}
+		}
+
+		for opts.Limit == 0 || len(res) < opts.Limit {
+			var next *listCursor
+			for _, c := range cursors {
+				if c.k != nil && (next == nil || opts.Less(c.key(), next.key())) {
+					next = c
+				}
+			}
+
+			if next == nil {
+				return nil
+			}
+
+			res = append(res, next.metric())
+			next.advance()
+		}
+
+		return nil
+	})
+
+	return res, err
+}
+
+// listCursor курсор бакета одного типа, k == nil - метрик для страницы больше нет
+type listCursor struct {
+	c     *bbolt.Cursor
+	mType string
+	opts  repository.ListOptions
+	k, v  []byte
+}
+
+// newListCursor ставит курсор на первую метрику страницы: после курсора страницы и с нужным префиксом
+func newListCursor(c *bbolt.Cursor, mType string, opts repository.ListOptions) *listCursor {
+	lc := &listCursor{c: c, mType: mType, opts: opts}
+
+	// при сортировке по типу курсор другого типа стоит целиком до или целиком после этого бакета
+	if opts.After != nil && opts.SortBy == repository.SortByType && opts.After.MType != mType {
+		if !opts.Less(*opts.After, repository.MetricKey{MType: mType, ID: opts.After.ID}) {
+			return lc
+		}
+
+		lc.opts.After = nil
+		opts.After = nil
+	}
+
+	switch {
+	case !opts.Desc:
+		start := opts.Prefix
+		if opts.After != nil && opts.After.ID > start {
+			start = opts.After.ID
+		}
+
+		lc.k, lc.v = c.Seek([]byte(start))
+	case opts.After != nil:
+		lc.seekBefore(opts.After.ID)
+	case opts.Prefix != "":
+		lc.seekBefore(prefixEnd(opts.Prefix))
+	default:
+		lc.k, lc.v = c.Last()
+	}
+
+	lc.skip()
+
+	return lc
+}
+
+// seekBefore ставит курсор на последний ключ не больше id, пустой id - на последний ключ
+func (lc *listCursor) seekBefore(id string) {
+	if id == "" {
+		lc.k, lc.v = lc.c.Last()
+		return
+	}
+
+	lc.k, lc.v = lc.c.Seek([]byte(id))
+	switch {
+	case lc.k == nil:
+		lc.k, lc.v = lc.c.Last()
+	case string(lc.k) > id:
+		lc.k, lc.v = lc.c.Prev()
+	}
+}
+
+func (lc *listCursor) advance() {
+	if lc.opts.Desc {
+		lc.k, lc.v = lc.c.Prev()
+	} else {
+		lc.k, lc.v = lc.c.Next()
+	}
+
+	lc.skip()
+}
+
+// skip пропускает ключи до курсора страницы и вне префикса, за концом префикса останавливает курсор
+func (lc *listCursor) skip() {
+	for lc.k != nil {
+		id := string(lc.k)
+		if !strings.HasPrefix(id, lc.opts.Prefix) {
+			// ключи упорядочены, поэтому за префиксом (по направлению чтения) подходящих ключей больше нет
+			if lc.opts.Desc == (id < lc.opts.Prefix) {
+				lc.k, lc.v = nil, nil
+				return
+			}
+		} else if lc.opts.After == nil || lc.opts.Less(*lc.opts.After, lc.key()) {
+			return
+		}
+
+		if lc.opts.Desc {
+			lc.k, lc.v = lc.c.Prev()
+		} else {
+			lc.k, lc.v = lc.c.Next()
+		}
+	}
+}
+
+func (lc *listCursor) key() repository.MetricKey {
+	return repository.MetricKey{MType: lc.mType, ID: string(lc.k)}
+}
+
+func (lc *listCursor) metric() internal.Metrics {
+	res := internal.Metrics{ID: string(lc.k), MType: lc.mType}
+	if lc.mType == internal.GaugeType {
+		value := decodeGauge(lc.v)
+		res.Value = &value
+	} else {
+		delta := decodeCounter(lc.v)
+		res.Delta = &delta
+	}
+
+	return res
+}
+
+// prefixEnd наименьшая строка больше всех строк с префиксом prefix, пустая - такой строки нет
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+
+	return ""
+}
+
+func (m *MetricsRepository) KeyExist(ctx context.Context, mType, key string) (bool, error) {
+	var exist bool
+
+	if mType != internal.GaugeType && mType != internal.CounterType {
+		return false, nil
+	}
+
+	err := m.db.View(func(tx *bbolt.Tx) error {
+		exist = tx.Bucket([]byte(mType)).Get([]byte(key)) != nil
+		return nil
+	})
+
+	return exist, err
+}
+
+func (m *MetricsRepository) GetGauge(ctx context.Context) (map[string]float64, error) {
+	res := make(map[string]float64)
+
+	err := m.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(gaugeBucket).ForEach(func(k, v []byte) error {
+			res[string(k)] = decodeGauge(v)
+			return nil
+		})
+	})
+
+	return res, err
+}
+
+func (m *MetricsRepository) GetGaugeValue(ctx context.Context, key string) (float64, error) {
+	val, err := m.GetValue(ctx, internal.GaugeType, key)
+	if err != nil {
+		return 0, err
+	}
+
+	return val.(float64), nil
+}
+
+func (m *MetricsRepository) GetCounters(ctx context.Context) (map[string]int64, error) {
+	res := make(map[string]int64)
+
+	err := m.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(counterBucket).ForEach(func(k, v []byte) error {
+			res[string(k)] = decodeCounter(v)
+			return nil
+		})
+	})
+
+	return res, err
+}
+
+func (m *MetricsRepository) GetCounterValue(ctx context.Context, key string) (int64, error) {
+	val, err := m.GetValue(ctx, internal.CounterType, key)
+	if err != nil {
+		return 0, err
+	}
+
+	return val.(int64), nil
+}
+
+// Delete удаляет метрику
+func (m *MetricsRepository) Delete(ctx context.Context, mType, key string) error {
+	if mType != internal.GaugeType && mType != internal.CounterType {
+		return repository.ErrUnknownType
+	}
+
+	return m.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(mType))
+		if b.Get([]byte(key)) == nil {
+			return repository.NewNotFoundError(mType, key)
+		}
+
+		if err := tx.Bucket(updatedBucket).Delete(updatedKey(repository.MetricKey{MType: mType, ID: key})); err != nil {
+			return err
+		}
+
+		return b.Delete([]byte(key))
+	})
+}
+
+// ResetCounter обнуляет счетчик
+func (m *MetricsRepository) ResetCounter(ctx context.Context, key string) error {
+	return m.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(counterBucket)
+		if b.Get([]byte(key)) == nil {
+			return repository.NewNotFoundError(internal.CounterType, key)
+		}
+
+		return b.Put([]byte(key), make([]byte, 8))
+	})
+}
+
+// LastUpdated возвращает время последней записи рядов
+func (m *MetricsRepository) LastUpdated(ctx context.Context, keys []repository.MetricKey) (map[repository.MetricKey]time.Time, error) {
+	res := make(map[repository.MetricKey]time.Time)
+
+	err := m.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(updatedBucket)
+
+		if keys != nil {
+			for _, k := range keys {
+				if v := b.Get(updatedKey(k)); v != nil {
+					res[k] = decodeTime(v)
+				}
+			}
+
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			res[parseUpdatedKey(k)] = decodeTime(v)
+			return nil
+		})
+	})
+
+	return res, err
+}
+
+// EvictStale удаляет ряды, не обновлявшиеся с момента before, в одной транзакции
+func (m *MetricsRepository) EvictStale(ctx context.Context, before time.Time) ([]repository.MetricKey, error) {
+	evicted := make([]repository.MetricKey, 0)
+
+	err := m.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(updatedBucket)
+
+		// удалять ключи во время обхода ForEach нельзя, поэтому сначала собираем их
+		err := b.ForEach(func(k, v []byte) error {
+			if decodeTime(v).Before(before) {
+				evicted = append(evicted, parseUpdatedKey(k))
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, k := range evicted {
+			if err = tx.Bucket([]byte(k.MType)).Delete([]byte(k.ID)); err != nil {
+				return err
+			}
+
+			if err = b.Delete(updatedKey(k)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return evicted, nil
+}
+
+func addMetric(tx *bbolt.Tx, metric internal.Metrics) error {
+	if err := repository.ValidateMetric(metric); err != nil {
+		return err
+	}
+
+	if metric.MType == internal.GaugeType {
+		return putGauge(tx, metric.ID, *metric.Value)
+	}
+
+	return addCounter(tx, metric.ID, *metric.Delta)
+}
+
+func putGauge(tx *bbolt.Tx, key string, value float64) error {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, math.Float64bits(value))
+
+	if err := tx.Bucket(gaugeBucket).Put([]byte(key), buf); err != nil {
+		return err
+	}
+
+	return touch(tx, repository.MetricKey{MType: internal.GaugeType, ID: key})
+}
+
+func addCounter(tx *bbolt.Tx, key string, value int64) error {
+	b := tx.Bucket(counterBucket)
+	if v := b.Get([]byte(key)); v != nil {
+		value += decodeCounter(v)
+	}
+
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(value))
+
+	if err := b.Put([]byte(key), buf); err != nil {
+		return err
+	}
+
+	return touch(tx, repository.MetricKey{MType: internal.CounterType, ID: key})
+}
+
+// touch записывает текущее время как время обновления ряда
+func touch(tx *bbolt.Tx, key repository.MetricKey) error {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(time.Now().UnixNano()))
+
+	return tx.Bucket(updatedBucket).Put(updatedKey(key), buf)
+}
+
+func updatedKey(key repository.MetricKey) []byte {
+	return []byte(key.MType + "/" + key.ID)
+}
+
+// parseUpdatedKey разбирает ключ бакета времени записи. Тип не содержит "/", поэтому ID может содержать любые символы.
+func parseUpdatedKey(k []byte) repository.MetricKey {
+	mType, id, _ := strings.Cut(string(k), "/")
+
+	return repository.MetricKey{MType: mType, ID: id}
+}
+
+func decodeTime(v []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(v)))
+}
+
+func decodeGauge(v []byte) float64 {
+	return math.Float64frombits(binary.BigEndian.Uint64(v))
+}
+
+func decodeCounter(v []byte) int64 {
+	return int64(binary.BigEndian.Uint64(v))
+}
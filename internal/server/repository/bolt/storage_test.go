@@ -0,0 +1,234 @@
+package bolt
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/server/repository"
+	"github.com/sotavant/yandex-metrics/internal/server/repository/storagetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRepository(t testing.TB) *MetricsRepository {
+	m, err := NewMetricsRepository(filepath.Join(t.TempDir(), "metrics.db"))
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		assert.NoError(t, m.Close())
+	})
+
+	return m
+}
+
+func TestBoltStorage_AddGaugeValue(t *testing.T) {
+	type args struct {
+		key   string
+		value float64
+	}
+
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		args  args
+		wants args
+	}{
+		{
+			name: `newValue`,
+			args: args{
+				key:   `newValue`,
+				value: 345.555,
+			},
+			wants: args{
+				value: 345.555,
+			},
+		},
+		{
+			name: `updateValue`,
+			args: args{
+				key:   `updateValue`,
+				value: 345.555,
+			},
+			wants: args{
+				value: 345.555,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestRepository(t)
+			err := m.AddGaugeValue(ctx, tt.args.key, tt.args.value)
+			assert.NoError(t, err)
+			if tt.name == `updateValue` {
+				err = m.AddGaugeValue(ctx, tt.args.key, tt.args.value)
+				assert.NoError(t, err)
+			}
+
+			got, err := m.GetGaugeValue(ctx, tt.args.key)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wants.value, got)
+		})
+	}
+}
+
+func TestBoltStorage_AddCounterValue(t *testing.T) {
+	type args struct {
+		key   string
+		value int64
+	}
+
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		args  args
+		wants args
+	}{
+		{
+			name: `newValue`,
+			args: args{
+				key:   `newValue`,
+				value: 3,
+			},
+			wants: args{
+				value: 3,
+			},
+		},
+		{
+			name: `updateValue`,
+			args: args{
+				key:   `updateValue`,
+				value: 5,
+			},
+			wants: args{
+				value: 10,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestRepository(t)
+			err := m.AddCounterValue(ctx, tt.args.key, tt.args.value)
+			assert.NoError(t, err)
+			if tt.name == `updateValue` {
+				err = m.AddCounterValue(ctx, tt.args.key, tt.args.value)
+				assert.NoError(t, err)
+			}
+
+			got, err := m.GetCounterValue(ctx, tt.args.key)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wants.value, got)
+		})
+	}
+}
+
+func TestBoltStorage_AddValue(t *testing.T) {
+	m := newTestRepository(t)
+
+	type args struct {
+		metric internal.Metrics
+	}
+
+	var delta int64 = 11
+
+	tests := []struct {
+		name    string
+		wantErr assert.ErrorAssertionFunc
+		args    args
+	}{
+		{
+			name: "correct type",
+			args: struct{ metric internal.Metrics }{metric: internal.Metrics{
+				ID:    "aa",
+				MType: internal.CounterType,
+				Delta: &delta,
+				Value: nil,
+			}},
+			wantErr: assert.NoError,
+		},
+		{
+			name: "bad type",
+			args: struct{ metric internal.Metrics }{metric: internal.Metrics{
+				ID:    "aa",
+				MType: "someBadType",
+				Delta: &delta,
+				Value: nil,
+			}},
+			wantErr: assert.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.wantErr(t, m.AddValue(context.Background(), tt.args.metric), fmt.Sprintf("AddValue(%v)", tt.args.metric))
+		})
+	}
+}
+
+func TestBoltStorage_Reopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.db")
+
+	m, err := NewMetricsRepository(path)
+	require.NoError(t, err)
+	require.NoError(t, m.AddValues(ctx, fillMetrics()))
+	require.NoError(t, m.Close())
+
+	m, err = NewMetricsRepository(path)
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, m.Close())
+	}()
+
+	got, err := m.GetValues(ctx)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, fillMetrics(), got)
+}
+
+func TestMetricsRepository_Conformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) repository.Storage {
+		return newTestRepository(t)
+	})
+}
+
+func BenchmarkMetricsRepository_AddValues(b *testing.B) {
+	m := fillMetrics()
+	storage := newTestRepository(b)
+	ctx := context.Background()
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		err := storage.AddValues(ctx, m)
+		assert.NoError(b, err)
+	}
+}
+
+func fillMetrics() []internal.Metrics {
+	var counter int64 = 1
+	res := make([]internal.Metrics, 0)
+
+	for i := 'a'; i < 'z'; i++ {
+		metric := internal.Metrics{
+			ID: string(i),
+		}
+
+		if counter%5 == 0 {
+			delta := counter
+			metric.MType = internal.CounterType
+			metric.Delta = &delta
+		} else {
+			gVal := float64(counter)
+			metric.MType = internal.GaugeType
+			metric.Value = &gVal
+		}
+		counter++
+
+		res = append(res, metric)
+	}
+
+	return res
+}
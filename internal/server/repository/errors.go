@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/sotavant/yandex-metrics/internal"
+)
+
+// Ошибки, которые возвращают все реализации Storage
+var (
+	ErrNotFound    = errors.New("metric not found")
+	ErrUnknownType = errors.New("unknown metric type")
+	ErrValueAbsent = errors.New("metric value is absent")
+)
+
+// NotFoundError ошибка обращения к отсутствующей метрике.
+// Проверяется через errors.Is(err, ErrNotFound).
+type NotFoundError struct {
+	MType string
+	ID    string
+}
+
+// NewNotFoundError создает ошибку для метрики заданного типа и имени
+func NewNotFoundError(mType, id string) *NotFoundError {
+	return &NotFoundError{MType: mType, ID: id}
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("%s %s: %s", e.MType, e.ID, ErrNotFound)
+}
+
+func (e *NotFoundError) Is(target error) bool {
+	return target == ErrNotFound
+}
+
+// ValidateMetric проверяет тип метрики и наличие значения, соответствующего типу
+func ValidateMetric(m internal.Metrics) error {
+	switch m.MType {
+	case internal.GaugeType:
+		if m.Value == nil {
+			return fmt.Errorf("%w: %s", ErrValueAbsent, m.ID)
+		}
+	case internal.CounterType:
+		if m.Delta == nil {
+			return fmt.Errorf("%w: %s", ErrValueAbsent, m.ID)
+		}
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownType, m.MType)
+	}
+
+	return nil
+}
@@ -0,0 +1,12 @@
+package repository
+
+import (
+	"context"
+	"time"
+)
+
+// UpdatedAtSetter хранилище, которому можно задать время обновления ряда.
+// Используется при восстановлении из файла, чтобы восстановленные ряды не выглядели только что обновленными.
+type UpdatedAtSetter interface {
+	SetUpdatedAt(ctx context.Context, key MetricKey, at time.Time) error
+}
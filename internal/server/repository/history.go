@@ -0,0 +1,253 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sotavant/yandex-metrics/internal"
+)
+
+// rawTierName обозначение уровня сырых точек в строке настройки хранения
+const rawTierName = "raw"
+
+// maxCompactInterval максимальный интервал между запусками сжатия истории
+const maxCompactInterval = time.Hour
+
+// Ошибки хранения истории
+var (
+	ErrBadRetention    = errors.New("bad history retention")
+	ErrHistoryDisabled = errors.New("history is not enabled")
+)
+
+// HistoryStorage необязательное расширение Storage для хранения истории значений.
+//
+// Каждая запись метрики сохраняется как сырая точка, фоновое сжатие (Compact) сворачивает
+// точки в агрегаты следующих уровней и удаляет данные старше срока хранения уровня.
+type HistoryStorage interface {
+	// GetHistory возвращает точки ряда за интервал [from, to].
+	// Уровень выбирается автоматически: самый детальный, срок хранения которого покрывает from.
+	GetHistory(ctx context.Context, mType, key string, from, to time.Time) (History, error)
+	// Compact сворачивает завершенные до now интервалы и удаляет устаревшие точки
+	Compact(ctx context.Context, now time.Time) error
+}
+
+// RetentionTier уровень хранения истории: точки с шагом Resolution хранятся в течение Retention.
+// Нулевой Resolution означает сырые точки без агрегации.
+type RetentionTier struct {
+	Resolution time.Duration
+	Retention  time.Duration
+}
+
+// Point точка истории.
+// Для сырой точки Time - время записи, для агрегата - начало интервала.
+// Для gauge заполняются Min, Max, Sum и Last, для counter - Increase.
+type Point struct {
+	Time     time.Time
+	Count    int64
+	Min      float64
+	Max      float64
+	Sum      float64
+	Last     float64
+	Increase int64
+}
+
+// History точки ряда и уровень хранения, из которого они получены
+type History struct {
+	Tier   RetentionTier
+	Points []Point
+}
+
+// NewPoint создает сырую точку для записанной метрики
+func NewPoint(m internal.Metrics, at time.Time) Point {
+	p := Point{Time: at, Count: 1}
+
+	if m.MType == internal.GaugeType {
+		p.Min, p.Max, p.Sum, p.Last = *m.Value, *m.Value, *m.Value, *m.Value
+	} else {
+		p.Increase = *m.Delta
+	}
+
+	return p
+}
+
+// Avg среднее значение gauge за интервал точки
+func (p Point) Avg() float64 {
+	if p.Count == 0 {
+		return 0
+	}
+
+	return p.Sum / float64(p.Count)
+}
+
+// Merge добавляет к агрегату более позднюю точку
+func (p *Point) Merge(o Point) {
+	if p.Count == 0 {
+		at := p.Time
+		*p = o
+		p.Time = at
+
+		return
+	}
+
+	if o.Min < p.Min {
+		p.Min = o.Min
+	}
+
+	if o.Max > p.Max {
+		p.Max = o.Max
+	}
+
+	p.Count += o.Count
+	p.Sum += o.Sum
+	p.Last = o.Last
+	p.Increase += o.Increase
+}
+
+// Rollup сворачивает точки из интервала [from, until) в агрегаты с шагом resolution.
+// Результат упорядочен по времени.
+func Rollup(points []Point, resolution time.Duration, from, until time.Time) []Point {
+	src := make([]Point, 0, len(points))
+	for _, p := range points {
+		if !p.Time.Before(from) && p.Time.Before(until) {
+			src = append(src, p)
+		}
+	}
+
+	sort.SliceStable(src, func(i, j int) bool {
+		return src[i].Time.Before(src[j].Time)
+	})
+
+	res := make([]Point, 0)
+	for _, p := range src {
+		bucket := BucketStart(p.Time, resolution)
+		if len(res) == 0 || !res[len(res)-1].Time.Equal(bucket) {
+			res = append(res, Point{Time: bucket})
+		}
+
+		res[len(res)-1].Merge(p)
+	}
+
+	return res
+}
+
+// BucketStart возвращает начало интервала длины resolution, содержащего t.
+// Интервалы отсчитываются от начала эпохи Unix, так же как при сжатии в базе данных.
+func BucketStart(t time.Time, resolution time.Duration) time.Time {
+	ns := t.UnixNano()
+	offset := ns % int64(resolution)
+	if offset < 0 {
+		offset += int64(resolution)
+	}
+
+	return time.Unix(0, ns-offset).In(t.Location())
+}
+
+// SelectTier возвращает индекс самого детального уровня, срок хранения которого покрывает from.
+// Если ни один уровень не покрывает from, возвращается самый грубый.
+func SelectTier(tiers []RetentionTier, now, from time.Time) int {
+	for i, tier := range tiers {
+		if !from.Before(now.Add(-tier.Retention)) {
+			return i
+		}
+	}
+
+	return len(tiers) - 1
+}
+
+// CompactInterval интервал запуска сжатия: шаг самого детального агрегата, но не больше часа
+func CompactInterval(tiers []RetentionTier) time.Duration {
+	interval := maxCompactInterval
+
+	for _, tier := range tiers {
+		if tier.Resolution > 0 && tier.Resolution < interval {
+			interval = tier.Resolution
+		}
+	}
+
+	return interval
+}
+
+// ParseRetention разбирает настройку уровней хранения вида "raw:24h,1m:30d,1h:365d".
+// Первым должен идти уровень сырых точек, шаг каждого следующего уровня кратен шагу предыдущего,
+// а срок хранения предыдущего уровня не меньше шага следующего, иначе данные удалятся раньше сжатия.
+func ParseRetention(s string) ([]RetentionTier, error) {
+	tiers := make([]RetentionTier, 0)
+
+	for _, part := range strings.Split(s, ",") {
+		resolution, retention, found := strings.Cut(strings.TrimSpace(part), ":")
+		if !found {
+			return nil, fmt.Errorf("%w: tier %q must be resolution:retention", ErrBadRetention, part)
+		}
+
+		var tier RetentionTier
+		var err error
+
+		if resolution != rawTierName {
+			if tier.Resolution, err = parseDuration(resolution); err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrBadRetention, err)
+			}
+		}
+
+		if tier.Retention, err = parseDuration(retention); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrBadRetention, err)
+		}
+
+		tiers = append(tiers, tier)
+	}
+
+	if err := validateRetention(tiers); err != nil {
+		return nil, err
+	}
+
+	return tiers, nil
+}
+
+func validateRetention(tiers []RetentionTier) error {
+	if tiers[0].Resolution != 0 {
+		return fmt.Errorf("%w: first tier must be %s", ErrBadRetention, rawTierName)
+	}
+
+	for i, tier := range tiers {
+		if tier.Retention <= 0 {
+			return fmt.Errorf("%w: retention must be positive", ErrBadRetention)
+		}
+
+		if i == 0 {
+			continue
+		}
+
+		prev := tiers[i-1]
+
+		switch {
+		case tier.Resolution <= prev.Resolution:
+			return fmt.Errorf("%w: resolutions must increase", ErrBadRetention)
+		case prev.Resolution > 0 && tier.Resolution%prev.Resolution != 0:
+			return fmt.Errorf("%w: resolution %s is not a multiple of %s", ErrBadRetention, tier.Resolution, prev.Resolution)
+		case prev.Retention < tier.Resolution:
+			return fmt.Errorf("%w: retention %s is shorter than next resolution %s", ErrBadRetention, prev.Retention, tier.Resolution)
+		case tier.Retention < tier.Resolution:
+			return fmt.Errorf("%w: retention %s is shorter than resolution %s", ErrBadRetention, tier.Retention, tier.Resolution)
+		}
+	}
+
+	return nil
+}
+
+// parseDuration дополняет time.ParseDuration суффиксом d (сутки)
+func parseDuration(s string) (time.Duration, error) {
+	if days, found := strings.CutSuffix(s, "d"); found {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("bad duration %q", s)
+		}
+
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	return time.ParseDuration(s)
+}
@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRetention(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []RetentionTier
+		wantErr bool
+	}{
+		{
+			name:  "three tiers",
+			value: "raw:24h, 1m:30d, 1h:365d",
+			want: []RetentionTier{
+				{Resolution: 0, Retention: 24 * time.Hour},
+				{Resolution: time.Minute, Retention: 30 * 24 * time.Hour},
+				{Resolution: time.Hour, Retention: 365 * 24 * time.Hour},
+			},
+		},
+		{
+			name:  "raw only",
+			value: "raw:1h",
+			want:  []RetentionTier{{Resolution: 0, Retention: time.Hour}},
+		},
+		{
+			name:    "no raw tier",
+			value:   "1m:30d",
+			wantErr: true,
+		},
+		{
+			name:    "bad format",
+			value:   "raw-24h",
+			wantErr: true,
+		},
+		{
+			name:    "bad duration",
+			value:   "raw:xd",
+			wantErr: true,
+		},
+		{
+			name:    "resolution not increasing",
+			value:   "raw:24h,1h:30d,1m:365d",
+			wantErr: true,
+		},
+		{
+			name:    "resolution not multiple",
+			value:   "raw:24h,2m:30d,3m:365d",
+			wantErr: true,
+		},
+		{
+			name:    "raw expires before rollup",
+			value:   "raw:30s,1m:30d",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRetention(tt.value)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrBadRetention)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestSelectTier(t *testing.T) {
+	tiers := []RetentionTier{
+		{Resolution: 0, Retention: 24 * time.Hour},
+		{Resolution: time.Minute, Retention: 30 * 24 * time.Hour},
+		{Resolution: time.Hour, Retention: 365 * 24 * time.Hour},
+	}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		from time.Time
+		want int
+	}{
+		{name: "last hour", from: now.Add(-time.Hour), want: 0},
+		{name: "last week", from: now.Add(-7 * 24 * time.Hour), want: 1},
+		{name: "last half year", from: now.Add(-180 * 24 * time.Hour), want: 2},
+		{name: "beyond retention", from: now.Add(-1000 * 24 * time.Hour), want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, SelectTier(tiers, now, tt.from))
+		})
+	}
+}
+
+func TestRollup(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	gauge := func(at time.Duration, v float64) Point {
+		return NewPoint(internal.Metrics{MType: internal.GaugeType, Value: &v}, start.Add(at))
+	}
+	counter := func(at time.Duration, d int64) Point {
+		return NewPoint(internal.Metrics{MType: internal.CounterType, Delta: &d}, start.Add(at))
+	}
+
+	t.Run("gauge", func(t *testing.T) {
+		points := []Point{
+			gauge(70*time.Second, 5),
+			gauge(10*time.Second, 3),
+			gauge(20*time.Second, 1),
+			gauge(30*time.Second, 8),
+			gauge(2*time.Minute, 100),
+		}
+
+		got := Rollup(points, time.Minute, start, start.Add(2*time.Minute))
+		assert.Equal(t, []Point{
+			{Time: start, Count: 3, Min: 1, Max: 8, Sum: 12, Last: 8},
+			{Time: start.Add(time.Minute), Count: 1, Min: 5, Max: 5, Sum: 5, Last: 5},
+		}, got)
+		assert.Equal(t, 4.0, got[0].Avg())
+	})
+
+	t.Run("counter", func(t *testing.T) {
+		points := []Point{counter(0, 2), counter(10*time.Second, 3), counter(61*time.Second, 4)}
+
+		got := Rollup(points, time.Minute, time.Time{}, start.Add(time.Hour))
+		assert.Equal(t, []Point{
+			{Time: start, Count: 2, Increase: 5},
+			{Time: start.Add(time.Minute), Count: 1, Increase: 4},
+		}, got)
+	})
+}
+
+func TestBucketStart(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 34, 56, 0, time.UTC)
+
+	assert.Equal(t, time.Date(2024, 5, 1, 12, 34, 0, 0, time.UTC), BucketStart(at, time.Minute).UTC())
+	assert.Equal(t, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), BucketStart(at, time.Hour).UTC())
+	assert.Equal(t, time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC), BucketStart(at, 15*time.Minute).UTC())
+}
@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/sotavant/yandex-metrics/internal"
+)
+
+// Поля сортировки для ListValues
+const (
+	SortByID   = "id"
+	SortByType = "type"
+)
+
+// MetricKey ключ метрики, используется как курсор постраничного чтения
+type MetricKey struct {
+	MType string `json:"type"`
+	ID    string `json:"id"`
+}
+
+// ListOptions параметры постраничного чтения метрик.
+//
+// Порядок задается полем SortBy: по ID (при равенстве - по типу) или по типу (при равенстве - по ID),
+// поэтому он однозначен и страницы не пересекаются. After - ключ последней метрики предыдущей страницы.
+type ListOptions struct {
+	// MType тип метрик, пустая строка - все типы
+	MType string
+	// Prefix префикс ID
+	Prefix string
+	SortBy string
+	Desc   bool
+	After  *MetricKey
+	// Limit максимальный размер страницы, 0 - без ограничения
+	Limit int
+}
+
+// KeyOf возвращает ключ метрики
+func KeyOf(m internal.Metrics) MetricKey {
+	return MetricKey{MType: m.MType, ID: m.ID}
+}
+
+// Less сравнивает ключи в порядке сортировки опций
+func (o ListOptions) Less(a, b MetricKey) bool {
+	first, second := compare(a.ID, b.ID), compare(a.MType, b.MType)
+	if o.SortBy == SortByType {
+		first, second = second, first
+	}
+
+	res := first
+	if res == 0 {
+		res = second
+	}
+
+	if o.Desc {
+		return res > 0
+	}
+
+	return res < 0
+}
+
+// Match проверяет, что метрика подходит под фильтры типа и префикса
+func (o ListOptions) Match(m internal.Metrics) bool {
+	return (o.MType == "" || m.MType == o.MType) && strings.HasPrefix(m.ID, o.Prefix)
+}
+
+// ApplyListOptions выбирает страницу из полного списка метрик.
+// Используется хранилищами, которые держат все значения в памяти.
+func ApplyListOptions(metrics []internal.Metrics, opts ListOptions) []internal.Metrics {
+	res := make([]internal.Metrics, 0)
+
+	for _, m := range metrics {
+		if !opts.Match(m) {
+			continue
+		}
+
+		if opts.After != nil && !opts.Less(*opts.After, KeyOf(m)) {
+			continue
+		}
+
+		res = append(res, m)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return opts.Less(KeyOf(res[i]), KeyOf(res[j]))
+	})
+
+	if opts.Limit > 0 && len(res) > opts.Limit {
+		res = res[:opts.Limit]
+	}
+
+	return res
+}
+
+func compare(a, b string) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,228 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/server/repository"
+)
+
+// HistoryRepository добавляет хранение истории к любому in-memory хранилищу.
+//
+// Текущие значения хранятся во вложенном Storage, каждая успешная запись дополнительно
+// сохраняется как сырая точка ряда. История каждого ряда защищена собственной блокировкой,
+// поэтому запись в разные ряды не конкурирует.
+type HistoryRepository struct {
+	repository.Storage
+	tiers  []repository.RetentionTier
+	series sync.Map // map[seriesKey]*seriesHistory
+	now    func() time.Time
+}
+
+type seriesKey struct {
+	mType string
+	id    string
+}
+
+type seriesHistory struct {
+	mutex  sync.Mutex
+	points [][]repository.Point
+	// compacted граница, до которой точки предыдущего уровня уже свернуты в уровень i
+	compacted []time.Time
+	// removed ряд удален из карты при сжатии, запись нужно повторить в новый ряд
+	removed bool
+}
+
+// NewHistoryRepository оборачивает хранилище st, сохраняя историю по уровням tiers
+func NewHistoryRepository(st repository.Storage, tiers []repository.RetentionTier) *HistoryRepository {
+	return &HistoryRepository{
+		Storage: st,
+		tiers:   tiers,
+		now:     time.Now,
+	}
+}
+
+func (h *HistoryRepository) AddGaugeValue(ctx context.Context, key string, value float64) error {
+	return h.AddValues(ctx, []internal.Metrics{{ID: key, MType: internal.GaugeType, Value: &value}})
+}
+
+func (h *HistoryRepository) AddCounterValue(ctx context.Context, key string, value int64) error {
+	return h.AddValues(ctx, []internal.Metrics{{ID: key, MType: internal.CounterType, Delta: &value}})
+}
+
+func (h *HistoryRepository) AddValue(ctx context.Context, metric internal.Metrics) error {
+	return h.AddValues(ctx, []internal.Metrics{metric})
+}
+
+// AddValues сохраняет пакет во вложенное хранилище и, если запись прошла успешно, добавляет точки в историю
+func (h *HistoryRepository) AddValues(ctx context.Context, metrics []internal.Metrics) error {
+	if err := h.Storage.AddValues(ctx, metrics); err != nil {
+		return err
+	}
+
+	for _, m := range metrics {
+		key := seriesKey{m.MType, m.ID}
+		for added := false; !added; {
+			added = h.getSeries(key).add(m, h.now)
+		}
+	}
+
+	return nil
+}
+
+func (h *HistoryRepository) GetHistory(ctx context.Context, mType, key string, from, to time.Time) (repository.History, error) {
+	if mType != internal.GaugeType && mType != internal.CounterType {
+		return repository.History{}, repository.ErrUnknownType
+	}
+
+	exist, err := h.Storage.KeyExist(ctx, mType, key)
+	if err != nil {
+		return repository.History{}, err
+	}
+
+	if !exist {
+		return repository.History{}, repository.NewNotFoundError(mType, key)
+	}
+
+	idx := repository.SelectTier(h.tiers, h.now(), from)
+	res := repository.History{Tier: h.tiers[idx], Points: make([]repository.Point, 0)}
+
+	s, ok := h.series.Load(seriesKey{mType, key})
+	if !ok {
+		return res, nil
+	}
+
+	series := s.(*seriesHistory)
+	series.mutex.Lock()
+	defer series.mutex.Unlock()
+
+	start := from
+	if res.Tier.Resolution > 0 {
+		start = repository.BucketStart(from, res.Tier.Resolution)
+	}
+
+	for _, p := range series.points[idx] {
+		if !p.Time.Before(start) && !p.Time.After(to) {
+			res.Points = append(res.Points, p)
+		}
+	}
+
+	return res, nil
+}
+
+// Delete удаляет метрику вместе с ее историей
+func (h *HistoryRepository) Delete(ctx context.Context, mType, key string) error {
+	if err := h.Storage.Delete(ctx, mType, key); err != nil {
+		return err
+	}
+
+	h.removeSeries(seriesKey{mType, key})
+
+	return nil
+}
+
+// EvictStale удаляет устаревшие ряды вместе с их историей
+func (h *HistoryRepository) EvictStale(ctx context.Context, before time.Time) ([]repository.MetricKey, error) {
+	evicted, err := h.Storage.EvictStale(ctx, before)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, k := range evicted {
+		h.removeSeries(seriesKey{k.MType, k.ID})
+	}
+
+	return evicted, nil
+}
+
+func (h *HistoryRepository) removeSeries(key seriesKey) {
+	if s, ok := h.series.LoadAndDelete(key); ok {
+		series := s.(*seriesHistory)
+		series.mutex.Lock()
+		series.removed = true
+		series.mutex.Unlock()
+	}
+}
+
+// Compact сворачивает историю всех рядов. Ряды, в которых не осталось точек, удаляются.
+func (h *HistoryRepository) Compact(ctx context.Context, now time.Time) error {
+	h.series.Range(func(k, s any) bool {
+		if s.(*seriesHistory).compact(h.tiers, now) {
+			h.series.CompareAndDelete(k, s)
+		}
+
+		return ctx.Err() == nil
+	})
+
+	return ctx.Err()
+}
+
+func (h *HistoryRepository) getSeries(key seriesKey) *seriesHistory {
+	if s, ok := h.series.Load(key); ok {
+		return s.(*seriesHistory)
+	}
+
+	s, _ := h.series.LoadOrStore(key, &seriesHistory{
+		points:    make([][]repository.Point, len(h.tiers)),
+		compacted: make([]time.Time, len(h.tiers)),
+	})
+
+	return s.(*seriesHistory)
+}
+
+// add сохраняет сырую точку. Время берется под блокировкой ряда,
+// поэтому точка не может оказаться раньше уже выполненного сжатия.
+// Возвращает false, если ряд уже удален при сжатии.
+func (s *seriesHistory) add(m internal.Metrics, now func() time.Time) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.removed {
+		return false
+	}
+
+	s.points[0] = append(s.points[0], repository.NewPoint(m, now()))
+
+	return true
+}
+
+// compact сворачивает завершенные интервалы и удаляет устаревшие точки.
+// Возвращает true, если в ряду не осталось точек.
+func (s *seriesHistory) compact(tiers []repository.RetentionTier, now time.Time) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for i := 1; i < len(tiers); i++ {
+		until := repository.BucketStart(now, tiers[i].Resolution)
+		if !until.After(s.compacted[i]) {
+			continue
+		}
+
+		s.points[i] = append(s.points[i], repository.Rollup(s.points[i-1], tiers[i].Resolution, s.compacted[i], until)...)
+		s.compacted[i] = until
+	}
+
+	empty := true
+	for i, tier := range tiers {
+		s.points[i] = dropBefore(s.points[i], now.Add(-tier.Retention))
+		empty = empty && len(s.points[i]) == 0
+	}
+
+	s.removed = empty
+
+	return empty
+}
+
+func dropBefore(points []repository.Point, cutoff time.Time) []repository.Point {
+	res := make([]repository.Point, 0, len(points))
+
+	for _, p := range points {
+		if !p.Time.Before(cutoff) {
+			res = append(res, p)
+		}
+	}
+
+	return res
+}
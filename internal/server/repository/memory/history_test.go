@@ -0,0 +1,142 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/server/repository"
+	"github.com/sotavant/yandex-metrics/internal/server/repository/storagetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testTiers = []repository.RetentionTier{
+	{Resolution: 0, Retention: time.Hour},
+	{Resolution: time.Minute, Retention: 24 * time.Hour},
+	{Resolution: time.Hour, Retention: 30 * 24 * time.Hour},
+}
+
+// fakeClock управляемое время для проверки сжатия
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func newTestHistory() (*HistoryRepository, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	h := NewHistoryRepository(NewShardedMetricsRepository(DefaultShardsCount), testTiers)
+	h.now = clock.Now
+
+	return h, clock
+}
+
+func TestHistoryRepository_Conformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) repository.Storage {
+		return NewHistoryRepository(NewShardedMetricsRepository(DefaultShardsCount), testTiers)
+	})
+}
+
+func TestHistoryRepository_RawPoints(t *testing.T) {
+	ctx := context.Background()
+	h, clock := newTestHistory()
+	start := clock.now
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, h.AddGaugeValue(ctx, "g", float64(i)))
+		clock.now = clock.now.Add(10 * time.Second)
+	}
+
+	got, err := h.GetHistory(ctx, internal.GaugeType, "g", start, clock.now)
+	assert.NoError(t, err)
+	assert.Equal(t, testTiers[0], got.Tier)
+	assert.Len(t, got.Points, 3)
+	assert.Equal(t, 2.0, got.Points[2].Last)
+
+	_, err = h.GetHistory(ctx, internal.GaugeType, "absent", start, clock.now)
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+
+	_, err = h.GetHistory(ctx, "unknown", "g", start, clock.now)
+	assert.ErrorIs(t, err, repository.ErrUnknownType)
+}
+
+func TestHistoryRepository_Compact(t *testing.T) {
+	ctx := context.Background()
+	h, clock := newTestHistory()
+	start := clock.now
+
+	// по две записи в каждую из первых трех минут
+	for i := 0; i < 6; i++ {
+		require.NoError(t, h.AddGaugeValue(ctx, "g", float64(i)))
+		require.NoError(t, h.AddCounterValue(ctx, "c", 1))
+		clock.now = clock.now.Add(30 * time.Second)
+	}
+
+	require.NoError(t, h.Compact(ctx, start.Add(150*time.Second)))
+	// повторное сжатие не должно дублировать агрегаты
+	require.NoError(t, h.Compact(ctx, start.Add(170*time.Second)))
+
+	// запрос за прошлые сутки обслуживает минутный уровень
+	clock.now = start.Add(2 * time.Hour)
+	got, err := h.GetHistory(ctx, internal.GaugeType, "g", start, clock.now)
+	assert.NoError(t, err)
+	assert.Equal(t, testTiers[1], got.Tier)
+	assert.Equal(t, []repository.Point{
+		{Time: start, Count: 2, Min: 0, Max: 1, Sum: 1, Last: 1},
+		{Time: start.Add(time.Minute), Count: 2, Min: 2, Max: 3, Sum: 5, Last: 3},
+	}, got.Points)
+
+	counter, err := h.GetHistory(ctx, internal.CounterType, "c", start, clock.now)
+	assert.NoError(t, err)
+	assert.Equal(t, []repository.Point{
+		{Time: start, Count: 2, Increase: 2},
+		{Time: start.Add(time.Minute), Count: 2, Increase: 2},
+	}, counter.Points)
+
+	// через два часа сырые точки удалены, оставшаяся минута свернута, все минуты свернуты в часовой агрегат
+	require.NoError(t, h.Compact(ctx, clock.now))
+
+	got, err = h.GetHistory(ctx, internal.GaugeType, "g", start, clock.now)
+	assert.NoError(t, err)
+	assert.Len(t, got.Points, 3)
+
+	raw, err := h.GetHistory(ctx, internal.GaugeType, "g", clock.now.Add(-time.Minute), clock.now)
+	assert.NoError(t, err)
+	assert.Equal(t, testTiers[0], raw.Tier)
+	assert.Empty(t, raw.Points)
+
+	clock.now = start.Add(7 * 24 * time.Hour)
+	require.NoError(t, h.Compact(ctx, clock.now))
+
+	hourly, err := h.GetHistory(ctx, internal.GaugeType, "g", start, clock.now)
+	assert.NoError(t, err)
+	assert.Equal(t, testTiers[2], hourly.Tier)
+	assert.Equal(t, []repository.Point{
+		{Time: start, Count: 6, Min: 0, Max: 5, Sum: 15, Last: 5},
+	}, hourly.Points)
+}
+
+func TestHistoryRepository_ExpiredSeriesRemoved(t *testing.T) {
+	ctx := context.Background()
+	h, clock := newTestHistory()
+
+	require.NoError(t, h.AddGaugeValue(ctx, "g", 1))
+	require.NoError(t, h.Compact(ctx, clock.now.Add(365*24*time.Hour)))
+
+	_, ok := h.series.Load(seriesKey{internal.GaugeType, "g"})
+	assert.False(t, ok)
+
+	// текущее значение не зависит от истории
+	val, err := h.GetGaugeValue(ctx, "g")
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, val)
+
+	require.NoError(t, h.AddGaugeValue(ctx, "g", 2))
+	got, err := h.GetHistory(ctx, internal.GaugeType, "g", clock.now, clock.now)
+	assert.NoError(t, err)
+	assert.Len(t, got.Points, 1)
+}
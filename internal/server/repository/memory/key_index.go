@@ -0,0 +1,103 @@
+package memory
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/server/repository"
+)
+
+// keyIndex отсортированные ключи рядов для постраничного чтения. Список перестраивается,
+// только если с прошлого чтения ряды добавлялись или удалялись, поэтому чтение всех страниц подряд
+// сортирует хранилище один раз.
+type keyIndex struct {
+	// version увеличивается после каждого добавления или удаления ряда
+	version atomic.Uint64
+
+	mu sync.Mutex
+	// built списки по полю сортировки, всегда по возрастанию
+	built map[string]sortedKeys
+}
+
+type sortedKeys struct {
+	version uint64
+	keys    []repository.MetricKey
+}
+
+func newKeyIndex() *keyIndex {
+	return &keyIndex{built: make(map[string]sortedKeys)}
+}
+
+// changed отмечает, что набор рядов изменился. Вызывается после изменения, чтобы перестроенный
+// после нового номера версии список уже содержал изменение.
+func (ix *keyIndex) changed() {
+	ix.version.Add(1)
+}
+
+// sorted ключи, отсортированные по возрастанию в порядке sortBy. Возвращаемый срез общий, менять его нельзя.
+func (ix *keyIndex) sorted(sortBy string, all func() []repository.MetricKey) []repository.MetricKey {
+	if sortBy != repository.SortByType {
+		sortBy = repository.SortByID
+	}
+
+	version := ix.version.Load()
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	if s, ok := ix.built[sortBy]; ok && s.version == version {
+		return s.keys
+	}
+
+	keys := all()
+	opts := repository.ListOptions{SortBy: sortBy}
+	sort.Slice(keys, func(i, j int) bool {
+		return opts.Less(keys[i], keys[j])
+	})
+
+	ix.built[sortBy] = sortedKeys{version: version, keys: keys}
+
+	return keys
+}
+
+// page метрики страницы по отсортированному списку ключей: после курсора, в нужном направлении.
+// load возвращает метрику по ключу или false, если ряд уже удален, такие ключи пропускаются.
+func page(keys []repository.MetricKey, opts repository.ListOptions, load func(repository.MetricKey) (internal.Metrics, bool)) []internal.Metrics {
+	asc := repository.ListOptions{SortBy: opts.SortBy}
+
+	i, step, end := 0, 1, len(keys)
+	if opts.Desc {
+		i, step, end = len(keys)-1, -1, -1
+	}
+
+	if opts.After != nil {
+		// первый ключ больше курсора по возрастанию
+		i = sort.Search(len(keys), func(j int) bool {
+			return asc.Less(*opts.After, keys[j])
+		})
+
+		if opts.Desc {
+			// последний ключ меньше курсора
+			i = sort.Search(len(keys), func(j int) bool {
+				return !asc.Less(keys[j], *opts.After)
+			}) - 1
+		}
+	}
+
+	res := make([]internal.Metrics, 0)
+	for ; i != end && (opts.Limit == 0 || len(res) < opts.Limit); i += step {
+		key := keys[i]
+		if (opts.MType != "" && key.MType != opts.MType) || !strings.HasPrefix(key.ID, opts.Prefix) {
+			continue
+		}
+
+		if m, ok := load(key); ok {
+			res = append(res, m)
+		}
+	}
+
+	return res
+}
@@ -2,22 +2,26 @@ package memory
 
 import (
 	"context"
-	"fmt"
 	"sync"
+	"time"
 
 	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/server/repository"
 )
 
 type MetricsRepository struct {
 	Gauge   map[string]float64
 	Counter map[string]int64
 	mutex   sync.RWMutex
+	// updated время последней записи ряда, создается при первой записи
+	updated map[repository.MetricKey]time.Time
 }
 
 func (m *MetricsRepository) AddGaugeValue(ctx context.Context, key string, value float64) error {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 	m.Gauge[key] = value
+	m.touch(internal.GaugeType, key, time.Now())
 
 	return nil
 }
@@ -26,33 +30,39 @@ func (m *MetricsRepository) AddCounterValue(ctx context.Context, key string, val
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 	m.Counter[key] += value
+	m.touch(internal.CounterType, key, time.Now())
 
 	return nil
 }
 
 func (m *MetricsRepository) AddValue(ctx context.Context, metric internal.Metrics) error {
-	var err error
-
-	switch metric.MType {
-	case internal.GaugeType:
-		err = m.AddGaugeValue(ctx, metric.ID, *metric.Value)
-	case internal.CounterType:
-		err = m.AddCounterValue(ctx, metric.ID, *metric.Delta)
-	default:
-		err = fmt.Errorf("undefinde type: %s", metric.MType)
-	}
-
-	return err
+	return m.AddValues(ctx, []internal.Metrics{metric})
 }
 
+// AddValues сохраняет пакет метрик. Пакет проверяется целиком до записи,
+// а запись выполняется под одной блокировкой, поэтому пакет применяется атомарно.
 func (m *MetricsRepository) AddValues(ctx context.Context, metrics []internal.Metrics) error {
 	for _, v := range metrics {
-		err := m.AddValue(ctx, v)
-		if err != nil {
+		if err := repository.ValidateMetric(v); err != nil {
 			return err
 		}
 	}
 
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	for _, v := range metrics {
+		switch v.MType {
+		case internal.GaugeType:
+			m.Gauge[v.ID] = *v.Value
+		case internal.CounterType:
+			m.Counter[v.ID] += *v.Delta
+		}
+
+		m.touch(v.MType, v.ID, now)
+	}
+
 	return nil
 }
 
@@ -71,31 +81,35 @@ func (m *MetricsRepository) GetValue(ctx context.Context, mType, key string) (in
 		if ok {
 			return val, nil
 		}
+	default:
+		return nil, repository.ErrUnknownType
 	}
 
-	return nil, nil
+	return nil, repository.NewNotFoundError(mType, key)
 }
 
 func (m *MetricsRepository) GetValues(ctx context.Context) ([]internal.Metrics, error) {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
 
 	metrics := make([]internal.Metrics, 0, len(m.Gauge)+len(m.Counter))
 
 	for k, v := range m.Gauge {
+		value := v
 		metrics = append(metrics, internal.Metrics{
 			ID:    k,
 			MType: internal.GaugeType,
 			Delta: nil,
-			Value: &v,
+			Value: &value,
 		})
 	}
 
 	for k, v := range m.Counter {
+		delta := v
 		metrics = append(metrics, internal.Metrics{
 			ID:    k,
 			MType: internal.CounterType,
-			Delta: &v,
+			Delta: &delta,
 			Value: nil,
 		})
 	}
@@ -103,6 +117,16 @@ func (m *MetricsRepository) GetValues(ctx context.Context) ([]internal.Metrics,
 	return metrics, nil
 }
 
+// ListValues возвращает страницу метрик, отбирая ее из полного списка
+func (m *MetricsRepository) ListValues(ctx context.Context, opts repository.ListOptions) ([]internal.Metrics, error) {
+	metrics, err := m.GetValues(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return repository.ApplyListOptions(metrics, opts), nil
+}
+
 func (m *MetricsRepository) KeyExist(ctx context.Context, mType, key string) (bool, error) {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
@@ -123,32 +147,164 @@ func (m *MetricsRepository) KeyExist(ctx context.Context, mType, key string) (bo
 	return false, nil
 }
 
+// GetGauge возвращает копию значений gauge
 func (m *MetricsRepository) GetGauge(ctx context.Context) (map[string]float64, error) {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 
-	return m.Gauge, nil
+	res := make(map[string]float64, len(m.Gauge))
+	for k, v := range m.Gauge {
+		res[k] = v
+	}
+
+	return res, nil
 }
 
 func (m *MetricsRepository) GetGaugeValue(ctx context.Context, key string) (float64, error) {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 
-	return m.Gauge[key], nil
+	val, ok := m.Gauge[key]
+	if !ok {
+		return 0, repository.NewNotFoundError(internal.GaugeType, key)
+	}
+
+	return val, nil
 }
 
+// GetCounters возвращает копию значений counter
 func (m *MetricsRepository) GetCounters(ctx context.Context) (map[string]int64, error) {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 
-	return m.Counter, nil
+	res := make(map[string]int64, len(m.Counter))
+	for k, v := range m.Counter {
+		res[k] = v
+	}
+
+	return res, nil
 }
 
 func (m *MetricsRepository) GetCounterValue(ctx context.Context, key string) (int64, error) {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 
-	return m.Counter[key], nil
+	val, ok := m.Counter[key]
+	if !ok {
+		return 0, repository.NewNotFoundError(internal.CounterType, key)
+	}
+
+	return val, nil
+}
+
+// Delete удаляет метрику
+func (m *MetricsRepository) Delete(ctx context.Context, mType, key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	switch mType {
+	case internal.GaugeType:
+		if _, ok := m.Gauge[key]; ok {
+			delete(m.Gauge, key)
+			delete(m.updated, repository.MetricKey{MType: mType, ID: key})
+			return nil
+		}
+	case internal.CounterType:
+		if _, ok := m.Counter[key]; ok {
+			delete(m.Counter, key)
+			delete(m.updated, repository.MetricKey{MType: mType, ID: key})
+			return nil
+		}
+	default:
+		return repository.ErrUnknownType
+	}
+
+	return repository.NewNotFoundError(mType, key)
+}
+
+// ResetCounter обнуляет счетчик
+func (m *MetricsRepository) ResetCounter(ctx context.Context, key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.Counter[key]; !ok {
+		return repository.NewNotFoundError(internal.CounterType, key)
+	}
+
+	m.Counter[key] = 0
+
+	return nil
+}
+
+// LastUpdated возвращает время последней записи рядов
+func (m *MetricsRepository) LastUpdated(ctx context.Context, keys []repository.MetricKey) (map[repository.MetricKey]time.Time, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if keys == nil {
+		res := make(map[repository.MetricKey]time.Time, len(m.updated))
+		for k, v := range m.updated {
+			res[k] = v
+		}
+
+		return res, nil
+	}
+
+	res := make(map[repository.MetricKey]time.Time, len(keys))
+	for _, k := range keys {
+		if at, ok := m.updated[k]; ok {
+			res[k] = at
+		}
+	}
+
+	return res, nil
+}
+
+// EvictStale удаляет ряды, не обновлявшиеся с момента before
+func (m *MetricsRepository) EvictStale(ctx context.Context, before time.Time) ([]repository.MetricKey, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	evicted := make([]repository.MetricKey, 0)
+	for k, at := range m.updated {
+		if !at.Before(before) {
+			continue
+		}
+
+		if k.MType == internal.GaugeType {
+			delete(m.Gauge, k.ID)
+		} else {
+			delete(m.Counter, k.ID)
+		}
+
+		delete(m.updated, k)
+		evicted = append(evicted, k)
+	}
+
+	return evicted, nil
+}
+
+// SetUpdatedAt задает время обновления существующего ряда
+func (m *MetricsRepository) SetUpdatedAt(ctx context.Context, key repository.MetricKey, at time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.updated[key]; !ok {
+		return repository.NewNotFoundError(key.MType, key.ID)
+	}
+
+	m.updated[key] = at
+
+	return nil
+}
+
+// touch обновляет время записи ряда, вызывается под блокировкой
+func (m *MetricsRepository) touch(mType, key string, at time.Time) {
+	if m.updated == nil {
+		m.updated = make(map[repository.MetricKey]time.Time)
+	}
+
+	m.updated[repository.MetricKey{MType: mType, ID: key}] = at
 }
 
 func NewMetricsRepository() *MetricsRepository {
@@ -0,0 +1,501 @@
+package memory
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/server/repository"
+)
+
+// DefaultShardsCount количество шардов по-умолчанию
+const DefaultShardsCount = 32
+
+// ShardedMetricsRepository in-memory хранилище, разбитое на шарды по ID метрики.
+//
+// Каждый шард хранит отдельные sync.Map для gauge и counter, значения в которых - атомарные ячейки.
+// Чтение выполняется без блокировок, обновление существующей метрики берет блокировку ячейки
+// только на чтение (записи не мешают друг другу, ее ждет лишь вытеснение),
+// внутренняя блокировка sync.Map затрагивает только создание новой метрики в одном шарде.
+// Ячейка хранит и время последней записи (unix-наносекунды).
+type ShardedMetricsRepository struct {
+	shards []*shard
+	// index отсортированные ключи для ListValues
+	index *keyIndex
+}
+
+// cellState время записи и признак вытесненной ячейки. Запись держит mu на чтение, поэтому
+// записи в одну ячейку не блокируют друг друга, а вытеснение ждет начатые записи.
+// Запись, попавшая в вытесненную ячейку, повторяется в новой.
+type cellState struct {
+	updated atomic.Int64
+	mu      sync.RWMutex
+	dead    bool
+}
+
+type gaugeCell struct {
+	cellState
+	bits atomic.Uint64
+}
+
+type counterCell struct {
+	cellState
+	value atomic.Int64
+}
+
+type shard struct {
+	gauge   sync.Map // map[string]*gaugeCell
+	counter sync.Map // map[string]*counterCell
+}
+
+// NewShardedMetricsRepository создает хранилище с заданным количеством шардов.
+// Если shardsCount не положительный, используется DefaultShardsCount.
+func NewShardedMetricsRepository(shardsCount int) *ShardedMetricsRepository {
+	if shardsCount <= 0 {
+		shardsCount = DefaultShardsCount
+	}
+
+	m := &ShardedMetricsRepository{
+		shards: make([]*shard, shardsCount),
+		index:  newKeyIndex(),
+	}
+
+	for i := range m.shards {
+		m.shards[i] = &shard{}
+	}
+
+	return m
+}
+
+func (m *ShardedMetricsRepository) AddGaugeValue(ctx context.Context, key string, value float64) error {
+	if m.getShard(key).storeGauge(key, value) {
+		m.index.changed()
+	}
+
+	return nil
+}
+
+func (m *ShardedMetricsRepository) AddCounterValue(ctx context.Context, key string, value int64) error {
+	if m.getShard(key).addCounter(key, value) {
+		m.index.changed()
+	}
+
+	return nil
+}
+
+func (m *ShardedMetricsRepository) AddValue(ctx context.Context, metric internal.Metrics) error {
+	return m.AddValues(ctx, []internal.Metrics{metric})
+}
+
+// AddValues сохраняет пакет метрик. Пакет проверяется целиком до записи,
+// поэтому при ошибке не сохраняется ни одна метрика.
+func (m *ShardedMetricsRepository) AddValues(ctx context.Context, metrics []internal.Metrics) error {
+	for _, v := range metrics {
+		if err := repository.ValidateMetric(v); err != nil {
+			return err
+		}
+	}
+
+	for _, v := range metrics {
+		if v.MType == internal.GaugeType {
+			_ = m.AddGaugeValue(ctx, v.ID, *v.Value)
+		} else {
+			_ = m.AddCounterValue(ctx, v.ID, *v.Delta)
+		}
+	}
+
+	return nil
+}
+
+func (m *ShardedMetricsRepository) GetValue(ctx context.Context, mType, key string) (interface{}, error) {
+	switch mType {
+	case internal.GaugeType:
+		if cell, ok := m.getShard(key).gauge.Load(key); ok {
+			return math.Float64frombits(cell.(*gaugeCell).bits.Load()), nil
+		}
+	case internal.CounterType:
+		if cell, ok := m.getShard(key).counter.Load(key); ok {
+			return cell.(*counterCell).value.Load(), nil
+		}
+	default:
+		return nil, repository.ErrUnknownType
+	}
+
+	return nil, repository.NewNotFoundError(mType, key)
+}
+
+func (m *ShardedMetricsRepository) GetValues(ctx context.Context) ([]internal.Metrics, error) {
+	gauge, _ := m.GetGauge(ctx)
+	counter, _ := m.GetCounters(ctx)
+	metrics := make([]internal.Metrics, 0, len(gauge)+len(counter))
+
+	for k, v := range gauge {
+		value := v
+		metrics = append(metrics, internal.Metrics{
+			ID:    k,
+			MType: internal.GaugeType,
+			Value: &value,
+		})
+	}
+
+	for k, v := range counter {
+		delta := v
+		metrics = append(metrics, internal.Metrics{
+			ID:    k,
+			MType: internal.CounterType,
+			Delta: &delta,
+		})
+	}
+
+	return metrics, nil
+}
+
+// ListValues возвращает страницу метрик по отсортированному списку ключей, который перестраивается
+// только при добавлении и удалении рядов
+func (m *ShardedMetricsRepository) ListValues(ctx context.Context, opts repository.ListOptions) ([]internal.Metrics, error) {
+	keys := m.index.sorted(opts.SortBy, m.keys)
+
+	return page(keys, opts, m.load), nil
+}
+
+// keys ключи всех рядов без сортировки
+func (m *ShardedMetricsRepository) keys() []repository.MetricKey {
+	res := make([]repository.MetricKey, 0)
+
+	for _, s := range m.shards {
+		s.gauge.Range(func(k, _ any) bool {
+			res = append(res, repository.MetricKey{MType: internal.GaugeType, ID: k.(string)})
+			return true
+		})
+		s.counter.Range(func(k, _ any) bool {
+			res = append(res, repository.MetricKey{MType: internal.CounterType, ID: k.(string)})
+			return true
+		})
+	}
+
+	return res
+}
+
+// load метрика по ключу, false - ряда нет
+func (m *ShardedMetricsRepository) load(key repository.MetricKey) (internal.Metrics, bool) {
+	res := internal.Metrics{ID: key.ID, MType: key.MType}
+
+	switch key.MType {
+	case internal.GaugeType:
+		cell, ok := m.getShard(key.ID).gauge.Load(key.ID)
+		if !ok {
+			return res, false
+		}
+
+		value := math.Float64frombits(cell.(*gaugeCell).bits.Load())
+		res.Value = &value
+	case internal.CounterType:
+		cell, ok := m.getShard(key.ID).counter.Load(key.ID)
+		if !ok {
+			return res, false
+		}
+
+		delta := cell.(*counterCell).value.Load()
+		res.Delta = &delta
+	default:
+		return res, false
+	}
+
+	return res, true
+}
+
+func (m *ShardedMetricsRepository) KeyExist(ctx context.Context, mType, key string) (bool, error) {
+	var ok bool
+
+	switch mType {
+	case internal.GaugeType:
+		_, ok = m.getShard(key).gauge.Load(key)
+	case internal.CounterType:
+		_, ok = m.getShard(key).counter.Load(key)
+	}
+
+	return ok, nil
+}
+
+// GetGauge возвращает копию значений gauge
+func (m *ShardedMetricsRepository) GetGauge(ctx context.Context) (map[string]float64, error) {
+	res := make(map[string]float64)
+
+	for _, s := range m.shards {
+		s.gauge.Range(func(k, cell any) bool {
+			res[k.(string)] = math.Float64frombits(cell.(*gaugeCell).bits.Load())
+			return true
+		})
+	}
+
+	return res, nil
+}
+
+func (m *ShardedMetricsRepository) GetGaugeValue(ctx context.Context, key string) (float64, error) {
+	val, err := m.GetValue(ctx, internal.GaugeType, key)
+	if err != nil {
+		return 0, err
+	}
+
+	return val.(float64), nil
+}
+
+// GetCounters возвращает копию значений counter
+func (m *ShardedMetricsRepository) GetCounters(ctx context.Context) (map[string]int64, error) {
+	res := make(map[string]int64)
+
+	for _, s := range m.shards {
+		s.counter.Range(func(k, cell any) bool {
+			res[k.(string)] = cell.(*counterCell).value.Load()
+			return true
+		})
+	}
+
+	return res, nil
+}
+
+func (m *ShardedMetricsRepository) GetCounterValue(ctx context.Context, key string) (int64, error) {
+	val, err := m.GetValue(ctx, internal.CounterType, key)
+	if err != nil {
+		return 0, err
+	}
+
+	return val.(int64), nil
+}
+
+// Delete удаляет метрику
+func (m *ShardedMetricsRepository) Delete(ctx context.Context, mType, key string) error {
+	var ok bool
+
+	switch mType {
+	case internal.GaugeType:
+		_, ok = m.getShard(key).gauge.LoadAndDelete(key)
+	case internal.CounterType:
+		_, ok = m.getShard(key).counter.LoadAndDelete(key)
+	default:
+		return repository.ErrUnknownType
+	}
+
+	if !ok {
+		return repository.NewNotFoundError(mType, key)
+	}
+
+	m.index.changed()
+
+	return nil
+}
+
+// ResetCounter обнуляет счетчик
+func (m *ShardedMetricsRepository) ResetCounter(ctx context.Context, key string) error {
+	cell, ok := m.getShard(key).counter.Load(key)
+	if !ok {
+		return repository.NewNotFoundError(internal.CounterType, key)
+	}
+
+	cell.(*counterCell).value.Store(0)
+
+	return nil
+}
+
+// LastUpdated возвращает время последней записи рядов
+func (m *ShardedMetricsRepository) LastUpdated(ctx context.Context, keys []repository.MetricKey) (map[repository.MetricKey]time.Time, error) {
+	res := make(map[repository.MetricKey]time.Time)
+
+	if keys != nil {
+		for _, k := range keys {
+			if updated := m.getUpdated(k); updated != nil {
+				res[k] = time.Unix(0, updated.Load())
+			}
+		}
+
+		return res, nil
+	}
+
+	for _, s := range m.shards {
+		s.gauge.Range(func(k, cell any) bool {
+			res[repository.MetricKey{MType: internal.GaugeType, ID: k.(string)}] = time.Unix(0, cell.(*gaugeCell).updated.Load())
+			return true
+		})
+		s.counter.Range(func(k, cell any) bool {
+			res[repository.MetricKey{MType: internal.CounterType, ID: k.(string)}] = time.Unix(0, cell.(*counterCell).updated.Load())
+			return true
+		})
+	}
+
+	return res, nil
+}
+
+// EvictStale удаляет ряды, не обновлявшиеся с момента before.
+// Ячейка помечается вытесненной под блокировкой, поэтому параллельная запись в нее не теряется,
+// а создает ряд заново.
+func (m *ShardedMetricsRepository) EvictStale(ctx context.Context, before time.Time) ([]repository.MetricKey, error) {
+	evicted := make([]repository.MetricKey, 0)
+	m.evictStale(before.UnixNano(), func(key repository.MetricKey, _ any) {
+		evicted = append(evicted, key)
+	})
+
+	if len(evicted) > 0 {
+		m.index.changed()
+	}
+
+	return evicted, nil
+}
+
+// evictStale удаляет ячейки, не обновлявшиеся с threshold, и передает их в evicted
+func (m *ShardedMetricsRepository) evictStale(threshold int64, evicted func(key repository.MetricKey, cell any)) {
+	for _, s := range m.shards {
+		s.gauge.Range(func(k, cell any) bool {
+			if cell.(*gaugeCell).evict(threshold, func() bool { return s.gauge.CompareAndDelete(k, cell) }) {
+				evicted(repository.MetricKey{MType: internal.GaugeType, ID: k.(string)}, cell)
+			}
+			return true
+		})
+		s.counter.Range(func(k, cell any) bool {
+			if cell.(*counterCell).evict(threshold, func() bool { return s.counter.CompareAndDelete(k, cell) }) {
+				evicted(repository.MetricKey{MType: internal.CounterType, ID: k.(string)}, cell)
+			}
+			return true
+		})
+	}
+}
+
+// SetUpdatedAt задает время обновления существующего ряда
+func (m *ShardedMetricsRepository) SetUpdatedAt(ctx context.Context, key repository.MetricKey, at time.Time) error {
+	updated := m.getUpdated(key)
+	if updated == nil {
+		return repository.NewNotFoundError(key.MType, key.ID)
+	}
+
+	updated.Store(at.UnixNano())
+
+	return nil
+}
+
+// getUpdated возвращает поле времени записи ячейки или nil, если ряда нет
+func (m *ShardedMetricsRepository) getUpdated(key repository.MetricKey) *atomic.Int64 {
+	switch key.MType {
+	case internal.GaugeType:
+		if cell, ok := m.getShard(key.ID).gauge.Load(key.ID); ok {
+			return &cell.(*gaugeCell).updated
+		}
+	case internal.CounterType:
+		if cell, ok := m.getShard(key.ID).counter.Load(key.ID); ok {
+			return &cell.(*counterCell).updated
+		}
+	}
+
+	return nil
+}
+
+func (m *ShardedMetricsRepository) getShard(key string) *shard {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+
+	return m.shards[h.Sum32()%uint32(len(m.shards))]
+}
+
+// storeGauge записывает значение gauge. Новая ячейка публикуется уже с записанным значением.
+// Возвращает true, если ряд создан.
+func (s *shard) storeGauge(key string, value float64) bool {
+	bits := math.Float64bits(value)
+	now := time.Now().UnixNano()
+
+	for {
+		if cell, ok := s.gauge.Load(key); ok {
+			if cell.(*gaugeCell).store(bits, now) {
+				return false
+			}
+
+			// ячейку вытеснили, она уже удалена из шарда
+			continue
+		}
+
+		cell := &gaugeCell{}
+		cell.store(bits, now)
+		existing, loaded := s.gauge.LoadOrStore(key, cell)
+		if !loaded {
+			return true
+		}
+
+		if existing.(*gaugeCell).store(bits, now) {
+			return false
+		}
+	}
+}
+
+// addCounter увеличивает счетчик. Новая ячейка публикуется уже с начальным значением.
+// Возвращает true, если ряд создан.
+func (s *shard) addCounter(key string, delta int64) bool {
+	now := time.Now().UnixNano()
+
+	for {
+		if cell, ok := s.counter.Load(key); ok {
+			if cell.(*counterCell).add(delta, now) {
+				return false
+			}
+
+			// ячейку вытеснили, она уже удалена из шарда
+			continue
+		}
+
+		cell := &counterCell{}
+		cell.add(delta, now)
+		existing, loaded := s.counter.LoadOrStore(key, cell)
+		if !loaded {
+			return true
+		}
+
+		if existing.(*counterCell).add(delta, now) {
+			return false
+		}
+	}
+}
+
+// store записывает значение, false - ячейка вытеснена и запись не выполнена
+func (c *gaugeCell) store(bits uint64, now int64) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.dead {
+		return false
+	}
+
+	c.bits.Store(bits)
+	c.updated.Store(now)
+
+	return true
+}
+
+// add увеличивает счетчик, false - ячейка вытеснена и запись не выполнена
+func (c *counterCell) add(delta int64, now int64) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.dead {
+		return false
+	}
+
+	c.value.Add(delta)
+	c.updated.Store(now)
+
+	return true
+}
+
+// evict помечает ячейку вытесненной, если она не обновлялась с threshold и remove удалил ее из шарда.
+// Удаление выполняется под блокировкой: запись, которая увидит dead, уже не найдет ячейку в шарде.
+func (c *cellState) evict(threshold int64, remove func() bool) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.dead || c.updated.Load() >= threshold || !remove() {
+		return false
+	}
+
+	c.dead = true
+
+	return true
+}
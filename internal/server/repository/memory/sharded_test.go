@@ -0,0 +1,222 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/server/repository"
+	"github.com/sotavant/yandex-metrics/internal/server/repository/storagetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	benchWriters = 1000
+	benchSeries  = 100
+)
+
+func TestShardedMetricsRepository_Conformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) repository.Storage {
+		return NewShardedMetricsRepository(DefaultShardsCount)
+	})
+}
+
+func TestShardedMetricsRepository_SingleShard(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) repository.Storage {
+		return NewShardedMetricsRepository(1)
+	})
+}
+
+func TestShardedMetricsRepository_ListAfterChanges(t *testing.T) {
+	ctx := context.Background()
+	st := NewShardedMetricsRepository(DefaultShardsCount)
+
+	ids := func() []string {
+		metrics, err := st.ListValues(ctx, repository.ListOptions{})
+		assert.NoError(t, err)
+
+		res := make([]string, 0, len(metrics))
+		for _, m := range metrics {
+			res = append(res, m.ID)
+		}
+
+		return res
+	}
+
+	assert.NoError(t, st.AddGaugeValue(ctx, "b", 1))
+	assert.NoError(t, st.AddCounterValue(ctx, "a", 1))
+	assert.Equal(t, []string{"a", "b"}, ids())
+
+	// отсортированный список перестраивается после добавления и удаления рядов
+	assert.NoError(t, st.AddGaugeValue(ctx, "c", 1))
+	assert.Equal(t, []string{"a", "b", "c"}, ids())
+
+	assert.NoError(t, st.Delete(ctx, internal.GaugeType, "b"))
+	assert.Equal(t, []string{"a", "c"}, ids())
+
+	// обновление значения не меняет набор рядов, но читается новое значение
+	assert.NoError(t, st.AddGaugeValue(ctx, "c", 5))
+	metrics, err := st.ListValues(ctx, repository.ListOptions{MType: internal.GaugeType})
+	assert.NoError(t, err)
+	require.Len(t, metrics, 1)
+	assert.Equal(t, 5.0, *metrics[0].Value)
+}
+
+func TestShardedMetricsRepository_EvictWhileWriting(t *testing.T) {
+	const (
+		writers = 8
+		adds    = 2000
+	)
+
+	ctx := context.Background()
+	st := NewShardedMetricsRepository(1)
+
+	// запись, загрузившая ячейку до вытеснения, не попадает в нее, а создает ряд заново
+	assert.NoError(t, st.AddCounterValue(ctx, "requests", 1))
+	loaded, _ := st.shards[0].counter.Load("requests")
+	st.evictStale(math.MaxInt64, func(repository.MetricKey, any) {})
+	assert.False(t, loaded.(*counterCell).add(1, 0))
+
+	assert.NoError(t, st.AddCounterValue(ctx, "requests", 1))
+	value, err := st.GetCounterValue(ctx, "requests")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), value)
+	st.evictStale(math.MaxInt64, func(repository.MetricKey, any) {})
+
+	type evictedCell struct {
+		cell  *counterCell
+		value int64
+	}
+	var evicted []evictedCell
+
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < adds; j++ {
+				assert.NoError(t, st.AddCounterValue(ctx, "requests", 1))
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	// вытесняем все ряды, пока идут записи
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+
+		st.evictStale(math.MaxInt64, func(_ repository.MetricKey, cell any) {
+			c := cell.(*counterCell)
+			evicted = append(evicted, evictedCell{cell: c, value: c.value.Load()})
+		})
+	}
+
+	total, err := st.GetCounterValue(ctx, "requests")
+	if errors.Is(err, repository.ErrNotFound) {
+		total = 0
+	}
+
+	for _, e := range evicted {
+		// после вытеснения в ячейку ничего не записывается
+		assert.Equal(t, e.value, e.cell.value.Load())
+		total += e.value
+	}
+
+	assert.Equal(t, int64(writers*adds), total)
+}
+
+func BenchmarkMetricsRepository_ConcurrentWriters(b *testing.B) {
+	benchmarkConcurrentWriters(b, NewMetricsRepository())
+}
+
+func BenchmarkShardedMetricsRepository_ConcurrentWriters(b *testing.B) {
+	benchmarkConcurrentWriters(b, NewShardedMetricsRepository(DefaultShardsCount))
+}
+
+func BenchmarkMetricsRepository_ConcurrentReadWrite(b *testing.B) {
+	benchmarkConcurrentReadWrite(b, NewMetricsRepository())
+}
+
+func BenchmarkShardedMetricsRepository_ConcurrentReadWrite(b *testing.B) {
+	benchmarkConcurrentReadWrite(b, NewShardedMetricsRepository(DefaultShardsCount))
+}
+
+// benchmarkConcurrentWriters распределяет b.N обновлений между benchWriters горутинами,
+// каждая из которых пишет в свой набор метрик
+func benchmarkConcurrentWriters(b *testing.B, st repository.Storage) {
+	ctx := context.Background()
+	ids := benchIDs()
+	b.ResetTimer()
+
+	var wg sync.WaitGroup
+	for w := 0; w < benchWriters; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < b.N; i += benchWriters {
+				id := ids[(w+i)%len(ids)]
+				if i%2 == 0 {
+					assert.NoError(b, st.AddCounterValue(ctx, id, 1))
+				} else {
+					assert.NoError(b, st.AddGaugeValue(ctx, id, float64(i)))
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+}
+
+// benchmarkConcurrentReadWrite половина горутин пишет, половина читает отдельные значения и снимки
+func benchmarkConcurrentReadWrite(b *testing.B, st repository.Storage) {
+	ctx := context.Background()
+	ids := benchIDs()
+	for _, id := range ids {
+		assert.NoError(b, st.AddCounterValue(ctx, id, 1))
+	}
+	b.ResetTimer()
+
+	var wg sync.WaitGroup
+	for w := 0; w < benchWriters; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < b.N; i += benchWriters {
+				id := ids[(w+i)%len(ids)]
+				switch {
+				case w%2 == 0:
+					assert.NoError(b, st.AddCounterValue(ctx, id, 1))
+				case i%100 == 1:
+					_, err := st.GetValues(ctx)
+					assert.NoError(b, err)
+				default:
+					_, err := st.GetCounterValue(ctx, id)
+					assert.NoError(b, err)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+}
+
+func benchIDs() []string {
+	ids := make([]string, benchSeries)
+	for i := range ids {
+		ids[i] = "metric" + strconv.Itoa(i)
+	}
+
+	return ids
+}
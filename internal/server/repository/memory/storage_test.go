@@ -6,6 +6,8 @@ import (
 	"testing"
 
 	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/server/repository"
+	"github.com/sotavant/yandex-metrics/internal/server/repository/storagetest"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -165,6 +167,12 @@ func TestMemStorage_AddValue(t *testing.T) {
 	}
 }
 
+func TestMetricsRepository_Conformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) repository.Storage {
+		return NewMetricsRepository()
+	})
+}
+
 func BenchmarkMetricsRepository_AddValues(b *testing.B) {
 	m := fillMetrics()
 	b.ResetTimer()
@@ -0,0 +1,205 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/server/repository"
+	"github.com/sotavant/yandex-metrics/internal/server/storage"
+)
+
+// compactionLag отставание границы сжатия от текущего времени. Время сырой точки берется внутри транзакции записи,
+// а видна точка становится только после фиксации: без отставания медленный пакет мог бы зафиксировать точку
+// позади уже свернутой границы, и она не попала бы ни в один агрегат.
+const compactionLag = time.Minute
+
+// EnableHistory создает таблицы истории и включает сохранение сырых точек при каждой записи
+func (m *MetricsRepository) EnableHistory(ctx context.Context, tiers []repository.RetentionTier) error {
+	connAlive := storage.CheckConnection(ctx, m.conn)
+	if !connAlive {
+		return errors.New("unable to connect")
+	}
+
+	queries := []string{
+		`create table if not exists #T#_history
+		(
+			id         varchar          not null,
+			type       varchar          not null,
+			tier       int2             not null,
+			ts         timestamptz      not null,
+			cnt        int8             not null,
+			min_value  double precision not null default 0,
+			max_value  double precision not null default 0,
+			sum_value  double precision not null default 0,
+			last_value double precision not null default 0,
+			increase   int8             not null default 0
+		);`,
+		`create index if not exists #T#_history_idx on #T#_history (type, id, tier, ts);`,
+		`create table if not exists #T#_history_compaction
+		(
+			tier            int2        not null primary key,
+			compacted_until timestamptz not null
+		);`,
+	}
+
+	for _, query := range queries {
+		if _, err := m.conn.Exec(ctx, m.setTableName(query)); err != nil {
+			return err
+		}
+	}
+
+	m.tiers = tiers
+
+	return nil
+}
+
+func (m *MetricsRepository) GetHistory(ctx context.Context, mType, key string, from, to time.Time) (repository.History, error) {
+	if mType != internal.GaugeType && mType != internal.CounterType {
+		return repository.History{}, repository.ErrUnknownType
+	}
+
+	if !m.historyEnabled() {
+		return repository.History{}, repository.ErrHistoryDisabled
+	}
+
+	exist, err := m.KeyExist(ctx, mType, key)
+	if err != nil {
+		return repository.History{}, err
+	}
+
+	if !exist {
+		return repository.History{}, repository.NewNotFoundError(mType, key)
+	}
+
+	idx := repository.SelectTier(m.tiers, time.Now(), from)
+	res := repository.History{Tier: m.tiers[idx], Points: make([]repository.Point, 0)}
+
+	start := from
+	if res.Tier.Resolution > 0 {
+		start = repository.BucketStart(from, res.Tier.Resolution)
+	}
+
+	query := m.setTableName(`select ts, cnt, min_value, max_value, sum_value, last_value, increase
+		from #T#_history
+		where type = $1 and id = $2 and tier = $3 and ts >= $4 and ts <= $5
+		order by ts`)
+
+	rows, err := m.conn.Query(ctx, query, mType, key, idx, start, to)
+	if err != nil {
+		internal.Logger.Infow("error in select history", "err", err)
+		return repository.History{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p repository.Point
+		if err = rows.Scan(&p.Time, &p.Count, &p.Min, &p.Max, &p.Sum, &p.Last, &p.Increase); err != nil {
+			return repository.History{}, err
+		}
+
+		res.Points = append(res.Points, p)
+	}
+
+	return res, rows.Err()
+}
+
+// Compact сворачивает завершенные интервалы каждого уровня из предыдущего уровня и удаляет устаревшие точки.
+// Граница свернутых данных каждого уровня хранится в отдельной таблице, все изменения выполняются в одной транзакции.
+func (m *MetricsRepository) Compact(ctx context.Context, now time.Time) (err error) {
+	if !m.historyEnabled() {
+		return nil
+	}
+
+	connAlive := storage.CheckConnection(ctx, m.conn)
+	if !connAlive {
+		return errors.New("unable to connect")
+	}
+
+	tx, err := m.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err == nil {
+			return
+		}
+
+		if rbErr := tx.Rollback(ctx); rbErr != nil && !errors.Is(rbErr, pgx.ErrTxClosed) {
+			internal.Logger.Infow("error in rollback transaction", "err", rbErr)
+		}
+	}()
+
+	for i := 1; i < len(m.tiers); i++ {
+		if err = m.rollupTier(ctx, tx, i, now); err != nil {
+			internal.Logger.Infow("error in history rollup", "tier", i, "err", err)
+			return err
+		}
+	}
+
+	for i, tier := range m.tiers {
+		_, err = tx.Exec(ctx, m.setTableName(`delete from #T#_history where tier = $1 and ts < $2`), i, now.Add(-tier.Retention))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit(ctx)
+
+	return err
+}
+
+// rollupTier сворачивает точки уровня idx-1 в интервалы уровня idx от прошлой границы до начала интервала,
+// в который попадает now - compactionLag
+func (m *MetricsRepository) rollupTier(ctx context.Context, tx pgx.Tx, idx int, now time.Time) error {
+	var from time.Time
+
+	resolution := m.tiers[idx].Resolution
+	until := repository.BucketStart(now.Add(-compactionLag), resolution)
+
+	err := tx.QueryRow(ctx, m.setTableName(`select compacted_until from #T#_history_compaction where tier = $1 for update`), idx).Scan(&from)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+
+	if !until.After(from) {
+		return nil
+	}
+
+	_, err = tx.Exec(ctx, m.setTableName(`insert into #T#_history
+			(id, type, tier, ts, cnt, min_value, max_value, sum_value, last_value, increase)
+		select id, type, $1::int2,
+			to_timestamp(floor(extract(epoch from ts)::float8 / $2::float8) * $2::float8) as bucket,
+			sum(cnt)::int8, min(min_value), max(max_value), sum(sum_value),
+			(array_agg(last_value order by ts desc))[1], sum(increase)::int8
+		from #T#_history
+		where tier = $3 and ts >= $4 and ts < $5
+		group by id, type, bucket`), idx, resolution.Seconds(), idx-1, from, until)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, m.setTableName(`insert into #T#_history_compaction (tier, compacted_until)
+		values ($1, $2)
+		on conflict (tier) do update set compacted_until = excluded.compacted_until`), idx, until)
+
+	return err
+}
+
+// queueRawPoint добавляет в пакет сырую точку. Время точки ставит база в момент вставки,
+// поэтому оно не зависит от часов серверов приложения и от времени подготовки пакета.
+func (m *MetricsRepository) queueRawPoint(batch *pgx.Batch, metric internal.Metrics) {
+	p := repository.NewPoint(metric, time.Time{})
+
+	batch.Queue(m.setTableName(`insert into #T#_history
+			(id, type, tier, ts, cnt, min_value, max_value, sum_value, last_value, increase)
+		values ($1, $2, 0, clock_timestamp(), $3, $4, $5, $6, $7, $8)`),
+		metric.ID, metric.MType, p.Count, p.Min, p.Max, p.Sum, p.Last, p.Increase)
+}
+
+func (m *MetricsRepository) historyEnabled() bool {
+	return len(m.tiers) > 0
+}
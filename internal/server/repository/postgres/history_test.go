@@ -0,0 +1,77 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/server/repository"
+	"github.com/sotavant/yandex-metrics/internal/server/repository/postgres/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testTiers = []repository.RetentionTier{
+	{Resolution: 0, Retention: time.Hour},
+	{Resolution: time.Minute, Retention: 24 * time.Hour},
+}
+
+func TestMetricsRepository_History(t *testing.T) {
+	ctx := context.Background()
+	conn, tableName, DSN, err := test.InitConnection(ctx, t)
+	assert.NoError(t, err)
+	if conn == nil {
+		return
+	}
+	defer conn.Close()
+
+	dropTables := func() {
+		for _, name := range []string{tableName, tableName + "_history", tableName + "_history_compaction"} {
+			assert.NoError(t, test.DropTable(ctx, conn, name))
+		}
+	}
+	dropTables()
+	defer dropTables()
+
+	m, err := NewMemStorage(ctx, conn, tableName, DSN)
+	require.NoError(t, err)
+	require.NoError(t, m.EnableHistory(ctx, testTiers))
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		require.NoError(t, m.AddGaugeValue(ctx, "g", float64(i)))
+		require.NoError(t, m.AddCounterValue(ctx, "c", 2))
+	}
+
+	got, err := m.GetHistory(ctx, internal.GaugeType, "g", start, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, testTiers[0], got.Tier)
+	assert.Len(t, got.Points, 3)
+
+	_, err = m.GetHistory(ctx, internal.GaugeType, "absent", start, time.Now())
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+
+	// сжатие через минуту (и отставание границы) сворачивает все сырые точки в минутные агрегаты
+	require.NoError(t, m.Compact(ctx, time.Now().Add(time.Minute+compactionLag)))
+	require.NoError(t, m.Compact(ctx, time.Now().Add(time.Minute+compactionLag)))
+
+	from := start.Add(-2 * time.Hour)
+	got, err = m.GetHistory(ctx, internal.CounterType, "c", from, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, testTiers[1], got.Tier)
+
+	var increase, count int64
+	for _, p := range got.Points {
+		increase += p.Increase
+		count += p.Count
+	}
+	assert.Equal(t, int64(6), increase)
+	assert.Equal(t, int64(3), count)
+
+	// после срока хранения сырые точки удаляются
+	require.NoError(t, m.Compact(ctx, time.Now().Add(2*time.Hour)))
+	got, err = m.GetHistory(ctx, internal.GaugeType, "g", start, time.Now())
+	assert.NoError(t, err)
+	assert.Empty(t, got.Points)
+}
@@ -4,11 +4,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/server/repository"
 	"github.com/sotavant/yandex-metrics/internal/server/storage"
 )
 
@@ -16,6 +19,8 @@ type MetricsRepository struct {
 	conn      *pgxpool.Pool
 	tableName string
 	DSN       string
+	// tiers уровни хранения истории, пустые если история не включена
+	tiers []repository.RetentionTier
 }
 
 func NewMemStorage(ctx context.Context, conn *pgxpool.Pool, tableName string, DSN string) (*MetricsRepository, error) {
@@ -29,7 +34,7 @@ func NewMemStorage(ctx context.Context, conn *pgxpool.Pool, tableName string, DS
 		return nil, fmt.Errorf("error in creating table: %s", err)
 	}
 
-	return &MetricsRepository{conn, tableName, DSN}, nil
+	return &MetricsRepository{conn: conn, tableName: tableName, DSN: DSN}, nil
 }
 
 func CreateTable(ctx context.Context, conn *pgxpool.Pool, tableName string) error {
@@ -49,58 +54,47 @@ func CreateTable(ctx context.Context, conn *pgxpool.Pool, tableName string) erro
 				unique (id, type)
 		);`, "#T", tableName)
 
+	if _, err := conn.Exec(ctx, query); err != nil {
+		return err
+	}
+
+	// updated_at добавляется отдельно, чтобы обновить таблицы, созданные до его появления
+	query = strings.ReplaceAll(`alter table #T add column if not exists updated_at timestamptz not null default now()`, "#T", tableName)
 	_, err := conn.Exec(ctx, query)
 
 	return err
 }
 
 func (m *MetricsRepository) AddGaugeValue(ctx context.Context, key string, value float64) error {
-	var err error
-
-	query := m.setTableName(`insert into #T# (id, type, value)
-		values ($1, $2, $3)
-		on conflict on constraint #T#_pk do update set id = $1, type = $2, value = $3;`)
-	query = m.setTableName(query)
+	if m.historyEnabled() {
+		return m.AddValues(ctx, []internal.Metrics{{ID: key, MType: internal.GaugeType, Value: &value}})
+	}
 
 	connAlive := storage.CheckConnection(ctx, m.conn)
 	if !connAlive {
 		return errors.New("unable to connect")
 	}
 
-	_, err = m.conn.Exec(ctx, query, key, internal.GaugeType, value)
+	_, err := m.conn.Exec(ctx, m.gaugeUpsertQuery(), key, internal.GaugeType, value)
 
 	return err
 }
 
+// AddCounterValue увеличивает значение счетчика одним запросом.
+// Инкремент выполняется на стороне базы данных, поэтому параллельные записи не теряются.
 func (m *MetricsRepository) AddCounterValue(ctx context.Context, key string, value int64) error {
-	var delta int64
-	var err error
-	selectQuery := m.setTableName(`select delta from #T# where type = $1 and id = $2`)
-	insertQuery := m.setTableName(`insert into #T# (id, type, delta) values ($1, $2, $3)`)
-	updateQuery := m.setTableName(`update #T# set delta = $1 where id = $2 and type = $3`)
+	if m.historyEnabled() {
+		return m.AddValues(ctx, []internal.Metrics{{ID: key, MType: internal.CounterType, Delta: &value}})
+	}
 
 	connAlive := storage.CheckConnection(ctx, m.conn)
 	if !connAlive {
 		return errors.New("unable to connect")
 	}
 
-	err = m.conn.QueryRow(ctx, selectQuery, internal.CounterType, key).Scan(&delta)
-
-	switch {
-	case err == nil:
-		_, err = m.conn.Exec(ctx, updateQuery, value+delta, key, internal.CounterType)
-		if err != nil {
-			internal.Logger.Infow("error in update", "err", err)
-			return err
-		}
-	case errors.Is(err, pgx.ErrNoRows):
-		_, err = m.conn.Exec(ctx, insertQuery, key, internal.CounterType, value)
-		if err != nil {
-			internal.Logger.Infow("error in insert", "err", err)
-			return err
-		}
-	default:
-		internal.Logger.Infow("error in select", "err", err)
+	_, err := m.conn.Exec(ctx, m.counterUpsertQuery(), key, internal.CounterType, value)
+	if err != nil {
+		internal.Logger.Infow("error in upsert counter", "err", err)
 		return err
 	}
 
@@ -108,53 +102,83 @@ func (m *MetricsRepository) AddCounterValue(ctx context.Context, key string, val
 }
 
 func (m *MetricsRepository) AddValue(ctx context.Context, metric internal.Metrics) error {
-	var err error
+	if err := repository.ValidateMetric(metric); err != nil {
+		return err
+	}
 
-	switch metric.MType {
-	case internal.GaugeType:
-		err = m.AddGaugeValue(ctx, metric.ID, *metric.Value)
-	case internal.CounterType:
-		err = m.AddCounterValue(ctx, metric.ID, *metric.Delta)
-	default:
-		err = fmt.Errorf("undefinde type: %s", metric.MType)
+	if metric.MType == internal.GaugeType {
+		return m.AddGaugeValue(ctx, metric.ID, *metric.Value)
 	}
 
-	return err
+	return m.AddCounterValue(ctx, metric.ID, *metric.Delta)
 }
 
-func (m *MetricsRepository) AddValues(ctx context.Context, metrics []internal.Metrics) error {
-	var err error
+// AddValues сохраняет пакет метрик в одной транзакции.
+// Все запросы отправляются одним pgx.Batch, при любой ошибке транзакция откатывается целиком.
+// Если включена история, в том же пакете сохраняются сырые точки.
+func (m *MetricsRepository) AddValues(ctx context.Context, metrics []internal.Metrics) (err error) {
+	batch := &pgx.Batch{}
+
+	// одинаковый порядок блокировки строк исключает взаимные блокировки параллельных пакетов
+	sorted := make([]internal.Metrics, len(metrics))
+	copy(sorted, metrics)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].MType != sorted[j].MType {
+			return sorted[i].MType < sorted[j].MType
+		}
+
+		return sorted[i].ID < sorted[j].ID
+	})
+
+	for _, metric := range sorted {
+		if err = repository.ValidateMetric(metric); err != nil {
+			return err
+		}
+
+		if metric.MType == internal.GaugeType {
+			batch.Queue(m.gaugeUpsertQuery(), metric.ID, internal.GaugeType, *metric.Value)
+		} else {
+			batch.Queue(m.counterUpsertQuery(), metric.ID, internal.CounterType, *metric.Delta)
+		}
+
+		if m.historyEnabled() {
+			m.queueRawPoint(batch, metric)
+		}
+	}
+
+	connAlive := storage.CheckConnection(ctx, m.conn)
+	if !connAlive {
+		return errors.New("unable to connect")
+	}
 
 	tx, err := m.conn.Begin(ctx)
 	if err != nil {
 		return err
 	}
 
-	defer func(tx pgx.Tx, ctx context.Context) {
-		if err != nil {
-			err = tx.Rollback(ctx)
-			if err != nil {
-				internal.Logger.Infow("error in rollback transaction", err, err)
-				panic(err)
-			}
+	defer func() {
+		if err == nil {
+			return
 		}
-	}(tx, ctx)
-
-	for _, metric := range metrics {
-		switch metric.MType {
-		case internal.GaugeType:
-			err = m.AddGaugeValue(ctx, metric.ID, *metric.Value)
-		case internal.CounterType:
-			err = m.AddCounterValue(ctx, metric.ID, *metric.Delta)
-		default:
-			return errors.New("undefined metric type")
+
+		if rbErr := tx.Rollback(ctx); rbErr != nil && !errors.Is(rbErr, pgx.ErrTxClosed) {
+			internal.Logger.Infow("error in rollback transaction", "err", rbErr)
 		}
+	}()
 
-		if err != nil {
+	br := tx.SendBatch(ctx, batch)
+	for i := 0; i < batch.Len(); i++ {
+		if _, err = br.Exec(); err != nil {
+			internal.Logger.Infow("error in batch exec", "err", err)
+			_ = br.Close()
 			return err
 		}
 	}
 
+	if err = br.Close(); err != nil {
+		return err
+	}
+
 	err = tx.Commit(ctx)
 
 	return err
@@ -180,25 +204,22 @@ func (m *MetricsRepository) GetValue(ctx context.Context, mType, key string) (in
 		query = strings.ReplaceAll(query, "#F#", "value")
 		err = m.conn.QueryRow(ctx, query, internal.GaugeType, key).Scan(&value)
 	default:
-		return nil, nil
+		return nil, repository.ErrUnknownType
 	}
 
 	switch {
 	case err == nil:
-		switch mType {
-		case internal.GaugeType:
+		if mType == internal.GaugeType {
 			return value, nil
-		case internal.CounterType:
-			return delta, nil
 		}
+
+		return delta, nil
 	case errors.Is(err, pgx.ErrNoRows):
-		return nil, nil
+		return nil, repository.NewNotFoundError(mType, key)
 	default:
 		internal.Logger.Infow("error in getValue", "err", err)
 		return nil, err
 	}
-
-	return nil, nil
 }
 
 func (m *MetricsRepository) GetValues(ctx context.Context) ([]internal.Metrics, error) {
@@ -236,6 +257,35 @@ func (m *MetricsRepository) GetValues(ctx context.Context) ([]internal.Metrics,
 	return metrics, nil
 }
 
+// ListValues возвращает страницу метрик. Фильтры, порядок, курсор и размер страницы
+// применяются в запросе, поэтому в память загружается только сама страница.
+func (m *MetricsRepository) ListValues(ctx context.Context, opts repository.ListOptions) ([]internal.Metrics, error) {
+	connAlive := storage.CheckConnection(ctx, m.conn)
+	if !connAlive {
+		return nil, errors.New("unable to connect")
+	}
+
+	query, args := m.listQuery(opts)
+	rows, err := m.conn.Query(ctx, query, args...)
+	if err != nil {
+		internal.Logger.Infow("error in list values", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	metrics := make([]internal.Metrics, 0)
+	for rows.Next() {
+		var metric internal.Metrics
+		if err = rows.Scan(&metric.MType, &metric.ID, &metric.Value, &metric.Delta); err != nil {
+			return nil, err
+		}
+
+		metrics = append(metrics, metric)
+	}
+
+	return metrics, rows.Err()
+}
+
 func (m *MetricsRepository) KeyExist(ctx context.Context, mType, key string) (bool, error) {
 	var err error
 	var count int
@@ -291,13 +341,11 @@ func (m *MetricsRepository) GetGauge(ctx context.Context) (map[string]float64, e
 
 func (m *MetricsRepository) GetGaugeValue(ctx context.Context, key string) (float64, error) {
 	val, err := m.GetValue(ctx, internal.GaugeType, key)
-
-	switch i := val.(type) {
-	case float64:
-		return i, err
-	default:
-		return 0, errors.New("unknown type of result")
+	if err != nil {
+		return 0, err
 	}
+
+	return val.(float64), nil
 }
 
 func (m *MetricsRepository) GetCounters(ctx context.Context) (map[string]int64, error) {
@@ -335,15 +383,190 @@ func (m *MetricsRepository) GetCounters(ctx context.Context) (map[string]int64,
 
 func (m *MetricsRepository) GetCounterValue(ctx context.Context, key string) (int64, error) {
 	val, err := m.GetValue(ctx, internal.CounterType, key)
+	if err != nil {
+		return 0, err
+	}
 
-	switch i := val.(type) {
-	case int64:
-		return i, err
-	default:
-		return 0, errors.New("unknown type of result")
+	return val.(int64), nil
+}
+
+// Delete удаляет метрику, а если включена история - и точки ее ряда
+func (m *MetricsRepository) Delete(ctx context.Context, mType, key string) error {
+	if mType != internal.GaugeType && mType != internal.CounterType {
+		return repository.ErrUnknownType
+	}
+
+	connAlive := storage.CheckConnection(ctx, m.conn)
+	if !connAlive {
+		return errors.New("unable to connect")
+	}
+
+	tag, err := m.conn.Exec(ctx, m.setTableName(`delete from #T# where type = $1 and id = $2`), mType, key)
+	if err != nil {
+		internal.Logger.Infow("error in delete", "err", err)
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return repository.NewNotFoundError(mType, key)
+	}
+
+	if m.historyEnabled() {
+		_, err = m.conn.Exec(ctx, m.setTableName(`delete from #T#_history where type = $1 and id = $2`), mType, key)
+	}
+
+	return err
+}
+
+// ResetCounter обнуляет счетчик
+func (m *MetricsRepository) ResetCounter(ctx context.Context, key string) error {
+	connAlive := storage.CheckConnection(ctx, m.conn)
+	if !connAlive {
+		return errors.New("unable to connect")
+	}
+
+	query := m.setTableName(`update #T# set delta = 0 where type = $1 and id = $2`)
+	tag, err := m.conn.Exec(ctx, query, internal.CounterType, key)
+	if err != nil {
+		internal.Logger.Infow("error in reset counter", "err", err)
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return repository.NewNotFoundError(internal.CounterType, key)
+	}
+
+	return nil
+}
+
+// LastUpdated возвращает время последней записи рядов
+func (m *MetricsRepository) LastUpdated(ctx context.Context, keys []repository.MetricKey) (map[repository.MetricKey]time.Time, error) {
+	connAlive := storage.CheckConnection(ctx, m.conn)
+	if !connAlive {
+		return nil, errors.New("unable to connect")
+	}
+
+	query := m.setTableName(`select type, id, updated_at from #T#`)
+	args := make([]any, 0, 2)
+
+	if keys != nil {
+		types := make([]string, len(keys))
+		ids := make([]string, len(keys))
+		for i, k := range keys {
+			types[i], ids[i] = k.MType, k.ID
+		}
+
+		query = m.setTableName(`select t.type, t.id, t.updated_at from #T# t
+			join unnest($1::varchar[], $2::varchar[]) as k(type, id) on t.type = k.type and t.id = k.id`)
+		args = append(args, types, ids)
+	}
+
+	rows, err := m.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make(map[repository.MetricKey]time.Time)
+	for rows.Next() {
+		var key repository.MetricKey
+		var at time.Time
+
+		if err = rows.Scan(&key.MType, &key.ID, &at); err != nil {
+			return nil, err
+		}
+
+		res[key] = at
+	}
+
+	return res, rows.Err()
+}
+
+// EvictStale удаляет ряды, не обновлявшиеся с момента before, одним запросом.
+// Если включена история, в том же запросе удаляются и точки вытесненных рядов.
+func (m *MetricsRepository) EvictStale(ctx context.Context, before time.Time) ([]repository.MetricKey, error) {
+	connAlive := storage.CheckConnection(ctx, m.conn)
+	if !connAlive {
+		return nil, errors.New("unable to connect")
+	}
+
+	query := m.setTableName(`delete from #T# where updated_at < $1 returning type, id`)
+	if m.historyEnabled() {
+		query = m.setTableName(`with evicted as (delete from #T# where updated_at < $1 returning type, id),
+			history as (delete from #T#_history h using evicted e where h.type = e.type and h.id = e.id)
+			select type, id from evicted`)
 	}
+
+	rows, err := m.conn.Query(ctx, query, before)
+	if err != nil {
+		internal.Logger.Infow("error in evict stale", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	evicted := make([]repository.MetricKey, 0)
+	for rows.Next() {
+		var key repository.MetricKey
+		if err = rows.Scan(&key.MType, &key.ID); err != nil {
+			return nil, err
+		}
+
+		evicted = append(evicted, key)
+	}
+
+	return evicted, rows.Err()
+}
+
+// listQuery собирает запрос страницы. Имена колонок сортировки выбираются из фиксированного набора,
+// все значения передаются параметрами. Сравнение побайтовое (collate "C"), как в остальных хранилищах.
+func (m *MetricsRepository) listQuery(opts repository.ListOptions) (string, []any) {
+	columns := `id collate "C", type collate "C"`
+	if opts.SortBy == repository.SortByType {
+		columns = `type collate "C", id collate "C"`
+	}
+
+	order, cmp := "asc", ">"
+	if opts.Desc {
+		order, cmp = "desc", "<"
+	}
+
+	args := []any{opts.MType, opts.Prefix}
+	query := `select type, id, value, delta from #T#
+		where ($1 = '' or type = $1) and starts_with(id, $2)`
+
+	if opts.After != nil {
+		args = append(args, opts.After.ID, opts.After.MType)
+		cursor := "($3, $4)"
+		if opts.SortBy == repository.SortByType {
+			cursor = "($4, $3)"
+		}
+
+		query += fmt.Sprintf(" and (%s) %s %s", columns, cmp, cursor)
+	}
+
+	query += fmt.Sprintf(" order by %s", strings.ReplaceAll(columns, ",", " "+order+","))
+	query += " " + order
+
+	if opts.Limit > 0 {
+		args = append(args, opts.Limit)
+		query += fmt.Sprintf(" limit $%d", len(args))
+	}
+
+	return m.setTableName(query), args
+}
+
+func (m *MetricsRepository) gaugeUpsertQuery() string {
+	return m.setTableName(`insert into #T# (id, type, value, updated_at)
+		values ($1, $2, $3, now())
+		on conflict on constraint #T#_pk do update set value = excluded.value, updated_at = excluded.updated_at`)
+}
+
+func (m *MetricsRepository) counterUpsertQuery() string {
+	return m.setTableName(`insert into #T# as t (id, type, delta, updated_at)
+		values ($1, $2, $3, now())
+		on conflict on constraint #T#_pk do update set delta = t.delta + excluded.delta, updated_at = excluded.updated_at`)
 }
 
 func (m *MetricsRepository) setTableName(query string) string {
-	return strings.Replace(query, "#T#", m.tableName, 1)
+	return strings.ReplaceAll(query, "#T#", m.tableName)
 }
@@ -3,11 +3,14 @@ package postgres
 import (
 	"context"
 	"fmt"
+	"sync"
 	"testing"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/server/repository"
 	"github.com/sotavant/yandex-metrics/internal/server/repository/postgres/test"
+	"github.com/sotavant/yandex-metrics/internal/server/repository/storagetest"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -499,6 +502,164 @@ func TestMetricsRepository_GetCounterValue(t *testing.T) {
 	}
 }
 
+func TestMetricsRepository_AddCounterValueConcurrent(t *testing.T) {
+	ctx := context.Background()
+	conn, tableName, _, err := test.InitConnection(ctx, t)
+	assert.NoError(t, err)
+	if conn == nil {
+		return
+	}
+	defer func(ctx context.Context, conn *pgxpool.Pool, tableName string) {
+		err := test.DropTable(ctx, conn, tableName)
+		assert.NoError(t, err)
+	}(ctx, conn, tableName)
+
+	const writers = 20
+	const increments = 50
+
+	m := &MetricsRepository{
+		conn:      conn,
+		tableName: tableName,
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; i++ {
+				assert.NoError(t, m.AddCounterValue(ctx, "concurrent", 1))
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := m.GetCounterValue(ctx, "concurrent")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(writers*increments), got)
+}
+
+func TestMetricsRepository_AddValuesConcurrent(t *testing.T) {
+	ctx := context.Background()
+	conn, tableName, _, err := test.InitConnection(ctx, t)
+	assert.NoError(t, err)
+	if conn == nil {
+		return
+	}
+	defer func(ctx context.Context, conn *pgxpool.Pool, tableName string) {
+		err := test.DropTable(ctx, conn, tableName)
+		assert.NoError(t, err)
+	}(ctx, conn, tableName)
+
+	const writers = 10
+	const batches = 10
+
+	m := &MetricsRepository{
+		conn:      conn,
+		tableName: tableName,
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < batches; i++ {
+				var delta int64 = 1
+				gauge := float64(i)
+				batch := []internal.Metrics{
+					{ID: "b", MType: internal.CounterType, Delta: &delta},
+					{ID: "a", MType: internal.CounterType, Delta: &delta},
+					{ID: "g", MType: internal.GaugeType, Value: &gauge},
+				}
+				assert.NoError(t, m.AddValues(ctx, batch))
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, id := range []string{"a", "b"} {
+		got, err := m.GetCounterValue(ctx, id)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(writers*batches), got)
+	}
+}
+
+func TestMetricsRepository_AddValuesRollback(t *testing.T) {
+	ctx := context.Background()
+	conn, tableName, _, err := test.InitConnection(ctx, t)
+	assert.NoError(t, err)
+	if conn == nil {
+		return
+	}
+	defer func(ctx context.Context, conn *pgxpool.Pool, tableName string) {
+		err := test.DropTable(ctx, conn, tableName)
+		assert.NoError(t, err)
+	}(ctx, conn, tableName)
+
+	m := &MetricsRepository{
+		conn:      conn,
+		tableName: tableName,
+	}
+
+	var delta int64 = 5
+	var value = 1.5
+
+	tests := []struct {
+		name    string
+		metrics []internal.Metrics
+	}{
+		{
+			name: "bad type",
+			metrics: []internal.Metrics{
+				{ID: "rc", MType: internal.CounterType, Delta: &delta},
+				{ID: "rg", MType: "bad", Value: &value},
+			},
+		},
+		{
+			name: "value absent",
+			metrics: []internal.Metrics{
+				{ID: "rc", MType: internal.CounterType, Delta: &delta},
+				{ID: "rg", MType: internal.GaugeType},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Error(t, m.AddValues(ctx, tt.metrics))
+
+			exist, err := m.KeyExist(ctx, internal.CounterType, "rc")
+			assert.NoError(t, err)
+			assert.False(t, exist)
+		})
+	}
+}
+
+func TestMetricsRepository_Conformance(t *testing.T) {
+	ctx := context.Background()
+	conn, tableName, DSN, err := test.InitConnection(ctx, t)
+	assert.NoError(t, err)
+	if conn == nil {
+		return
+	}
+	defer conn.Close()
+
+	storagetest.Run(t, func(t *testing.T) repository.Storage {
+		err := test.DropTable(ctx, conn, tableName)
+		assert.NoError(t, err)
+
+		m, err := NewMemStorage(ctx, conn, tableName, DSN)
+		assert.NoError(t, err)
+
+		t.Cleanup(func() {
+			err := test.DropTable(ctx, conn, tableName)
+			assert.NoError(t, err)
+		})
+
+		return m
+	})
+}
+
 func BenchmarkMetricsRepository_AddValues(b *testing.B) {
 	ctx := context.Background()
 	conn, tableName, _, err := test.InitConnection(ctx, b)
@@ -550,3 +711,54 @@ func fillMetrics() []internal.Metrics {
 
 	return res
 }
+
+func TestMetricsRepository_listQuery(t *testing.T) {
+	m := &MetricsRepository{tableName: "metric"}
+	base := `select type, id, value, delta from metric
+		where ($1 = '' or type = $1) and starts_with(id, $2)`
+
+	tests := []struct {
+		name      string
+		opts      repository.ListOptions
+		wantQuery string
+		wantArgs  []any
+	}{
+		{
+			name:      "defaults",
+			opts:      repository.ListOptions{},
+			wantQuery: base + ` order by id collate "C" asc, type collate "C" asc`,
+			wantArgs:  []any{"", ""},
+		},
+		{
+			name: "cursor by id desc",
+			opts: repository.ListOptions{
+				MType:  internal.GaugeType,
+				Prefix: "Heap",
+				Desc:   true,
+				After:  &repository.MetricKey{MType: internal.GaugeType, ID: "HeapAlloc"},
+				Limit:  10,
+			},
+			wantQuery: base + ` and (id collate "C", type collate "C") < ($3, $4)` +
+				` order by id collate "C" desc, type collate "C" desc limit $5`,
+			wantArgs: []any{internal.GaugeType, "Heap", "HeapAlloc", internal.GaugeType, 10},
+		},
+		{
+			name: "cursor by type",
+			opts: repository.ListOptions{
+				SortBy: repository.SortByType,
+				After:  &repository.MetricKey{MType: internal.CounterType, ID: "PollCount"},
+				Limit:  5,
+			},
+			wantQuery: base + ` and (type collate "C", id collate "C") > ($4, $3)` +
+				` order by type collate "C" asc, id collate "C" asc limit $5`,
+			wantArgs: []any{"", "", "PollCount", internal.CounterType, 5},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, args := m.listQuery(tt.opts)
+			assert.Equal(t, tt.wantQuery, query)
+			assert.Equal(t, tt.wantArgs, args)
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sotavant/yandex-metrics/internal/server/auth"
+	"github.com/sotavant/yandex-metrics/internal/server/storage"
+)
+
+// TokensRepository токены доступа в таблице. Хранится только SHA-256 токена (auth.HashToken).
+type TokensRepository struct {
+	conn      *pgxpool.Pool
+	tableName string
+}
+
+func NewTokensRepository(ctx context.Context, conn *pgxpool.Pool, tableName string) (*TokensRepository, error) {
+	connAlive := storage.CheckConnection(ctx, conn)
+	if !connAlive {
+		return nil, errors.New("unable to connect")
+	}
+
+	query := strings.ReplaceAll(`create table if not exists #T
+		(
+			token_hash varchar   not null primary key,
+			name       varchar   not null default '',
+			scopes     varchar[] not null
+		);`, "#T", tableName)
+
+	if _, err := conn.Exec(ctx, query); err != nil {
+		return nil, err
+	}
+
+	return &TokensRepository{conn: conn, tableName: tableName}, nil
+}
+
+func (t *TokensRepository) Scopes(ctx context.Context, token string) ([]auth.Scope, error) {
+	var names []string
+	query := strings.ReplaceAll(`select scopes from #T where token_hash = $1`, "#T", t.tableName)
+
+	err := t.conn.QueryRow(ctx, query, auth.HashToken(token)).Scan(&names)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, auth.ErrUnknownToken
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return auth.ParseScopes(names)
+}
+
+// AddToken сохраняет токен с правами, права существующего токена заменяются
+func (t *TokensRepository) AddToken(ctx context.Context, name, token string, scopes ...auth.Scope) error {
+	names := make([]string, 0, len(scopes))
+	for _, s := range scopes {
+		names = append(names, string(s))
+	}
+
+	query := strings.ReplaceAll(`insert into #T (token_hash, name, scopes) values ($1, $2, $3)
+		on conflict (token_hash) do update set name = excluded.name, scopes = excluded.scopes`, "#T", t.tableName)
+
+	_, err := t.conn.Exec(ctx, query, auth.HashToken(token), name, names)
+	return err
+}
@@ -0,0 +1,43 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sotavant/yandex-metrics/internal/server/auth"
+	"github.com/sotavant/yandex-metrics/internal/server/repository/postgres/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokensRepository(t *testing.T) {
+	ctx := context.Background()
+	conn, tableName, _, err := test.InitConnection(ctx, t)
+	assert.NoError(t, err)
+	if conn == nil {
+		return
+	}
+	defer conn.Close()
+
+	tokensTable := tableName + "_tokens"
+	defer func() {
+		assert.NoError(t, test.DropTable(ctx, conn, tokensTable))
+		assert.NoError(t, test.DropTable(ctx, conn, tableName))
+	}()
+
+	tokens, err := NewTokensRepository(ctx, conn, tokensTable)
+	require.NoError(t, err)
+	require.NoError(t, tokens.AddToken(ctx, "agent", "secret", auth.ScopeWrite))
+
+	scopes, err := tokens.Scopes(ctx, "secret")
+	require.NoError(t, err)
+	assert.Equal(t, []auth.Scope{auth.ScopeWrite}, scopes)
+
+	require.NoError(t, tokens.AddToken(ctx, "agent", "secret", auth.ScopeRead, auth.ScopeWrite))
+	scopes, err = tokens.Scopes(ctx, "secret")
+	require.NoError(t, err)
+	assert.Equal(t, []auth.Scope{auth.ScopeRead, auth.ScopeWrite}, scopes)
+
+	_, err = tokens.Scopes(ctx, "other")
+	assert.ErrorIs(t, err, auth.ErrUnknownToken)
+}
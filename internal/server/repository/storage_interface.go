@@ -2,11 +2,23 @@ package repository
 
 import (
 	"context"
+	"time"
 
 	"github.com/sotavant/yandex-metrics/internal"
 )
 
-// Storage Интерфейс, описывающий методы для работы с хранилищем
+// Storage Интерфейс, описывающий методы для работы с хранилищем.
+//
+// Общие соглашения для всех реализаций:
+//   - чтение отсутствующей метрики возвращает ошибку NotFoundError (errors.Is(err, ErrNotFound));
+//   - неизвестный тип метрики возвращает ErrUnknownType, метрика без значения - ErrValueAbsent;
+//   - AddValues применяет пакет атомарно: при ошибке не сохраняется ни одна метрика из пакета;
+//   - gauge и counter с одинаковым ID хранятся независимо;
+//   - Delete и ResetCounter для отсутствующей метрики возвращают NotFoundError;
+//   - каждая запись обновляет время обновления ряда; LastUpdated возвращает его для ключей keys
+//     (для всех рядов, если keys равен nil), ключи без известного времени пропускаются;
+//   - EvictStale удаляет ряды, не обновлявшиеся с момента before, и возвращает их ключи;
+//   - ListValues возвращает одну страницу в порядке ListOptions, не загружая в память все метрики там, где это возможно.
 type Storage interface {
 	AddGaugeValue(ctx context.Context, key string, value float64) error
 	AddCounterValue(ctx context.Context, key string, value int64) error
@@ -19,4 +31,9 @@ type Storage interface {
 	AddValue(ctx context.Context, m internal.Metrics) error
 	AddValues(ctx context.Context, m []internal.Metrics) error
 	GetValues(ctx context.Context) ([]internal.Metrics, error)
+	ListValues(ctx context.Context, opts ListOptions) ([]internal.Metrics, error)
+	Delete(ctx context.Context, mType string, key string) error
+	ResetCounter(ctx context.Context, key string) error
+	LastUpdated(ctx context.Context, keys []MetricKey) (map[MetricKey]time.Time, error)
+	EvictStale(ctx context.Context, before time.Time) ([]MetricKey, error)
 }
@@ -0,0 +1,506 @@
+// Package storagetest Общий набор тестов на соответствие контракту repository.Storage.
+// Любая реализация хранилища подключается вызовом Run из своего пакета с тестами.
+package storagetest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/server/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Factory создает новое пустое хранилище для одного теста
+type Factory func(t *testing.T) repository.Storage
+
+const (
+	concurrentWriters    = 20
+	concurrentIncrements = 50
+	largeBatchSize       = 5000
+)
+
+// Run запускает все проверки контракта для хранилища, созданного newStorage
+func Run(t *testing.T, newStorage Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, st repository.Storage)
+	}{
+		{"AddAndGet", testAddAndGet},
+		{"MissingKeys", testMissingKeys},
+		{"TypeConflicts", testTypeConflicts},
+		{"InvalidMetrics", testInvalidMetrics},
+		{"ConcurrentCounterIncrements", testConcurrentCounterIncrements},
+		{"BatchAtomicity", testBatchAtomicity},
+		{"LargeBatch", testLargeBatch},
+		{"ReturnedMapsAreCopies", testReturnedMapsAreCopies},
+		{"ListValues", testListValues},
+		{"DeleteAndReset", testDeleteAndReset},
+		{"Freshness", testFreshness},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStorage(t))
+		})
+	}
+}
+
+func testAddAndGet(t *testing.T, st repository.Storage) {
+	ctx := context.Background()
+
+	require.NoError(t, st.AddGaugeValue(ctx, "g", 1.5))
+	require.NoError(t, st.AddGaugeValue(ctx, "g", -2.25))
+	require.NoError(t, st.AddCounterValue(ctx, "c", 3))
+	require.NoError(t, st.AddCounterValue(ctx, "c", 4))
+
+	gauge, err := st.GetGaugeValue(ctx, "g")
+	assert.NoError(t, err)
+	assert.Equal(t, -2.25, gauge)
+
+	counter, err := st.GetCounterValue(ctx, "c")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), counter)
+
+	val, err := st.GetValue(ctx, internal.GaugeType, "g")
+	assert.NoError(t, err)
+	assert.Equal(t, -2.25, val)
+
+	val, err = st.GetValue(ctx, internal.CounterType, "c")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), val)
+
+	gauges, err := st.GetGauge(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{"g": -2.25}, gauges)
+
+	counters, err := st.GetCounters(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int64{"c": 7}, counters)
+
+	values, err := st.GetValues(ctx)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []internal.Metrics{gaugeMetric("g", -2.25), counterMetric("c", 7)}, values)
+}
+
+func testMissingKeys(t *testing.T, st repository.Storage) {
+	ctx := context.Background()
+
+	val, err := st.GetValue(ctx, internal.GaugeType, "absent")
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+	assert.Nil(t, val)
+
+	val, err = st.GetValue(ctx, internal.CounterType, "absent")
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+	assert.Nil(t, val)
+
+	_, err = st.GetGaugeValue(ctx, "absent")
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+
+	_, err = st.GetCounterValue(ctx, "absent")
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+
+	var notFound *repository.NotFoundError
+	if assert.ErrorAs(t, err, &notFound) {
+		assert.Equal(t, internal.CounterType, notFound.MType)
+		assert.Equal(t, "absent", notFound.ID)
+	}
+
+	exist, err := st.KeyExist(ctx, internal.GaugeType, "absent")
+	assert.NoError(t, err)
+	assert.False(t, exist)
+
+	gauges, err := st.GetGauge(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, gauges)
+
+	values, err := st.GetValues(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, values)
+}
+
+func testTypeConflicts(t *testing.T, st repository.Storage) {
+	ctx := context.Background()
+
+	require.NoError(t, st.AddGaugeValue(ctx, "same", 10.5))
+	require.NoError(t, st.AddCounterValue(ctx, "same", 3))
+
+	gauge, err := st.GetGaugeValue(ctx, "same")
+	assert.NoError(t, err)
+	assert.Equal(t, 10.5, gauge)
+
+	counter, err := st.GetCounterValue(ctx, "same")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), counter)
+
+	_, err = st.GetCounterValue(ctx, "onlyGauge")
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+	require.NoError(t, st.AddGaugeValue(ctx, "onlyGauge", 1))
+
+	exist, err := st.KeyExist(ctx, internal.CounterType, "onlyGauge")
+	assert.NoError(t, err)
+	assert.False(t, exist)
+
+	_, err = st.GetValue(ctx, "unknown", "same")
+	assert.ErrorIs(t, err, repository.ErrUnknownType)
+}
+
+func testInvalidMetrics(t *testing.T, st repository.Storage) {
+	ctx := context.Background()
+	value := 1.0
+
+	tests := []struct {
+		name   string
+		metric internal.Metrics
+		err    error
+	}{
+		{
+			name:   "unknown type",
+			metric: internal.Metrics{ID: "m", MType: "unknown", Value: &value},
+			err:    repository.ErrUnknownType,
+		},
+		{
+			name:   "gauge without value",
+			metric: internal.Metrics{ID: "m", MType: internal.GaugeType},
+			err:    repository.ErrValueAbsent,
+		},
+		{
+			name:   "counter with value instead of delta",
+			metric: internal.Metrics{ID: "m", MType: internal.CounterType, Value: &value},
+			err:    repository.ErrValueAbsent,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.ErrorIs(t, st.AddValue(ctx, tt.metric), tt.err)
+		})
+	}
+
+	values, err := st.GetValues(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, values)
+}
+
+func testConcurrentCounterIncrements(t *testing.T, st repository.Storage) {
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for w := 0; w < concurrentWriters; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < concurrentIncrements; i++ {
+				if w%2 == 0 {
+					assert.NoError(t, st.AddCounterValue(ctx, "concurrent", 1))
+				} else {
+					assert.NoError(t, st.AddValues(ctx, []internal.Metrics{counterMetric("concurrent", 1)}))
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	got, err := st.GetCounterValue(ctx, "concurrent")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(concurrentWriters*concurrentIncrements), got)
+}
+
+func testBatchAtomicity(t *testing.T, st repository.Storage) {
+	ctx := context.Background()
+
+	require.NoError(t, st.AddValues(ctx, []internal.Metrics{
+		gaugeMetric("g", 1),
+		counterMetric("c", 1),
+	}))
+
+	err := st.AddValues(ctx, []internal.Metrics{
+		gaugeMetric("g", 2),
+		counterMetric("c", 10),
+		gaugeMetric("new", 3),
+		{ID: "broken", MType: internal.GaugeType},
+	})
+	assert.ErrorIs(t, err, repository.ErrValueAbsent)
+
+	gauge, err := st.GetGaugeValue(ctx, "g")
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, gauge)
+
+	counter, err := st.GetCounterValue(ctx, "c")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), counter)
+
+	_, err = st.GetGaugeValue(ctx, "new")
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+
+	require.NoError(t, st.AddValues(ctx, []internal.Metrics{
+		counterMetric("c", 2),
+		counterMetric("c", 3),
+		gaugeMetric("g", 5),
+		gaugeMetric("g", 6),
+	}))
+
+	counter, err = st.GetCounterValue(ctx, "c")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(6), counter)
+
+	gauge, err = st.GetGaugeValue(ctx, "g")
+	assert.NoError(t, err)
+	assert.Equal(t, 6.0, gauge)
+}
+
+func testLargeBatch(t *testing.T, st repository.Storage) {
+	ctx := context.Background()
+	batch := make([]internal.Metrics, 0, largeBatchSize)
+
+	for i := 0; i < largeBatchSize; i++ {
+		id := fmt.Sprintf("metric%d", i)
+		if i%2 == 0 {
+			batch = append(batch, gaugeMetric(id, float64(i)))
+		} else {
+			batch = append(batch, counterMetric(id, int64(i)))
+		}
+	}
+
+	require.NoError(t, st.AddValues(ctx, batch))
+
+	values, err := st.GetValues(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, values, largeBatchSize)
+
+	counter, err := st.GetCounterValue(ctx, fmt.Sprintf("metric%d", largeBatchSize-1))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(largeBatchSize-1), counter)
+}
+
+func testReturnedMapsAreCopies(t *testing.T, st repository.Storage) {
+	ctx := context.Background()
+
+	require.NoError(t, st.AddGaugeValue(ctx, "g", 1))
+	require.NoError(t, st.AddCounterValue(ctx, "c", 1))
+
+	gauges, err := st.GetGauge(ctx)
+	require.NoError(t, err)
+	gauges["g"] = 100
+	gauges["injected"] = 1
+
+	counters, err := st.GetCounters(ctx)
+	require.NoError(t, err)
+	counters["c"] = 100
+
+	gauge, err := st.GetGaugeValue(ctx, "g")
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, gauge)
+
+	counter, err := st.GetCounterValue(ctx, "c")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), counter)
+
+	exist, err := st.KeyExist(ctx, internal.GaugeType, "injected")
+	assert.NoError(t, err)
+	assert.False(t, exist)
+}
+
+func testListValues(t *testing.T, st repository.Storage) {
+	ctx := context.Background()
+
+	require.NoError(t, st.AddValues(ctx, []internal.Metrics{
+		gaugeMetric("b", 1),
+		gaugeMetric("a", 2),
+		counterMetric("b", 3),
+		counterMetric("c", 4),
+		gaugeMetric("ab", 5),
+	}))
+
+	tests := []struct {
+		name string
+		opts repository.ListOptions
+		want []repository.MetricKey
+	}{
+		{
+			name: "by id",
+			opts: repository.ListOptions{},
+			want: []repository.MetricKey{
+				{MType: internal.GaugeType, ID: "a"},
+				{MType: internal.GaugeType, ID: "ab"},
+				{MType: internal.CounterType, ID: "b"},
+				{MType: internal.GaugeType, ID: "b"},
+				{MType: internal.CounterType, ID: "c"},
+			},
+		},
+		{
+			name: "by type desc",
+			opts: repository.ListOptions{SortBy: repository.SortByType, Desc: true},
+			want: []repository.MetricKey{
+				{MType: internal.GaugeType, ID: "b"},
+				{MType: internal.GaugeType, ID: "ab"},
+				{MType: internal.GaugeType, ID: "a"},
+				{MType: internal.CounterType, ID: "c"},
+				{MType: internal.CounterType, ID: "b"},
+			},
+		},
+		{
+			name: "by type",
+			opts: repository.ListOptions{SortBy: repository.SortByType},
+			want: []repository.MetricKey{
+				{MType: internal.CounterType, ID: "b"},
+				{MType: internal.CounterType, ID: "c"},
+				{MType: internal.GaugeType, ID: "a"},
+				{MType: internal.GaugeType, ID: "ab"},
+				{MType: internal.GaugeType, ID: "b"},
+			},
+		},
+		{
+			name: "prefix desc",
+			opts: repository.ListOptions{Prefix: "b", Desc: true},
+			want: []repository.MetricKey{
+				{MType: internal.GaugeType, ID: "b"},
+				{MType: internal.CounterType, ID: "b"},
+			},
+		},
+		{
+			name: "type and prefix filter",
+			opts: repository.ListOptions{MType: internal.GaugeType, Prefix: "a"},
+			want: []repository.MetricKey{
+				{MType: internal.GaugeType, ID: "a"},
+				{MType: internal.GaugeType, ID: "ab"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// читаем по две метрики, продолжая с ключа последней метрики страницы
+			opts := tt.opts
+			opts.Limit = 2
+			got := make([]repository.MetricKey, 0)
+
+			for {
+				page, err := st.ListValues(ctx, opts)
+				require.NoError(t, err)
+				require.LessOrEqual(t, len(page), opts.Limit)
+
+				for _, m := range page {
+					got = append(got, repository.KeyOf(m))
+				}
+
+				if len(page) < opts.Limit {
+					break
+				}
+
+				last := repository.KeyOf(page[len(page)-1])
+				opts.After = &last
+			}
+
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	page, err := st.ListValues(ctx, repository.ListOptions{Prefix: "c"})
+	require.NoError(t, err)
+	assert.Equal(t, []internal.Metrics{counterMetric("c", 4)}, page)
+}
+
+func testDeleteAndReset(t *testing.T, st repository.Storage) {
+	ctx := context.Background()
+
+	require.NoError(t, st.AddGaugeValue(ctx, "same", 1))
+	require.NoError(t, st.AddCounterValue(ctx, "same", 5))
+
+	require.NoError(t, st.Delete(ctx, internal.GaugeType, "same"))
+
+	_, err := st.GetGaugeValue(ctx, "same")
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+
+	exist, err := st.KeyExist(ctx, internal.GaugeType, "same")
+	assert.NoError(t, err)
+	assert.False(t, exist)
+
+	// удаление gauge не затрагивает counter с тем же ID
+	counter, err := st.GetCounterValue(ctx, "same")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), counter)
+
+	require.NoError(t, st.ResetCounter(ctx, "same"))
+	counter, err = st.GetCounterValue(ctx, "same")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), counter)
+
+	require.NoError(t, st.AddCounterValue(ctx, "same", 2))
+	counter, err = st.GetCounterValue(ctx, "same")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), counter)
+
+	assert.ErrorIs(t, st.Delete(ctx, internal.GaugeType, "same"), repository.ErrNotFound)
+	assert.ErrorIs(t, st.Delete(ctx, "unknown", "same"), repository.ErrUnknownType)
+	assert.ErrorIs(t, st.ResetCounter(ctx, "absent"), repository.ErrNotFound)
+
+	// после удаления метрика создается заново с нуля
+	require.NoError(t, st.Delete(ctx, internal.CounterType, "same"))
+	require.NoError(t, st.AddCounterValue(ctx, "same", 7))
+	counter, err = st.GetCounterValue(ctx, "same")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), counter)
+
+	values, err := st.GetValues(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []internal.Metrics{counterMetric("same", 7)}, values)
+}
+
+func testFreshness(t *testing.T, st repository.Storage) {
+	ctx := context.Background()
+	oldGauge := repository.MetricKey{MType: internal.GaugeType, ID: "old"}
+	oldCounter := repository.MetricKey{MType: internal.CounterType, ID: "old"}
+	fresh := repository.MetricKey{MType: internal.GaugeType, ID: "fresh"}
+
+	require.NoError(t, st.AddGaugeValue(ctx, "old", 1))
+	require.NoError(t, st.AddCounterValue(ctx, "old", 1))
+
+	updated, err := st.LastUpdated(ctx, nil)
+	require.NoError(t, err)
+	require.Len(t, updated, 2)
+	oldAt := updated[oldGauge]
+	assert.False(t, oldAt.IsZero())
+	assert.False(t, updated[oldCounter].IsZero())
+
+	// время берется на стороне хранилища (в postgres - с точностью до микросекунд), пауза разводит записи во времени
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, st.AddValues(ctx, []internal.Metrics{gaugeMetric("fresh", 2), counterMetric("old", 2)}))
+
+	updated, err = st.LastUpdated(ctx, []repository.MetricKey{fresh, {MType: internal.GaugeType, ID: "absent"}})
+	require.NoError(t, err)
+	require.Len(t, updated, 1)
+	assert.True(t, updated[fresh].After(oldAt))
+
+	evicted, err := st.EvictStale(ctx, oldAt.Add(10*time.Millisecond))
+	require.NoError(t, err)
+	assert.Equal(t, []repository.MetricKey{oldGauge}, evicted)
+
+	exist, err := st.KeyExist(ctx, internal.GaugeType, "old")
+	assert.NoError(t, err)
+	assert.False(t, exist)
+
+	counter, err := st.GetCounterValue(ctx, "old")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), counter)
+
+	updated, err = st.LastUpdated(ctx, nil)
+	require.NoError(t, err)
+	assert.Len(t, updated, 2)
+	assert.NotContains(t, updated, oldGauge)
+
+	require.NoError(t, st.Delete(ctx, internal.GaugeType, "fresh"))
+	updated, err = st.LastUpdated(ctx, []repository.MetricKey{fresh})
+	require.NoError(t, err)
+	assert.Empty(t, updated)
+}
+
+func gaugeMetric(id string, value float64) internal.Metrics {
+	return internal.Metrics{ID: id, MType: internal.GaugeType, Value: &value}
+}
+
+func counterMetric(id string, delta int64) internal.Metrics {
+	return internal.Metrics{ID: id, MType: internal.CounterType, Delta: &delta}
+}
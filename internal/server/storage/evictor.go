@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/server/repository"
+)
+
+// Границы интервала проверки устаревших рядов
+const (
+	minEvictInterval = time.Second
+	maxEvictInterval = time.Minute
+)
+
+// EvictInterval интервал проверки для вытеснения рядов через ttl: десятая часть ttl, от секунды до минуты
+func EvictInterval(ttl time.Duration) time.Duration {
+	interval := ttl / 10
+	if interval < minEvictInterval {
+		return minEvictInterval
+	}
+
+	if interval > maxEvictInterval {
+		return maxEvictInterval
+	}
+
+	return interval
+}
+
+// EvictStale удаляет ряды, не обновлявшиеся дольше ttl, и сразу сбрасывает хранилище в файл fs (если он задан),
+// иначе вытесненные ряды вернутся из старого снимка при восстановлении. Возвращает ключи вытесненных рядов.
+func EvictStale(ctx context.Context, st repository.Storage, fs *FileStorage, ttl time.Duration, now time.Time) ([]repository.MetricKey, error) {
+	evicted, err := st.EvictStale(ctx, now.Add(-ttl))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(evicted) == 0 || fs == nil {
+		return evicted, nil
+	}
+
+	return evicted, fs.Sync(ctx, st)
+}
+
+// EvictByInterval запускает вытеснение устаревших рядов до отмены контекста.
+// Ключи вытесненных рядов передаются в forget (если он задан), например, для учета количества рядов.
+// Ошибка не останавливает цикл, ряды будут вытеснены при следующей проверке.
+func EvictByInterval(ctx context.Context, st repository.Storage, fs *FileStorage, ttl time.Duration, forget func([]repository.MetricKey)) {
+	ticker := time.NewTicker(EvictInterval(ttl))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			evicted, err := EvictStale(ctx, st, fs, ttl, now)
+			// ряды могли удалиться, даже если сброс в файл не удался
+			if forget != nil && len(evicted) > 0 {
+				forget(evicted)
+			}
+
+			if err != nil {
+				internal.Logger.Infow("stale series eviction failed", "err", err)
+				continue
+			}
+
+			if len(evicted) > 0 {
+				internal.Logger.Infow("stale series evicted", "count", len(evicted))
+			}
+		}
+	}
+}
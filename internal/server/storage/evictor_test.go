@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/server/repository"
+	"github.com/sotavant/yandex-metrics/internal/server/repository/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvictStale(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	st := memory.NewShardedMetricsRepository(memory.DefaultShardsCount)
+
+	fs, err := NewFileStorage(path, true, 300)
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, fs.File.Close())
+	}()
+
+	require.NoError(t, st.AddGaugeValue(ctx, "stale", 1))
+	require.NoError(t, st.AddGaugeValue(ctx, "live", 2))
+
+	now := time.Now()
+	staleAt := now.Add(-2 * time.Hour)
+	require.NoError(t, st.SetUpdatedAt(ctx, repository.MetricKey{MType: internal.GaugeType, ID: "stale"}, staleAt))
+	require.NoError(t, fs.Sync(ctx, st))
+
+	// время обновления сохраняется в файле и восстанавливается
+	restored := restore(t, path)
+	updated, err := restored.LastUpdated(ctx, []repository.MetricKey{{MType: internal.GaugeType, ID: "stale"}})
+	require.NoError(t, err)
+	assert.True(t, staleAt.Equal(updated[repository.MetricKey{MType: internal.GaugeType, ID: "stale"}]))
+
+	evicted, err := EvictStale(ctx, st, fs, time.Hour, now)
+	require.NoError(t, err)
+	assert.Equal(t, []repository.MetricKey{{MType: internal.GaugeType, ID: "stale"}}, evicted)
+
+	// вытесненный ряд не возвращается при восстановлении, хотя интервал сохранения еще не прошел
+	restored = restore(t, path)
+	gauges, err := restored.GetGauge(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{"live": 2}, gauges)
+}
+
+// restore читает файл так же, как при запуске сервера - новым FileStorage
+func restore(t *testing.T, path string) *memory.ShardedMetricsRepository {
+	fs, err := NewFileStorage(path, true, 0)
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, fs.File.Close())
+	}()
+
+	st := memory.NewShardedMetricsRepository(memory.DefaultShardsCount)
+	require.NoError(t, fs.Restore(context.Background(), st))
+
+	return st
+}
+
+func TestEvictInterval(t *testing.T) {
+	tests := []struct {
+		name string
+		ttl  time.Duration
+		want time.Duration
+	}{
+		{name: "short", ttl: 5 * time.Second, want: time.Second},
+		{name: "middle", ttl: 5 * time.Minute, want: 30 * time.Second},
+		{name: "long", ttl: 24 * time.Hour, want: time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, EvictInterval(tt.ttl))
+		})
+	}
+}
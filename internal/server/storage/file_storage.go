@@ -14,7 +14,10 @@ import (
 	"github.com/sotavant/yandex-metrics/internal/server/repository"
 )
 
-// FileStorage структура для работы с файловым хранилищем
+// FileStorage структура для работы с файловым хранилищем.
+//
+// Файл содержит снимок хранилища: каждая строка - метрика и время ее последнего обновления.
+// Sync перезаписывает снимок целиком, поэтому удаленные и вытесненные ряды в него не попадают.
 type FileStorage struct {
 	File          *os.File
 	encoder       *json.Encoder
@@ -24,6 +27,12 @@ type FileStorage struct {
 	StoreInterval uint
 }
 
+// fileRecord строка файла. Время обновления необязательно: в файлах старого формата его нет.
+type fileRecord struct {
+	internal.Metrics
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
 // NewFileStorage инициализация файлового хранилища.
 //
 // Параметры:
@@ -46,7 +55,8 @@ func NewFileStorage(fileStorage string, needRestore bool, storeInterval uint) (*
 	}, nil
 }
 
-// Restore метод для восстановления значения из файла
+// Restore метод для восстановления значения из файла.
+// Если хранилище поддерживает repository.UpdatedAtSetter, восстанавливается и время обновления рядов.
 func (fs *FileStorage) Restore(ctx context.Context, st repository.Storage) error {
 	fs.fileMutex.Lock()
 	defer fs.fileMutex.Unlock()
@@ -59,10 +69,12 @@ func (fs *FileStorage) Restore(ctx context.Context, st repository.Storage) error
 		return err
 	}
 
+	setter, canSetUpdated := st.(repository.UpdatedAtSetter)
+
 	for {
-		var m internal.Metrics
+		var rec fileRecord
 
-		err := fs.decoder.Decode(&m)
+		err := fs.decoder.Decode(&rec)
 
 		if err == io.EOF {
 			break
@@ -72,9 +84,15 @@ func (fs *FileStorage) Restore(ctx context.Context, st repository.Storage) error
 			return err
 		}
 
-		if err = st.AddValue(ctx, m); err != nil {
+		if err = st.AddValue(ctx, rec.Metrics); err != nil {
 			return err
 		}
+
+		if rec.UpdatedAt != nil && canSetUpdated {
+			if err = setter.SetUpdatedAt(ctx, repository.KeyOf(rec.Metrics), *rec.UpdatedAt); err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
@@ -89,6 +107,11 @@ func (fs *FileStorage) Sync(ctx context.Context, st repository.Storage) error {
 		return err
 	}
 
+	updated, err := st.LastUpdated(ctx, nil)
+	if err != nil {
+		return err
+	}
+
 	gaugeValues, err := st.GetGauge(ctx)
 	if err != nil {
 		return err
@@ -102,7 +125,7 @@ func (fs *FileStorage) Sync(ctx context.Context, st repository.Storage) error {
 			Value: &v,
 		}
 
-		if err = fs.encoder.Encode(&m); err != nil {
+		if err = fs.encoder.Encode(newFileRecord(m, updated)); err != nil {
 			return err
 		}
 	}
@@ -120,7 +143,7 @@ func (fs *FileStorage) Sync(ctx context.Context, st repository.Storage) error {
 			Value: nil,
 		}
 
-		if err := fs.encoder.Encode(&m); err != nil {
+		if err := fs.encoder.Encode(newFileRecord(m, updated)); err != nil {
 			return err
 		}
 	}
@@ -132,6 +155,15 @@ func (fs *FileStorage) Sync(ctx context.Context, st repository.Storage) error {
 	return nil
 }
 
+func newFileRecord(m internal.Metrics, updated map[repository.MetricKey]time.Time) *fileRecord {
+	rec := &fileRecord{Metrics: m}
+	if at, ok := updated[repository.KeyOf(m)]; ok {
+		rec.UpdatedAt = &at
+	}
+
+	return rec
+}
+
 // SyncByInterval сброс значения в файл с заданным интервалом. Если интервал не задан, то не синхронизируется.
 func (fs *FileStorage) SyncByInterval(ctx context.Context, storage repository.Storage) error {
 	storeIntervalDuration := time.Duration(fs.StoreInterval) * time.Second
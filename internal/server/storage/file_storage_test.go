@@ -8,6 +8,7 @@ import (
 
 	"github.com/sotavant/yandex-metrics/internal"
 	"github.com/sotavant/yandex-metrics/internal/server/config"
+	"github.com/sotavant/yandex-metrics/internal/server/repository"
 	"github.com/sotavant/yandex-metrics/internal/server/repository/memory"
 	"github.com/stretchr/testify/assert"
 )
@@ -110,7 +111,11 @@ func TestFileStorage_Restore(t *testing.T) {
 			assert.NoError(t, err)
 			for _, v := range tt.wantData {
 				val, err := ms.GetGaugeValue(ctx, v.ID)
-				assert.NoError(t, err)
+				if tt.needRestore {
+					assert.NoError(t, err)
+				} else {
+					assert.ErrorIs(t, err, repository.ErrNotFound)
+				}
 				assert.Equal(t, *v.Value, val)
 			}
 		})
@@ -163,4 +168,33 @@ func TestFileStorage_Sync(t *testing.T) {
 			assert.Contains(t, string(data), str)
 		}
 	})
+
+	t.Run("deletedMetricRemoved", func(t *testing.T) {
+		fs, _ := NewFileStorage(conf.FileStoragePath, true, conf.StoreInterval)
+		ms := memory.NewMetricsRepository()
+		assert.NoError(t, ms.AddGaugeValue(ctx, "s", 111))
+		assert.NoError(t, ms.AddCounterValue(ctx, "c", 13))
+
+		defer func(file *os.File) {
+			err := file.Close()
+			assert.NoError(t, err)
+
+			err = os.Remove(conf.FileStoragePath)
+			assert.NoError(t, err)
+		}(fs.File)
+
+		assert.NoError(t, fs.Sync(ctx, ms))
+		assert.NoError(t, ms.Delete(ctx, internal.GaugeType, "s"))
+		assert.NoError(t, fs.Sync(ctx, ms))
+
+		restored := memory.NewMetricsRepository()
+		assert.NoError(t, fs.Restore(ctx, restored))
+
+		_, err := restored.GetGaugeValue(ctx, "s")
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+
+		counter, err := restored.GetCounterValue(ctx, "c")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(13), counter)
+	})
 }
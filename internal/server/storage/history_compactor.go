@@ -0,0 +1,27 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/sotavant/yandex-metrics/internal/server/repository"
+)
+
+// CompactByInterval запускает сжатие истории с заданным интервалом до отмены контекста.
+// Ошибка сжатия не останавливает цикл: незавершенные интервалы будут свернуты при следующем запуске.
+func CompactByInterval(ctx context.Context, hs repository.HistoryStorage, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := hs.Compact(ctx, now); err != nil {
+				internal.Logger.Infow("history compaction failed", "err", err)
+			}
+		}
+	}
+}
@@ -1,10 +1,17 @@
 package utils
 
 import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/pem"
+	"errors"
 	"os"
 
 	"github.com/sotavant/yandex-metrics/internal"
@@ -12,11 +19,27 @@ import (
 	"google.golang.org/grpc/credentials/insecure"
 )
 
+// Конверт: случайный ключ AES-256-GCM на каждое сообщение, зашифрованный RSA-OAEP (SHA-256).
+// Формат: envelopeMagic | длина ключа (2 байта, big endian) | ключ | nonce | шифротекст AES-GCM
+var envelopeMagic = []byte("YME1")
+
+const dataKeySize = 32
+
+var (
+	ErrNotEnvelope = errors.New("message is not an envelope")
+	ErrBadEnvelope = errors.New("bad envelope")
+	ErrBadKey      = errors.New("bad PEM key")
+)
+
+// Cipher шифрует тела запросов открытым ключом и расшифровывает закрытым.
+// По-умолчанию используется конверт RSA-OAEP + AES-GCM, в режиме legacy - RSA PKCS#1 v1.5
+// над всем сообщением (ограничено размером ключа).
 type Cipher struct {
 	privateKey     *rsa.PrivateKey
 	publicKey      *rsa.PublicKey
 	certPath       string
 	privateKeyPath string
+	legacy         bool
 }
 
 func NewCipher(privateKeyPath, publicKeyPath, certPath string) (*Cipher, error) {
@@ -52,13 +75,16 @@ func getPrivKey(privateKeyPath string) (*rsa.PrivateKey, error) {
 		return nil, err
 	}
 
+	return parsePrivKey(privateKeyPEM)
+}
+
+func parsePrivKey(privateKeyPEM []byte) (*rsa.PrivateKey, error) {
 	privateKeyBlock, _ := pem.Decode(privateKeyPEM)
-	privateKey, err := x509.ParsePKCS1PrivateKey(privateKeyBlock.Bytes)
-	if err != nil {
-		return nil, err
+	if privateKeyBlock == nil {
+		return nil, ErrBadKey
 	}
 
-	return privateKey, nil
+	return x509.ParsePKCS1PrivateKey(privateKeyBlock.Bytes)
 }
 
 func getPubKey(publicKeyPath string) (*rsa.PublicKey, error) {
@@ -79,32 +105,143 @@ func getPubKey(publicKeyPath string) (*rsa.PublicKey, error) {
 	return publicKey, nil
 }
 
+// WithLegacy включает прежний режим шифрования PKCS#1 v1.5. При расшифровке в этом режиме
+// конверты также принимаются, чтобы клиенты можно было переводить по одному.
+func (c *Cipher) WithLegacy(legacy bool) *Cipher {
+	if c != nil {
+		c.legacy = legacy
+	}
+
+	return c
+}
+
+// Encrypt шифрует сообщение открытым ключом, без ключа возвращает сообщение как есть
 func (c *Cipher) Encrypt(plaintext []byte) ([]byte, error) {
 	if c.publicKey == nil {
 		return plaintext, nil
 	}
 
-	ciphertext, err := rsa.EncryptPKCS1v15(rand.Reader, c.publicKey, plaintext)
-	if err != nil {
-		return nil, err
+	if c.legacy {
+		return rsa.EncryptPKCS1v15(rand.Reader, c.publicKey, plaintext)
 	}
 
-	return ciphertext, nil
+	return c.seal(plaintext)
 }
 
+// Decrypt расшифровывает сообщение закрытым ключом, без ключа возвращает сообщение как есть.
+// Вне режима legacy принимаются только конверты.
 func (c *Cipher) Decrypt(encrypted []byte) ([]byte, error) {
 	if c.privateKey == nil {
 		return encrypted, nil
 	}
 
-	plaintext, err := rsa.DecryptPKCS1v15(rand.Reader, c.privateKey, encrypted)
+	return decrypt(c.privateKey, encrypted, c.legacy)
+}
+
+// KeyID идентификатор открытого ключа, по которому сервер выбирает закрытый ключ из KeyRing.
+// Без открытого ключа возвращает пустую строку.
+func (c *Cipher) KeyID() string {
+	if !c.IsPublicKeyExist() {
+		return ""
+	}
+
+	return KeyID(c.publicKey)
+}
+
+// KeyID первые 8 байт SHA-256 от открытого ключа в формате PKCS#1, в шестнадцатеричном виде
+func KeyID(pub *rsa.PublicKey) string {
+	sum := sha256.Sum256(x509.MarshalPKCS1PublicKey(pub))
+	return hex.EncodeToString(sum[:8])
+}
+
+// decrypt расшифровывает конверт, а в режиме legacy - также сообщение, зашифрованное PKCS#1 v1.5
+func decrypt(privateKey *rsa.PrivateKey, encrypted []byte, legacy bool) ([]byte, error) {
+	plaintext, err := openEnvelope(privateKey, encrypted)
+	if err == nil || !legacy {
+		return plaintext, err
+	}
+
+	return rsa.DecryptPKCS1v15(rand.Reader, privateKey, encrypted)
+}
+
+func (c *Cipher) seal(plaintext []byte) ([]byte, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+
+	wrappedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, c.publicKey, dataKey, nil)
 	if err != nil {
 		return nil, err
 	}
 
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	res := make([]byte, 0, len(envelopeMagic)+2+len(wrappedKey)+len(nonce)+len(plaintext)+gcm.Overhead())
+	res = append(res, envelopeMagic...)
+	res = binary.BigEndian.AppendUint16(res, uint16(len(wrappedKey)))
+	res = append(res, wrappedKey...)
+	res = append(res, nonce...)
+
+	return gcm.Seal(res, nonce, plaintext, nil), nil
+}
+
+func openEnvelope(privateKey *rsa.PrivateKey, envelope []byte) ([]byte, error) {
+	rest, ok := bytes.CutPrefix(envelope, envelopeMagic)
+	if !ok {
+		return nil, ErrNotEnvelope
+	}
+
+	if len(rest) < 2 {
+		return nil, ErrBadEnvelope
+	}
+
+	keyLen := int(binary.BigEndian.Uint16(rest))
+	rest = rest[2:]
+	if len(rest) < keyLen {
+		return nil, ErrBadEnvelope
+	}
+
+	dataKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, privateKey, rest[:keyLen], nil)
+	if err != nil {
+		return nil, errors.Join(ErrBadEnvelope, err)
+	}
+	rest = rest[keyLen:]
+
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rest) < gcm.NonceSize() {
+		return nil, ErrBadEnvelope
+	}
+
+	plaintext, err := gcm.Open(nil, rest[:gcm.NonceSize()], rest[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.Join(ErrBadEnvelope, err)
+	}
+
 	return plaintext, nil
 }
 
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
 func (c *Cipher) IsPrivateKeyExist() bool {
 	if c == nil || c.privateKey == nil {
 		return false
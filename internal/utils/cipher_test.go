@@ -1,12 +1,116 @@
 package utils
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+// writeTestKeys создает пару RSA-ключей в формате PKCS#1 и возвращает пути к закрытому и открытому ключу
+func writeTestKeys(t *testing.T) (string, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	privPath, pubPath := filepath.Join(dir, "private.pem"), filepath.Join(dir, "public.pem")
+
+	priv := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	require.NoError(t, os.WriteFile(privPath, priv, 0600))
+
+	pub := pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&key.PublicKey)})
+	require.NoError(t, os.WriteFile(pubPath, pub, 0644))
+
+	return privPath, pubPath
+}
+
+func TestCipher_Envelope(t *testing.T) {
+	privPath, pubPath := writeTestKeys(t)
+
+	// больше, чем помещается в один блок RSA
+	large := bytes.Repeat([]byte(`{"id":"Alloc","type":"gauge","value":1.5}`), 100)
+
+	tests := []struct {
+		name          string
+		msg           []byte
+		agentLegacy   bool
+		serverLegacy  bool
+		tamper        bool
+		wantEncErr    bool
+		wantDecodeErr bool
+	}{
+		{
+			name: "envelope",
+			msg:  large,
+		},
+		{
+			name:         "envelope accepted by legacy server",
+			msg:          large,
+			serverLegacy: true,
+		},
+		{
+			name:         "legacy",
+			msg:          []byte("test message"),
+			agentLegacy:  true,
+			serverLegacy: true,
+		},
+		{
+			name:          "legacy rejected by strict server",
+			msg:           []byte("test message"),
+			agentLegacy:   true,
+			wantDecodeErr: true,
+		},
+		{
+			name:        "legacy does not fit large message",
+			msg:         large,
+			agentLegacy: true,
+			wantEncErr:  true,
+		},
+		{
+			name:          "tampered envelope",
+			msg:           large,
+			tamper:        true,
+			wantDecodeErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent, err := NewCipher("", pubPath, "")
+			require.NoError(t, err)
+			srv, err := NewCipher(privPath, "", "")
+			require.NoError(t, err)
+
+			encrypted, err := agent.WithLegacy(tt.agentLegacy).Encrypt(tt.msg)
+			if tt.wantEncErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			if tt.tamper {
+				encrypted[len(encrypted)-1] ^= 1
+			}
+
+			decrypted, err := srv.WithLegacy(tt.serverLegacy).Decrypt(encrypted)
+			if tt.wantDecodeErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.msg, decrypted)
+		})
+	}
+}
+
 func TestCipher_Encrypt(t *testing.T) {
 	pubKeyPath := os.Getenv("TEST_CRYPT_PUB_KEY")
 	privKeyPath := os.Getenv("TEST_CRYPT_PRIV_KEY")
@@ -3,34 +3,117 @@ package utils
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"strings"
+	"os"
+	"strconv"
+	"time"
 
 	"github.com/sotavant/yandex-metrics/internal"
 )
 
 const HasherHeaderKey = "HashSHA256"
 
-// GetHash Получения зашифрованного сообщения, с помощью ключа key. Используется алгоритм sha256
-func GetHash(data []byte, key string) (hash string, err error) {
-	cleanData := strings.TrimSuffix(string(data), "\n")
-	h := sha256.New()
-	_, err = h.Write([]byte(cleanData))
+// AgentIDHeaderKey заголовок с идентификатором агента, по которому сервер выбирает ключ подписи
+const AgentIDHeaderKey = "X-Agent-ID"
+
+// Заголовки с меткой времени и одноразовым значением, которые подписываются вместе с данными
+const (
+	TimestampHeaderKey = "X-Timestamp"
+	NonceHeaderKey     = "X-Nonce"
+)
+
+const nonceSize = 16
+
+var (
+	ErrNoSignature   = errors.New("empty timestamp or nonce")
+	ErrEmptyAgentKey = errors.New("empty agent key")
+)
+
+// Signature метка времени (unix, секунды) и nonce подписанного запроса.
+// Сервер отклоняет запросы с устаревшей меткой и повторно использованным nonce.
+type Signature struct {
+	Nonce     string
+	Timestamp int64
+}
+
+// NewSignature подпись с текущим временем и случайным nonce
+func NewSignature() (Signature, error) {
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return Signature{}, err
+	}
+
+	return Signature{Timestamp: time.Now().Unix(), Nonce: hex.EncodeToString(nonce)}, nil
+}
+
+// ParseSignature разбирает значения заголовков TimestampHeaderKey и NonceHeaderKey
+func ParseSignature(timestamp, nonce string) (Signature, error) {
+	if timestamp == "" || nonce == "" {
+		return Signature{}, ErrNoSignature
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
 	if err != nil {
+		return Signature{}, fmt.Errorf("bad timestamp: %w", err)
+	}
+
+	return Signature{Timestamp: ts, Nonce: nonce}, nil
+}
+
+// TimestampString метка времени в формате заголовка
+func (s Signature) TimestampString() string {
+	return strconv.FormatInt(s.Timestamp, 10)
+}
+
+// GetHash подпись данных HMAC-SHA256 с ключом key в шестнадцатеричном виде.
+// Данные подписываются как есть, без нормализации.
+func GetHash(data []byte, key string) (hash string, err error) {
+	h := hmac.New(sha256.New, []byte(key))
+	if _, err = h.Write(data); err != nil {
 		return
 	}
 
-	_, err = h.Write([]byte(key))
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// CheckHash сравнивает подпись с ожидаемой за постоянное время
+func CheckHash(hash, want string) bool {
+	return hmac.Equal([]byte(hash), []byte(want))
+}
+
+// GetSignedHash подпись данных вместе с меткой времени и nonce: timestamp \n nonce \n data
+func GetSignedHash(data []byte, key string, s Signature) (string, error) {
+	signed := make([]byte, 0, len(data)+len(s.Nonce)+22)
+	signed = strconv.AppendInt(signed, s.Timestamp, 10)
+	signed = append(signed, '\n')
+	signed = append(signed, s.Nonce...)
+	signed = append(signed, '\n')
+	signed = append(signed, data...)
+
+	return GetHash(signed, key)
+}
+
+// GetMetricHash подпись одной метрики
+func GetMetricHash(m internal.Metrics, key string, s Signature) (hash string, err error) {
+	var metricsBuf bytes.Buffer
+	enc := gob.NewEncoder(&metricsBuf)
+	err = enc.Encode(m)
 	if err != nil {
 		return
 	}
 
-	return fmt.Sprintf("%x", h.Sum(nil)), err
+	return GetSignedHash(metricsBuf.Bytes(), key, s)
 }
 
-func GetMetricHash(m internal.Metrics, key string) (hash string, err error) {
+// GetMetricsHash подпись пакета метрик, порядок метрик учитывается
+func GetMetricsHash(m []internal.Metrics, key string, s Signature) (hash string, err error) {
 	var metricsBuf bytes.Buffer
 	enc := gob.NewEncoder(&metricsBuf)
 	err = enc.Encode(m)
@@ -38,5 +121,26 @@ func GetMetricHash(m internal.Metrics, key string) (hash string, err error) {
 		return
 	}
 
-	return GetHash(metricsBuf.Bytes(), key)
+	return GetSignedHash(metricsBuf.Bytes(), key, s)
+}
+
+// LoadAgentKeys читает таблицу ключей агентов из JSON-файла вида {"agent-id": "secret"}
+func LoadAgentKeys(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]string)
+	if err = json.Unmarshal(data, &keys); err != nil {
+		return nil, err
+	}
+
+	for id, key := range keys {
+		if key == "" {
+			return nil, fmt.Errorf("%w: %s", ErrEmptyAgentKey, id)
+		}
+	}
+
+	return keys, nil
 }
@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetHash(t *testing.T) {
+	mac := hmac.New(sha256.New, []byte("key"))
+	mac.Write([]byte("body\n"))
+	want := hex.EncodeToString(mac.Sum(nil))
+
+	hash, err := GetHash([]byte("body\n"), "key")
+	require.NoError(t, err)
+	assert.Equal(t, want, hash)
+	assert.True(t, CheckHash(hash, want))
+
+	// перевод строки входит в подпись
+	trimmed, err := GetHash([]byte("body"), "key")
+	require.NoError(t, err)
+	assert.False(t, CheckHash(trimmed, want))
+
+	sig := Signature{Timestamp: 1700000000, Nonce: "abc"}
+	signed, err := GetSignedHash([]byte("body\n"), "key", sig)
+	require.NoError(t, err)
+	other, err := GetSignedHash([]byte("body\n"), "key", Signature{Timestamp: 1700000000, Nonce: "abd"})
+	require.NoError(t, err)
+	assert.NotEqual(t, signed, other)
+}
+
+func TestLoadAgentKeys(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:    "keys",
+			content: `{"agent-1": "secret-1", "agent-2": "secret-2"}`,
+			want:    map[string]string{"agent-1": "secret-1", "agent-2": "secret-2"},
+		},
+		{
+			name:    "empty key",
+			content: `{"agent-1": ""}`,
+			wantErr: true,
+		},
+		{
+			name:    "bad json",
+			content: `["agent-1"]`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name+".json")
+			require.NoError(t, os.WriteFile(path, []byte(tt.content), 0600))
+
+			keys, err := LoadAgentKeys(path)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, keys)
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package utils
+
+import (
+	"context"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sotavant/yandex-metrics/internal"
+)
+
+// KeyIDHeader заголовок с идентификатором открытого ключа, которым агент зашифровал тело запроса
+const KeyIDHeader = "X-Key-ID"
+
+var (
+	ErrUnknownKey = errors.New("unknown key id")
+	ErrNoKeys     = errors.New("no keys in key directory")
+)
+
+// KeyRing набор закрытых ключей из каталога с PEM-файлами (*.pem). Ключ определяется по KeyID
+// его открытой части, поэтому имена файлов значения не имеют.
+//
+// Ключ, удаленный из каталога, принимается еще grace после перезагрузки, которая заметила удаление,
+// чтобы агенты успели перейти на новый ключ.
+type KeyRing struct {
+	dir    string
+	grace  time.Duration
+	legacy bool
+
+	mu    sync.RWMutex
+	keys  map[string]*ringKey
+	state string
+	now   func() time.Time
+}
+
+type ringKey struct {
+	key *rsa.PrivateKey
+	// removedAt время, когда ключ пропал из каталога, нулевое - ключ в каталоге
+	removedAt time.Time
+}
+
+// NewKeyRing загружает ключи из каталога. Каталог должен содержать хотя бы один ключ.
+func NewKeyRing(dir string, grace time.Duration) (*KeyRing, error) {
+	r := &KeyRing{
+		dir:   dir,
+		grace: grace,
+		keys:  make(map[string]*ringKey),
+		now:   time.Now,
+	}
+
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// WithLegacy включает прием сообщений, зашифрованных PKCS#1 v1.5 без конверта
+func (r *KeyRing) WithLegacy(legacy bool) *KeyRing {
+	r.legacy = legacy
+
+	return r
+}
+
+// Reload перечитывает каталог. Если хотя бы один файл не читается или ключей нет,
+// возвращает ошибку и оставляет прежний набор ключей.
+func (r *KeyRing) Reload() error {
+	state, err := r.dirState()
+	if err != nil {
+		return err
+	}
+
+	loaded, err := r.load()
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	for id, k := range r.keys {
+		if _, ok := loaded[id]; ok {
+			continue
+		}
+
+		if k.removedAt.IsZero() {
+			k.removedAt = now
+		}
+
+		if r.active(k, now) {
+			loaded[id] = k
+		}
+	}
+
+	r.keys = loaded
+	r.state = state
+
+	return nil
+}
+
+// Watch перезагружает ключи при изменении каталога, проверяя его раз в interval, до отмены ctx
+func (r *KeyRing) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			state, err := r.dirState()
+			if err != nil {
+				internal.Logger.Infow("key dir read error", "err", err)
+				continue
+			}
+
+			r.mu.RLock()
+			changed := state != r.state
+			r.mu.RUnlock()
+
+			if !changed {
+				continue
+			}
+
+			if err = r.Reload(); err != nil {
+				internal.Logger.Infow("key ring reload error", "err", err)
+				continue
+			}
+
+			internal.Logger.Infow("key ring reloaded", "keys", r.KeyIDs())
+		}
+	}
+}
+
+// KeyIDs идентификаторы принимаемых ключей
+func (r *KeyRing) KeyIDs() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := r.now()
+	ids := make([]string, 0, len(r.keys))
+	for id, k := range r.keys {
+		if r.active(k, now) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// Decrypt расшифровывает сообщение ключом keyID. Без keyID перебираются все принимаемые ключи,
+// так расшифровываются запросы агентов, которые еще не передают идентификатор.
+func (r *KeyRing) Decrypt(keyID string, encrypted []byte) ([]byte, error) {
+	r.mu.RLock()
+	now := r.now()
+	keys := make([]*rsa.PrivateKey, 0, len(r.keys))
+	for id, k := range r.keys {
+		if (keyID == "" || id == keyID) && r.active(k, now) {
+			keys = append(keys, k.key)
+		}
+	}
+	r.mu.RUnlock()
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, keyID)
+	}
+
+	var err error
+	var plaintext []byte
+	for _, k := range keys {
+		if plaintext, err = decrypt(k, encrypted, r.legacy); err == nil {
+			return plaintext, nil
+		}
+	}
+
+	return nil, err
+}
+
+func (r *KeyRing) active(k *ringKey, now time.Time) bool {
+	return k.removedAt.IsZero() || now.Sub(k.removedAt) < r.grace
+}
+
+func (r *KeyRing) load() (map[string]*ringKey, error) {
+	paths, err := filepath.Glob(filepath.Join(r.dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*ringKey, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := parsePrivKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		keys[KeyID(&key.PublicKey)] = &ringKey{key: key}
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoKeys, r.dir)
+	}
+
+	return keys, nil
+}
+
+// dirState имена, размеры и время изменения PEM-файлов каталога
+func (r *KeyRing) dirState() (string, error) {
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".pem" {
+			continue
+		}
+
+		info, err := e.Info()
+		if err != nil {
+			return "", err
+		}
+
+		fmt.Fprintf(&b, "%s:%d:%d;", e.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+
+	return b.String(), nil
+}
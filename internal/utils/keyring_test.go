@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sotavant/yandex-metrics/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeRingKey сохраняет новый закрытый ключ в каталог и возвращает шифратор его открытой частью
+func writeRingKey(t *testing.T, dir, name string) *Cipher {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	priv := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name), priv, 0600))
+
+	return &Cipher{publicKey: &key.PublicKey}
+}
+
+func TestKeyRing_Decrypt(t *testing.T) {
+	dir := t.TempDir()
+	first := writeRingKey(t, dir, "first.pem")
+	second := writeRingKey(t, dir, "second.pem")
+	unknown := writeRingKey(t, t.TempDir(), "unknown.pem")
+
+	ring, err := NewKeyRing(dir, time.Hour)
+	require.NoError(t, err)
+
+	msg := []byte("test message")
+
+	tests := []struct {
+		name    string
+		cipher  *Cipher
+		keyID   string
+		wantErr error
+	}{
+		{
+			name:   "first key",
+			cipher: first,
+			keyID:  first.KeyID(),
+		},
+		{
+			name:   "second key",
+			cipher: second,
+			keyID:  second.KeyID(),
+		},
+		{
+			name:   "without key id",
+			cipher: second,
+		},
+		{
+			name:    "key id of another key",
+			cipher:  second,
+			keyID:   first.KeyID(),
+			wantErr: ErrBadEnvelope,
+		},
+		{
+			name:    "unknown key",
+			cipher:  unknown,
+			keyID:   unknown.KeyID(),
+			wantErr: ErrUnknownKey,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encrypted, err := tt.cipher.Encrypt(msg)
+			require.NoError(t, err)
+
+			decrypted, err := ring.Decrypt(tt.keyID, encrypted)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, msg, decrypted)
+		})
+	}
+}
+
+func TestKeyRing_Reload(t *testing.T) {
+	dir := t.TempDir()
+	old := writeRingKey(t, dir, "old.pem")
+
+	ring, err := NewKeyRing(dir, time.Hour)
+	require.NoError(t, err)
+
+	now := time.Now()
+	ring.now = func() time.Time { return now }
+
+	// ротация: новый ключ добавлен, старый удален
+	current := writeRingKey(t, dir, "current.pem")
+	require.NoError(t, os.Remove(filepath.Join(dir, "old.pem")))
+	require.NoError(t, ring.Reload())
+	assert.ElementsMatch(t, []string{old.KeyID(), current.KeyID()}, ring.KeyIDs())
+
+	encrypted, err := old.Encrypt([]byte("msg"))
+	require.NoError(t, err)
+
+	// старый ключ принимается в течение grace
+	now = now.Add(59 * time.Minute)
+	_, err = ring.Decrypt(old.KeyID(), encrypted)
+	assert.NoError(t, err)
+
+	now = now.Add(time.Minute)
+	_, err = ring.Decrypt(old.KeyID(), encrypted)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	assert.Equal(t, []string{current.KeyID()}, ring.KeyIDs())
+
+	// некорректный файл не сбрасывает загруженные ключи
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "broken.pem"), []byte("not a key"), 0600))
+	assert.ErrorIs(t, ring.Reload(), ErrBadKey)
+	assert.Equal(t, []string{current.KeyID()}, ring.KeyIDs())
+
+	_, err = NewKeyRing(t.TempDir(), time.Hour)
+	assert.ErrorIs(t, err, ErrNoKeys)
+}
+
+func TestKeyRing_Watch(t *testing.T) {
+	internal.InitLogger()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir := t.TempDir()
+	first := writeRingKey(t, dir, "first.pem")
+
+	ring, err := NewKeyRing(dir, 0)
+	require.NoError(t, err)
+	go ring.Watch(ctx, 10*time.Millisecond)
+
+	second := writeRingKey(t, dir, "second.pem")
+	require.Eventually(t, func() bool {
+		return len(ring.KeyIDs()) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.ElementsMatch(t, []string{first.KeyID(), second.KeyID()}, ring.KeyIDs())
+}
@@ -1,5 +1,11 @@
 package utils
 
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
 const (
 	RetriesCount  = 3
 	FirstWaitTime = 1
@@ -20,3 +26,33 @@ func GetRetryWaitTimes() map[int]int {
 
 	return interval
 }
+
+// MaxRetryAfter верхняя граница ожидания, которое может запросить сервер
+const MaxRetryAfter = time.Minute
+
+// RetryAfter время ожидания из заголовка Retry-After: число секунд или HTTP-дата.
+// Пустое или некорректное значение - FirstWaitTime секунд.
+func RetryAfter(value string, now time.Time) time.Duration {
+	wait := time.Duration(FirstWaitTime) * time.Second
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		wait = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		wait = date.Sub(now)
+	}
+
+	return ClampRetryAfter(wait)
+}
+
+// ClampRetryAfter ограничивает ожидание промежутком от нуля до MaxRetryAfter
+func ClampRetryAfter(wait time.Duration) time.Duration {
+	if wait < 0 {
+		return 0
+	}
+
+	if wait > MaxRetryAfter {
+		return MaxRetryAfter
+	}
+
+	return wait
+}
@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration
+	}{
+		{
+			name:  "seconds",
+			value: "3",
+			want:  3 * time.Second,
+		},
+		{
+			name:  "http date",
+			value: now.Add(5 * time.Second).Format(http.TimeFormat),
+			want:  5 * time.Second,
+		},
+		{
+			name:  "date in the past",
+			value: now.Add(-time.Minute).Format(http.TimeFormat),
+			want:  0,
+		},
+		{
+			name:  "too long",
+			value: "3600",
+			want:  MaxRetryAfter,
+		},
+		{
+			name:  "empty",
+			value: "",
+			want:  FirstWaitTime * time.Second,
+		},
+		{
+			name:  "invalid",
+			value: "soon",
+			want:  FirstWaitTime * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, RetryAfter(tt.value, now))
+		})
+	}
+}
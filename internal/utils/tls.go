@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+)
+
+var ErrBadCA = errors.New("no certificates in CA bundle")
+
+// NewServerTLSConfig настройки TLS HTTP-сервера. С clientCAPath клиент обязан предъявить
+// сертификат, подписанный одним из CA из этого файла, без него сертификат клиента не запрашивается.
+// Сертификат сервера передается в ServeTLS.
+func NewServerTLSConfig(clientCAPath string) (*tls.Config, error) {
+	conf := &tls.Config{MinVersion: tls.VersionTLS12}
+	if clientCAPath == "" {
+		return conf, nil
+	}
+
+	pool, err := loadCertPool(clientCAPath)
+	if err != nil {
+		return nil, err
+	}
+
+	conf.ClientCAs = pool
+	conf.ClientAuth = tls.RequireAndVerifyClientCert
+
+	return conf, nil
+}
+
+// NewClientTLSConfig настройки TLS клиента. caPath - сертификаты для проверки сервера,
+// без него используются системные. certPath и keyPath - сертификат клиента для mTLS, необязательны.
+func NewClientTLSConfig(caPath, certPath, keyPath string) (*tls.Config, error) {
+	conf := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caPath != "" {
+		pool, err := loadCertPool(caPath)
+		if err != nil {
+			return nil, err
+		}
+
+		conf.RootCAs = pool
+	}
+
+	if certPath != "" || keyPath != "" {
+		cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return nil, err
+		}
+
+		conf.Certificates = []tls.Certificate{cert}
+	}
+
+	return conf, nil
+}
+
+// CertIdentity идентификатор агента по сертификату: CN, а если он пуст - первое DNS-имя из SAN
+func CertIdentity(cert *x509.Certificate) string {
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+
+	if len(cert.DNSNames) > 0 {
+		return cert.DNSNames[0]
+	}
+
+	return ""
+}
+
+// PeerIdentity идентификатор агента по проверенному сертификату клиента из состояния соединения
+func PeerIdentity(state *tls.ConnectionState) string {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ""
+	}
+
+	return CertIdentity(state.VerifiedChains[0][0])
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, ErrBadCA
+	}
+
+	return pool, nil
+}
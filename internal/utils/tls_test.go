@@ -0,0 +1,173 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCA центр сертификации для тестов, выпускает сертификаты в каталог dir
+type testCA struct {
+	t    *testing.T
+	dir  string
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	// path путь к сертификату CA в PEM
+	path string
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	ca := &testCA{t: t, dir: t.TempDir(), cert: cert, key: key}
+	ca.path = filepath.Join(ca.dir, name+".pem")
+	require.NoError(t, os.WriteFile(ca.path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+
+	return ca
+}
+
+// issue выпускает сертификат и возвращает пути к сертификату и ключу
+func (ca *testCA) issue(name string, tmpl *x509.Certificate) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(ca.t, err)
+
+	tmpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(ca.t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(ca.t, err)
+
+	certPath, keyPath := filepath.Join(ca.dir, name+".pem"), filepath.Join(ca.dir, name+"-key.pem")
+	require.NoError(ca.t, os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(ca.t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+
+	return certPath, keyPath
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	serverCert, serverKey := ca.issue("server", &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "server"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+
+	tests := []struct {
+		name string
+		// client шаблон сертификата клиента, nil - без сертификата
+		client       *x509.Certificate
+		otherCA      bool
+		wantIdentity string
+		wantErr      bool
+	}{
+		{
+			name: "common name",
+			client: &x509.Certificate{
+				Subject:     pkix.Name{CommonName: "agent-1"},
+				ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			},
+			wantIdentity: "agent-1",
+		},
+		{
+			name: "dns name",
+			client: &x509.Certificate{
+				DNSNames:    []string{"agent-2.metrics.local"},
+				ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			},
+			wantIdentity: "agent-2.metrics.local",
+		},
+		{
+			name: "certificate of another CA",
+			client: &x509.Certificate{
+				Subject:     pkix.Name{CommonName: "agent-3"},
+				ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			},
+			otherCA: true,
+			wantErr: true,
+		},
+		{
+			name:    "without certificate",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverTLS, err := NewServerTLSConfig(ca.path)
+			require.NoError(t, err)
+			cert, err := tls.LoadX509KeyPair(serverCert, serverKey)
+			require.NoError(t, err)
+			serverTLS.Certificates = []tls.Certificate{cert}
+
+			srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(PeerIdentity(r.TLS)))
+			}))
+			srv.TLS = serverTLS
+			srv.StartTLS()
+			defer srv.Close()
+
+			var clientCert, clientKey string
+			if tt.client != nil {
+				issuer := ca
+				if tt.otherCA {
+					issuer = newTestCA(t, "other")
+				}
+				clientCert, clientKey = issuer.issue("client", tt.client)
+			}
+
+			clientTLS, err := NewClientTLSConfig(ca.path, clientCert, clientKey)
+			require.NoError(t, err)
+
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS}}
+			resp, err := client.Get(srv.URL)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			var identity [64]byte
+			n, _ := resp.Body.Read(identity[:])
+			assert.Equal(t, tt.wantIdentity, string(identity[:n]))
+		})
+	}
+
+	_, err := NewServerTLSConfig(serverKey)
+	assert.ErrorIs(t, err, ErrBadCA)
+}